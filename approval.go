@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ToolKind says what a tool can do to the workspace, which decides whether
+// it needs the user's approval
+type ToolKind string
+
+const (
+	ToolRead    ToolKind = "read"    // only looks at the workspace
+	ToolWrite   ToolKind = "write"   // modifies files
+	ToolExecute ToolKind = "execute" // runs commands
+)
+
+// ApprovalMode is a preset policy for which tool calls run without asking
+type ApprovalMode string
+
+const (
+	ApprovalPlan     ApprovalMode = "plan"      // no writes or commands at all
+	ApprovalDefault  ApprovalMode = "default"   // prompt for writes and commands
+	ApprovalAutoEdit ApprovalMode = "auto-edit" // auto file edits, prompt for commands
+	ApprovalYolo     ApprovalMode = "yolo"      // auto everything
+)
+
+var approvalModes = []ApprovalMode{ApprovalPlan, ApprovalDefault, ApprovalAutoEdit, ApprovalYolo}
+
+func ParseApprovalMode(s string) (ApprovalMode, error) {
+	for _, mode := range approvalModes {
+		if string(mode) == s {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown approval mode %q (want one of plan, default, auto-edit, yolo)", s)
+}
+
+type approvalDecision int
+
+const (
+	approvalAllow approvalDecision = iota
+	approvalPrompt
+	approvalDeny
+)
+
+// decide returns what the mode does with a tool of the given kind
+func (m ApprovalMode) decide(kind ToolKind) approvalDecision {
+	if kind == ToolRead {
+		return approvalAllow
+	}
+	switch m {
+	case ApprovalPlan:
+		return approvalDeny
+	case ApprovalAutoEdit:
+		if kind == ToolWrite {
+			return approvalAllow
+		}
+		return approvalPrompt
+	case ApprovalYolo:
+		return approvalAllow
+	default:
+		return approvalPrompt
+	}
+}
+
+// approveToolCall applies the current approval mode to a tool call, asking
+// the user when needed. Calls tripping a guardrail need the user to type a
+// confirmation phrase in every mode that allows them. A non-nil error
+// explains why the call was refused.
+func (a *Agent) approveToolCall(tool ToolDefinition, input json.RawMessage) error {
+	decision := a.config.Approvals.decide(tool.callKind(input))
+	if decision == approvalDeny {
+		return &toolError{Code: errDenied, Message: fmt.Sprintf("%s is not allowed in %s mode", tool.Name, a.config.Approvals), Suggestion: "Describe the change instead of making it."}
+	}
+	// Commands can depend on the workspace, such as the branch pushed
+	var g *guardrail
+	a.inWorkspace(func() { g = riskyIntent(tool, input) })
+	if g != nil {
+		a.printStatus()
+		err := a.confirmIntent(tool, input, g)
+		if decision == approvalPrompt && a.status != nil && a.status.approvals > 0 {
+			a.status.approvals--
+		}
+		return err
+	}
+	if decision == approvalAllow {
+		return nil
+	}
+
+	a.printStatus()
+	approved := a.confirm(fmt.Sprintf("%s: %s(%s)?", paint(roleNotice, tr("approve")), tool.Name, input))
+	if a.status != nil && a.status.approvals > 0 {
+		a.status.approvals--
+	}
+	if !approved {
+		return &toolError{Code: errDenied, Message: fmt.Sprintf("user denied %s", tool.Name), Suggestion: "Ask the user what they want instead of repeating the call."}
+	}
+	return nil
+}
+
+// confirm asks a yes/no question on the prompt, defaulting to no
+func (a *Agent) confirm(question string) bool {
+	a.notifyLong(question)
+	fmt.Fprintf(a.out, "%s %s ", question, tr("[y/N]"))
+	answer, ok := a.getUserMessage()
+	// The user is back, restart the clock for the rest of the request
+	if !a.taskStart.IsZero() {
+		a.taskStart = time.Now()
+	}
+	if !ok {
+		return false
+	}
+	// English answers work in every language
+	switch answer = strings.ToLower(strings.TrimSpace(answer)); answer {
+	case "y", "yes", tr("y"), tr("yes"):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"google.golang.org/genai"
+)
+
+var sessionSaveWarn sync.Once
+
+// autosave saves the session as it is right now: the history, the request
+// in progress and the file tracker, so that after a crash or a lost
+// terminal --resume continues where it stopped. It runs after every model
+// reply and tool call. Failing to save only warns, once.
+func (a *Agent) autosave() {
+	a.session.SetHistory(a.history)
+	a.session.Files = a.fileTracker().save()
+	if err := a.session.Save(); err != nil {
+		sessionSaveWarn.Do(func() { log.Println("ERROR saving session:", err.Error()) })
+	}
+}
+
+// fileTracker returns the tracker the agent's tools use, see inWorkspace
+func (a *Agent) fileTracker() *fileTracker {
+	if a.files != nil {
+		return a.files
+	}
+	return fileVersions
+}
+
+// resumeSession makes s the agent's session, with its history and the
+// files the model had seen
+func (a *Agent) resumeSession(s *Session) {
+	a.session = s
+	a.history = s.Contents()
+	a.fileTracker().restore(s.Files)
+}
+
+// pendingCalls returns the tool calls of the last message in history when
+// they haven't been answered, which happens when a session was saved while
+// running them
+func pendingCalls(history []*genai.Content) []*genai.FunctionCall {
+	if len(history) == 0 || history[len(history)-1].Role != genai.RoleModel {
+		return nil
+	}
+	var calls []*genai.FunctionCall
+	for _, part := range history[len(history)-1].Parts {
+		if part.FunctionCall != nil {
+			calls = append(calls, part.FunctionCall)
+		}
+	}
+	return calls
+}
+
+// ranResults returns the saved results of the pending calls that ran
+func (a *Agent) ranResults(calls []*genai.FunctionCall) []*genai.FunctionResponse {
+	if a.session.Pending == nil {
+		return nil
+	}
+	ran := a.session.Pending.Results
+	if len(ran) > len(calls) {
+		ran = ran[:len(calls)]
+	}
+	return ran
+}
+
+// unansweredToolParts answers tool calls a crash left pending, for when the
+// next message is sent without resuming them: with their results when they
+// ran, and as not run otherwise. The model needs every call answered.
+func (a *Agent) unansweredToolParts() []*genai.Part {
+	calls := pendingCalls(a.history)
+	ran := a.ranResults(calls)
+	var parts []*genai.Part
+	for i, call := range calls {
+		response := &genai.FunctionResponse{
+			ID:       call.ID,
+			Name:     call.Name,
+			Response: toolErrorResult(&toolError{Code: errCancelled, Message: "not run, codegent stopped before running it"}),
+		}
+		if i < len(ran) {
+			response = ran[i]
+		}
+		parts = append(parts, &genai.Part{FunctionResponse: response})
+	}
+	return parts
+}
+
+// resumeRequest continues the request a resumed session was saved in the
+// middle of: the tool calls that hadn't run are run, asking for approval
+// again, or the message is sent again if the model never answered it.
+// Files changed under a journal, as by refactor, are offered for review.
+func (a *Agent) resumeRequest(ctx context.Context, modelConfig *genai.GenerateContentConfig) error {
+	pending := a.session.Pending
+	if calls := pendingCalls(a.history); len(calls) > 0 {
+		ran := a.ranResults(calls)
+		fmt.Fprintln(a.out, paint(roleNotice, tr("Resuming the last request, %d of %d tool calls left to run", len(calls)-len(ran), len(calls))))
+		last := a.history[len(a.history)-1]
+		resp := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{Content: last}}}
+		if _, err := a.runToolCalls(ctx, modelConfig, resp, ran); err != nil {
+			return err
+		}
+	} else if pending != nil && pending.Message != "" {
+		fmt.Fprintln(a.out, paint(roleNotice, tr("Resuming the last request, sending it again")))
+		a.attachments = pending.Attachments
+		if _, err := a.handleRequest(ctx, modelConfig, pending.Message); err != nil {
+			return err
+		}
+	}
+
+	if len(a.fileTracker().changes()) > 0 {
+		return a.reviewChanges()
+	}
+	return nil
+}
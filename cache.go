@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/genai"
+)
+
+// minCacheTokens is the smallest prefix Gemini accepts for context caching.
+// Smaller prefixes are sent uncached.
+const minCacheTokens = 4096
+
+// cacheIndex maps a hash of the static prompt prefix to the name of the
+// cached content holding it, so later sessions can reuse the same cache
+type cacheIndex map[string]string
+
+func cacheIndexPath() (string, error) {
+	dir, err := codegentDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cache.json"), nil
+}
+
+func loadCacheIndex() cacheIndex {
+	index := cacheIndex{}
+	path, err := cacheIndexPath()
+	if err != nil {
+		return index
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return index
+	}
+	json.Unmarshal(data, &index)
+	return index
+}
+
+func (c cacheIndex) save() error {
+	path, err := cacheIndexPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// prefixKey hashes everything that goes into the cached prefix
+func prefixKey(modelName string, modelConfig *genai.GenerateContentConfig) (string, []byte, error) {
+	prefix, err := json.Marshal(struct {
+		Model             string
+		SystemInstruction *genai.Content
+		Tools             []*genai.Tool
+	}{modelName, modelConfig.SystemInstruction, modelConfig.Tools})
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(prefix)
+	return hex.EncodeToString(sum[:]), prefix, nil
+}
+
+// useContextCache moves the system instruction and tools of modelConfig into
+// Gemini cached content instead of resending them every turn. A live cache
+// for the same prefix is reused and its TTL extended, otherwise a new one is
+// created. Prefixes too small to cache are left alone.
+func (a *Agent) useContextCache(ctx context.Context, modelConfig *genai.GenerateContentConfig) error {
+	key, prefix, err := prefixKey(a.config.Model, modelConfig)
+	if err != nil {
+		return err
+	}
+	// Roughly 4 bytes per token
+	if len(prefix)/4 < minCacheTokens {
+		return nil
+	}
+
+	index := loadCacheIndex()
+
+	var cc *genai.CachedContent
+	if name, ok := index[key]; ok {
+		if _, err := a.client.Caches.Get(ctx, name, nil); err == nil {
+			cc, err = a.client.Caches.Update(ctx, name, &genai.UpdateCachedContentConfig{TTL: a.config.CacheTTL})
+			if err != nil {
+				cc = nil
+			}
+		}
+	}
+
+	if cc == nil {
+		cc, err = a.client.Caches.Create(ctx, a.config.Model, &genai.CreateCachedContentConfig{
+			TTL:               a.config.CacheTTL,
+			DisplayName:       "codegent",
+			SystemInstruction: modelConfig.SystemInstruction,
+			Tools:             modelConfig.Tools,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create context cache: %w", err)
+		}
+		index[key] = cc.Name
+		if err := index.save(); err != nil {
+			return err
+		}
+	}
+
+	// Requests using cached content must not repeat what it holds
+	modelConfig.CachedContent = cc.Name
+	modelConfig.SystemInstruction = nil
+	modelConfig.Tools = nil
+	return nil
+}
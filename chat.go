@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ansiEscape = regexp.MustCompile("\u001b\\[[0-9;]*m")
+
+// chatOutput collects the agent's output and posts it to a chat, batched so
+// tool calls don't become one message each. Colors are stripped and long
+// output is split into messages of at most limit bytes.
+type chatOutput struct {
+	post  func(text string) error
+	limit int
+
+	mu       sync.Mutex
+	buf      strings.Builder
+	lastPost time.Time
+}
+
+func (o *chatOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	o.buf.Write(p)
+	due := time.Since(o.lastPost) > 3*time.Second && strings.HasSuffix(o.buf.String(), "\n")
+	o.mu.Unlock()
+	if due {
+		o.flush()
+	}
+	return len(p), nil
+}
+
+// take returns everything written so far without posting it
+func (o *chatOutput) take() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	text := strings.TrimSpace(ansiEscape.ReplaceAllString(o.buf.String(), ""))
+	o.buf.Reset()
+	o.lastPost = time.Now()
+	return text
+}
+
+// flush posts everything written so far
+func (o *chatOutput) flush() {
+	o.send(o.take())
+}
+
+// send posts text, split into messages of at most limit bytes
+func (o *chatOutput) send(text string) {
+	for text != "" {
+		chunk := text
+		if len(chunk) > o.limit {
+			chunk = chunk[:o.limit]
+			if i := strings.LastIndexByte(chunk, '\n'); i > 0 {
+				chunk = chunk[:i]
+			}
+		}
+		text = strings.TrimSpace(text[len(chunk):])
+		if err := o.post(chunk); err != nil {
+			log.Println("failed to post output:", err)
+			return
+		}
+	}
+}
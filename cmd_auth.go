@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ergochat/readline"
+	"github.com/joho/godotenv"
+)
+
+const authUsage = `usage: codegent auth <command>
+
+Commands:
+  login [provider]      Store an API key or token in the OS keychain
+  logout <provider>     Remove it from the keychain
+  status                Show where each credential comes from
+
+Providers: gemini (the default), slack-app, slack-bot, telegram, jira,
+linear, github, gitlab, and google to sign in with a Google account and
+use its Code Assist quota`
+
+// runAuthCommand handles `codegent auth ...`
+func runAuthCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", authUsage)
+	}
+
+	switch args[0] {
+	case "login":
+		name := "gemini"
+		if len(args) > 1 {
+			name = args[1]
+		}
+		return authLogin(name)
+	case "logout":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: codegent auth logout <provider>")
+		}
+		return authLogout(args[1])
+	case "status":
+		return authStatus()
+	default:
+		return fmt.Errorf("unknown auth command %q\n\n%s", args[0], authUsage)
+	}
+}
+
+func authProviderNamed(name string) (authProvider, error) {
+	provider, ok := findProvider(name)
+	if !ok {
+		return provider, fmt.Errorf("unknown provider %q\n\n%s", name, authUsage)
+	}
+	return provider, nil
+}
+
+// authLogin reads a credential without echoing it, or from stdin when that
+// isn't a terminal, and stores it in the keychain
+func authLogin(name string) error {
+	provider, err := authProviderNamed(name)
+	if err != nil {
+		return err
+	}
+	if provider.name == "google" {
+		return authLoginGoogle()
+	}
+
+	var value []byte
+	if isTerminal(os.Stdin) {
+		rl, err := readline.NewFromConfig(&readline.Config{})
+		if err != nil {
+			return err
+		}
+		value, err = rl.ReadPassword(provider.label + ": ")
+		rl.Close()
+		if err != nil {
+			return err
+		}
+	} else if value, err = io.ReadAll(os.Stdin); err != nil {
+		return err
+	}
+	key := strings.TrimSpace(string(value))
+	if key == "" {
+		return fmt.Errorf("no %s given", provider.label)
+	}
+
+	if err := keychainSet(provider.name, "codegent "+provider.label, key); err != nil {
+		return err
+	}
+	fmt.Printf("Stored the %s in the keychain\n", provider.label)
+	if source := envSource(provider); source != "" {
+		fmt.Printf("The %s in %s is used instead, remove it from there\n", provider.label, source)
+	}
+	return nil
+}
+
+// authLoginGoogle signs in with a Google account and sets up its Code
+// Assist project, then keeps the sign-in in the keychain
+func authLoginGoogle() error {
+	ctx := context.Background()
+	login, err := signInWithGoogle(ctx)
+	if err != nil {
+		return err
+	}
+	if err := setupCodeAssist(ctx, login); err != nil {
+		return err
+	}
+	if err := login.save(); err != nil {
+		return err
+	}
+	fmt.Printf("Signed in as %s, using the Code Assist project %s\n", login.Email, login.Project)
+	if secret("gemini") != "" {
+		fmt.Println("A Gemini API key is set as well and is used instead, see codegent auth status")
+	}
+	return nil
+}
+
+func authLogout(name string) error {
+	provider, err := authProviderNamed(name)
+	if err != nil {
+		return err
+	}
+	if err := keychainDelete(provider.name); err != nil {
+		return err
+	}
+	fmt.Printf("Removed the %s from the keychain\n", provider.label)
+	return nil
+}
+
+// authStatus lists where codegent gets each credential from, with its last
+// characters to tell keys apart
+func authStatus() error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tSOURCE\tKEY")
+	var keychainErr error
+	for _, provider := range authProviders {
+		stored, err := keychainGet(provider.name)
+		if err != nil && !errors.Is(err, errNotInKeychain) {
+			keychainErr = err
+		}
+
+		source, value := "not set", ""
+		if provider.name == "google" {
+			if login := loadGoogleLogin(); login != nil {
+				source, value = "keychain", login.Email
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", provider.name, source, value)
+			continue
+		}
+		if env := envSource(provider); env != "" {
+			source, value = env, os.Getenv(provider.env)
+			if stored != "" {
+				source += ", overriding the keychain"
+			}
+		} else if stored != "" {
+			source, value = "keychain", stored
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", provider.name, source, maskSecret(value))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if keychainErr != nil {
+		fmt.Printf("\nThe keychain can't be read: %v\n", keychainErr)
+	}
+	return nil
+}
+
+// envSource says where a provider's environment variable is set, the .env
+// file or the environment, "" when it isn't
+func envSource(provider authProvider) string {
+	value := os.Getenv(provider.env)
+	if value == "" {
+		return ""
+	}
+	if dotenv, err := godotenv.Read(); err == nil && dotenv[provider.env] == value {
+		return ".env (" + provider.env + ")"
+	}
+	return "environment (" + provider.env + ")"
+}
+
+// maskSecret shows only the end of a credential
+func maskSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	if len(value) <= 8 {
+		return "****"
+	}
+	return "…" + value[len(value)-4:]
+}
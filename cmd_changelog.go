@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// conventionalType matches the type prefix of a conventional commit subject,
+// e.g. "feat(api)!: ..."
+var conventionalType = regexp.MustCompile(`^(\w+)(\([^)]*\))?!?:\s*`)
+
+type commitInfo struct {
+	Hash    string
+	Subject string
+	Body    string
+}
+
+// runChangelogCommand handles `codegent changelog [flags]`, which drafts
+// changelog entries from the git history since a tag. The draft is printed,
+// or with --write added to CHANGELOG.md through the usual edit tools.
+func runChangelogCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent changelog", flag.ContinueOnError)
+	since := fs.String("since", "", "tag or commit to start from (default: the latest tag)")
+	version := fs.String("version", "Unreleased", "heading for the new entry")
+	write := fs.Bool("write", false, "add the entry to the changelog file instead of printing it")
+	file := fs.String("file", "CHANGELOG.md", "changelog file to update with --write")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *since == "" {
+		if tag, err := gitOutput(ctx, "describe", "--tags", "--abbrev=0"); err == nil {
+			*since = tag
+		}
+	}
+	commits, err := gitCommits(ctx, *since)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("no commits since %s", *since)
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "changelog " + *version
+
+	var prompt strings.Builder
+	rangeName := "the beginning of the history"
+	if *since != "" {
+		rangeName = *since
+	}
+	fmt.Fprintf(&prompt, "Draft a changelog entry headed %q for the %d commits since %s, grouped by their type:\n\n%s\n", *version, len(commits), rangeName, groupCommits(commits))
+	prompt.WriteString(`Write it in the Keep a Changelog style, with ### Added, ### Changed, ### Deprecated, ### Removed, ### Fixed and ### Security subsections as needed. Describe user-visible changes in plain words, merge commits that belong to the same change, and leave out purely internal ones such as refactors, CI and test changes.`)
+
+	if !*write {
+		config.Approvals = ApprovalPlan
+		noInput := func() (string, bool) { return "", false }
+		agent := NewAgent(client, noInput, defaultTools(), session, config)
+		agent.out = os.Stderr
+		answer, err := agent.handleRequest(ctx, agent.newModelConfig(ctx), prompt.String()+" Reply with only the Markdown of the entry.")
+		if err != nil {
+			return err
+		}
+		fmt.Println(answer)
+		return nil
+	}
+
+	fmt.Fprintf(&prompt, "\n\nAdd the entry to %s above the previous release, creating the file with a short header if it doesn't exist. If it already has a %q section, update that section instead of adding another.", *file, *version)
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+	_, err = agent.handleRequest(ctx, agent.newModelConfig(ctx), prompt.String())
+	return err
+}
+
+// gitCommits lists the non-merge commits after since, oldest first. An empty
+// since means the whole history.
+func gitCommits(ctx context.Context, since string) ([]commitInfo, error) {
+	args := []string{"log", "--no-merges", "--reverse", "--format=%h%x1f%s%x1f%b%x1e"}
+	if since != "" {
+		args = append(args, since+"..HEAD")
+	}
+	out, err := gitOutput(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitInfo
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		commits = append(commits, commitInfo{Hash: fields[0], Subject: fields[1], Body: strings.TrimSpace(fields[2])})
+	}
+	return commits, nil
+}
+
+// groupCommits lists commits under their conventional commit type, with
+// commits not following the convention under "other"
+func groupCommits(commits []commitInfo) string {
+	var order []string
+	groups := make(map[string][]string)
+	for _, commit := range commits {
+		kind := "other"
+		if m := conventionalType.FindStringSubmatch(commit.Subject); m != nil {
+			kind = strings.ToLower(m[1])
+		}
+		if _, ok := groups[kind]; !ok {
+			order = append(order, kind)
+		}
+		line := fmt.Sprintf("- %s %s", commit.Hash, commit.Subject)
+		if commit.Body != "" {
+			line += "\n  " + strings.ReplaceAll(truncateOutput(commit.Body, 200), "\n", "\n  ")
+		}
+		groups[kind] = append(groups[kind], line)
+	}
+
+	var sb strings.Builder
+	for _, kind := range order {
+		fmt.Fprintf(&sb, "%s:\n%s\n\n", kind, strings.Join(groups[kind], "\n"))
+	}
+	return sb.String()
+}
+
+// gitOutput runs git and returns its trimmed standard output
+func gitOutput(ctx context.Context, args ...string) (string, error) {
+	return gitIn(ctx, "", args...)
+}
+
+// gitIn runs git in dir, the working directory when empty, and returns its
+// trimmed standard output
+func gitIn(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
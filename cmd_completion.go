@@ -0,0 +1,341 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const completionUsage = `usage: codegent completion <bash|zsh|fish|powershell>
+
+Prints a script that completes subcommands, flags, session names and
+models. Load it in your shell's startup file:
+
+  bash        source <(codegent completion bash)
+  zsh         source <(codegent completion zsh)
+  fish        codegent completion fish | source
+  powershell  codegent completion powershell | Out-String | Invoke-Expression`
+
+// runCompletionCommand handles `codegent completion ...`. The scripts ask
+// `codegent completion __complete <words>` for the candidates, so sessions
+// are looked up as they are typed.
+func runCompletionCommand(args []string) error {
+	if len(args) > 0 && args[0] == "__complete" {
+		for _, candidate := range completions(args[1:]) {
+			fmt.Println(candidate)
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("%s", completionUsage)
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown shell %q\n\n%s", args[0], completionUsage)
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// commandGroups are the subcommands that have subcommands of their own
+var commandGroups = map[string][]string{
+	"sessions":   {"list", "show", "rename", "delete"},
+	"history":    {"grep", "show"},
+	"hook":       {"pre-commit", "install"},
+	"auth":       {"login", "logout", "status"},
+	"completion": {"bash", "zsh", "fish", "powershell"},
+	"queue":      {"add", "list", "run", "show", "retry", "remove"},
+	"schedule":   {"add", "list", "run", "remove"},
+	"workflow":   {"list", "show", "run"},
+}
+
+// commandFlags are the flags commands define besides parseFlags' ones. A
+// trailing = marks the flags that take a value.
+var commandFlags = map[string][]string{
+	"run":             {"schema=", "done-when="},
+	"refactor":        {"check="},
+	"docs":            {"readme="},
+	"changelog":       {"since=", "version=", "write", "file="},
+	"hook pre-commit": {"timeout=", "local", "fix"},
+	"hook install":    {"force"},
+	"watch":           {"interval=", "test", "test-command="},
+	"serve":           {"addr=", "ui", "idle-timeout=", "grpc="},
+	"slack":           {"policies="},
+	"telegram":        {"allow="},
+	"usage":           {"by=", "since="},
+	"history grep":    {"limit="},
+	"upgrade":         {"check", "force"},
+	"queue run":       {"parallel="},
+	"schedule run":    {"loop"},
+	"workflow run":    {"from="},
+	"migrate":         {"dir=", "tool=", "schema=", "database=", "driver=", "scratch=", "name="},
+	"resolve":         {"check="},
+}
+
+// completions returns the candidates for the last of words, the command line
+// after "codegent". An empty list leaves it to the shell to complete files.
+func completions(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	prev, cur := words[:len(words)-1], words[len(words)-1]
+	// bash splits --model=gemini into "--model", "=" and "gemini"
+	if cur == "=" {
+		prev, cur = append(prev[:len(prev):len(prev)], "="), ""
+	}
+	if n := len(prev); n >= 2 && prev[n-1] == "=" {
+		prev = prev[:n-1]
+	}
+
+	// Find the command and the arguments given so far
+	command, valueOf := "", ""
+	var positional []string
+	for _, word := range prev {
+		switch {
+		case valueOf != "":
+			valueOf = ""
+		case strings.HasPrefix(word, "-") && word != "-":
+			name := strings.TrimLeft(word, "-")
+			if !strings.Contains(name, "=") && takesValue(command, name) {
+				valueOf = name
+			}
+		case command == "" && len(positional) == 0 && isCommand(word):
+			command = word
+		case len(positional) == 0 && slices.Contains(commandGroups[command], word):
+			command += " " + word
+		default:
+			positional = append(positional, word)
+		}
+	}
+
+	if valueOf != "" {
+		return matching(flagValues(valueOf), cur)
+	}
+	if name, value, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(name, "-") {
+		var candidates []string
+		for _, candidate := range matching(flagValues(strings.TrimLeft(name, "-")), value) {
+			candidates = append(candidates, name+"="+candidate)
+		}
+		return candidates
+	}
+	if strings.HasPrefix(cur, "-") {
+		return matching(commandFlagNames(command), cur)
+	}
+	if command == "" && len(positional) == 0 {
+		return matching(commandNames(), cur)
+	}
+	if subcommands, ok := commandGroups[command]; ok && len(positional) == 0 {
+		return matching(subcommands, cur)
+	}
+	switch {
+	case command == "new" && len(positional) == 0:
+		return matching(templateNames(), cur)
+	case (command == "auth login" || command == "auth logout") && len(positional) == 0:
+		var names []string
+		for _, provider := range authProviders {
+			names = append(names, provider.name)
+		}
+		return matching(names, cur)
+	case (command == "sessions show" || command == "sessions rename" || command == "sessions delete" || command == "history show") && len(positional) == 0:
+		return matching(sessionNames(), cur)
+	case (command == "workflow show" || command == "workflow run") && len(positional) == 0:
+		return matching(workflowNames(), cur)
+	}
+	return nil
+}
+
+// commandNames lists the subcommands of codegent
+func commandNames() []string {
+	var names []string
+	for name := range localCommands {
+		names = append(names, name)
+	}
+	for name := range taskCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isCommand(word string) bool {
+	return slices.Contains(commandNames(), word)
+}
+
+// takesConfig reports whether a command is set up with parseFlags
+func takesConfig(command string) bool {
+	switch command {
+	case "", "hook pre-commit", "queue run", "schedule run", "workflow run":
+		return true
+	case "hook", "queue", "schedule", "workflow":
+		return false
+	}
+	_, ok := taskCommands[command]
+	return ok
+}
+
+// configFlags returns parseFlags' flags. It defines them all before parsing,
+// and -h stops it right there.
+func configFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("codegent", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	parseFlags(fs, []string{"-h"})
+	return fs
+}
+
+// commandFlagNames lists the flags of a command as --name
+func commandFlagNames(command string) []string {
+	var names []string
+	if takesConfig(command) {
+		configFlags().VisitAll(func(f *flag.Flag) {
+			names = append(names, "--"+f.Name)
+		})
+	}
+	for _, name := range commandFlags[command] {
+		names = append(names, "--"+strings.TrimSuffix(name, "="))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// takesValue reports whether a command's flag is followed by a value
+func takesValue(command, name string) bool {
+	if takesConfig(command) {
+		if f := configFlags().Lookup(name); f != nil {
+			boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+			return !ok || !boolFlag.IsBoolFlag()
+		}
+	}
+	return slices.Contains(commandFlags[command], name+"=")
+}
+
+// flagValues returns the values a flag takes, nil for files and values
+// that can't be listed
+func flagValues(name string) []string {
+	switch name {
+	case "model", "fallback-models":
+		return modelNames()
+	case "approvals":
+		return []string{string(ApprovalPlan), string(ApprovalDefault), string(ApprovalAutoEdit), string(ApprovalYolo)}
+	case "notify":
+		return []string{notifyBell, notifyDesktop, notifyOff}
+	case "by":
+		return []string{"day", "week", "session"}
+	case "tool":
+		return migrationTools
+	case "driver":
+		return []string{"postgres", "sqlite"}
+	case "database":
+		databases, _ := loadDatabases()
+		var names []string
+		for name := range databases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
+
+// modelNames lists the Gemini models codegent knows the prices of
+func modelNames() []string {
+	var names []string
+	for _, price := range modelPrices {
+		if price.prefix != ollamaPrefix {
+			names = append(names, price.prefix)
+		}
+	}
+	return names
+}
+
+// sessionNames lists the IDs and titles of saved sessions, most recent first
+func sessionNames() []string {
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, s := range sessions {
+		names = append(names, s.ID)
+		if s.Title != "" {
+			names = append(names, s.Title)
+		}
+	}
+	return names
+}
+
+func matching(candidates []string, prefix string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
+
+// completionScripts hand the words on the command line to __complete and
+// fall back to completing files when it has nothing to offer
+var completionScripts = map[string]string{
+	"bash": `# bash completion for codegent, load it with: source <(codegent completion bash)
+_codegent() {
+	local IFS=$'\n' candidate
+	COMPREPLY=()
+	for candidate in $("${COMP_WORDS[0]}" completion __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null); do
+		COMPREPLY+=("$(printf '%q' "$candidate")")
+	done
+}
+complete -o default -F _codegent codegent
+`,
+	"zsh": `#compdef codegent
+# zsh completion for codegent, load it with: source <(codegent completion zsh)
+# or save it as _codegent in a directory of your $fpath
+_codegent() {
+	local -a candidates
+	candidates=("${(@f)$("${words[1]}" completion __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n ${candidates[1]} ]]; then
+		compadd -- "${candidates[@]}"
+	else
+		_files
+	fi
+}
+if [[ ${zsh_eval_context[-1]} == loadautofunc ]]; then
+	_codegent "$@"
+else
+	compdef _codegent codegent
+fi
+`,
+	"fish": `# fish completion for codegent, load it with: codegent completion fish | source
+# or save it as ~/.config/fish/completions/codegent.fish
+function __codegent_complete
+	set -l args (commandline -opc)
+	set -e args[1]
+	set -l current (commandline -ct)
+	set -l candidates (codegent completion __complete $args "$current" 2>/dev/null)
+	if test (count $candidates) -gt 0
+		printf '%s\n' $candidates
+	else
+		__fish_complete_path "$current"
+	end
+end
+complete -c codegent -f -a '(__codegent_complete)'
+`,
+	"powershell": `# PowerShell completion for codegent, load it with:
+#   codegent completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName codegent, codegent.exe -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements | Where-Object { $_.Extent.StartOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+	if ($wordToComplete -eq '') {
+		# PowerShell before 7.3 drops empty arguments to native commands
+		if ($PSVersionTable.PSVersion -lt [version]'7.3.0' -or $PSNativeCommandArgumentPassing -eq 'Legacy') { $words += '""' } else { $words += '' }
+	}
+	& $words[0] completion __complete @($words | Select-Object -Skip 1) 2>$null | ForEach-Object {
+		$text = $_
+		if ($text -match '\s') { $text = "'" + ($text -replace "'", "''") + "'" }
+		[System.Management.Automation.CompletionResult]::new($text, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// API sections in READMEs are delimited by these markers, so they can be
+// regenerated without touching the rest of the file
+const (
+	apiSectionStart = "<!-- codegent:api -->"
+	apiSectionEnd   = "<!-- /codegent:api -->"
+)
+
+// runDocsCommand handles `codegent docs [flags] [dir]`. The agent adds
+// godoc-style comments to exported Go declarations that lack one, and with
+// --readme also writes an API section into a README. The changes are shown
+// as one diff that the user keeps or reverts.
+func runDocsCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent docs", flag.ContinueOnError)
+	readme := fs.String("readme", "", "README file whose API section to generate or update")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	root := "."
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	index, err := buildSymbolIndex(ctx, root)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, def := range index.defs {
+		// Doc comments are only generated for Go
+		if def.Exported && !def.HasDoc && strings.HasSuffix(def.File, ".go") && !strings.HasSuffix(def.File, "_test.go") {
+			name := def.Name
+			if def.Recv != "" {
+				name = def.Recv + "." + name
+			}
+			missing = append(missing, fmt.Sprintf("- %s %s at %s:%d", def.Kind, name, filepath.Join(root, def.File), def.Line))
+		}
+	}
+	if len(missing) == 0 && *readme == "" {
+		fmt.Println("Every exported declaration has a doc comment.")
+		return nil
+	}
+
+	if !flagSet(fs, "approvals") && !startEnv["CODEGENT_APPROVALS"] {
+		config.Approvals = ApprovalAutoEdit
+	}
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "docs " + root
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+
+	var prompt strings.Builder
+	if len(missing) > 0 {
+		fmt.Fprintf(&prompt, `These exported Go declarations have no doc comment:
+
+%s
+
+Read each one and add a godoc-style comment directly above it: full sentences starting with the declared name, saying what it does or represents rather than how. Match the tone of existing comments in the file. Only add comments, don't change any code. When inserting by line number, work from the bottom of each file up so earlier line numbers stay valid.
+`, strings.Join(missing, "\n"))
+	}
+	if *readme != "" {
+		fmt.Fprintf(&prompt, `
+Then write an API overview of the package in %s: its purpose and the main exported types and functions with short usage examples. Put it between the lines %s and %s, replacing what is between them if they already exist, or append them as a new "API" section at the end otherwise. Leave the rest of the file unchanged.
+`, *readme, apiSectionStart, apiSectionEnd)
+	}
+
+	fileVersions.startJournal()
+	if _, err := agent.handleRequest(ctx, agent.newModelConfig(ctx), prompt.String()); err != nil {
+		return err
+	}
+	return agent.reviewChanges()
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxExplainRefs bounds how many callers and types are listed as context
+const maxExplainRefs = 20
+
+// runExplainCommand handles `codegent explain [flags] <file>[:line]`, which
+// prints a structured explanation of a file, or of the declaration at the
+// given line, to stdout. The agent can only read files.
+func runExplainCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent explain", flag.ContinueOnError)
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: codegent explain [flags] <file>[:line]")
+	}
+	path, line, err := parseTarget(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	config.Approvals = ApprovalPlan
+	noInput := func() (string, bool) { return "", false }
+	session := NewSession()
+	session.Title = "explain " + fs.Arg(0)
+	agent := NewAgent(client, noInput, defaultTools(), session, config)
+	agent.out = os.Stderr
+
+	answer, err := agent.handleRequest(ctx, agent.newModelConfig(ctx), explainPrompt(path, line, content))
+	if err != nil {
+		return err
+	}
+	fmt.Println(answer)
+	return nil
+}
+
+// parseTarget splits "file.go:123" into the path and line, 0 if absent
+func parseTarget(target string) (string, int, error) {
+	path, lineStr, found := strings.Cut(target, ":")
+	if !found {
+		return target, 0, nil
+	}
+	line, err := strconv.Atoi(lineStr)
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid line in %q", target)
+	}
+	return path, line, nil
+}
+
+// explainPrompt asks for the explanation, preloading the target and, for a
+// Go declaration, its callers and the types it uses
+func explainPrompt(path string, line int, content []byte) string {
+	var sb strings.Builder
+	target := path
+	if line > 0 {
+		target = fmt.Sprintf("line %d of %s", line, path)
+	}
+	fmt.Fprintf(&sb, "Explain %s to a developer who is new to this codebase.\n\n", target)
+	fmt.Fprintf(&sb, "## %s\n\n%s\n", path, truncateOutput(numberLines(string(content), 1), 8000))
+
+	if strings.HasSuffix(path, ".go") && line > 0 {
+		sb.WriteString(goDeclContext(path, line, content))
+	}
+
+	sb.WriteString(`
+Look up anything else you need with the tools. Answer in Markdown with these sections:
+## Summary
+What it is for, in two or three sentences.
+## How it works
+The main steps or logic, referring to line numbers.
+## Inputs and outputs
+Parameters, return values, side effects and errors.
+## Related code
+Callers, the types it uses, and where to look next.
+## Gotchas
+Anything surprising, fragile or easy to get wrong.`)
+	return sb.String()
+}
+
+// goDeclContext describes the declaration enclosing line in a Go file: where
+// it's used and which declared types it refers to
+func goDeclContext(path string, line int, content []byte) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, content, parser.SkipObjectResolution)
+	if err != nil {
+		return ""
+	}
+	var decl ast.Decl
+	for _, d := range file.Decls {
+		if fset.Position(d.Pos()).Line <= line && line <= fset.Position(d.End()).Line {
+			decl = d
+			break
+		}
+	}
+	if decl == nil {
+		return ""
+	}
+	var name *ast.Ident
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		name = d.Name
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				name = spec.Name
+			case *ast.ValueSpec:
+				name = spec.Names[0]
+			}
+			if name != nil {
+				break
+			}
+		}
+	}
+	if name == nil {
+		return ""
+	}
+
+	index, err := buildSymbolIndex(context.Background(), ".")
+	if err != nil {
+		return ""
+	}
+	var sb strings.Builder
+	start, end := fset.Position(decl.Pos()).Line, fset.Position(decl.End()).Line
+	fmt.Fprintf(&sb, "\nThe line is in the declaration of %s (lines %d-%d).\n", name.Name, start, end)
+
+	self := filepath.Clean(path)
+	_, refs := index.lookup(name.Name)
+	var callers []string
+	for _, ref := range refs {
+		if ref.File == self && start <= ref.Line && ref.Line <= end {
+			continue
+		}
+		callers = append(callers, fmt.Sprintf("- %s:%d", ref.File, ref.Line))
+	}
+	if len(callers) > 0 {
+		sb.WriteString("\nUses of " + name.Name + " (by name):\n")
+		sb.WriteString(strings.Join(callers[:min(len(callers), maxExplainRefs)], "\n") + "\n")
+	}
+
+	seen := map[string]bool{name.Name: true}
+	var types []string
+	ast.Inspect(decl, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || seen[ident.Name] || len(types) >= maxExplainRefs {
+			return true
+		}
+		seen[ident.Name] = true
+		defs, _ := index.lookup(ident.Name)
+		for _, def := range defs {
+			if def.Kind == "type" {
+				types = append(types, fmt.Sprintf("- %s at %s:%d", def.Name, def.File, def.Line))
+			}
+		}
+		return true
+	})
+	if len(types) > 0 {
+		sb.WriteString("\nTypes it uses, declared in this repository:\n")
+		sb.WriteString(strings.Join(types, "\n") + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const historyUsage = `usage: codegent history <command>
+
+Commands:
+  grep [--limit n] <words>   Find messages containing all the words in saved sessions
+  show <id|title> <n>        Print the exchange around message n of a session`
+
+// runHistoryCommand handles `codegent history ...`
+func runHistoryCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", historyUsage)
+	}
+
+	switch args[0] {
+	case "grep", "search":
+		flags := flag.NewFlagSet("codegent history grep", flag.ContinueOnError)
+		limit := flags.Int("limit", 20, "show at most this many matches (0 = all)")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		terms := searchTerms(strings.Join(flags.Args(), " "))
+		hits, err := searchHistory(terms, *limit)
+		if err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			fmt.Println("No matches")
+			return nil
+		}
+		printHits(os.Stdout, hits, terms)
+		fmt.Println("\nShow one with: codegent history show <session> <#>")
+		return nil
+	case "show":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: codegent history show <id|title> <n>")
+		}
+		session, err := FindSession(args[1])
+		if err != nil {
+			return err
+		}
+		index, err := strconv.Atoi(strings.TrimPrefix(args[2], "#"))
+		if err != nil || index < 0 || index >= len(session.History) {
+			return fmt.Errorf("session %s has messages 0 to %d", session.DisplayName(), len(session.History)-1)
+		}
+		start, end := historyHit{session: session, index: index}.exchange()
+		fmt.Printf("=== %s (%s) #%d ===\n", session.DisplayName(), session.CreatedAt.Format("2006-01-02 15:04"), index)
+		for _, msg := range session.History[start:end] {
+			printMessage(os.Stdout, msg)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown history command %q\n\n%s", args[0], historyUsage)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// hookFinding is a problem found in the staged changes
+type hookFinding struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity" jsonschema:"enum=error,enum=warning"`
+	Message  string `json:"message"`
+	Fixable  bool   `json:"fixable,omitempty"`
+}
+
+type hookReview struct {
+	Findings []hookFinding `json:"findings"`
+}
+
+// hookPattern is a cheap local check run on every added line
+type hookPattern struct {
+	re       *regexp.Regexp
+	severity string
+	message  string
+}
+
+var hookPatterns = []hookPattern{
+	{regexp.MustCompile(`AKIA[0-9A-Z]{16}`), "error", "looks like an AWS access key"},
+	{regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`), "error", "looks like a Google API key"},
+	{regexp.MustCompile(`gh[pousr]_[A-Za-z0-9]{36}`), "error", "looks like a GitHub token"},
+	{regexp.MustCompile(`xox[abprs]-[A-Za-z0-9-]{10,}`), "error", "looks like a Slack token"},
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`), "error", "private key"},
+	{regexp.MustCompile(`(?i)(api[_-]?key|secret|token|passw(or)?d)["']?\s*[:=]\s*["'][^"'\s]{12,}["']`), "error", "hardcoded secret"},
+	{regexp.MustCompile(`^(<<<<<<<|>>>>>>>)( |$)`), "error", "merge conflict marker"},
+	{regexp.MustCompile(`\bconsole\.log\(|\bdebugger;|\bpdb\.set_trace\(\)|\bbreakpoint\(\)|\bbinding\.pry\b|\bdbg!\(|\bspew\.Dump\(`), "warning", "debug statement"},
+}
+
+// runHookCommand handles `codegent hook <pre-commit|install>`
+func runHookCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: codegent hook <pre-commit|install> [flags]")
+	}
+	switch args[0] {
+	case "pre-commit":
+		return runPreCommitHook(ctx, args[1:])
+	case "install":
+		return installPreCommitHook(args[1:])
+	default:
+		return fmt.Errorf("unknown hook %q", args[0])
+	}
+}
+
+// runPreCommitHook reviews the staged changes, first with local checks for
+// secrets, conflict markers and debug statements, then with the model. Any
+// error-level finding blocks the commit. A failing model call only warns, so
+// an outage never blocks committing.
+func runPreCommitHook(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent hook pre-commit", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 30*time.Second, "time limit for the model review")
+	local := fs.Bool("local", false, "only run the local checks, without the model")
+	fix := fs.Bool("fix", false, "let the agent fix trivial findings in fully staged files and restage them")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	diff, err := gitOutput(ctx, "diff", "--cached", "--no-color", "-U3", "--diff-filter=ACMR")
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		return nil
+	}
+
+	findings := localFindings(diff)
+	var agent *Agent
+	if !*local {
+		client, err := newClient(ctx, config)
+		if err != nil {
+			return err
+		}
+		noInput := func() (string, bool) { return "", false }
+		agent = NewAgent(client, noInput, nil, NewSession(), config)
+		agent.out = os.Stderr
+
+		reviewCtx, cancel := context.WithTimeout(ctx, *timeout)
+		review, err := agent.reviewDiff(reviewCtx, diff)
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "codegent: model review skipped:", err)
+		}
+		findings = append(findings, review...)
+	}
+
+	if *fix && agent != nil {
+		findings = agent.fixFindings(ctx, findings)
+	}
+
+	blocking := 0
+	for _, finding := range findings {
+		role := roleWarn
+		if finding.Severity == "error" {
+			role = roleError
+			blocking++
+		}
+		fmt.Fprintf(os.Stderr, "%s %s:%d: %s\n", paint(role, finding.Severity), finding.File, finding.Line, finding.Message)
+	}
+	if blocking > 0 {
+		return fmt.Errorf("commit blocked by %d problems (bypass with git commit --no-verify)", blocking)
+	}
+	return nil
+}
+
+// localFindings runs hookPatterns over the lines added in diff
+func localFindings(diff string) []hookFinding {
+	var findings []hookFinding
+	file, line := "", 0
+	for _, text := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+		case strings.HasPrefix(text, "@@"):
+			// @@ -a,b +c,d @@
+			if fields := strings.Fields(text); len(fields) > 2 {
+				start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+				line, _ = strconv.Atoi(start)
+			}
+		case strings.HasPrefix(text, "+"):
+			for _, pattern := range hookPatterns {
+				if pattern.re.MatchString(text[1:]) {
+					findings = append(findings, hookFinding{File: file, Line: line, Severity: pattern.severity, Message: pattern.message})
+				}
+			}
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return findings
+}
+
+// reviewDiff asks the model for obvious bugs in the staged diff, as JSON
+func (a *Agent) reviewDiff(ctx context.Context, diff string) ([]hookFinding, error) {
+	schema := GenerateSchema[hookReview]()
+	modelConfig := &genai.GenerateContentConfig{
+		MaxOutputTokens:   2048,
+		SystemInstruction: genai.NewContentFromText(`You review staged changes right before a commit. Report only clear problems in the added lines: obvious bugs, leftover debug code, secrets and credentials, and accidentally committed junk. Use severity "error" only for problems that must not be committed, and set fixable for trivial mechanical fixes. Ignore style and anything you are unsure about. Return no findings for a clean diff.`, genai.RoleUser),
+		ResponseMIMEType:  "application/json",
+		ResponseSchema:    &schema,
+	}
+	resp, err := a.runInference(ctx, modelConfig, TaskReview, genai.NewPartFromText(truncateOutput(diff, 20000)))
+	if err != nil {
+		return nil, err
+	}
+	var review hookReview
+	if err := json.Unmarshal([]byte(resp.Text()), &review); err != nil {
+		return nil, fmt.Errorf("model returned invalid JSON: %w", err)
+	}
+	return review.Findings, nil
+}
+
+// fixFindings has the agent fix the fixable findings in files without
+// unstaged changes, restages them, and returns the findings left
+func (a *Agent) fixFindings(ctx context.Context, findings []hookFinding) []hookFinding {
+	var fixable, remaining []hookFinding
+	for _, finding := range findings {
+		if finding.Fixable {
+			// Fixing a partially staged file would stage the unstaged part too
+			if _, err := gitOutput(ctx, "diff", "--quiet", "--", finding.File); err == nil {
+				fixable = append(fixable, finding)
+				continue
+			}
+		}
+		remaining = append(remaining, finding)
+	}
+	if len(fixable) == 0 {
+		return findings
+	}
+
+	var list strings.Builder
+	files := make(map[string]bool)
+	for _, finding := range fixable {
+		fmt.Fprintf(&list, "- %s:%d: %s\n", finding.File, finding.Line, finding.Message)
+		files[finding.File] = true
+	}
+	a.tools = defaultTools()
+	a.config.Approvals = ApprovalAutoEdit
+	prompt := "Fix these problems with minimal edits and change nothing else:\n\n" + list.String()
+	if _, err := a.handleRequest(ctx, a.newModelConfig(ctx), prompt); err != nil {
+		fmt.Fprintln(os.Stderr, "codegent: auto-fix failed:", err)
+		return findings
+	}
+	for file := range files {
+		if _, err := gitOutput(ctx, "add", "--", file); err != nil {
+			fmt.Fprintln(os.Stderr, "codegent:", err)
+			return findings
+		}
+	}
+	fmt.Fprintf(os.Stderr, "codegent: fixed and restaged %d problems\n", len(fixable))
+	return remaining
+}
+
+// installPreCommitHook writes a git pre-commit hook running this binary
+func installPreCommitHook(args []string) error {
+	fs := flag.NewFlagSet("codegent hook install", flag.ContinueOnError)
+	force := fs.Bool("force", false, "replace an existing pre-commit hook")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	hooksDir, err := gitOutput(context.Background(), "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(hooksDir, "pre-commit")
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use --force to replace it", path)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return err
+	}
+	command := strings.Join(append([]string{strconv.Quote(exe), "hook", "pre-commit"}, fs.Args()...), " ")
+	script := "#!/bin/sh\nexec " + command + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		return err
+	}
+	fmt.Println("Installed", path)
+	return nil
+}
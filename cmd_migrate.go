@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// runMigrateCommand handles `codegent migrate [flags] [description]`. The
+// agent compares the desired schema, from Go structs or schema files, with
+// the one the database has or the migrations declare, and writes a
+// migration in the project's migration tool's layout. The migrations are
+// verified on a scratch database, failures are fed back for fixing, and
+// the changes are shown as one diff that the user keeps or reverts.
+func runMigrateCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent migrate", flag.ContinueOnError)
+	dir := fs.String("dir", "", "migrations directory (default: the first of "+strings.Join(migrationDirs, ", ")+" that exists)")
+	tool := fs.String("tool", "", "migration tool whose layout to follow: "+strings.Join(migrationTools, ", ")+" (default: told from the existing migrations)")
+	var schemaPaths []string
+	fs.Func("schema", "file or directory declaring the desired schema, Go structs or SQL (repeatable, default: the model looks for it)", func(path string) error {
+		schemaPaths = append(schemaPaths, path)
+		return nil
+	})
+	database := fs.String("database", "", "database from databases.json whose live schema to start from (default: the schema the migrations declare)")
+	driver := fs.String("driver", "", "SQL dialect of the migrations, postgres or sqlite (default: the database's, or sqlite)")
+	scratch := fs.String("scratch", os.Getenv("CODEGENT_SCRATCH_DATABASE"), "postgres:// URL of a server to create scratch databases on, for verifying postgres migrations")
+	name := fs.String("name", "", "name of the migration file (default: from the description)")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	description := strings.Join(fs.Args(), " ")
+	if description == "" {
+		description = "bring the schema in line with the desired schema"
+	}
+
+	set, err := loadMigrationSet(*dir, *tool)
+	if err != nil {
+		return err
+	}
+	var live *databaseConfig
+	if *database != "" {
+		databases, err := loadDatabases()
+		if err != nil {
+			return err
+		}
+		db, ok := databases[*database]
+		if !ok {
+			return fmt.Errorf("no database %q in databases.json", *database)
+		}
+		live = &db
+		if *driver == "" {
+			*driver = db.Driver
+		}
+	}
+	switch *driver {
+	case "":
+		*driver = "sqlite"
+	case "sqlite", "postgres":
+	default:
+		return fmt.Errorf("unknown driver %q, want postgres or sqlite", *driver)
+	}
+	if *driver == "postgres" && *scratch == "" {
+		return fmt.Errorf("postgres migrations are verified on a scratch database, pass --scratch with a server to create it on, such as postgres://postgres@localhost:5432/postgres")
+	}
+
+	// The schema the new migration starts from
+	var current, source string
+	if live != nil {
+		db, err := sql.Open(map[string]string{"postgres": "pgx", "sqlite": "sqlite"}[live.Driver], live.dsn())
+		if err != nil {
+			return err
+		}
+		current, err = describeSchema(ctx, db, *live)
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read the schema of %s: %w", *database, err)
+		}
+		source = "The live schema of the database " + *database
+	} else {
+		current, err = set.declaredSchema(ctx, *driver, *scratch)
+		if err != nil {
+			return fmt.Errorf("the existing migrations don't apply to a scratch %s database, pass --driver if they are written for another: %w", *driver, err)
+		}
+		source = "The schema the existing migrations in " + set.Dir + " declare"
+	}
+
+	migrationName := *name
+	if migrationName == "" {
+		migrationName = strings.ReplaceAll(branchSlug(description), "-", "_")
+	}
+	files := set.newFiles(set.nextVersion(time.Now()), migrationName)
+	since := set.lastVersion()
+	// Applied migrations must stay as they are
+	existing := make(map[string][]byte)
+	for _, m := range set.Migrations {
+		for _, path := range []string{m.Up, m.Down} {
+			if content, err := os.ReadFile(path); err == nil {
+				existing[path] = content
+			}
+		}
+	}
+
+	// Every change is reviewed at the end, so edits needn't be approved one
+	// by one unless a mode was chosen explicitly
+	if !flagSet(fs, "approvals") && !startEnv["CODEGENT_APPROVALS"] {
+		config.Approvals = ApprovalAutoEdit
+	}
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "migrate: " + description
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+	agent.progress = progressOutput()
+	modelConfig := agent.newModelConfig(ctx)
+
+	desired := "Find what declares the desired schema first: Go structs that map to tables, with db, gorm, bun or sql tags, or schema files such as schema.sql."
+	if len(schemaPaths) > 0 {
+		desired = "The desired schema is declared in " + strings.Join(schemaPaths, ", ") + "."
+	}
+	desired += " For Go structs the column names come from the tags, or else are the field names in snake_case."
+	current = strings.TrimSpace(current)
+	if current == "No tables" {
+		current = "(no tables yet)"
+	}
+
+	fileVersions.startJournal()
+	prompt := fmt.Sprintf(`Write a database migration: %s
+
+%s
+
+%s:
+
+%s
+
+Compare the two and write a migration that takes the database from the current schema to the desired one, covering only the differences. Create %s, in the layout of %s. Write the SQL for %s. Keep existing data where you can, for example rename a column rather than drop and add it, and never change the existing migrations. The migrations are applied to a scratch database for you afterwards.`,
+		description, desired, source, current, strings.Join(files, " and "), set.layout(), *driver)
+	if _, err := agent.handleRequest(ctx, modelConfig, prompt); err != nil {
+		return err
+	}
+
+	for attempt := 1; attempt <= maxCheckAttempts; attempt++ {
+		fmt.Fprintf(agent.out, "%s: %s\n", paint(roleTool, "verify"), "migrations on a scratch database")
+		problem := ""
+		set, err = loadMigrationSet(set.Dir, set.Tool)
+		if err != nil {
+			return err
+		}
+		for path, content := range existing {
+			if now, err := os.ReadFile(path); err != nil || string(now) != string(content) {
+				problem = fmt.Sprintf("The existing migration %s was changed or removed, it may already be applied somewhere. Restore it and put all changes in the new migration.", path)
+				break
+			}
+		}
+		if problem == "" && set.lastVersion() == since {
+			problem = fmt.Sprintf("There is no new migration in %s. Create %s.", set.Dir, strings.Join(files, " and "))
+		}
+		var schema string
+		if problem == "" {
+			schema, err = set.verifyMigrations(ctx, since, *driver, *scratch)
+			if err != nil {
+				problem = fmt.Sprintf("Verifying the migrations on a scratch %s database failed: %v\n\nFix the new migration.", *driver, err)
+			}
+		}
+		if problem == "" {
+			fmt.Fprintf(agent.out, "verify passed, the migrations lead to:\n\n%s\n", schema)
+			break
+		}
+		fmt.Fprintf(agent.out, "verify failed: %s\n", problem)
+		if attempt == maxCheckAttempts {
+			fmt.Fprintln(agent.out, "giving up on fixing the migration, review the changes below")
+			break
+		}
+		if _, err := agent.handleRequest(ctx, modelConfig, problem); err != nil {
+			return err
+		}
+	}
+
+	return agent.reviewChanges()
+}
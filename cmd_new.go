@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// builtinTemplates describe the projects `codegent new` can scaffold without
+// any setup. User templates in ~/.codegent/templates/<name>.md take
+// precedence over these.
+var builtinTemplates = map[string]string{
+	"go-cli": `A Go command-line tool.
+- go.mod with a module path derived from the directory name
+- main.go that parses flags with the standard flag package and dispatches to subcommands if the description calls for them
+- Logic in separate files of package main, kept small
+- A README.md with build and usage instructions
+- A .gitignore ignoring the built binary`,
+	"http-api": `A Go HTTP JSON API using only the standard library.
+- go.mod with a module path derived from the directory name
+- main.go that reads the listen address from the ADDR environment variable (default :8080) and shuts down gracefully on SIGINT/SIGTERM
+- Handlers registered on an http.ServeMux using Go 1.22 method and path patterns, in handlers.go
+- A /healthz endpoint
+- JSON request and response helpers with consistent error responses
+- A README.md documenting the endpoints with curl examples`,
+	"library": `A Go library package.
+- go.mod with a module path derived from the directory name
+- A package named after the directory with a doc.go holding the package documentation
+- The exported API the description calls for, each exported identifier with a doc comment
+- An example_test.go with runnable examples
+- A README.md with installation and usage`,
+}
+
+func templatesDir() (string, error) {
+	dir, err := codegentDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// loadTemplate returns the description of the named template, preferring a
+// user-defined one
+func loadTemplate(name string) (string, error) {
+	if dir, err := templatesDir(); err == nil {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.Base(name)+".md"))
+		if err == nil {
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if template, ok := builtinTemplates[name]; ok {
+		return template, nil
+	}
+	return "", fmt.Errorf("unknown template %q (available: %s)", name, strings.Join(templateNames(), ", "))
+}
+
+// templateNames lists the built-in and user-defined templates
+func templateNames() []string {
+	seen := make(map[string]bool)
+	for name := range builtinTemplates {
+		seen[name] = true
+	}
+	if dir, err := templatesDir(); err == nil {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if name, ok := strings.CutSuffix(entry.Name(), ".md"); ok && !entry.IsDir() {
+				seen[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runNewCommand handles `codegent new [flags] <template> <dir> [description]`,
+// which has the agent scaffold a project in a new directory. Files are
+// created through the usual tools, so the approval mode applies.
+func runNewCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent new", flag.ContinueOnError)
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: codegent new [flags] <template> <dir> [description]\ntemplates: %s", strings.Join(templateNames(), ", "))
+	}
+	name, dir := fs.Arg(0), fs.Arg(1)
+	description := strings.Join(fs.Args()[2:], " ")
+
+	template, err := loadTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	// Only scaffold into a new or empty directory
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	session := NewSession()
+	session.Title = fmt.Sprintf("new %s %s", name, dir)
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+
+	prompt := fmt.Sprintf(`Scaffold a new project named %q in the current (empty) directory from this template:
+
+%s`, filepath.Base(dir), template)
+	if description != "" {
+		prompt += "\n\nThe project should do the following:\n" + description
+	}
+	prompt += "\n\nCreate every file with edit_file, then summarize what you created and how to build and run it."
+
+	_, err = agent.handleRequest(ctx, agent.newModelConfig(ctx), prompt)
+	return err
+}
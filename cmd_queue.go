@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+const queueUsage = `usage: codegent queue <command>
+
+Commands:
+  add <task>            Queue a task in the current repository
+  list                  List queued, running and finished tasks
+  run [flags]           Run the queued tasks, each on its own branch
+  show <id>             Print a task's report
+  retry <id>            Queue a failed or interrupted task again
+  remove <id>           Remove a task from the queue`
+
+// Statuses of queued tasks
+const (
+	taskQueued  = "queued"
+	taskRunning = "running"
+	taskDone    = "done"
+	taskFailed  = "failed"
+)
+
+// queuedTask is a task lined up with `codegent queue add`. It runs in a git
+// worktree of its repository on a branch of its own, which is left with the
+// changes committed for review.
+type queuedTask struct {
+	ID       int       `json:"id"`
+	Task     string    `json:"task"`
+	Dir      string    `json:"dir"` // the repository's top level
+	Added    time.Time `json:"added"`
+	Status   string    `json:"status"`
+	Branch   string    `json:"branch,omitempty"`
+	Session  string    `json:"session,omitempty"`
+	Schedule int       `json:"schedule,omitempty"` // that added it, see runSchedules
+	Finished time.Time `json:"finished,omitzero"`
+	Report   string    `json:"report,omitempty"`
+}
+
+type taskQueue struct {
+	NextID int           `json:"next_id"`
+	Tasks  []*queuedTask `json:"tasks"`
+}
+
+// stateMu serializes updates of state files, such as the queue's by
+// parallel workers. A lock file next to them does the same for other
+// codegent processes.
+var stateMu sync.Mutex
+
+// updateQueue loads ~/.codegent/queue.json, lets fn change it and saves it
+// again. The file is read afresh every time, so tasks added while a runner
+// is going are picked up.
+func updateQueue(fn func(q *taskQueue) error) error {
+	return updateState("queue.json", &taskQueue{NextID: 1}, fn)
+}
+
+// updateState loads the JSON file name in ~/.codegent into v, lets fn
+// change it and saves it again, replacing the file in one go
+func updateState[T any](name string, v *T, fn func(v *T) error) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	dir, err := codegentDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := fn(v); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// findTask returns the task with the given ID
+func (q *taskQueue) findTask(ref string) (*queuedTask, error) {
+	id, err := strconv.Atoi(strings.TrimPrefix(ref, "#"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid task ID %q", ref)
+	}
+	for _, task := range q.Tasks {
+		if task.ID == id {
+			return task, nil
+		}
+	}
+	return nil, fmt.Errorf("no queued task %d", id)
+}
+
+// runQueueCommand handles `codegent queue ...`
+func runQueueCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", queueUsage)
+	}
+	switch args[0] {
+	case "add":
+		return addQueuedTask(ctx, strings.Join(args[1:], " "))
+	case "list", "ls":
+		return listQueue()
+	case "run":
+		return runQueue(ctx, args[1:])
+	case "show", "retry", "remove", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: codegent queue %s <id>", args[0])
+		}
+		return updateQueue(func(q *taskQueue) error {
+			task, err := q.findTask(args[1])
+			if err != nil {
+				return err
+			}
+			switch args[0] {
+			case "show":
+				printTask(task)
+			case "retry":
+				if task.Status == taskQueued || task.Status == taskDone {
+					return fmt.Errorf("task %d is %s", task.ID, task.Status)
+				}
+				task.Status, task.Report, task.Finished = taskQueued, "", time.Time{}
+				fmt.Printf("Queued task %d again\n", task.ID)
+			default:
+				if task.Status == taskRunning {
+					return fmt.Errorf("task %d is running", task.ID)
+				}
+				q.Tasks = slices.DeleteFunc(q.Tasks, func(t *queuedTask) bool { return t == task })
+				fmt.Printf("Removed task %d\n", task.ID)
+			}
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown queue command %q\n\n%s", args[0], queueUsage)
+	}
+}
+
+// addQueuedTask queues a task for the repository in the working directory
+func addQueuedTask(ctx context.Context, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("usage: codegent queue add <task>")
+	}
+	dir, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("queued tasks run on a branch of their own, so they need a git repository: %w", err)
+	}
+	return updateQueue(func(q *taskQueue) error {
+		task := &queuedTask{ID: q.NextID, Task: text, Dir: dir, Added: time.Now(), Status: taskQueued}
+		q.NextID++
+		q.Tasks = append(q.Tasks, task)
+		fmt.Printf("Queued task %d in %s\n", task.ID, dir)
+		return nil
+	})
+}
+
+func listQueue() error {
+	return updateQueue(func(q *taskQueue) error {
+		if len(q.Tasks) == 0 {
+			fmt.Println("The queue is empty")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tBRANCH\tTASK")
+		for _, task := range q.Tasks {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", task.ID, task.Status, task.Branch, truncateRunes(task.Task, 60))
+		}
+		return w.Flush()
+	})
+}
+
+func printTask(task *queuedTask) {
+	fmt.Printf("Task %d, %s: %s\n", task.ID, task.Status, task.Task)
+	fmt.Printf("Repository: %s\n", task.Dir)
+	if task.Schedule != 0 {
+		fmt.Printf("Run by schedule %d\n", task.Schedule)
+	}
+	if task.Report != "" {
+		fmt.Printf("\n%s\n", task.Report)
+	}
+}
+
+// runQueue handles `codegent queue run`: workers take the queued tasks in
+// order until none are left, each in a worktree of its own
+func runQueue(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent queue run", flag.ContinueOnError)
+	parallel := fs.Int("parallel", 1, "how many tasks to run at once")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	// Nobody approves anything overnight, edits are reviewed on the branch
+	if !flagSet(fs, "approvals") && !startEnv["CODEGENT_APPROVALS"] {
+		config.Approvals = ApprovalAutoEdit
+	}
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for range max(*parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var task queuedTask
+				err := updateQueue(func(q *taskQueue) error {
+					for _, t := range q.Tasks {
+						if t.Status == taskQueued {
+							t.Status = taskRunning
+							task = *t
+							return nil
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "ERROR reading the queue:", err)
+					return
+				}
+				if task.ID == 0 {
+					return
+				}
+				runClaimedTask(ctx, client, config, task)
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// runClaimedTask runs a task marked as running and saves how it went
+func runClaimedTask(ctx context.Context, client *genai.Client, config *Config, task queuedTask) queuedTask {
+	fmt.Printf("Started task %d: %s\n", task.ID, truncateRunes(task.Task, 60))
+	runErr := runQueuedTask(ctx, client, config, &task)
+	task.Status = taskDone
+	if runErr != nil {
+		task.Status = taskFailed
+		task.Report = strings.TrimSpace(task.Report + "\n\nFailed: " + runErr.Error())
+	}
+	task.Finished = time.Now()
+	err := updateQueue(func(q *taskQueue) error {
+		if t, err := q.findTask(strconv.Itoa(task.ID)); err == nil {
+			*t = task
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR saving the queue:", err)
+	}
+	fmt.Printf("Finished task %d, %s, see codegent queue show %d\n", task.ID, task.Status, task.ID)
+	return task
+}
+
+// runQueuedTask runs a task in a new worktree on a new branch and commits
+// what the agent changed there. The chat goes to a log file next to the
+// queue; the report says what happened.
+func runQueuedTask(ctx context.Context, client *genai.Client, config *Config, task *queuedTask) error {
+	stateDir, err := codegentDir()
+	if err != nil {
+		return err
+	}
+	task.Branch = fmt.Sprintf("codegent/queue-%d-%s", task.ID, branchSlug(task.Task))
+	worktree := filepath.Join(stateDir, "worktrees", fmt.Sprintf("queue-%d", task.ID))
+	// A run that failed before may have left its worktree and branch, the
+	// branch starts over from HEAD
+	os.RemoveAll(worktree)
+	gitIn(ctx, task.Dir, "worktree", "prune")
+	if _, err := gitIn(ctx, task.Dir, "worktree", "add", "-B", task.Branch, worktree, "HEAD"); err != nil {
+		task.Branch = ""
+		return err
+	}
+	defer gitIn(context.WithoutCancel(ctx), task.Dir, "worktree", "remove", "--force", worktree)
+
+	logPath := filepath.Join(stateDir, "queue", fmt.Sprintf("%d.log", task.ID))
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o700); err != nil {
+		return err
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	session := NewSession()
+	session.Title = "queue: " + task.Task
+	task.Session = session.ID
+	noInput := func() (string, bool) { return "", false }
+	agent := NewAgent(client, noInput, defaultTools(), session, config)
+	agent.setRoot(worktree)
+	agent.out = logFile
+	startCommit, _ := gitIn(ctx, worktree, "rev-parse", "HEAD")
+	start := time.Now()
+	answer, runErr := agent.handleRequest(ctx, agent.newModelConfig(ctx), task.Task)
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "Ran for %s, %s tokens, session %s, log %s\n", time.Since(start).Round(time.Second), formatTokens(agent.tokensUsed), session.ID, logPath)
+	defer func() { task.Report = strings.TrimSpace(report.String()) }()
+
+	// Whatever the agent got done is kept, also when it failed halfway
+	if _, err := gitIn(ctx, worktree, "add", "-A"); err != nil {
+		return err
+	}
+	// The agent may have committed some of it itself, see commit_changes
+	status, _ := gitIn(ctx, worktree, "status", "--porcelain")
+	committed, _ := gitIn(ctx, worktree, "rev-list", "--count", startCommit+"..HEAD")
+	if status == "" && committed == "0" {
+		report.WriteString("No files were changed, so the branch was deleted.\n")
+		gitIn(context.WithoutCancel(ctx), task.Dir, "worktree", "remove", "--force", worktree)
+		gitIn(context.WithoutCancel(ctx), task.Dir, "branch", "-D", task.Branch)
+		task.Branch = ""
+	} else {
+		if status != "" {
+			message := "codegent: " + truncateRunes(strings.SplitN(task.Task, "\n", 2)[0], 60) + "\n\n" + task.Task
+			if _, err := gitIn(ctx, worktree, "commit", "-q", "-m", message); err != nil {
+				return err
+			}
+		}
+		log, _ := gitIn(ctx, worktree, "log", "--format=%h %s", startCommit+"..HEAD")
+		stat, _ := gitIn(ctx, worktree, "diff", "--stat", startCommit, "HEAD")
+		fmt.Fprintf(&report, "Committed to %s:\n%s\n\n%s\n", task.Branch, log, stat)
+	}
+	if answer != "" {
+		fmt.Fprintf(&report, "\n%s\n", answer)
+	}
+	return runErr
+}
+
+// branchSlug shortens a task to a few words fit for a branch name
+func branchSlug(task string) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(task), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	}) {
+		if len(words) == 5 {
+			break
+		}
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		return "task"
+	}
+	return strings.Join(words, "-")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxCheckAttempts is how many times the agent may try to fix a failing
+// check before the refactor is handed to the user as is
+const maxCheckAttempts = 3
+
+// runRefactorCommand handles `codegent refactor [flags] <description>`. The
+// agent plans and applies the change across the repository, the build and
+// tests are run until they pass, and all changes are shown as one diff that
+// the user keeps or reverts.
+func runRefactorCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent refactor", flag.ContinueOnError)
+	check := fs.String("check", defaultCheckCommand(), "shell command verifying the refactor, such as the build and tests (empty to skip)")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	description := strings.Join(fs.Args(), " ")
+	if description == "" {
+		return fmt.Errorf("usage: codegent refactor [flags] <description>")
+	}
+
+	// Every change is reviewed at the end, so edits needn't be approved one
+	// by one unless a mode was chosen explicitly
+	if !flagSet(fs, "approvals") && !startEnv["CODEGENT_APPROVALS"] {
+		config.Approvals = ApprovalAutoEdit
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "refactor: " + description
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+	agent.progress = progressOutput()
+	modelConfig := agent.newModelConfig(ctx)
+
+	fileVersions.startJournal()
+	prompt := fmt.Sprintf(`Refactor the code in the current directory: %s
+
+Use find_symbol to find every declaration and use that is affected, then apply all the edits, preferring multi_edit or regex_replace for changes spanning many places. Keep the change limited to what was asked. The build and tests are run for you afterwards.`, description)
+	if _, err := agent.handleRequest(ctx, modelConfig, prompt); err != nil {
+		return err
+	}
+
+	for attempt := 1; *check != "" && attempt <= maxCheckAttempts; attempt++ {
+		fmt.Fprintf(agent.out, "%s: %s\n", paint(roleTool, "check"), *check)
+		progress := agent.startProgress("check")
+		output, err := runCheck(ctx, *check, progress)
+		progress.stop()
+		if err == nil {
+			fmt.Fprintln(agent.out, "check passed")
+			break
+		}
+		fmt.Fprintf(agent.out, "check failed: %v\n%s", err, output)
+		if attempt == maxCheckAttempts {
+			fmt.Fprintln(agent.out, "giving up on fixing the check, review the changes below")
+			break
+		}
+		fix := fmt.Sprintf("The check `%s` failed after the refactor:\n\n%s\nFix the problem.", *check, truncateOutput(output, 2000))
+		if _, err := agent.handleRequest(ctx, modelConfig, fix); err != nil {
+			return err
+		}
+	}
+
+	return agent.reviewChanges()
+}
+
+// reviewChanges prints the combined diff of all journaled changes and asks
+// whether to keep them, reverting every file otherwise. Either way the
+// journal is done with.
+func (a *Agent) reviewChanges() error {
+	tracker := a.fileTracker()
+	defer func() {
+		tracker.stopJournal()
+		a.autosave()
+	}()
+	changes := tracker.changes()
+	var diff strings.Builder
+	for _, change := range changes {
+		current, _ := os.ReadFile(change.path)
+		diff.WriteString(unifiedDiff(change.path, string(change.content), string(current)))
+	}
+	if diff.Len() == 0 {
+		fmt.Fprintln(a.out, "No files were changed.")
+		return nil
+	}
+	fmt.Fprint(a.out, diff.String())
+
+	if a.confirm(fmt.Sprintf("Keep the changes to %d files?", len(changes))) {
+		return nil
+	}
+	for _, change := range changes {
+		if err := change.revert(); err != nil {
+			return fmt.Errorf("failed to revert %s: %w", change.path, err)
+		}
+	}
+	fmt.Fprintln(a.out, "Reverted all changes.")
+	return nil
+}
+
+// runCheck runs a shell command, see shellCommand, returning its combined
+// output. The output is also copied to progress, when given, as it is
+// written.
+func runCheck(ctx context.Context, command string, progress io.Writer) (string, error) {
+	var output bytes.Buffer
+	var w io.Writer = &output
+	if progress != nil {
+		w = io.MultiWriter(&output, progress)
+	}
+	cmd := shellCommand(ctx, command)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	return output.String(), err
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
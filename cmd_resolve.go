@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// conflictMarker matches the lines git starts and ends a conflict with,
+// and the one before the base in diff3 style. The ======= between the sides
+// is left out, as Markdown underlines headings with it.
+var conflictMarker = regexp.MustCompile(`^(<<<<<<<|\|\|\|\|\|\|\||>>>>>>>)( .*)?$`)
+
+// conflictSides describes what "ours" and "theirs" are in the operation
+// that stopped with conflicts
+type conflictSides struct {
+	Operation   string // merge, rebase, cherry-pick, revert, or "" when unknown
+	Ours        string
+	Theirs      string
+	TheirsRef   string // the commit being merged or replayed
+	ContinueCmd string // what finishes the operation, if anything
+}
+
+// detectConflictSides tells the operation in progress from the refs git
+// leaves while it waits for conflicts to be resolved
+func detectConflictSides(ctx context.Context) conflictSides {
+	branch, _ := gitOutput(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	subject := func(ref string) string {
+		s, _ := gitOutput(ctx, "log", "-1", "--format=%h %s", ref)
+		return s
+	}
+	exists := func(ref string) bool {
+		_, err := gitOutput(ctx, "rev-parse", "-q", "--verify", ref)
+		return err == nil
+	}
+	switch {
+	case exists("MERGE_HEAD"):
+		return conflictSides{"merge", "HEAD, the branch " + branch + " being merged into", "MERGE_HEAD, " + subject("MERGE_HEAD") + ", being merged in", "MERGE_HEAD", "git merge --continue"}
+	case exists("REBASE_HEAD"):
+		return conflictSides{"rebase", "the branch being rebased onto, with the commits replayed so far", "the commit being replayed, " + subject("REBASE_HEAD"), "REBASE_HEAD", "git rebase --continue"}
+	case exists("CHERRY_PICK_HEAD"):
+		return conflictSides{"cherry-pick", "HEAD, the branch " + branch, "the commit being cherry-picked, " + subject("CHERRY_PICK_HEAD"), "CHERRY_PICK_HEAD", "git cherry-pick --continue"}
+	case exists("REVERT_HEAD"):
+		return conflictSides{"revert", "HEAD, the branch " + branch, "the reverse of the commit being reverted, " + subject("REVERT_HEAD"), "REVERT_HEAD", "git revert --continue"}
+	}
+	return conflictSides{Ours: "the current version, such as upstream after a stash pop", Theirs: "the incoming version, such as the stashed changes"}
+}
+
+// conflictedFiles lists the unmerged files, or else files that still
+// contain conflict markers, limited to paths when given. Like git grep's,
+// the paths are relative to the working directory, which is where they
+// are read and written.
+func conflictedFiles(ctx context.Context, paths []string) ([]string, error) {
+	args := append([]string{"diff", "--name-only", "--relative", "--diff-filter=U", "--"}, paths...)
+	out, err := gitOutput(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		args = append([]string{"grep", "-l", "-E", "^(<<<<<<<|>>>>>>>)( |$)", "--"}, paths...)
+		// git grep exits 1 when nothing matches
+		out, _ = gitOutput(ctx, args...)
+	}
+	var files []string
+	for _, file := range strings.Split(out, "\n") {
+		if file != "" && !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// conflictContext describes how each side changed a file since their
+// common base, with the commits that did it, for the model to understand
+// what each side meant
+func conflictContext(ctx context.Context, path string, sides conflictSides) string {
+	stage := func(n int) (string, bool) {
+		// ./ takes the path from the working directory, not the top level
+		content, err := exec.CommandContext(ctx, "git", "show", fmt.Sprintf(":%d:./%s", n, path)).Output()
+		return string(content), err == nil
+	}
+	base, hasBase := stage(1)
+	ours, hasOurs := stage(2)
+	theirs, hasTheirs := stage(3)
+
+	var b strings.Builder
+	switch {
+	case !hasOurs && !hasTheirs:
+		b.WriteString("git has no stages for this file, only the markers in it tell the sides apart.\n")
+	case !hasBase:
+		b.WriteString("Both sides added this file, there is no common base.\n")
+	case !hasOurs:
+		b.WriteString("Ours deleted this file, theirs changed it. It has no conflict markers: keep it, adapted to ours, or delete it.\n")
+	case !hasTheirs:
+		b.WriteString("Theirs deleted this file, ours changed it. It has no conflict markers: keep it, adapted to theirs, or delete it.\n")
+	}
+	if hasOurs {
+		fmt.Fprintf(&b, "\nHow ours changed it since the base:\n%s", truncateOutput(orNone(unifiedDiff(path, base, ours)), 3000))
+	}
+	if hasTheirs {
+		fmt.Fprintf(&b, "\nHow theirs changed it since the base:\n%s", truncateOutput(orNone(unifiedDiff(path, base, theirs)), 3000))
+	}
+
+	if sides.TheirsRef != "" {
+		if sides.Operation == "merge" {
+			oursLog, _ := gitOutput(ctx, "log", "--oneline", "--no-merges", "-10", sides.TheirsRef+"..HEAD", "--", path)
+			theirsLog, _ := gitOutput(ctx, "log", "--oneline", "--no-merges", "-10", "HEAD.."+sides.TheirsRef, "--", path)
+			fmt.Fprintf(&b, "\nCommits on ours touching it:\n%s\n\nCommits on theirs touching it:\n%s\n", orNone(oursLog), orNone(theirsLog))
+		} else {
+			message, _ := gitOutput(ctx, "log", "-1", "--format=%B", sides.TheirsRef)
+			fmt.Fprintf(&b, "\nThe message of the commit on theirs:\n%s\n", message)
+		}
+	}
+	return b.String()
+}
+
+// orNone stands in for an empty diff or log
+func orNone(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "(none)\n"
+	}
+	return s
+}
+
+// runResolveCommand handles `codegent resolve [flags] [files]`. The agent
+// resolves the conflicts of a merge, rebase, cherry-pick or revert file by
+// file, knowing what each side changed since their base. Each resolution
+// is shown as a diff to keep, which marks the file resolved, or revert.
+// Then the build is checked and failures fed back for fixing.
+func runResolveCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent resolve", flag.ContinueOnError)
+	check := fs.String("check", defaultCheckCommand(), "shell command verifying the resolutions, such as the build and tests (empty to skip)")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	files, err := conflictedFiles(ctx, fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("No conflicts to resolve.")
+		return nil
+	}
+	sides := detectConflictSides(ctx)
+
+	// Each resolution is reviewed, so edits needn't be approved one by one
+	// unless a mode was chosen explicitly
+	if !flagSet(fs, "approvals") && !startEnv["CODEGENT_APPROVALS"] {
+		config.Approvals = ApprovalAutoEdit
+	}
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "resolve: " + strings.Join(files, ", ")
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+	agent.progress = progressOutput()
+	modelConfig := agent.newModelConfig(ctx)
+
+	operation := "a " + sides.Operation
+	if sides.Operation == "" {
+		operation = "an operation git doesn't track, such as a stash pop"
+	}
+	var resolved []string
+	for i, path := range files {
+		fmt.Fprintf(agent.out, "%s: %s (%d of %d)\n", paint(roleTool, "resolve"), path, i+1, len(files))
+		before, beforeErr := os.ReadFile(path)
+		fileVersions.startJournal()
+		prompt := fmt.Sprintf(`Resolve the conflicts in %s, left by %s.
+
+Ours is %s.
+Theirs is %s.
+%s
+Read the file and replace every conflict, from <<<<<<< to >>>>>>>, with code that keeps what both sides meant: combine their changes where they are compatible, and where they aren't, prefer the one that fits the rest of the code and say why. Remove all conflict markers. Only change other files when the resolution requires it. The build is checked for you afterwards.`,
+			path, operation, sides.Ours, sides.Theirs, conflictContext(ctx, path, sides))
+		if _, err := agent.handleRequest(ctx, modelConfig, prompt); err != nil {
+			return err
+		}
+		for attempt := 1; attempt < maxCheckAttempts; attempt++ {
+			lines := markerLines(path)
+			if len(lines) == 0 {
+				break
+			}
+			fix := fmt.Sprintf("%s still has conflict markers on lines %s. Resolve those conflicts as well.", path, strings.Join(lines, ", "))
+			if _, err := agent.handleRequest(ctx, modelConfig, fix); err != nil {
+				return err
+			}
+		}
+		if err := agent.reviewChanges(); err != nil {
+			return err
+		}
+		after, afterErr := os.ReadFile(path)
+		// Files one side deleted have no markers, leaving them as they are
+		// is a resolution too
+		unchanged := (beforeErr == nil) == (afterErr == nil) && string(before) == string(after)
+		if len(markerLines(path)) > 0 || unchanged && !agent.confirm(fmt.Sprintf("Keep %s as it is and mark it resolved?", path)) {
+			fmt.Fprintf(agent.out, "%s is left unresolved\n", path)
+			continue
+		}
+		// -A stages a resolution that deletes the file as well
+		if _, err := gitOutput(ctx, "add", "-A", "--", path); err != nil {
+			return err
+		}
+		resolved = append(resolved, path)
+	}
+
+	if len(resolved) > 0 && *check != "" {
+		fileVersions.startJournal()
+		for attempt := 1; attempt <= maxCheckAttempts; attempt++ {
+			fmt.Fprintf(agent.out, "%s: %s\n", paint(roleTool, "check"), *check)
+			progress := agent.startProgress("check")
+			output, err := runCheck(ctx, *check, progress)
+			progress.stop()
+			if err == nil {
+				fmt.Fprintln(agent.out, "check passed")
+				break
+			}
+			fmt.Fprintf(agent.out, "check failed: %v\n%s", err, output)
+			if attempt == maxCheckAttempts {
+				fmt.Fprintln(agent.out, "giving up on fixing the check, review the changes below")
+				break
+			}
+			fix := fmt.Sprintf("The check `%s` failed after resolving the conflicts in %s:\n\n%s\nFix the problem, keeping what both sides meant.", *check, strings.Join(resolved, ", "), truncateOutput(output, 2000))
+			if _, err := agent.handleRequest(ctx, modelConfig, fix); err != nil {
+				return err
+			}
+		}
+		if err := agent.reviewChanges(); err != nil {
+			return err
+		}
+		// Fixes to resolved files belong to the resolution
+		if _, err := gitOutput(ctx, append([]string{"add", "-A", "--"}, resolved...)...); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(agent.out, "Resolved %d of %d files.", len(resolved), len(files))
+	switch left, _ := conflictedFiles(ctx, nil); {
+	case len(left) > 0:
+		fmt.Fprintf(agent.out, " Still conflicted: %s\n", strings.Join(left, ", "))
+	case sides.ContinueCmd != "":
+		fmt.Fprintf(agent.out, " Finish with %s.\n", sides.ContinueCmd)
+	default:
+		fmt.Fprintln(agent.out)
+	}
+	return nil
+}
+
+// markerLines returns the numbers of the lines in path that are conflict
+// markers
+func markerLines(path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for i, line := range strings.Split(string(content), "\n") {
+		if conflictMarker.MatchString(line) {
+			lines = append(lines, fmt.Sprint(i+1))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// runRunCommand handles `codegent run [flags] <prompt>`, a single
+// non-interactive request whose final answer is printed to stdout. Chat
+// output and tool calls go to stderr so stdout can be piped.
+func runRunCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent run", flag.ContinueOnError)
+	schemaPath := fs.String("schema", "", "JSON schema file the final answer must conform to")
+	var doneWhen []string
+	fs.Func("done-when", "success criterion the request must meet, repeatable: a command that must exit 0, file:<path> <regexp> or bench:<command>", func(spec string) error {
+		doneWhen = append(doneWhen, spec)
+		return nil
+	})
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// Read the prompt from stdin when none is given
+	prompt := strings.Join(fs.Args(), " ")
+	if prompt == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		prompt = strings.TrimSpace(string(data))
+	}
+	if prompt == "" {
+		return fmt.Errorf("usage: codegent run [flags] <prompt>")
+	}
+
+	var schema any
+	if *schemaPath != "" {
+		data, err := os.ReadFile(*schemaPath)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return fmt.Errorf("invalid JSON schema %s: %w", *schemaPath, err)
+		}
+	}
+
+	criteria, err := parseCriteria(doneWhen)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// Nobody is around to approve anything, so approval prompts are refused
+	noInput := func() (string, bool) { return "", false }
+	agent := NewAgent(client, noInput, defaultTools(), NewSession(), config)
+	agent.out = os.Stderr
+	if err := agent.measureBaselines(ctx, criteria); err != nil {
+		return err
+	}
+	agent.criteria = criteria
+
+	answer, err := agent.handleRequest(ctx, agent.newModelConfig(ctx), prompt)
+	if err != nil {
+		return err
+	}
+	if schema != nil {
+		if answer, err = agent.structuredAnswer(ctx, schema); err != nil {
+			return err
+		}
+	}
+	fmt.Println(answer)
+	return nil
+}
+
+// structuredAnswer asks the model to restate its final answer as JSON
+// conforming to schema. Function calling can't be combined with a response
+// schema, so this is a separate request without tools.
+func (a *Agent) structuredAnswer(ctx context.Context, schema any) (string, error) {
+	modelConfig := &genai.GenerateContentConfig{
+		MaxOutputTokens:    4096,
+		SystemInstruction:  systemInstruction(a.root),
+		ResponseMIMEType:   "application/json",
+		ResponseJsonSchema: schema,
+	}
+	resp, err := a.runInference(ctx, modelConfig, TaskReview, genai.NewPartFromText("Give your final answer as JSON matching the response schema."))
+	if err != nil {
+		return "", err
+	}
+
+	answer := resp.Text()
+	if !json.Valid([]byte(answer)) {
+		return "", fmt.Errorf("model returned invalid JSON: %s", answer)
+	}
+
+	a.session.SetHistory(a.history)
+	if err := a.session.Save(); err != nil {
+		return "", err
+	}
+	return answer, nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+const scheduleUsage = `usage: codegent schedule <command>
+
+Commands:
+  add "<cron> <task>"   Run a task in the current repository on a schedule,
+                        such as "0 6 * * 1-5 update deps and run the tests"
+  list                  List the schedules and when they run next
+  run [--loop] [flags]  Run the tasks that are due, for cron or a systemd timer
+  remove <id>           Remove a schedule
+
+Tasks run like queued ones, on a branch of their own, and their reports go to
+stdout and to codegent queue show.`
+
+// staleLock is how old a lock file may get before it is taken over, a
+// runner that holds it touches it every minute
+const staleLock = 5 * time.Minute
+
+// schedule is a task that `codegent schedule run` runs whenever its cron
+// specification comes due
+type schedule struct {
+	ID      int       `json:"id"`
+	Cron    string    `json:"cron"`
+	Task    string    `json:"task"`
+	Dir     string    `json:"dir"` // the repository's top level
+	Added   time.Time `json:"added"`
+	LastRun time.Time `json:"last_run,omitzero"`
+}
+
+type scheduleList struct {
+	NextID    int         `json:"next_id"`
+	Schedules []*schedule `json:"schedules"`
+}
+
+// nextRun returns when the schedule runs next, the zero time if never
+func (s *schedule) nextRun() time.Time {
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return time.Time{}
+	}
+	from := s.LastRun
+	if from.IsZero() {
+		from = s.Added
+	}
+	return spec.next(from)
+}
+
+// updateSchedules loads ~/.codegent/schedules.json, lets fn change it and
+// saves it again
+func updateSchedules(fn func(l *scheduleList) error) error {
+	return updateState("schedules.json", &scheduleList{NextID: 1}, fn)
+}
+
+// runScheduleCommand handles `codegent schedule ...`
+func runScheduleCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", scheduleUsage)
+	}
+	switch args[0] {
+	case "add":
+		return addSchedule(ctx, strings.Join(args[1:], " "))
+	case "list", "ls":
+		return listSchedules()
+	case "run":
+		return runSchedules(ctx, args[1:])
+	case "remove", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: codegent schedule %s <id>", args[0])
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+		if err != nil {
+			return fmt.Errorf("invalid schedule ID %q", args[1])
+		}
+		return updateSchedules(func(l *scheduleList) error {
+			before := len(l.Schedules)
+			l.Schedules = slices.DeleteFunc(l.Schedules, func(s *schedule) bool { return s.ID == id })
+			if len(l.Schedules) == before {
+				return fmt.Errorf("no schedule %d", id)
+			}
+			fmt.Printf("Removed schedule %d\n", id)
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown schedule command %q\n\n%s", args[0], scheduleUsage)
+	}
+}
+
+// addSchedule adds a schedule for the repository in the working directory.
+// The first five words of text, or a shortcut such as @daily, are the cron
+// specification and the rest is the task.
+func addSchedule(ctx context.Context, text string) error {
+	fields := strings.Fields(text)
+	n := 5
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		n = 1
+	}
+	if len(fields) <= n {
+		return fmt.Errorf("usage: codegent schedule add \"<cron> <task>\", such as \"0 6 * * * update deps and run the tests\"")
+	}
+	spec := strings.Join(fields[:n], " ")
+	if _, err := parseCron(spec); err != nil {
+		return err
+	}
+	task := strings.Join(fields[n:], " ")
+	dir, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("scheduled tasks run on a branch of their own, so they need a git repository: %w", err)
+	}
+	return updateSchedules(func(l *scheduleList) error {
+		s := &schedule{ID: l.NextID, Cron: spec, Task: task, Dir: dir, Added: time.Now()}
+		l.NextID++
+		l.Schedules = append(l.Schedules, s)
+		fmt.Printf("Added schedule %d in %s, next run %s\n", s.ID, dir, s.nextRun().Format("Mon Jan 2 15:04"))
+		return nil
+	})
+}
+
+func listSchedules() error {
+	return updateSchedules(func(l *scheduleList) error {
+		if len(l.Schedules) == 0 {
+			fmt.Println("There are no schedules")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCRON\tNEXT RUN\tREPOSITORY\tTASK")
+		for _, s := range l.Schedules {
+			next := "never"
+			if t := s.nextRun(); !t.IsZero() {
+				next = t.Format("Mon Jan 2 15:04")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", s.ID, s.Cron, next, filepath.Base(s.Dir), truncateRunes(s.Task, 50))
+		}
+		return w.Flush()
+	})
+}
+
+// runSchedules handles `codegent schedule run`: it runs the schedules that
+// are due, one after the other, and prints their reports. Run it every
+// minute from cron or a systemd timer, or keep it going with --loop. A lock
+// file keeps runs from overlapping.
+func runSchedules(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent schedule run", flag.ContinueOnError)
+	loop := fs.Bool("loop", false, "keep running, checking the schedules every minute")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if !flagSet(fs, "approvals") && !startEnv["CODEGENT_APPROVALS"] {
+		config.Approvals = ApprovalAutoEdit
+	}
+
+	release, err := lockSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var client *genai.Client
+	for {
+		due, err := claimDueSchedules()
+		if err != nil {
+			return err
+		}
+		for _, task := range due {
+			if client == nil {
+				if client, err = newClient(ctx, config); err != nil {
+					return err
+				}
+			}
+			task = runClaimedTask(ctx, client, config, task)
+			fmt.Printf("\n%s\n\n", task.Report)
+		}
+		if !*loop {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute))):
+		}
+	}
+}
+
+// claimDueSchedules marks the schedules that are due as run and adds a
+// running task to the queue for each. A schedule that missed several runs,
+// say while the machine was off, runs once.
+func claimDueSchedules() ([]queuedTask, error) {
+	var due []*schedule
+	now := time.Now()
+	err := updateSchedules(func(l *scheduleList) error {
+		for _, s := range l.Schedules {
+			if next := s.nextRun(); !next.IsZero() && !next.After(now) {
+				s.LastRun = now
+				due = append(due, s)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(due) == 0 {
+		return nil, err
+	}
+	var tasks []queuedTask
+	err = updateQueue(func(q *taskQueue) error {
+		for _, s := range due {
+			task := &queuedTask{ID: q.NextID, Task: s.Task, Dir: s.Dir, Added: now, Status: taskRunning, Schedule: s.ID}
+			q.NextID++
+			q.Tasks = append(q.Tasks, task)
+			tasks = append(tasks, *task)
+		}
+		return nil
+	})
+	return tasks, err
+}
+
+// lockSchedules takes ~/.codegent/schedule.lock, failing when another run
+// holds it. The lock is touched every minute until release is called; one
+// left behind by a crashed run goes stale and is taken over.
+func lockSchedules(ctx context.Context) (release func(), err error) {
+	dir, err := codegentDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "schedule.lock")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		info, statErr := os.Stat(path)
+		if statErr == nil && time.Since(info.ModTime()) < staleLock {
+			holder, _ := os.ReadFile(path)
+			return nil, fmt.Errorf("another schedule run holds %s (pid %s)", path, strings.TrimSpace(string(holder)))
+		}
+		os.Remove(path)
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the schedules: %w", err)
+	}
+	fmt.Fprintln(f, os.Getpid())
+	f.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				os.Chtimes(path, now, now)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+		os.Remove(path)
+	}, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// runServeCommand handles `codegent serve [flags]`, which serves the agent
+// over HTTP, with a browser chat UI when --ui is given and the gRPC
+// AgentService when --grpc is given
+func runServeCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("codegent serve", flag.ContinueOnError)
+	addr := flags.String("addr", userEnvOr("CODEGENT_ADDR", "127.0.0.1:8080"), "address to listen on")
+	ui := flags.Bool("ui", false, "serve the web chat UI at /")
+	idleTimeout := flags.Duration("idle-timeout", envDuration("CODEGENT_IDLE_TIMEOUT", 30*time.Minute), "evict gRPC sessions idle for this long (0 = never)")
+	grpcAddr := flags.String("grpc", userEnvOr("CODEGENT_GRPC_ADDR", ""), "also serve the gRPC AgentService on this address, e.g. 127.0.0.1:9090")
+	config, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	// Output goes to the browser and gRPC clients rather than this terminal,
+	// the web UI styles the dark theme's colors
+	palette = themes["dark"]
+	// They're shared by users with their own languages, and clients look for
+	// the English "[y/N]" of approval questions
+	catalog = nil
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "serve"
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	web := newWebSession(ctx, client, session, config, root)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", web.handler(ctx))
+	mux.Handle("/metrics", promhttp.Handler())
+	if *ui {
+		assets, err := fs.Sub(webAssets, "web")
+		if err != nil {
+			return err
+		}
+		mux.Handle("/", http.FileServerFS(assets))
+	}
+
+	if *grpcAddr != "" {
+		warnPublic(*grpcAddr)
+		go func() {
+			log.Printf("serving gRPC on %s", *grpcAddr)
+			log.Fatal(serveGRPC(ctx, *grpcAddr, client, config, *idleTimeout))
+		}()
+	}
+
+	warnPublic(*addr)
+	log.Printf("serving on http://%s", *addr)
+	return http.ListenAndServe(*addr, logRequests(checkHost(*addr, mux)))
+}
+
+// warnPublic warns when addr is reachable from other machines
+func warnPublic(addr string) {
+	if host, _, err := net.SplitHostPort(addr); err == nil && !net.ParseIP(host).IsLoopback() && host != "localhost" {
+		log.Println("WARNING serving on", addr, "lets anyone who can reach it use the agent on this machine")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+const sessionsUsage = `usage: codegent sessions <command>
+
+Commands:
+  list                  List saved sessions
+  show <id|title>       Print a saved conversation
+  rename <id|title> <new title>
+  delete <id|title>     Delete a saved session`
+
+// runSessionsCommand handles `codegent sessions ...`
+func runSessionsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", sessionsUsage)
+	}
+
+	switch args[0] {
+	case "list", "ls":
+		return listSessions()
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: codegent sessions show <id|title>")
+		}
+		return showSession(args[1])
+	case "rename":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: codegent sessions rename <id|title> <new title>")
+		}
+		return renameSession(args[1], strings.Join(args[2:], " "))
+	case "delete", "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: codegent sessions delete <id|title>")
+		}
+		s, err := DeleteSession(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deleted session %s\n", s.DisplayName())
+		return nil
+	default:
+		return fmt.Errorf("unknown sessions command %q\n\n%s", args[0], sessionsUsage)
+	}
+}
+
+func listSessions() error {
+	sessions, err := ListSessions()
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No saved sessions")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tUPDATED\tMESSAGES")
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", s.ID, s.Title, s.UpdatedAt.Format("2006-01-02 15:04"), len(s.History))
+	}
+	return w.Flush()
+}
+
+func showSession(ref string) error {
+	s, err := FindSession(ref)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("=== %s (%s) ===\n", s.DisplayName(), s.CreatedAt.Format("2006-01-02 15:04"))
+	for _, msg := range s.History {
+		printMessage(os.Stdout, msg)
+	}
+	return nil
+}
+
+// printMessage prints a saved message the way the chat showed it
+func printMessage(w io.Writer, msg SessionMessage) {
+	for _, part := range msg.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			args, _ := json.Marshal(part.FunctionCall.Args)
+			fmt.Fprintf(w, "%s: %s(%s)\n", paint(roleTool, tr("tool")), part.FunctionCall.Name, args)
+		case part.FunctionResponse != nil:
+			// Tool output is usually long file contents, skip it
+		case msg.Role == "user":
+			fmt.Fprintf(w, "%s: %s\n", paint(roleUser, tr("You")), part.Text)
+		default:
+			fmt.Fprintf(w, "%s: %s\n", paint(roleModel, "Gemini"), part.Text)
+		}
+	}
+}
+
+func renameSession(ref, title string) error {
+	s, err := FindSession(ref)
+	if err != nil {
+		return err
+	}
+	s.Title = title
+	if err := s.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Renamed session %s to %q\n", s.ID, title)
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// slackAnswerTimeout is how long an approval prompt waits for a reply
+const slackAnswerTimeout = 10 * time.Minute
+
+// slackMessageLimit keeps posts well under Slack's message size limit
+const slackMessageLimit = 3500
+
+// slackBot maps Slack threads to agent sessions working on the shared
+// checkout in the current directory
+type slackBot struct {
+	ctx       context.Context
+	client    *genai.Client
+	config    *Config
+	botToken  string
+	botUserID string
+	policies  map[string]ApprovalMode
+
+	// Only one request at a time changes the shared checkout. A request
+	// waiting for its requester's answer lets the others go on.
+	workspace sync.Mutex
+
+	mu      sync.Mutex
+	threads map[string]*slackThread
+	seen    map[string]bool
+}
+
+// slackThread is one conversation, fed with the thread's messages
+type slackThread struct {
+	bot       *slackBot
+	channel   string
+	threadTS  string
+	inbox     chan slackEvent
+	pending   []slackEvent
+	requester string
+	out       *chatOutput
+}
+
+// runSlackCommand handles `codegent slack [flags]`, which connects to Slack
+// over Socket Mode. Mentioning the bot in a channel, or messaging it
+// directly, starts a session tied to that thread; later messages in the
+// thread continue it.
+func runSlackCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent slack", flag.ContinueOnError)
+	policies := fs.String("policies", os.Getenv("CODEGENT_SLACK_POLICIES"), "per-user approval modes, e.g. U012AB3CD=auto-edit,U045EF6GH=plan (others get --approvals)")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	// Chats are shared by users with their own languages
+	catalog = nil
+
+	appToken, botToken := secret("slack-app"), secret("slack-bot")
+	if appToken == "" || botToken == "" {
+		return fmt.Errorf("SLACK_APP_TOKEN (xapp-...) and SLACK_BOT_TOKEN (xoxb-...) must be set, or stored with codegent auth login slack-app and slack-bot")
+	}
+
+	bot := &slackBot{
+		ctx:      ctx,
+		config:   config,
+		botToken: botToken,
+		policies: make(map[string]ApprovalMode),
+		threads:  make(map[string]*slackThread),
+		seen:     make(map[string]bool),
+	}
+	for _, item := range splitList(*policies) {
+		user, mode, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("invalid policy %q, want USER=mode", item)
+		}
+		if bot.policies[user], err = ParseApprovalMode(mode); err != nil {
+			return err
+		}
+	}
+
+	var auth struct {
+		UserID string `json:"user_id"`
+	}
+	if err := slackAPI(ctx, botToken, "auth.test", struct{}{}, &auth); err != nil {
+		return err
+	}
+	bot.botUserID = auth.UserID
+
+	if bot.client, err = newClient(ctx, config); err != nil {
+		return err
+	}
+
+	log.Println("connected to Slack as", bot.botUserID)
+	for {
+		err := slackSocket(ctx, appToken, bot.handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Println("slack connection lost, reconnecting:", err)
+			time.Sleep(2 * time.Second)
+		}
+	}
+}
+
+// handle routes an event to its thread, starting one for mentions and
+// direct messages
+func (b *slackBot) handle(event slackEvent) {
+	if event.BotID != "" || event.Subtype != "" || event.User == "" || event.User == b.botUserID {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A mention arrives both as app_mention and as message
+	if b.seen[event.Channel+event.TS] {
+		return
+	}
+	if len(b.seen) > 10000 {
+		b.seen = make(map[string]bool)
+	}
+	b.seen[event.Channel+event.TS] = true
+
+	threadTS := event.ThreadTS
+	if threadTS == "" {
+		threadTS = event.TS
+	}
+	key := event.Channel + "/" + threadTS
+	thread, ok := b.threads[key]
+	if !ok {
+		mentioned := event.Type == "app_mention" || strings.Contains(event.Text, "<@"+b.botUserID+">")
+		if !mentioned && event.ChannelType != "im" {
+			return
+		}
+		thread = &slackThread{
+			bot:      b,
+			channel:  event.Channel,
+			threadTS: threadTS,
+			inbox:    make(chan slackEvent, 64),
+		}
+		thread.out = &chatOutput{limit: slackMessageLimit, post: func(text string) error {
+			return slackPost(b.ctx, b.botToken, thread.channel, thread.threadTS, text)
+		}}
+		b.threads[key] = thread
+		go thread.run()
+	}
+
+	select {
+	case thread.inbox <- event:
+	default:
+		log.Println("slack thread", key, "is busy, dropping message")
+	}
+}
+
+// policy returns the approval mode for a Slack user
+func (b *slackBot) policy(user string) ApprovalMode {
+	if mode, ok := b.policies[user]; ok {
+		return mode
+	}
+	return b.config.Approvals
+}
+
+// run handles the thread's requests one after another
+func (t *slackThread) run() {
+	title := fmt.Sprintf("slack %s %s", t.channel, t.threadTS)
+	session, err := FindSession(title)
+	if err != nil {
+		session = NewSession()
+		session.Title = title
+	}
+
+	config := *t.bot.config
+	agent := NewAgent(t.bot.client, t.answer, defaultTools(), session, &config)
+	agent.out = t.out
+	agent.history = session.Contents()
+	modelConfig := agent.newModelConfig(t.bot.ctx)
+
+	for {
+		event := t.next()
+		t.requester = event.User
+		config.Approvals = t.bot.policy(event.User)
+		text := strings.TrimSpace(strings.ReplaceAll(event.Text, "<@"+t.bot.botUserID+">", ""))
+		if text == "" {
+			continue
+		}
+
+		t.bot.workspace.Lock()
+		_, err := agent.handleRequest(t.bot.ctx, modelConfig, text)
+		t.bot.workspace.Unlock()
+		if err != nil {
+			fmt.Fprintln(t.out, "ERROR:", err)
+		}
+		t.out.flush()
+	}
+}
+
+// next returns the next request, starting with messages that arrived while
+// the previous one ran
+func (t *slackThread) next() slackEvent {
+	if len(t.pending) > 0 {
+		event := t.pending[0]
+		t.pending = t.pending[1:]
+		return event
+	}
+	return <-t.inbox
+}
+
+// answer is the agent's getUserMessage: it waits for the requester's reply
+// in the thread, so only they can approve their own tool calls. Other
+// threads can use the checkout in the meantime.
+func (t *slackThread) answer() (string, bool) {
+	t.out.flush()
+	t.bot.workspace.Unlock()
+	defer t.bot.workspace.Lock()
+	timeout := time.After(slackAnswerTimeout)
+	for {
+		select {
+		case event := <-t.inbox:
+			if event.User != t.requester {
+				t.pending = append(t.pending, event)
+				continue
+			}
+			return event.Text, true
+		case <-timeout:
+			return "", false
+		case <-t.bot.ctx.Done():
+			return "", false
+		}
+	}
+}
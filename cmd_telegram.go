@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// telegramMessageLimit keeps posts under Telegram's 4096 character limit
+const telegramMessageLimit = 4000
+
+// telegramBot serves the chats of allowed users, one session per chat
+type telegramBot struct {
+	ctx     context.Context
+	client  *genai.Client
+	config  *Config
+	token   string
+	allowed map[int64]bool
+
+	mu    sync.Mutex
+	chats map[int64]*telegramChatSession
+}
+
+// telegramChatSession is the conversation in one chat. Text messages and
+// button presses arrive on inbox. Each chat has its own copy of the config,
+// taken under the bot's lock, so what one chat changes in it, such as the
+// approval mode, doesn't reach the others.
+type telegramChatSession struct {
+	bot    *telegramBot
+	id     int64
+	config Config
+	inbox  chan telegramUpdate
+	out    *chatOutput
+}
+
+// runTelegramCommand handles `codegent telegram [flags]`, which lets the
+// allowed Telegram users talk to the agent from their phone. Tool calls that
+// need approval are asked with Approve and Deny buttons.
+func runTelegramCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent telegram", flag.ContinueOnError)
+	allow := fs.String("allow", os.Getenv("TELEGRAM_ALLOWED_USERS"), "comma separated Telegram user IDs allowed to use the bot")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	// Chats are shared by users with their own languages
+	catalog = nil
+
+	token := secret("telegram")
+	if token == "" {
+		return fmt.Errorf("TELEGRAM_BOT_TOKEN must be set, or stored with codegent auth login telegram")
+	}
+	bot := &telegramBot{
+		ctx:     ctx,
+		config:  config,
+		token:   token,
+		allowed: make(map[int64]bool),
+		chats:   make(map[int64]*telegramChatSession),
+	}
+	for _, item := range splitList(*allow) {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid Telegram user ID %q", item)
+		}
+		bot.allowed[id] = true
+	}
+	// Anyone can find a bot, so refuse to run open to everybody
+	if len(bot.allowed) == 0 {
+		return fmt.Errorf("no allowed users, set --allow or TELEGRAM_ALLOWED_USERS to your Telegram user ID")
+	}
+
+	if bot.client, err = newClient(ctx, config); err != nil {
+		return err
+	}
+
+	log.Println("telegram bot running")
+	offset := int64(0)
+	for {
+		var updates []telegramUpdate
+		err := telegramAPI(ctx, token, "getUpdates", map[string]any{"offset": offset, "timeout": 30}, &updates)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Println("telegram:", err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			bot.handle(update)
+		}
+	}
+}
+
+// handle passes an update from an allowed user on to its chat
+func (b *telegramBot) handle(update telegramUpdate) {
+	var userID, chatID int64
+	switch {
+	case update.Message != nil && update.Message.Text != "":
+		userID, chatID = update.Message.From.ID, update.Message.Chat.ID
+	case update.Callback != nil:
+		userID, chatID = update.Callback.From.ID, update.Callback.Message.Chat.ID
+	default:
+		return
+	}
+	if !b.allowed[userID] {
+		log.Printf("telegram: ignoring user %d, who is not allowed", userID)
+		return
+	}
+
+	b.mu.Lock()
+	chat, ok := b.chats[chatID]
+	if !ok {
+		chat = &telegramChatSession{bot: b, id: chatID, config: *b.config, inbox: make(chan telegramUpdate, 64)}
+		chat.out = &chatOutput{limit: telegramMessageLimit, post: func(text string) error {
+			_, err := telegramSend(b.ctx, b.token, chatID, text)
+			return err
+		}}
+		b.chats[chatID] = chat
+		go chat.run()
+	}
+	b.mu.Unlock()
+
+	select {
+	case chat.inbox <- update:
+	default:
+		log.Println("telegram chat", chatID, "is busy, dropping message")
+	}
+}
+
+// run handles the chat's messages one after another
+func (c *telegramChatSession) run() {
+	title := fmt.Sprintf("telegram %d", c.id)
+	session, err := FindSession(title)
+	if err != nil {
+		session = NewSession()
+		session.Title = title
+	}
+
+	agent := NewAgent(c.bot.client, c.answer, defaultTools(), session, &c.config)
+	agent.out = c.out
+	agent.history = session.Contents()
+	modelConfig := agent.newModelConfig(c.bot.ctx)
+
+	for update := range c.inbox {
+		if update.Callback != nil {
+			// A button from an approval that's no longer waiting
+			c.bot.answerCallback(update.Callback, "This request is already over")
+			continue
+		}
+		if _, err := agent.handleRequest(c.bot.ctx, modelConfig, update.Message.Text); err != nil {
+			fmt.Fprintln(c.out, "ERROR:", err)
+		}
+		c.out.flush()
+	}
+}
+
+// answer is the agent's getUserMessage. Questions ending in [y/N] are sent
+// with Approve and Deny buttons; a typed reply works too.
+func (c *telegramChatSession) answer() (string, bool) {
+	text := c.out.take()
+	var questionID int64
+	if strings.HasSuffix(text, "[y/N]") {
+		before, question := "", text
+		if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+			before, question = text[:i], text[i+1:]
+		}
+		c.out.send(before)
+		if len(question) > 1000 {
+			question = question[:500] + " ... " + question[len(question)-500:]
+		}
+		id, err := telegramSend(c.bot.ctx, c.bot.token, c.id, strings.TrimSuffix(question, " [y/N]"),
+			telegramButton{Text: "Approve", Data: "y"}, telegramButton{Text: "Deny", Data: "n"})
+		if err != nil {
+			log.Println("telegram:", err)
+			return "", false
+		}
+		questionID = id
+	} else {
+		c.out.send(text)
+	}
+
+	for {
+		select {
+		case update := <-c.inbox:
+			if update.Callback == nil {
+				return update.Message.Text, true
+			}
+			if questionID == 0 || update.Callback.Message.MessageID != questionID {
+				c.bot.answerCallback(update.Callback, "This request is already over")
+				continue
+			}
+			decision := "Denied"
+			if update.Callback.Data == "y" {
+				decision = "Approved"
+			}
+			c.bot.answerCallback(update.Callback, decision)
+			// Replace the buttons with the decision
+			telegramAPI(c.bot.ctx, c.bot.token, "editMessageReplyMarkup", map[string]any{
+				"chat_id":      c.id,
+				"message_id":   questionID,
+				"reply_markup": map[string]any{"inline_keyboard": [][]telegramButton{{{Text: decision, Data: "-"}}}},
+			}, nil)
+			return update.Callback.Data, true
+		case <-c.bot.ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// answerCallback acknowledges a button press with a short notice
+func (b *telegramBot) answerCallback(callback *telegramCallback, text string) {
+	if err := telegramAPI(b.ctx, b.token, "answerCallbackQuery", map[string]any{
+		"callback_query_id": callback.ID,
+		"text":              text,
+	}, nil); err != nil {
+		log.Println("telegram:", err)
+	}
+}
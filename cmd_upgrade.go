@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// runUpgradeCommand handles `codegent upgrade [--check] [--force]`, which
+// replaces the running binary with the latest release. The download must
+// match the release's checksums.txt, which must be signed with releaseKey.
+func runUpgradeCommand(args []string) error {
+	flags := flag.NewFlagSet("codegent upgrade", flag.ContinueOnError)
+	check := flags.Bool("check", false, "only report whether there is a newer release")
+	force := flags.Bool("force", false, "install the latest release even if it isn't newer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	rel, err := latestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	if !newerVersion(rel.TagName, version) && !*force {
+		fmt.Printf("codegent %s is up to date\n", version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("codegent %s is available, you have %s\n%s\n", rel.TagName, version, rel.HTMLURL)
+		return nil
+	}
+
+	if releaseKey == "" {
+		return fmt.Errorf("this build has no release key to verify %s with, download it from %s", rel.TagName, rel.HTMLURL)
+	}
+	name := releaseAssetName(runtime.GOOS, runtime.GOARCH)
+	binaryURL, sumsURL := rel.asset(name), rel.asset("checksums.txt")
+	if binaryURL == "" {
+		return fmt.Errorf("release %s has no build for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	if sumsURL == "" {
+		return fmt.Errorf("release %s has no checksums.txt, not installing it", rel.TagName)
+	}
+	sums, err := download(ctx, sumsURL)
+	if err != nil {
+		return err
+	}
+	sigURL := rel.asset("checksums.txt.sig")
+	if sigURL == "" {
+		return fmt.Errorf("release %s isn't signed, not installing it", rel.TagName)
+	}
+	sig, err := download(ctx, sigURL)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(sums, sig); err != nil {
+		return fmt.Errorf("release %s: %w", rel.TagName, err)
+	}
+	binary, err := download(ctx, binaryURL)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksum(sums, name, binary); err != nil {
+		return fmt.Errorf("release %s: %w", rel.TagName, err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	fmt.Printf("Upgraded %s from %s to %s\n", exe, version, rel.TagName)
+	return nil
+}
+
+// releaseAssetName is the name of the release's binary for a platform,
+// e.g. codegent_linux_amd64 or codegent_windows_amd64.exe
+func releaseAssetName(goos, goarch string) string {
+	name := "codegent_" + goos + "_" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// verifyChecksum checks data against the SHA-256 listed for name in sums,
+// which is in sha256sum's format
+func verifyChecksum(sums []byte, name string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("checksum mismatch for %s, the download may be corrupted or tampered with", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("checksums.txt has no checksum for %s", name)
+}
+
+// verifySignature checks the base64 Ed25519 signature of the checksums
+// against releaseKey
+func verifySignature(sums, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(releaseKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release key built into this codegent")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), sums, signature) {
+		return fmt.Errorf("checksums.txt has no valid signature")
+	}
+	return nil
+}
+
+// replaceExecutable swaps in the new binary. It's written next to the old
+// one and renamed over it, so an interrupted upgrade leaves the old one in
+// place. Windows can't replace a running executable, only rename it, so
+// there the old one is moved aside first and removed by the next upgrade.
+func replaceExecutable(path string, binary []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".codegent-upgrade-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "windows" {
+		return os.Rename(tmp.Name(), path)
+	}
+	old := path + ".old"
+	os.Remove(old)
+	if err := os.Rename(path, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Rename(old, path)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// usageTotals sums the usage log over one day, week or session
+type usageTotals struct {
+	key          string
+	sessions     map[string]bool
+	modelCalls   int
+	inputTokens  int
+	outputTokens int
+	cost         float64
+	toolCalls    int
+	edits        int
+	files        map[string]bool
+	linesAdded   int
+	linesRemoved int
+}
+
+func (t *usageTotals) add(rec usageRecord, unpriced map[string]bool) {
+	t.sessions[rec.Session] = true
+	if rec.Model != "" {
+		t.modelCalls++
+		t.inputTokens += rec.InputTokens
+		t.outputTokens += rec.OutputTokens
+		if cost, ok := estimateCost(rec); ok {
+			t.cost += cost
+		} else {
+			unpriced[rec.Model] = true
+		}
+	}
+	if rec.Tool != "" {
+		t.toolCalls++
+		if rec.Write && !rec.Failed {
+			t.edits++
+			for _, file := range rec.Files {
+				t.files[rec.Session+"\x00"+file] = true
+			}
+			t.linesAdded += rec.LinesAdded
+			t.linesRemoved += rec.LinesRemoved
+		}
+	}
+}
+
+// runUsageCommand handles `codegent usage [flags]`, which summarizes the
+// usage log by day, week or session
+func runUsageCommand(args []string) error {
+	flags := flag.NewFlagSet("codegent usage", flag.ContinueOnError)
+	by := flags.String("by", "day", "group by day, week or session")
+	since := flags.String("since", "7d", "only count usage since this long ago (e.g. 30d, 12h) or date (2006-01-02), empty for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var groupKey func(rec usageRecord) string
+	switch *by {
+	case "day":
+		groupKey = func(rec usageRecord) string { return rec.Time.Local().Format("2006-01-02") }
+	case "week":
+		groupKey = func(rec usageRecord) string {
+			year, week := rec.Time.Local().ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}
+	case "session":
+		groupKey = func(rec usageRecord) string { return rec.Session }
+	default:
+		return fmt.Errorf("unknown grouping %q, want day, week or session", *by)
+	}
+	start, err := parseSince(*since, time.Now())
+	if err != nil {
+		return err
+	}
+
+	st, err := store()
+	if err != nil {
+		return err
+	}
+	records, err := st.ReadUsage()
+	if err != nil {
+		return err
+	}
+	groups := map[string]*usageTotals{}
+	total := newUsageTotals("TOTAL")
+	tools := map[string]int{}
+	unpriced := map[string]bool{}
+	for _, rec := range records {
+		if rec.Time.Before(start) {
+			continue
+		}
+		key := groupKey(rec)
+		if groups[key] == nil {
+			groups[key] = newUsageTotals(key)
+		}
+		groups[key].add(rec, unpriced)
+		total.add(rec, unpriced)
+		if rec.Tool != "" {
+			tools[rec.Tool]++
+		}
+	}
+	if len(groups) == 0 {
+		fmt.Println("No usage recorded")
+		return nil
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\tSESSIONS\tMODEL CALLS\tINPUT\tOUTPUT\tCOST\tTOOL CALLS\tEDITS\tFILES\tLINES\t\n", strings.ToUpper(*by))
+	for _, key := range append(keys, "") {
+		t := total
+		if key != "" {
+			t = groups[key]
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t+%d/-%d\t\n", t.key, len(t.sessions), t.modelCalls,
+			formatTokens(t.inputTokens), formatTokens(t.outputTokens), formatCost(t.cost), t.toolCalls, t.edits, len(t.files), t.linesAdded, t.linesRemoved)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if tools[names[i]] != tools[names[j]] {
+			return tools[names[i]] > tools[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > 0 {
+		counts := make([]string, len(names))
+		for i, name := range names {
+			counts[i] = fmt.Sprintf("%s %d", name, tools[name])
+		}
+		fmt.Printf("\nTools: %s\n", strings.Join(counts, ", "))
+	}
+	if len(unpriced) > 0 {
+		models := make([]string, 0, len(unpriced))
+		for model := range unpriced {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		fmt.Printf("\nNo price known for %s, not included in the cost\n", strings.Join(models, ", "))
+	}
+	fmt.Println("\nCosts are estimates from list prices.")
+	return nil
+}
+
+func newUsageTotals(key string) *usageTotals {
+	return &usageTotals{key: key, sessions: map[string]bool{}, files: map[string]bool{}}
+}
+
+// parseSince turns a --since value into a start time: a number of days like
+// 30d, a Go duration like 12h, or a date
+func parseSince(since string, now time.Time) (time.Time, error) {
+	if since == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(since, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(since); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", since, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, want e.g. 7d, 12h or 2006-01-02", since)
+}
+
+func formatCost(cost float64) string {
+	if cost > 0 && cost < 0.01 {
+		return "<$0.01"
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+// formatTokens abbreviates a token count, e.g. 12.3k or 4.5M
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	default:
+		return strconv.Itoa(n)
+	}
+}
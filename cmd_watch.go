@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// runWatchCommand handles `codegent watch [flags]`, which monitors the
+// working directory and offers to resolve TODO comments addressed to the
+// agent, TODO(AI): ... or TODO: AI: ..., as soon as they are added. With
+// --test it also re-runs the tests affected by each save and offers fixes
+// when they fail.
+func runWatchCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("codegent watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Second, "how often to check for changed files")
+	test := fs.Bool("test", false, "re-run affected tests when files change and offer fixes for failures")
+	testCommand := fs.String("test-command", "", "command to run on every change in test mode (default: the tests of the changed files' languages, for Go only the changed packages)")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "watch"
+	agent := NewAgent(client, stdinMessages(), defaultTools(), session, config)
+	modelConfig := agent.newModelConfig(ctx)
+
+	// Only comments added from now on are offered
+	known := make(map[string]bool)
+	todos, err := findTodos(ctx, ".")
+	if err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		known[todo.File+"\x00"+todo.Text] = true
+	}
+
+	fmt.Fprintln(agent.out, "=== Watching for TODO(AI) comments (use 'ctrl-c' to quit) ===")
+	if *test {
+		fmt.Fprintln(agent.out, "Running affected tests on every save")
+	}
+	watchFiles(ctx, ".", *interval, func(changed []string) {
+		if *test {
+			agent.testChanges(ctx, modelConfig, changed, *testCommand)
+		}
+		for _, path := range changed {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			for _, todo := range scanTodos(path, content) {
+				key := todo.File + "\x00" + todo.Text
+				if known[key] || !todo.aiTagged() {
+					continue
+				}
+				known[key] = true
+				if !agent.confirm(fmt.Sprintf("%s: %s:%d %s %s\nResolve it?", paint(roleNotice, "todo"), todo.File, todo.Line, todo.Tag, todo.Text)) {
+					continue
+				}
+				prompt := fmt.Sprintf("Resolve the TODO comment at %s:%d: %s\n\nImplement what it asks, keeping the change focused, then remove the TODO comment.", todo.File, todo.Line, todo.Text)
+				if _, err := agent.handleRequest(ctx, modelConfig, prompt); err != nil {
+					fmt.Fprintln(agent.out, "ERROR:", err)
+				}
+			}
+		}
+	})
+	return ctx.Err()
+}
+
+// testChanges runs the tests affected by the changed files and, when they
+// fail, offers to have the agent fix them
+func (a *Agent) testChanges(ctx context.Context, modelConfig *genai.GenerateContentConfig, changed []string, command string) {
+	if command == "" {
+		command = changedTestCommand(changed)
+		if command == "" {
+			return
+		}
+	}
+
+	fmt.Fprintf(a.out, "%s: %s\n", paint(roleTool, "test"), command)
+	output, err := runCheck(ctx, command, nil)
+	if err == nil {
+		fmt.Fprintln(a.out, "tests passed")
+		return
+	}
+	fmt.Fprint(a.out, truncateOutput(output, 1000))
+	if !a.confirm("Tests failed. Propose a fix?") {
+		return
+	}
+
+	prompt := fmt.Sprintf("I just edited %s and `%s` now fails:\n\n%s\nFind the cause and fix it. If the test itself is wrong rather than my change, say so instead of changing it.",
+		strings.Join(changed, ", "), command, truncateOutput(output, 4000))
+	if _, err := a.handleRequest(ctx, modelConfig, prompt); err != nil {
+		fmt.Fprintln(a.out, "ERROR:", err)
+		return
+	}
+	if _, err := runCheck(ctx, command, nil); err == nil {
+		fmt.Fprintln(a.out, "tests pass now")
+	} else {
+		fmt.Fprintln(a.out, "tests still fail")
+	}
+}
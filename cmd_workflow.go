@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const workflowUsage = `usage: codegent workflow <command>
+
+Commands:
+  list                         List the workflows
+  show <name>                  Print a workflow's steps
+  run <name> [flags] [var=value ...]
+                               Run a workflow's steps in order
+
+Workflows are YAML files in .codegent/workflows of the project or in
+~/.codegent/workflows, such as .codegent/workflows/release-prep.yaml.`
+
+// defaultStepAttempts is how often a step is tried before its success
+// criteria count as failed
+const defaultStepAttempts = 3
+
+// workflow is a repeatable multi-step automation. Its steps are requests
+// made one after the other in a single session, so later steps know what
+// earlier ones did.
+type workflow struct {
+	Description string         `yaml:"description"`
+	Steps       []workflowStep `yaml:"steps"`
+
+	// Where it was defined
+	source string
+}
+
+type workflowStep struct {
+	Name string `yaml:"name"`
+	// The request, with {{variables}} filled from the run's var=value
+	// arguments
+	Prompt string `yaml:"prompt"`
+	// The tools the step may use, all of them when empty
+	Tools stringList `yaml:"tools"`
+	// Criteria the step is done once they hold, such as "go test ./...",
+	// see parseCriterion, with {{variables}} filled in too. What fails goes
+	// back to the model until they hold or Attempts run out.
+	Success  stringList `yaml:"success"`
+	Attempts int        `yaml:"attempts"`
+}
+
+// stringList is a YAML list of strings that may be written as one string
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	return node.Decode((*[]string)(l))
+}
+
+// workflowDirs are where workflows are looked up, the project's first
+func workflowDirs() []string {
+	dirs := []string{filepath.Join(".codegent", "workflows")}
+	if dir, err := codegentDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "workflows"))
+	}
+	return dirs
+}
+
+// loadWorkflow reads and checks the named workflow
+func loadWorkflow(name string) (*workflow, error) {
+	for _, dir := range workflowDirs() {
+		for _, ext := range []string{".yaml", ".yml"} {
+			path := filepath.Join(dir, name+ext)
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			var wf workflow
+			if err := yaml.Unmarshal(data, &wf); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+			wf.source = path
+			return &wf, wf.check()
+		}
+	}
+	return nil, fmt.Errorf("no workflow %q in %s", name, strings.Join(workflowDirs(), " or "))
+}
+
+// check names the steps that don't have a name and rejects those that
+// can't run
+func (wf *workflow) check() error {
+	if len(wf.Steps) == 0 {
+		return fmt.Errorf("%s has no steps", wf.source)
+	}
+	known := make(map[string]bool)
+	for _, tool := range defaultTools() {
+		known[tool.Name] = true
+	}
+	for i := range wf.Steps {
+		step := &wf.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if strings.TrimSpace(step.Prompt) == "" {
+			return fmt.Errorf("%s: %s needs a prompt", wf.source, step.Name)
+		}
+		if _, err := parseCriteria(step.Success); err != nil {
+			return fmt.Errorf("%s: %s: %w", wf.source, step.Name, err)
+		}
+		for _, tool := range step.Tools {
+			if !known[tool] {
+				return fmt.Errorf("%s: %s allows unknown tool %q", wf.source, step.Name, tool)
+			}
+		}
+		if step.Attempts <= 0 {
+			step.Attempts = defaultStepAttempts
+		}
+	}
+	return nil
+}
+
+// runWorkflowCommand handles `codegent workflow ...`
+func runWorkflowCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", workflowUsage)
+	}
+	switch args[0] {
+	case "list", "ls":
+		names := workflowNames()
+		if len(names) == 0 {
+			fmt.Printf("There are no workflows, add them to %s\n", strings.Join(workflowDirs(), " or "))
+			return nil
+		}
+		for _, name := range names {
+			wf, err := loadWorkflow(name)
+			if err != nil {
+				fmt.Printf("%-20s ERROR: %v\n", name, err)
+				continue
+			}
+			fmt.Printf("%-20s %s (%d steps)\n", name, wf.Description, len(wf.Steps))
+		}
+		return nil
+	case "show":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: codegent workflow show <name>")
+		}
+		wf, err := loadWorkflow(args[1])
+		if err != nil {
+			return err
+		}
+		printWorkflow(wf)
+		return nil
+	case "run":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: codegent workflow run <name> [flags] [var=value ...]")
+		}
+		return runWorkflow(ctx, args[1], args[2:])
+	default:
+		return fmt.Errorf("unknown workflow command %q\n\n%s", args[0], workflowUsage)
+	}
+}
+
+// workflowNames lists the workflows defined in workflowDirs
+func workflowNames() []string {
+	var names []string
+	for _, dir := range workflowDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			name := strings.TrimSuffix(entry.Name(), ext)
+			if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func printWorkflow(wf *workflow) {
+	fmt.Printf("%s\n", wf.source)
+	if wf.Description != "" {
+		fmt.Printf("%s\n", wf.Description)
+	}
+	for i, step := range wf.Steps {
+		fmt.Printf("\n%d. %s\n", i+1, step.Name)
+		fmt.Printf("   %s\n", strings.ReplaceAll(strings.TrimSpace(step.Prompt), "\n", "\n   "))
+		if len(step.Tools) > 0 {
+			fmt.Printf("   tools: %s\n", strings.Join(step.Tools, ", "))
+		}
+		for _, check := range step.Success {
+			fmt.Printf("   success: %s\n", check)
+		}
+	}
+}
+
+// runWorkflow handles `codegent workflow run`: each step is a request
+// restricted to the step's tools, which is done once its success criteria
+// hold. A step that doesn't get there stops the workflow; --from picks it
+// up again at that step.
+func runWorkflow(ctx context.Context, name string, args []string) error {
+	fs := flag.NewFlagSet("codegent workflow run", flag.ContinueOnError)
+	from := fs.String("from", "", "start at this step, by number or name")
+	config, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	wf, err := loadWorkflow(name)
+	if err != nil {
+		return err
+	}
+	vars := fs.Args()
+	for _, v := range vars {
+		if !strings.Contains(v, "=") {
+			return fmt.Errorf("invalid argument %q, workflows take var=value arguments", v)
+		}
+	}
+	for i := range wf.Steps {
+		step := &wf.Steps[i]
+		if step.Prompt, err = expandPrompt(step.Prompt, vars); err != nil {
+			return fmt.Errorf("%s: %w", step.Name, err)
+		}
+		for j := range step.Success {
+			if step.Success[j], err = expandPrompt(step.Success[j], vars); err != nil {
+				return fmt.Errorf("%s: %w", step.Name, err)
+			}
+		}
+	}
+	first := 0
+	if *from != "" {
+		first = slices.IndexFunc(wf.Steps, func(s workflowStep) bool { return s.Name == *from })
+		if n, err := strconv.Atoi(*from); err == nil {
+			first = n - 1
+		}
+		if first < 0 || first >= len(wf.Steps) {
+			return fmt.Errorf("no step %q in %s", *from, wf.source)
+		}
+	}
+
+	client, err := newClient(ctx, config)
+	if err != nil {
+		return err
+	}
+	session := NewSession()
+	session.Title = "workflow: " + name
+	agent := NewAgent(client, stdinMessages(), nil, session, config)
+
+	for i := first; i < len(wf.Steps); i++ {
+		step := wf.Steps[i]
+		fmt.Println(paint(roleBold, fmt.Sprintf("Step %d of %d: %s", i+1, len(wf.Steps), step.Name)))
+		agent.tools = defaultTools()
+		if len(step.Tools) > 0 {
+			agent.tools = slices.DeleteFunc(agent.tools, func(t ToolDefinition) bool { return !slices.Contains(step.Tools, t.Name) })
+		}
+		modelConfig := agent.newModelConfig(ctx)
+		criteria, err := parseCriteria(step.Success)
+		if err != nil {
+			return fmt.Errorf("step %d, %s: %w", i+1, step.Name, err)
+		}
+		if err := agent.measureBaselines(ctx, criteria); err != nil {
+			return fmt.Errorf("step %d, %s: %w", i+1, step.Name, err)
+		}
+
+		request := step.Prompt
+		for attempt := 1; ; attempt++ {
+			if _, err := agent.handleRequest(ctx, modelConfig, request); err != nil {
+				return fmt.Errorf("step %d, %s: %w", i+1, step.Name, err)
+			}
+			failures := agent.checkCriteria(ctx, criteria)
+			if failures == "" {
+				break
+			}
+			if attempt == step.Attempts {
+				return fmt.Errorf("step %d, %s, didn't meet its success criteria in %d attempts, rerun it with --from %d", i+1, step.Name, attempt, i+1)
+			}
+			request = "The step isn't done yet:\n\n" + failures + "Fix this, then give your final answer."
+		}
+	}
+	fmt.Println(paint(roleNotice, fmt.Sprintf("Workflow %s done, session %s", name, session.ID)))
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Gemini Code Assist serves the Gemini models to Google accounts, with the
+// quota of the account's Code Assist tier rather than an API key's. Its API
+// takes the Gemini API's requests wrapped in an envelope naming the model
+// and the project. A project's .env can't change the URL, since the
+// account's token is sent there.
+var codeAssistURL = userEnvOr("CODEGENT_CODE_ASSIST_URL", "https://cloudcode-pa.googleapis.com/v1internal")
+
+// codeAssistMetadata identifies the client to Code Assist
+var codeAssistMetadata = map[string]string{
+	"ideType":    "IDE_UNSPECIFIED",
+	"platform":   "PLATFORM_UNSPECIFIED",
+	"pluginType": "GEMINI",
+}
+
+// setupCodeAssist finds the account's Code Assist project, onboarding it
+// onto the default tier the first time. The free tier comes with a project
+// of its own, others need GOOGLE_CLOUD_PROJECT.
+func setupCodeAssist(ctx context.Context, login *googleLogin) error {
+	project := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	var loaded struct {
+		CurrentTier  *codeAssistTier  `json:"currentTier"`
+		AllowedTiers []codeAssistTier `json:"allowedTiers"`
+		Project      string           `json:"cloudaicompanionProject"`
+	}
+	err := login.postJSON(ctx, codeAssistURL+":loadCodeAssist", map[string]any{
+		"cloudaicompanionProject": project,
+		"metadata":                codeAssistMetadata,
+	}, &loaded)
+	if err != nil {
+		return err
+	}
+	if loaded.CurrentTier != nil {
+		if loaded.Project == "" {
+			loaded.Project = project
+		}
+		if loaded.Project == "" {
+			return errors.New("your Code Assist tier needs a Google Cloud project, set GOOGLE_CLOUD_PROJECT")
+		}
+		login.Project = loaded.Project
+		return nil
+	}
+
+	tier := codeAssistTier{ID: "legacy-tier", UserDefinedProject: true}
+	for _, allowed := range loaded.AllowedTiers {
+		if allowed.IsDefault {
+			tier = allowed
+		}
+	}
+	request := map[string]any{"tierId": tier.ID, "metadata": codeAssistMetadata}
+	if tier.UserDefinedProject {
+		if project == "" {
+			return fmt.Errorf("the %s Code Assist tier needs a Google Cloud project, set GOOGLE_CLOUD_PROJECT", tier.ID)
+		}
+		request["cloudaicompanionProject"] = project
+	}
+	// Onboarding is a long-running operation, asked for again until it's done
+	for {
+		var op struct {
+			Done     bool `json:"done"`
+			Response struct {
+				Project struct {
+					ID string `json:"id"`
+				} `json:"cloudaicompanionProject"`
+			} `json:"response"`
+		}
+		if err := login.postJSON(ctx, codeAssistURL+":onboardUser", request, &op); err != nil {
+			return err
+		}
+		if op.Done {
+			login.Project = op.Response.Project.ID
+			if login.Project == "" {
+				login.Project = project
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+type codeAssistTier struct {
+	ID                 string `json:"id"`
+	IsDefault          bool   `json:"isDefault"`
+	UserDefinedProject bool   `json:"userDefinedCloudaicompanionProject"`
+}
+
+// postJSON posts to a Google API with the account's credentials
+func (l *googleLogin) postJSON(ctx context.Context, url string, body, out any) error {
+	token, err := l.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("code assist: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newCodeAssistClient returns a Gemini API client whose requests go to
+// Code Assist instead, see codeAssistTransport
+func newCodeAssistClient(ctx context.Context, login *googleLogin) (*genai.Client, error) {
+	return genai.NewClient(ctx, &genai.ClientConfig{
+		// The client wants a key, the transport swaps it for the account's token
+		APIKey:     "code-assist",
+		Backend:    genai.BackendGeminiAPI,
+		HTTPClient: &http.Client{Transport: &codeAssistTransport{login: login}},
+	})
+}
+
+// codeAssistTransport turns the Gemini API's generateContent requests into
+// Code Assist ones and unwraps the responses. Nothing else is supported,
+// such as context caching.
+type codeAssistTransport struct {
+	login *googleLogin
+}
+
+var generateContentPath = regexp.MustCompile(`/models/([^/:]+):generateContent$`)
+
+func (t *codeAssistTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	match := generateContentPath.FindStringSubmatch(req.URL.Path)
+	if match == nil {
+		return nil, fmt.Errorf("%s isn't supported with a Google account, use an API key", req.URL.Path)
+	}
+	request, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]any{
+		"model":   match[1],
+		"project": t.login.Project,
+		"request": json.RawMessage(request),
+	})
+	if err != nil {
+		return nil, err
+	}
+	token, err := t.login.accessToken(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), http.MethodPost, codeAssistURL+":generateContent", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	out.Header = req.Header.Clone()
+	out.Header.Del("x-goog-api-key")
+	out.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	defer resp.Body.Close()
+	var wrapped struct {
+		Response json.RawMessage `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&wrapped); err != nil {
+		return nil, fmt.Errorf("code assist: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(wrapped.Response))
+	resp.ContentLength = int64(len(wrapped.Response))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// customCommand is a slash command defined in a commands.json file. It
+// runs its shell commands and tools, then sends its prompt with their
+// output attached.
+type customCommand struct {
+	Description string `json:"description"`
+	// Shell commands to run first, e.g. "git diff --staged"
+	Run []string `json:"run,omitempty"`
+	// Tool calls to make first, through the usual approvals
+	Tools []struct {
+		Name  string                 `json:"name"`
+		Input map[string]interface{} `json:"input"`
+	} `json:"tools,omitempty"`
+	// The prompt to send, with {{variables}} filled from the command's
+	// arguments, or the name of a prompt template
+	Prompt   string `json:"prompt,omitempty"`
+	Template string `json:"template,omitempty"`
+
+	// Where it was defined and whether that is the project
+	source  string
+	project bool
+}
+
+// loadCustomCommands reads ~/.codegent/commands.json and the project's
+// .codegent/commands.json, whose commands win on a name clash
+func (a *Agent) loadCustomCommands() (map[string]*customCommand, error) {
+	var paths []string
+	if dir, err := codegentDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "commands.json"))
+	}
+	paths = append(paths, filepath.Join(a.root, ".codegent", "commands.json"))
+
+	commands := make(map[string]*customCommand)
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var defined map[string]*customCommand
+		if err := json.Unmarshal(data, &defined); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for name, cmd := range defined {
+			if cmd.Prompt == "" && cmd.Template == "" {
+				return nil, fmt.Errorf("%s: /%s needs a prompt or a template", path, name)
+			}
+			cmd.source = path
+			cmd.project = i == len(paths)-1
+			commands[strings.TrimPrefix(name, "/")] = cmd
+		}
+	}
+	return commands, nil
+}
+
+// runCustomCommand runs cmd's shell commands and tools, attaches their
+// output and queues its prompt
+func (a *Agent) runCustomCommand(name string, cmd *customCommand, args string) error {
+	template := cmd.Prompt
+	if cmd.Template != "" {
+		var err error
+		if template, err = a.loadPrompt(cmd.Template); err != nil {
+			return err
+		}
+	}
+	prompt, err := expandPrompt(template, splitArgs(args))
+	if err != nil {
+		return fmt.Errorf("/%s: %w", name, err)
+	}
+
+	// A project's commands come with the repository, so they only run
+	// shell commands the user agreed to
+	if len(cmd.Run) > 0 && cmd.project && a.config.Approvals != ApprovalYolo {
+		question := fmt.Sprintf("%s: %s", paint(roleNotice, tr("approve")), tr("/%s from %s runs:\n  %s\nRun it?", name, cmd.source, strings.Join(cmd.Run, "\n  ")))
+		if !a.confirm(question) {
+			return errors.New(tr("/%s not run", name))
+		}
+	}
+
+	var attachments []string
+	for _, command := range cmd.Run {
+		fmt.Fprintf(a.out, "%s: %s\n", paint(roleTool, tr("run")), command)
+		var output string
+		var runErr error
+		progress := a.startProgress(command)
+		err := a.inWorkspace(func() { output, runErr = runCheck(context.Background(), command, progress) })
+		progress.stop()
+		if err != nil {
+			return err
+		}
+		status := ""
+		if runErr != nil {
+			status = fmt.Sprintf(" (%v)", runErr)
+		}
+		attachments = append(attachments, fmt.Sprintf("Output of `%s`%s:\n```\n%s\n```", command, status, truncateOutput(strings.TrimRight(output, "\n"), a.config.MaxToolOutputTokens)))
+	}
+	for _, call := range cmd.Tools {
+		result, _ := a.executeTool(context.Background(), call.Name, call.Input)
+		out, _ := json.Marshal(result)
+		attachments = append(attachments, fmt.Sprintf("Result of %s:\n%s", call.Name, out))
+	}
+
+	a.attachments = append(a.attachments, attachments...)
+	a.queued = prompt
+	return nil
+}
+
+// printCustomCommands lists the custom commands for /help
+func (a *Agent) printCustomCommands(w io.Writer) {
+	commands, err := a.loadCustomCommands()
+	if err != nil {
+		fmt.Fprintln(w, tr("ERROR:"), err)
+		return
+	}
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-20s %s\n", "/"+name, commands[name].Description)
+	}
+}
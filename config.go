@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Config holds the settings for an interactive run
+type Config struct {
+	Model string
+	// Tried in order when the model is overloaded, rate limited or down.
+	// "ollama:<name>" entries use a local Ollama server.
+	FallbackModels []string
+	// Models for specific kinds of turns, see Task
+	Routes    map[Task]string
+	Vertex    VertexConfig
+	Approvals ApprovalMode
+	// Sends model requests to an API-compatible gateway instead of Google
+	BaseURL string
+
+	// Per user request limits, 0 means unlimited
+	MaxTurns     int
+	MaxToolCalls int
+
+	// Per session token budget, 0 means unlimited
+	MaxSessionTokens int
+	// Estimated USD a session may cost, 0 means unlimited
+	MaxSessionCost float64
+
+	// Tool results above this many tokens are cut down, 0 means unlimited
+	MaxToolOutputTokens int
+
+	// File contents are dropped from conversations above this many tokens,
+	// 0 means most of the model's context window, see manageContext
+	ContextBudget int
+	// Attach the definitions of identifiers mentioned in messages, see
+	// attachRelevant
+	AutoContext bool
+	// Have the model review its changes before finishing a request, with
+	// the result of ReviewCheck, see selfReview
+	SelfReview  bool
+	ReviewCheck string
+
+	// How long a tool call may run before it is cancelled, 0 means no
+	// limit. ToolTimeouts overrides it for the tools it names.
+	ToolTimeout  time.Duration
+	ToolTimeouts map[string]time.Duration
+
+	// Cache the static prompt prefix with Gemini's cached-content API
+	ContextCache bool
+	CacheTTL     time.Duration
+
+	// Reasoning models: nil budget leaves it to the model, 0 turns thinking off
+	ThinkingBudget *int32
+	ShowThoughts   bool
+
+	// How to tell the user that a chat request running longer than
+	// NotifyAfter is done or needs an answer: bell, desktop or off
+	Notify      string
+	NotifyAfter time.Duration
+
+	// Tell the chat's user about new releases, see updateNotice
+	UpdateCheck bool
+
+	// Session the chat continues, by ID, title or "last", see resumeRequest
+	Resume string
+}
+
+// VertexConfig selects Vertex AI with Application Default Credentials
+// instead of the Gemini API key
+type VertexConfig struct {
+	Enabled  bool
+	Project  string
+	Location string
+}
+
+// parseFlags registers the shared flags on fs and parses args, falling back
+// to CODEGENT_* environment variables for defaults
+func parseFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	model := fs.String("model", envOr("CODEGENT_MODEL", "gemini-2.0-flash"), "Gemini model to chat with")
+	fallbackModels := fs.String("fallback-models", os.Getenv("CODEGENT_FALLBACK_MODELS"), "comma separated models to fall back to, e.g. gemini-2.0-flash,ollama:qwen2.5-coder")
+	routes := fs.String("routes", os.Getenv("CODEGENT_ROUTES"), "per-task models, e.g. plan=gemini-2.5-pro,edit=gemini-2.0-flash,review=gemini-2.5-pro")
+	vertex := fs.Bool("vertex", envBool("GOOGLE_GENAI_USE_VERTEXAI", false), "use Vertex AI with Application Default Credentials")
+	project := fs.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "GCP project for Vertex AI")
+	location := fs.String("location", envOr("GOOGLE_CLOUD_LOCATION", "us-central1"), "GCP region for Vertex AI")
+	baseURL := fs.String("base-url", userEnvOr("CODEGENT_BASE_URL", ""), "Gemini or Vertex AI API base URL, for gateways and proxies that serve the same API")
+	approvals := fs.String("approvals", userEnvOr("CODEGENT_APPROVALS", string(ApprovalDefault)), "approval mode: plan, default, auto-edit or yolo")
+	maxTurns := fs.Int("max-turns", envInt("CODEGENT_MAX_TURNS", 25), "max model iterations per request (0 = unlimited)")
+	maxToolCalls := fs.Int("max-tool-calls", envInt("CODEGENT_MAX_TOOL_CALLS", 100), "max tool calls per request (0 = unlimited)")
+	maxSessionTokens := fs.Int("max-session-tokens", envInt("CODEGENT_MAX_SESSION_TOKENS", 0), "wrap up and stop a session once it has used this many tokens (0 = unlimited)")
+	maxSessionCost := fs.Float64("max-session-cost", envFloat("CODEGENT_MAX_SESSION_COST", 0), "wrap up and stop a session once its estimated cost reaches this many US dollars (0 = unlimited)")
+	maxToolOutput := fs.Int("max-tool-output-tokens", envInt("CODEGENT_MAX_TOOL_OUTPUT_TOKENS", 10000), "truncate tool results above this many tokens (0 = unlimited)")
+	autoContext := fs.Bool("auto-context", envBool("CODEGENT_AUTO_CONTEXT", true), "attach the definitions of identifiers and source files mentioned in messages, and the code behind pasted stack traces")
+	contextBudget := fs.Int("context-budget", envInt("CODEGENT_CONTEXT_BUDGET", 0), "drop the oldest file contents from the conversation above this many tokens (0 = 80% of the model's context window)")
+	selfReview := fs.Bool("self-review", envBool("CODEGENT_SELF_REVIEW", false), "have the model review its diff against the request and fix it before finishing")
+	reviewCheck := fs.String("review-check", userEnvOr("CODEGENT_REVIEW_CHECK", defaultCheckCommand()), "shell command run for the self-review, such as the build and tests (empty to skip)")
+	toolTimeout := fs.Duration("tool-timeout", envDuration("CODEGENT_TOOL_TIMEOUT", 2*time.Minute), "cancel tool calls running longer than this (0 = no limit)")
+	toolTimeouts := fs.String("tool-timeouts", os.Getenv("CODEGENT_TOOL_TIMEOUTS"), "per-tool timeouts, e.g. find_todos=5m,read_file=10s")
+	contextCache := fs.Bool("context-cache", envBool("CODEGENT_CONTEXT_CACHE", true), "cache the system prompt and tools across turns and sessions")
+	cacheTTL := fs.Duration("cache-ttl", envDuration("CODEGENT_CACHE_TTL", time.Hour), "how long a context cache lives after its last use")
+	thinkingBudget := fs.String("thinking-budget", os.Getenv("CODEGENT_THINKING_BUDGET"), "thinking token budget for reasoning models (-1 dynamic, 0 off)")
+	showThoughts := fs.Bool("show-thoughts", envBool("CODEGENT_SHOW_THOUGHTS", false), "display the model's thought summaries")
+	notify := fs.String("notify", envOr("CODEGENT_NOTIFY", notifyBell), "when a long request is done or needs an answer: bell, desktop (notification, falling back to the bell) or off")
+	notifyAfter := fs.Duration("notify-after", envDuration("CODEGENT_NOTIFY_AFTER", 30*time.Second), "notify about requests taking longer than this")
+	resume := fs.String("resume", "", "continue a saved chat session, by ID, title or \"last\", including a request it was in the middle of")
+	updateCheck := fs.Bool("update-check", envBool("CODEGENT_UPDATE_CHECK", true), "say when a new release is out at the start of a chat")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *notify != notifyBell && *notify != notifyDesktop && *notify != notifyOff {
+		return nil, fmt.Errorf("invalid --notify %q, want bell, desktop or off", *notify)
+	}
+
+	mode, err := ParseApprovalMode(*approvals)
+	if err != nil {
+		return nil, err
+	}
+	var budget *int32
+	if *thinkingBudget != "" {
+		n, err := strconv.ParseInt(*thinkingBudget, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid thinking budget %q: %w", *thinkingBudget, err)
+		}
+		budget = genai.Ptr(int32(n))
+	}
+	taskRoutes, err := parseRoutes(*routes)
+	if err != nil {
+		return nil, err
+	}
+	perTool, err := parseToolTimeouts(*toolTimeouts)
+	if err != nil {
+		return nil, err
+	}
+	if *vertex && *project == "" {
+		return nil, fmt.Errorf("--vertex needs a GCP project, set --project or GOOGLE_CLOUD_PROJECT")
+	}
+	return &Config{
+		Model:          *model,
+		FallbackModels: splitList(*fallbackModels),
+		Routes:         taskRoutes,
+		Vertex: VertexConfig{
+			Enabled:  *vertex,
+			Project:  *project,
+			Location: *location,
+		},
+		Approvals:           mode,
+		BaseURL:             *baseURL,
+		ThinkingBudget:      budget,
+		ShowThoughts:        *showThoughts,
+		MaxTurns:            *maxTurns,
+		MaxToolCalls:        *maxToolCalls,
+		MaxSessionTokens:    *maxSessionTokens,
+		MaxSessionCost:      *maxSessionCost,
+		MaxToolOutputTokens: *maxToolOutput,
+		ContextBudget:       *contextBudget,
+		AutoContext:         *autoContext,
+		SelfReview:          *selfReview,
+		ReviewCheck:         *reviewCheck,
+		ToolTimeout:         *toolTimeout,
+		ToolTimeouts:        perTool,
+		ContextCache:        *contextCache,
+		CacheTTL:            *cacheTTL,
+		Notify:              *notify,
+		NotifyAfter:         *notifyAfter,
+		UpdateCheck:         *updateCheck,
+		Resume:              *resume,
+	}, nil
+}
+
+// parseToolTimeouts parses comma separated tool=duration pairs
+func parseToolTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, item := range splitList(s) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid tool timeout %q, want tool=duration", item)
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tool timeout %q: %w", item, err)
+		}
+		timeouts[name] = timeout
+	}
+	return timeouts, nil
+}
+
+// toolTimeout returns how long the named tool may run, 0 for no limit
+func (c *Config) toolTimeout(name string) time.Duration {
+	if timeout, ok := c.ToolTimeouts[name]; ok {
+		return timeout
+	}
+	return c.ToolTimeout
+}
+
+// codegentDir is where codegent keeps its per-user state
+func codegentDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".codegent"), nil
+}
+
+// splitList splits a comma separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// startEnv holds the names of the variables codegent was started with.
+// Package variables are set before main loads the project's .env.
+var startEnv = environNames()
+
+func environNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		names[name] = true
+	}
+	return names
+}
+
+// userEnvOr is envOr for settings the project's .env may not change, such
+// as where credentials or the binary come from: a cloned repository picks
+// that file.
+func userEnvOr(key, fallback string) string {
+	if !startEnv[key] {
+		return fallback
+	}
+	return envOr(key, fallback)
+}
+
+func envInt(key string, fallback int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Where file contents come into the conversation
+const (
+	sourceRead    = "read_file"
+	sourceMention = "@mention"
+	sourceSymbol  = "symbol"
+	sourcePinned  = "pinned"
+	sourceTrace   = "trace"
+)
+
+// droppedPrefix starts what replaces file contents dropped from the
+// conversation, see dropItem
+const droppedPrefix = "[dropped from the context: "
+
+// contextItem is a file's contents somewhere in the conversation
+type contextItem struct {
+	path    string
+	source  string
+	partial bool // a line range rather than the whole file
+	tokens  int
+	index   int // of the message in the history, older first
+	part    *genai.Part
+}
+
+// contextItems lists the file contents the model can currently see, oldest
+// first: read_file results and attached files, pinned or mentioned
+func (a *Agent) contextItems() []contextItem {
+	var items []contextItem
+	var calls []*genai.FunctionCall
+	for i, content := range a.history {
+		if content.Role == genai.RoleModel {
+			calls = nil
+			for _, part := range content.Parts {
+				if part.FunctionCall != nil {
+					calls = append(calls, part.FunctionCall)
+				}
+			}
+			continue
+		}
+		// Tool results answer the calls before them in order
+		n := 0
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionResponse != nil:
+				call := (*genai.FunctionCall)(nil)
+				if n < len(calls) {
+					call = calls[n]
+				}
+				n++
+				result, _ := part.FunctionResponse.Response["result"].(string)
+				if call == nil || call.Name != "read_file" || result == "" || strings.HasPrefix(result, droppedPrefix) {
+					continue
+				}
+				path, _ := call.Args["path"].(string)
+				items = append(items, contextItem{
+					path:    filepath.Clean(path),
+					source:  sourceRead,
+					partial: call.Args["start_line"] != nil || call.Args["end_line"] != nil,
+					tokens:  len(result) / 4,
+					index:   i,
+					part:    part,
+				})
+			case part.Text != "":
+				if path, source, partial, ok := attachedFile(part.Text); ok {
+					items = append(items, contextItem{path: path, source: source, partial: partial, tokens: len(part.Text) / 4, index: i, part: part})
+				}
+			}
+		}
+	}
+	return items
+}
+
+// attachedFile recognizes a file attached to a message, see attachMentions,
+// pinnedAttachments, definitionSource and attachStackTrace
+func attachedFile(text string) (path, source string, partial, ok bool) {
+	header, _, found := strings.Cut(text, ":\n```")
+	if !found {
+		return "", "", false, false
+	}
+	if path, ok := strings.CutPrefix(header, "Contents of "); ok {
+		return filepath.Clean(path), sourceMention, false, true
+	}
+	if path, ok := strings.CutPrefix(header, "Pinned file "); ok {
+		return filepath.Clean(path), sourcePinned, false, true
+	}
+	if def, ok := strings.CutPrefix(header, "Definition of "); ok {
+		_, rest, _ := strings.Cut(def, " from ")
+		if path, _, found := strings.Cut(rest, ", lines "); found {
+			return filepath.Clean(path), sourceSymbol, true, true
+		}
+	}
+	if around, ok := strings.CutPrefix(header, "Code around "); ok {
+		if location, _, found := strings.Cut(around, ", lines "); found {
+			path := location[:max(strings.LastIndex(location, ":"), 0)]
+			return filepath.Clean(path), sourceTrace, true, true
+		}
+	}
+	return "", "", false, false
+}
+
+// dropItem replaces a file's contents in the conversation with a note, so
+// the model knows to read it again if it still needs it
+func dropItem(item contextItem) {
+	note := droppedPrefix + item.path + ", read it again if you need it]"
+	if item.part.FunctionResponse != nil {
+		item.part.FunctionResponse.Response = map[string]any{"result": note}
+	} else {
+		item.part.Text = note
+	}
+}
+
+// contextBudget is how many tokens the conversation may take before file
+// contents are dropped: --context-budget, or most of the model's context
+// window. 0 when neither is known.
+func (a *Agent) contextBudget() int {
+	if a.config.ContextBudget > 0 {
+		return a.config.ContextBudget
+	}
+	return contextLimit(a.config.modelFor(TaskPlan)) * contextWarning / 100
+}
+
+// manageContext runs before each request. It drops file contents that are
+// stale, because the file was read in full again later or is pinned and
+// sent anew, and then, when the last model call went over the context
+// budget, the oldest contents that aren't pinned until it fits.
+func (a *Agent) manageContext() {
+	items := a.contextItems()
+	freed := 0
+	var kept []contextItem
+	for i, item := range items {
+		stale := slices.Contains(a.session.Pinned, item.path)
+		for _, later := range items[i+1:] {
+			if later.path == item.path && !later.partial {
+				stale = true
+			}
+		}
+		if stale {
+			dropItem(item)
+			freed += item.tokens
+		} else {
+			kept = append(kept, item)
+		}
+	}
+
+	budget := a.contextBudget()
+	if budget == 0 || a.contextTokens-freed <= budget {
+		return
+	}
+	var dropped []string
+	for _, item := range kept {
+		if a.contextTokens-freed <= budget {
+			break
+		}
+		dropItem(item)
+		freed += item.tokens
+		if !slices.Contains(dropped, item.path) {
+			dropped = append(dropped, item.path)
+		}
+	}
+	if len(dropped) > 0 {
+		fmt.Fprintf(a.out, "%s: %s\n", paint(roleNotice, tr("context")), tr("over the %s token budget, dropped %s", formatTokens(budget), strings.Join(dropped, ", ")))
+	}
+}
+
+// pinnedAttachments attaches the current contents of the pinned files,
+// which go with every message
+func (a *Agent) pinnedAttachments() []string {
+	var attachments []string
+	for _, path := range a.session.Pinned {
+		var content []byte
+		var err error
+		a.inWorkspace(func() {
+			if content, err = os.ReadFile(path); err == nil {
+				a.fileTracker().record(path, content)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(a.out, "%s: %s\n", paint(roleWarn, tr("pinned")), err)
+			continue
+		}
+		attachments = append(attachments, fmt.Sprintf("Pinned file %s:\n```\n%s\n```", path, truncateOutput(strings.TrimRight(string(content), "\n"), a.config.MaxToolOutputTokens)))
+	}
+	return attachments
+}
+
+// printContext shows which files the model can see, for /context
+func (a *Agent) printContext() {
+	items := a.contextItems()
+	total := 0
+	for _, item := range items {
+		total += item.tokens
+	}
+	fmt.Fprintln(a.out, tr("Files in context: ~%s tokens, of %s in the conversation", formatTokens(total), formatTokens(a.contextTokens)))
+	for _, item := range items {
+		path := item.path
+		if item.partial {
+			path += " " + tr("(lines)")
+		}
+		fmt.Fprintf(a.out, "  %-10s %-40s %s\n", item.source, path, formatTokens(item.tokens))
+	}
+	for _, path := range a.session.Pinned {
+		if !slices.ContainsFunc(items, func(item contextItem) bool { return item.path == path && item.source == sourcePinned }) {
+			fmt.Fprintf(a.out, "  %-10s %-40s %s\n", sourcePinned, path, tr("sent with the next message"))
+		}
+	}
+}
+
+// pin keeps a file's current contents in every message, for /pin
+func (a *Agent) pin(path string) error {
+	path = filepath.Clean(path)
+	var err error
+	a.inWorkspace(func() {
+		var info os.FileInfo
+		if info, err = os.Stat(path); err == nil && !info.Mode().IsRegular() {
+			err = errors.New(tr("%s is not a file", path))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(a.session.Pinned, path) {
+		a.session.Pinned = append(a.session.Pinned, path)
+	}
+	a.autosave()
+	fmt.Fprintln(a.out, tr("Pinned %s, its current contents go with every message", path))
+	return nil
+}
+
+// drop unpins a file and drops its contents from the conversation, for
+// /drop
+func (a *Agent) drop(path string) error {
+	path = filepath.Clean(path)
+	unpinned := slices.Contains(a.session.Pinned, path)
+	a.session.Pinned = slices.DeleteFunc(a.session.Pinned, func(p string) bool { return p == path })
+	dropped := 0
+	for _, item := range a.contextItems() {
+		if item.path == path {
+			dropItem(item)
+			dropped++
+		}
+	}
+	if dropped == 0 && !unpinned {
+		return errors.New(tr("%s is not in the context", path))
+	}
+	a.autosave()
+	fmt.Fprintln(a.out, tr("Dropped %s from the context", path))
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// maxCriteriaRounds is how often the model is sent back to work when it
+// declares a request done while its success criteria aren't met
+const maxCriteriaRounds = 3
+
+var errCriteriaUnmet = errors.New("success criteria not met")
+
+var (
+	// goBenchResult is a result line of go test -bench
+	goBenchResult = regexp.MustCompile(`([0-9.]+) ns/op`)
+	number        = regexp.MustCompile(`-?[0-9]+(\.[0-9]+)?`)
+)
+
+// criterion is a machine-checkable condition a request is only done when
+// it holds, see parseCriterion
+type criterion struct {
+	Spec string // as given
+
+	// Shell command that must exit 0, or the benchmark to run
+	Command string
+	// File that must contain a match of Pattern
+	File    string
+	Pattern *regexp.Regexp
+	// Whether Command is a benchmark whose result must get below Baseline,
+	// measured before the request
+	Bench    bool
+	Baseline float64
+}
+
+// parseCriterion parses one of
+//
+//	file:<path> <regexp>   the file must contain a match of the expression
+//	bench:<command>        the benchmark must improve, see benchResult
+//	<command>              the shell command must exit 0
+func parseCriterion(spec string) (*criterion, error) {
+	spec = strings.TrimSpace(spec)
+	c := &criterion{Spec: spec}
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		path, expr, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(spec, "file:")), " ")
+		if !ok || strings.TrimSpace(expr) == "" {
+			return nil, fmt.Errorf("invalid criterion %q, want file:<path> <regexp>", spec)
+		}
+		pattern, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid criterion %q: %w", spec, err)
+		}
+		c.File, c.Pattern = path, pattern
+	case strings.HasPrefix(spec, "bench:"):
+		c.Command, c.Bench = strings.TrimSpace(strings.TrimPrefix(spec, "bench:")), true
+	default:
+		c.Command = spec
+	}
+	if c.File == "" && c.Command == "" {
+		return nil, fmt.Errorf("invalid criterion %q, it has no command", spec)
+	}
+	return c, nil
+}
+
+// parseCriteria parses several criteria, see parseCriterion
+func parseCriteria(specs []string) ([]*criterion, error) {
+	var criteria []*criterion
+	for _, spec := range specs {
+		c, err := parseCriterion(spec)
+		if err != nil {
+			return nil, err
+		}
+		criteria = append(criteria, c)
+	}
+	return criteria, nil
+}
+
+// benchResult reads a benchmark's result from its output: the sum of the
+// ns/op of go test -bench, or else the last number printed. Lower is
+// better.
+func benchResult(output string) (float64, bool) {
+	if matches := goBenchResult.FindAllStringSubmatch(output, -1); len(matches) > 0 {
+		total := 0.0
+		for _, m := range matches {
+			v, _ := strconv.ParseFloat(m[1], 64)
+			total += v
+		}
+		return total, true
+	}
+	numbers := number.FindAllString(output, -1)
+	if len(numbers) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(numbers[len(numbers)-1], 64)
+	return v, err == nil
+}
+
+// runBench runs a benchmark criterion and reads its result
+func (a *Agent) runBench(ctx context.Context, c *criterion) (float64, string, error) {
+	fmt.Fprintf(a.out, "%s: %s\n", paint(roleTool, "bench"), c.Command)
+	progress := a.startProgress("bench")
+	var output string
+	var err error
+	a.inWorkspace(func() { output, err = runCheck(ctx, c.Command, progress) })
+	progress.stop()
+	if err != nil {
+		return 0, output, err
+	}
+	result, ok := benchResult(output)
+	if !ok {
+		return 0, output, errors.New("it printed no result")
+	}
+	return result, output, nil
+}
+
+// measureBaselines runs the benchmark criteria before the work starts, so
+// there is something to improve on
+func (a *Agent) measureBaselines(ctx context.Context, criteria []*criterion) error {
+	for _, c := range criteria {
+		if !c.Bench {
+			continue
+		}
+		result, output, err := a.runBench(ctx, c)
+		if err != nil {
+			return fmt.Errorf("failed to measure the baseline of %s: %w\n%s", c.Command, err, truncateOutput(output, 500))
+		}
+		c.Baseline = result
+		fmt.Fprintln(a.out, tr("Baseline: %s", strconv.FormatFloat(result, 'g', -1, 64)))
+	}
+	return nil
+}
+
+// checkCriteria checks each criterion in the workspace and describes the
+// ones that don't hold, for the model. All holding gives "".
+func (a *Agent) checkCriteria(ctx context.Context, criteria []*criterion) string {
+	var failures strings.Builder
+	for _, c := range criteria {
+		switch {
+		case c.File != "":
+			var content []byte
+			var err error
+			a.inWorkspace(func() { content, err = os.ReadFile(c.File) })
+			fmt.Fprintf(a.out, "%s: %s\n", paint(roleTool, "check"), c.Spec)
+			switch {
+			case err != nil:
+				fmt.Fprintf(a.out, "check failed: %v\n", err)
+				fmt.Fprintf(&failures, "%s must contain a match of `%s`, but it can't be read: %v\n\n", c.File, c.Pattern, err)
+			case !c.Pattern.Match(content):
+				fmt.Fprintln(a.out, "check failed: no match")
+				fmt.Fprintf(&failures, "%s must contain a match of `%s`, but doesn't.\n\n", c.File, c.Pattern)
+			default:
+				fmt.Fprintln(a.out, "check passed")
+			}
+		case c.Bench:
+			result, output, err := a.runBench(ctx, c)
+			switch {
+			case err != nil:
+				fmt.Fprintf(a.out, "check failed: %v\n", err)
+				fmt.Fprintf(&failures, "The benchmark `%s` failed, %v:\n\n%s\n\n", c.Command, err, truncateOutput(output, 2000))
+			case result >= c.Baseline:
+				fmt.Fprintf(a.out, "check failed: %g, baseline %g\n", result, c.Baseline)
+				fmt.Fprintf(&failures, "The benchmark `%s` must improve on %g, before your changes, but gave %g. Lower is better.\n\n", c.Command, c.Baseline, result)
+			default:
+				fmt.Fprintf(a.out, "check passed: %g, baseline %g\n", result, c.Baseline)
+			}
+		default:
+			failures.WriteString(a.checkCommand(ctx, c.Command))
+		}
+	}
+	return failures.String()
+}
+
+// checkCommand runs a shell command that must exit 0 in the workspace and
+// describes its failure, with its output
+func (a *Agent) checkCommand(ctx context.Context, check string) string {
+	fmt.Fprintf(a.out, "%s: %s\n", paint(roleTool, "check"), check)
+	progress := a.startProgress("check")
+	var output string
+	var err error
+	a.inWorkspace(func() { output, err = runCheck(ctx, check, progress) })
+	progress.stop()
+	if err != nil {
+		fmt.Fprintf(a.out, "check failed: %v\n", err)
+		return fmt.Sprintf("The check `%s` failed, %v:\n\n%s\n\n", check, err, truncateOutput(output, 2000))
+	}
+	fmt.Fprintln(a.out, "check passed")
+	return ""
+}
+
+// meetCriteria is called when the model gives its final answer. While the
+// request's criteria don't hold it sends the model back to work and returns
+// its response; nil means they hold, or that there are none.
+func (a *Agent) meetCriteria(ctx context.Context, modelConfig *genai.GenerateContentConfig, rounds int) (*genai.GenerateContentResponse, error) {
+	if len(a.criteria) == 0 {
+		return nil, nil
+	}
+	failures := a.checkCriteria(ctx, a.criteria)
+	if failures == "" {
+		return nil, nil
+	}
+	if rounds == maxCriteriaRounds {
+		return nil, fmt.Errorf("%w after %d attempts:\n%s", errCriteriaUnmet, rounds, strings.TrimSpace(failures))
+	}
+	prompt := "You aren't done yet, the request's success criteria aren't met:\n\n" + failures + "Fix this with the tools, then give your final answer."
+	return a.runInference(ctx, modelConfig, TaskEdit, genai.NewPartFromText(prompt))
+}
+
+// addCriterion attaches a criterion to the next request, for /done-when
+func (a *Agent) addCriterion(ctx context.Context, spec string) error {
+	c, err := parseCriterion(spec)
+	if err != nil {
+		return err
+	}
+	if err := a.measureBaselines(ctx, []*criterion{c}); err != nil {
+		return err
+	}
+	a.criteria = append(a.criteria, c)
+	fmt.Fprintln(a.out, tr("The next request is done when: %s", c.Spec))
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed crontab time specification: minute, hour, day of
+// month, month and day of week, each the set of values it matches
+type cronSchedule struct {
+	minute, hour, dom, month, dow [61]bool
+	// Cron matches either day field when both are restricted
+	domAny, dowAny bool
+}
+
+var cronShortcuts = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses five crontab fields or a shortcut such as @daily. Fields
+// take *, numbers, ranges such as 1-5, steps such as */15 and lists of
+// those; days of the week run from 0, Sunday, to 6, with 7 also Sunday.
+func parseCron(spec string) (*cronSchedule, error) {
+	if expanded, ok := cronShortcuts[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, want five fields such as \"0 6 * * *\" or @daily", spec)
+	}
+	s := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, f := range []struct {
+		set      *[61]bool
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		if err := parseCronField(fields[i], f.min, f.max, f.set); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, set *[61]bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if r, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("bad step in %q", part)
+			}
+			rng, step = r, n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return fmt.Errorf("bad range %q", part)
+				}
+			} else if step > 1 {
+				// 5/15 means from 5 on, every 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// matches reports whether the schedule fires in t's minute
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.month[int(t.Month())] && s.dayMatches(t) && s.hour[t.Hour()] && s.minute[t.Minute()]
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first minute after t the schedule fires in, or the zero
+// time when it never does, such as on February 30th
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every schedule that can fire does so within a few years. Months, days
+	// and hours that don't match are skipped whole.
+	for end := t.AddDate(5, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		y, m, d := t.Date()
+		switch {
+		case !s.month[int(m)]:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case !s.hour[t.Hour()]:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location()).Add(-time.Minute)
+		case s.minute[t.Minute()]:
+			return t
+		}
+	}
+	return time.Time{}
+}
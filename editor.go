@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/readline"
+)
+
+// lineEditor reads chat input with line editing, history and tab
+// completion. It is also the agent's output: text printed after the last
+// newline, such as "You: " or an approval question, is held back and given
+// to readline as the prompt, so it is redrawn along with the line.
+type lineEditor struct {
+	rl       *readline.Instance
+	bindings map[rune]string
+
+	mu      sync.Mutex
+	pending []byte
+	// Set when the line was ended with the newline key
+	newline bool
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// useLineEditor switches the agent to a line editor on the terminal, with
+// the keys bound in CODEGENT_KEYS. The returned function restores the
+// terminal.
+func (a *Agent) useLineEditor() (func(), error) {
+	bindings, err := parseKeyBindings(os.Getenv("CODEGENT_KEYS"))
+	if err != nil {
+		return nil, fmt.Errorf("CODEGENT_KEYS: %w", err)
+	}
+	e := &lineEditor{bindings: bindings}
+	config := &readline.Config{
+		AutoComplete:           completer{a},
+		DisableAutoSaveHistory: true,
+		HistorySearchFold:      true,
+		FuncFilterInputRune:    e.filterKey,
+	}
+	if dir, err := codegentDir(); err == nil && os.MkdirAll(dir, 0755) == nil {
+		config.HistoryFile = filepath.Join(dir, "history")
+	}
+	rl, err := readline.NewFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	e.rl = rl
+	a.out = e
+	a.getUserMessage = e.message
+	return func() { rl.Close() }, nil
+}
+
+func (e *lineEditor) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, p...)
+	if i := bytes.LastIndexByte(e.pending, '\n'); i >= 0 {
+		if _, err := e.rl.Write(e.pending[:i+1]); err != nil {
+			return 0, err
+		}
+		e.pending = append([]byte(nil), e.pending[i+1:]...)
+	}
+	return len(p), nil
+}
+
+// filterKey turns bound keys into the runes the line editor handles their
+// action with
+func (e *lineEditor) filterKey(r rune) (rune, bool) {
+	action, ok := e.bindings[r]
+	if !ok {
+		return r, true
+	}
+	if action == keyNewline {
+		e.mu.Lock()
+		e.newline = true
+		e.mu.Unlock()
+	}
+	return keyActions[action], true
+}
+
+// message reads a message, with the held back output as its prompt. Lines
+// ended with the newline key are continued on the next. The interrupt key
+// and ctrl-d end the input.
+func (e *lineEditor) message() (string, bool) {
+	e.mu.Lock()
+	prompt := string(e.pending)
+	e.pending = nil
+	e.mu.Unlock()
+
+	e.rl.SetPrompt(prompt)
+	var lines []string
+	for {
+		e.mu.Lock()
+		e.newline = false
+		e.mu.Unlock()
+		line, err := e.rl.Readline()
+		if err != nil {
+			return "", false
+		}
+		lines = append(lines, line)
+
+		e.mu.Lock()
+		more := e.newline
+		e.mu.Unlock()
+		if !more {
+			break
+		}
+		e.rl.SetPrompt(paint(roleDim, "... "))
+	}
+	message := strings.Join(lines, "\n")
+
+	// Answers to yes/no questions aren't worth recalling, and the history
+	// file holds one line per entry
+	if strings.TrimSpace(message) != "" && len(lines) == 1 && !strings.HasSuffix(prompt, tr("[y/N]")+" ") {
+		e.rl.SaveToHistory(message)
+	}
+	return message, true
+}
+
+// completer completes slash commands, their arguments and @file mentions
+type completer struct {
+	a *Agent
+}
+
+func (c completer) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	word := text[strings.LastIndexAny(text, " \t")+1:]
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(word, "@"):
+		word = word[1:]
+		candidates = c.paths(word)
+	case strings.HasPrefix(text, "/") && text == word:
+		word = word[1:]
+		candidates = c.commands()
+	case (strings.HasPrefix(text, "/pin ") && text == "/pin "+word) || (strings.HasPrefix(text, "/drop ") && text == "/drop "+word):
+		candidates = c.paths(word)
+	case strings.HasPrefix(text, "/resume ") && text == "/resume "+word:
+		candidates = c.sessions()
+	case strings.HasPrefix(text, "/prompt ") && text == "/prompt "+word:
+		for _, name := range c.a.promptNames() {
+			candidates = append(candidates, name+" ")
+		}
+	}
+
+	var suffixes [][]rune
+	for _, candidate := range candidates {
+		if rest, ok := strings.CutPrefix(candidate, word); ok {
+			suffixes = append(suffixes, []rune(rest))
+		}
+	}
+	return suffixes, len([]rune(word))
+}
+
+// commands lists the built-in and custom slash command names
+func (c completer) commands() []string {
+	var names []string
+	for _, cmd := range slashCommands {
+		names = append(names, cmd.Name+" ")
+	}
+	if custom, err := c.a.loadCustomCommands(); err == nil {
+		for name := range custom {
+			names = append(names, name+" ")
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sessions lists saved session IDs and titles
+func (c completer) sessions() []string {
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, s := range sessions {
+		names = append(names, s.ID)
+		if s.Title != "" && !strings.ContainsAny(s.Title, " \t") {
+			names = append(names, s.Title)
+		}
+	}
+	return names
+}
+
+// paths lists the entries of the directory part of prefix, relative to the
+// workspace, with a trailing slash on directories
+func (c completer) paths(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	entries, err := os.ReadDir(filepath.Join(c.a.root, dir, "."))
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if entry.IsDir() {
+			paths = append(paths, dir+name+"/")
+		} else {
+			paths = append(paths, dir+name+" ")
+		}
+	}
+	return paths
+}
+
+var _ io.Writer = (*lineEditor)(nil)
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genai"
+)
+
+// generate runs one model turn on model, moving down the fallback chain
+// when it is overloaded, rate limited or unreachable
+func (a *Agent) generate(ctx context.Context, model string, contents []*genai.Content, modelConfig *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	models := []string{model}
+	for _, fallback := range a.config.FallbackModels {
+		if fallback != model {
+			models = append(models, fallback)
+		}
+	}
+
+	var lastErr error
+	for i, model := range models {
+		resp, err := a.generateWith(ctx, model, contents, modelConfig)
+		if err == nil {
+			return resp, nil
+		}
+		if !isUnavailable(err) {
+			return nil, err
+		}
+		lastErr = err
+		if i+1 < len(models) {
+			fmt.Fprintf(a.out, "%s: %s\n", paint(roleNotice, tr("fallback")), tr("%s unavailable (%s), using %s", model, unavailableReason(err), models[i+1]))
+		}
+	}
+	return nil, lastErr
+}
+
+func (a *Agent) generateWith(ctx context.Context, model string, contents []*genai.Content, modelConfig *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	// The context cache belongs to the primary model, others get the
+	// system prompt and tools inline
+	if model != a.config.Model && modelConfig.CachedContent != "" {
+		uncached := *modelConfig
+		uncached.CachedContent = ""
+		uncached.SystemInstruction = systemInstruction(a.root)
+		uncached.Tools = a.geminiTools()
+		modelConfig = &uncached
+	}
+
+	system := "gemini"
+	if strings.HasPrefix(model, ollamaPrefix) {
+		system = "ollama"
+	}
+	ctx, span := tracer.Start(ctx, "chat "+model, trace.WithAttributes(
+		attribute.String("gen_ai.system", system),
+		attribute.String("gen_ai.request.model", model),
+	))
+	var resp *genai.GenerateContentResponse
+	var err error
+	progress := a.startProgress("waiting for " + model)
+	if system == "ollama" {
+		resp, err = ollamaGenerate(ctx, model, contents, modelConfig)
+	} else {
+		resp, err = a.client.Models.GenerateContent(ctx, model, contents, modelConfig)
+	}
+	progress.stop()
+	if err == nil {
+		span.SetAttributes(usageAttributes(resp.UsageMetadata)...)
+		a.recordModelCall(model, resp.UsageMetadata)
+	}
+	endSpan(span, err)
+	observeModelCall(model, resp, err)
+	return resp, err
+}
+
+// isUnavailable reports whether err means the model can't serve right now,
+// as opposed to a problem with the request itself
+func isUnavailable(err error) bool {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= 500
+	}
+	var netErr net.Error
+	var opErr *net.OpError
+	return errors.As(err, &netErr) || errors.As(err, &opErr)
+}
+
+func unavailableReason(err error) string {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) && apiErr.Status != "" {
+		return apiErr.Status
+	}
+	return err.Error()
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileVersions remembers the content hash of every file the model has read
+// or written, so edits can detect changes made on disk in the meantime
+var fileVersions = newFileTracker()
+
+func newFileTracker() *fileTracker {
+	return &fileTracker{hashes: make(map[string][32]byte)}
+}
+
+type fileTracker struct {
+	mu     sync.Mutex
+	hashes map[string][32]byte
+
+	// While journaling, the content each file had before its first write
+	journaling bool
+	journal    []journalEntry
+	journaled  map[string]bool
+}
+
+// journalEntry is the original state of a file changed while journaling
+type journalEntry struct {
+	path    string
+	content []byte
+	existed bool
+}
+
+func trackerKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// record stores the content the model has now seen for path
+func (t *fileTracker) record(path string, content []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hashes[trackerKey(path)] = sha256.Sum256(content)
+}
+
+// check fails if path was seen before and its content on disk has changed
+// since. Files the model never looked at are not checked.
+func (t *fileTracker) check(path string, content []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	seen, ok := t.hashes[trackerKey(path)]
+	if !ok || seen == sha256.Sum256(content) {
+		return nil
+	}
+	return newToolError(errConflict, fmt.Sprintf("conflict: %s changed on disk since you last read it", path), "Read it again with read_file before editing.")
+}
+
+// startJournal begins remembering the original content of every file
+// written from now on, so the changes can be reviewed and reverted together
+func (t *fileTracker) startJournal() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.journaling = true
+	t.journal = nil
+	t.journaled = make(map[string]bool)
+}
+
+// journalingNow reports whether a journal is being kept
+func (t *fileTracker) journalingNow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.journaling
+}
+
+// beforeWrite journals the current content of path if this is its first
+// write since journaling started. Call it before changing a file.
+func (t *fileTracker) beforeWrite(path string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := trackerKey(path)
+	if !t.journaling || t.journaled[key] {
+		return
+	}
+	t.journaled[key] = true
+	content, err := os.ReadFile(path)
+	t.journal = append(t.journal, journalEntry{path: path, content: content, existed: err == nil})
+}
+
+// changes returns the journaled files in the order they were first written
+func (t *fileTracker) changes() []journalEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]journalEntry(nil), t.journal...)
+}
+
+// stopJournal stops journaling and forgets the journal
+func (t *fileTracker) stopJournal() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.journaling = false
+	t.journal = nil
+	t.journaled = nil
+}
+
+// savedFiles is a fileTracker as saved with the session, so a resumed
+// session still notices files changed behind its back, and can still
+// revert journaled changes
+type savedFiles struct {
+	Hashes     map[string]string `json:"hashes,omitempty"`
+	Journaling bool              `json:"journaling,omitempty"`
+	Journal    []savedJournal    `json:"journal,omitempty"`
+}
+
+type savedJournal struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content,omitempty"`
+	Existed bool   `json:"existed"`
+}
+
+func (t *fileTracker) save() *savedFiles {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	saved := &savedFiles{Hashes: make(map[string]string, len(t.hashes)), Journaling: t.journaling}
+	for key, hash := range t.hashes {
+		saved.Hashes[key] = hex.EncodeToString(hash[:])
+	}
+	for _, entry := range t.journal {
+		saved.Journal = append(saved.Journal, savedJournal{Path: entry.path, Content: entry.content, Existed: entry.existed})
+	}
+	return saved
+}
+
+// restore replaces the tracker's state with a saved one
+func (t *fileTracker) restore(saved *savedFiles) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hashes = make(map[string][32]byte)
+	t.journaling, t.journal, t.journaled = false, nil, nil
+	if saved == nil {
+		return
+	}
+	for key, value := range saved.Hashes {
+		var hash [32]byte
+		if decoded, err := hex.DecodeString(value); err == nil && len(decoded) == len(hash) {
+			copy(hash[:], decoded)
+			t.hashes[key] = hash
+		}
+	}
+	t.journaling = saved.Journaling
+	t.journaled = make(map[string]bool)
+	for _, entry := range saved.Journal {
+		t.journal = append(t.journal, journalEntry{path: entry.Path, content: entry.Content, existed: entry.Existed})
+		t.journaled[trackerKey(entry.Path)] = true
+	}
+}
+
+// revert restores a journaled file to its original state
+func (entry journalEntry) revert() error {
+	if !entry.existed {
+		return os.Remove(entry.path)
+	}
+	return os.WriteFile(entry.path, entry.content, 0644)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	codegentv1 "github.com/anubhavgh023/codegent/proto/codegent/v1"
+	"google.golang.org/genai"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative proto/codegent/v1/agent.proto
+
+// grpcServer implements codegent.v1.AgentService
+type grpcServer struct {
+	codegentv1.UnimplementedAgentServiceServer
+
+	ctx    context.Context
+	client *genai.Client
+	config *Config
+
+	// Sessions work inside root and are evicted after idleTimeout
+	root        string
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*grpcSession
+}
+
+// grpcSession is an agent driven over gRPC. The SendMessage stream that is
+// running, if any, receives its events.
+type grpcSession struct {
+	agent       *Agent
+	modelConfig *genai.GenerateContentConfig
+
+	// Held while a message is handled
+	busy sync.Mutex
+
+	mu           sync.Mutex
+	send         func(*codegentv1.AgentEvent) error
+	done         <-chan struct{}
+	lastWrite    string
+	approvals    chan bool
+	approvalID   string
+	nextApproval int
+	lastUsed     time.Time
+}
+
+// serveGRPC serves the AgentService on addr until it fails. Sessions idle
+// for longer than idleTimeout are evicted, 0 keeps them forever.
+func serveGRPC(ctx context.Context, addr string, client *genai.Client, config *Config, idleTimeout time.Duration) error {
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g := &grpcServer{
+		ctx:         ctx,
+		client:      client,
+		config:      config,
+		root:        root,
+		idleTimeout: idleTimeout,
+		sessions:    make(map[string]*grpcSession),
+	}
+	if idleTimeout > 0 {
+		go g.evictIdle()
+	}
+	server := grpc.NewServer()
+	codegentv1.RegisterAgentServiceServer(server, g)
+	return server.Serve(lis)
+}
+
+// evictIdle drops sessions that have been idle for longer than the idle
+// timeout. Their history stays saved on disk.
+func (g *grpcServer) evictIdle() {
+	ticker := time.NewTicker(min(g.idleTimeout, time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		g.mu.Lock()
+		for id, s := range g.sessions {
+			// Busy sessions aren't idle, however long they take
+			if !s.busy.TryLock() {
+				continue
+			}
+			if s.idleFor() > g.idleTimeout {
+				delete(g.sessions, id)
+				log.Printf("evicted idle session %s", id)
+			}
+			s.busy.Unlock()
+		}
+		g.mu.Unlock()
+	}
+}
+
+func (g *grpcServer) CreateSession(ctx context.Context, req *codegentv1.CreateSessionRequest) (*codegentv1.CreateSessionResponse, error) {
+	config := *g.config
+	if req.ApprovalMode != "" {
+		mode, err := ParseApprovalMode(req.ApprovalMode)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		// Clients can't loosen the approvals the server was started with
+		if slices.Index(approvalModes, mode) < slices.Index(approvalModes, config.Approvals) {
+			config.Approvals = mode
+		}
+	}
+	if budget := int(req.MaxTokens); budget > 0 && (config.MaxSessionTokens == 0 || budget < config.MaxSessionTokens) {
+		config.MaxSessionTokens = budget
+	}
+	root, err := resolveWorkspace(g.root, req.Root)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	session := NewSession()
+	session.Title = req.Title
+	s := &grpcSession{approvals: make(chan bool, 1), lastUsed: time.Now()}
+	s.agent = NewAgent(g.client, s.answer, defaultTools(), session, &config)
+	s.agent.setRoot(root)
+	s.agent.out = s
+	s.agent.toolOutput = s
+	s.agent.onToolCall = s.toolCall
+	s.modelConfig = s.agent.newModelConfig(g.ctx)
+
+	g.mu.Lock()
+	g.sessions[session.ID] = s
+	g.mu.Unlock()
+	return &codegentv1.CreateSessionResponse{SessionId: session.ID, Root: root}, nil
+}
+
+func (g *grpcServer) session(id string) (*grpcSession, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.sessions[id]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no session %q, it may have been evicted after being idle", id)
+	}
+	return s, nil
+}
+
+func (g *grpcServer) SendMessage(req *codegentv1.SendMessageRequest, stream grpc.ServerStreamingServer[codegentv1.AgentEvent]) error {
+	s, err := g.session(req.SessionId)
+	if err != nil {
+		return err
+	}
+	if !s.busy.TryLock() {
+		return status.Error(codes.FailedPrecondition, errBusy.Error())
+	}
+	defer s.busy.Unlock()
+
+	s.mu.Lock()
+	s.send, s.done = stream.Send, stream.Context().Done()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.send, s.done, s.approvalID = nil, nil, ""
+		s.lastUsed = time.Now()
+		s.mu.Unlock()
+		// Drop an approval that raced with the stream ending
+		select {
+		case <-s.approvals:
+		default:
+		}
+	}()
+
+	answer, err := s.agent.handleRequest(stream.Context(), s.modelConfig, req.Text)
+	if errors.Is(err, errBudgetSpent) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return stream.Send(&codegentv1.AgentEvent{Event: &codegentv1.AgentEvent_Answer{Answer: answer}})
+}
+
+func (g *grpcServer) ListTools(ctx context.Context, req *codegentv1.ListToolsRequest) (*codegentv1.ListToolsResponse, error) {
+	resp := &codegentv1.ListToolsResponse{}
+	for _, tool := range defaultTools() {
+		schema, err := json.Marshal(schemaToJSON(&tool.InputSchema))
+		if err != nil {
+			return nil, err
+		}
+		resp.Tools = append(resp.Tools, &codegentv1.Tool{
+			Name:            tool.Name,
+			Description:     tool.Description,
+			Kind:            string(tool.Kind),
+			InputSchemaJson: string(schema),
+		})
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) ApproveToolCall(ctx context.Context, req *codegentv1.ApproveToolCallRequest) (*codegentv1.ApproveToolCallResponse, error) {
+	s, err := g.session(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	waiting := s.approvalID != "" && s.approvalID == req.ApprovalId
+	if waiting {
+		s.approvalID = ""
+	}
+	s.mu.Unlock()
+	if !waiting {
+		return nil, status.Errorf(codes.FailedPrecondition, "approval %q is not pending", req.ApprovalId)
+	}
+	s.approvals <- req.Approved
+	return &codegentv1.ApproveToolCallResponse{}, nil
+}
+
+// idleFor is how long ago the session last finished a message
+func (s *grpcSession) idleFor() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastUsed)
+}
+
+// emit sends an event to the running stream, if any
+func (s *grpcSession) emit(ev *codegentv1.AgentEvent) {
+	s.mu.Lock()
+	send := s.send
+	s.mu.Unlock()
+	if send != nil {
+		send(ev)
+	}
+}
+
+// Write streams the agent's printed output
+func (s *grpcSession) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.lastWrite = string(p)
+	s.mu.Unlock()
+	s.emit(&codegentv1.AgentEvent{Event: &codegentv1.AgentEvent_Output{Output: string(p)}})
+	return len(p), nil
+}
+
+func (s *grpcSession) toolCall(name string, input json.RawMessage, result map[string]interface{}) {
+	call := &codegentv1.ToolCall{Name: name, InputJson: string(input)}
+	if errText, failed := result["error"]; failed {
+		call.Error = fmt.Sprint(errText)
+	} else {
+		out, _ := json.Marshal(result["result"])
+		call.ResultJson = string(out)
+	}
+	s.emit(&codegentv1.AgentEvent{Event: &codegentv1.AgentEvent_ToolCall{ToolCall: call}})
+}
+
+// answer is the agent's getUserMessage. Questions become ApprovalRequest
+// events and wait for ApproveToolCall.
+func (s *grpcSession) answer() (string, bool) {
+	s.mu.Lock()
+	s.nextApproval++
+	id := strconv.Itoa(s.nextApproval)
+	s.approvalID = id
+	send, done, question := s.send, s.done, s.lastWrite
+	s.mu.Unlock()
+	if send == nil {
+		return "", false
+	}
+	question = strings.TrimSuffix(question, " [y/N] ")
+	if err := send(&codegentv1.AgentEvent{Event: &codegentv1.AgentEvent_ApprovalRequest{
+		ApprovalRequest: &codegentv1.ApprovalRequest{ApprovalId: id, Question: question},
+	}}); err != nil {
+		return "", false
+	}
+	select {
+	case approved := <-s.approvals:
+		if approved {
+			return "y", true
+		}
+		return "n", true
+	case <-done:
+		return "", false
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// guardrail recognizes a tool call that does something hard to take back.
+// Such calls only run once the user types the intent as the confirmation
+// phrase, whatever the approval mode: a yes said out of habit, or yolo mode,
+// isn't enough. Guardrails without Tools match the command lines of every
+// tool that runs commands, see ToolDefinition.Commands.
+type guardrail struct {
+	Intent  string   // what the call would do, and the phrase to type
+	Tools   []string // the tools whose calls are checked
+	Arg     string   // the argument matched
+	Pattern *regexp.Regexp
+}
+
+// guardrails are checked in order, the first match counts. Patterns look
+// for the risky part anywhere in the command, so chained and piped shell
+// commands are caught too.
+var guardrails = []guardrail{
+	{
+		Intent:  "recursive delete",
+		Pattern: regexp.MustCompile(`(?i)\brm\s+([^\s;&|]+\s+)*(-[a-z]*r|--recursive\b)|\bfind\b.*\s-delete\b|\bgit\s+clean\s+([^\s;&|]+\s+)*-[a-z]*f|\b(rmdir|rd|del)\s+.*/s\b|\bremove-item\b.*-recurse\b`),
+	},
+	{
+		Intent:  "force push",
+		Pattern: regexp.MustCompile(`\bgit\s+push\b[^;&|]*(\s--force\b|\s--force-with-lease\b|\s-[a-zA-Z]*f\b|\s\+\S)`),
+	},
+	{
+		Intent:  "discard work",
+		Pattern: regexp.MustCompile(`\bgit\s+(reset\s+([^\s;&|]+\s+)*--hard\b|checkout\s+([^\s;&|]+\s+)*--\s+\.|restore\s+([^\s;&|]+\s+)*\.(\s|$)|stash\s+(drop|clear)\b|branch\s+([^\s;&|]+\s+)*-D\b)`),
+	},
+	{
+		Intent:  "recursive permission change",
+		Pattern: regexp.MustCompile(`\b(chmod|chown|chgrp)\s+([^\s;&|]+\s+)*(-[a-zA-Z]*R|--recursive\b)`),
+	},
+	{
+		Intent:  "drop database data",
+		Pattern: regexp.MustCompile(`(?i)\b(drop\s+(table|database|schema|index|view)|truncate\s+(table\s+)?\w+|delete\s+from\s+[\w."]+\s*(;|'|"|$))|\bdropdb\b|\bflushall\b|\bflushdb\b`),
+	},
+	{
+		Intent:  "drop database data",
+		Tools:   []string{"query_database"},
+		Arg:     "query",
+		Pattern: regexp.MustCompile(`(?i)\b(drop\s+(table|database|schema|index|view)|truncate\s+(table\s+)?\w+|delete\s+from\s+[\w."]+\s*(;|$))`),
+	},
+	{
+		Intent:  "overwrite a disk",
+		Pattern: regexp.MustCompile(`\bmkfs(\.\w+)?\b|\bdd\b.*\bof=/dev/|>\s*/dev/(sd|nvme|hd|disk)`),
+	},
+}
+
+// riskyIntent returns the guardrail a tool call trips, if any
+func riskyIntent(tool ToolDefinition, input json.RawMessage) *guardrail {
+	var args map[string]interface{}
+	if err := json.Unmarshal(input, &args); err != nil {
+		return nil
+	}
+	var commands []string
+	if tool.Commands != nil {
+		commands = tool.Commands(input)
+	}
+	for i, g := range guardrails {
+		values := commands
+		if g.Tools != nil {
+			value, ok := args[g.Arg].(string)
+			if !ok || !slices.Contains(g.Tools, tool.Name) {
+				continue
+			}
+			values = []string{value}
+		}
+		if slices.ContainsFunc(values, g.Pattern.MatchString) {
+			return &guardrails[i]
+		}
+	}
+	return nil
+}
+
+// confirmIntent has the user type the intent of a risky tool call to let it
+// run. Anything else refuses it, as does a frontend that can't ask.
+func (a *Agent) confirmIntent(tool ToolDefinition, input json.RawMessage, g *guardrail) error {
+	a.notifyLong(tr("%s needs a confirmation: %s", tool.Name, g.Intent))
+	fmt.Fprintf(a.out, "%s: %s(%s)\n", paint(roleWarn, tr("caution")), tool.Name, input)
+	fmt.Fprintf(a.out, "%s ", tr("Guarded as %s, this can't be undone. Type %q to run it:", g.Intent, g.Intent))
+	answer, ok := a.getUserMessage()
+	if !a.taskStart.IsZero() {
+		a.taskStart = time.Now()
+	}
+	if ok && strings.EqualFold(strings.Join(strings.Fields(answer), " "), g.Intent) {
+		return nil
+	}
+	return &toolError{
+		Code:       errDenied,
+		Message:    fmt.Sprintf("the user didn't confirm the %s call, guarded as %s", tool.Name, g.Intent),
+		Suggestion: "Don't try to get the same done another way. Ask the user how to go on, or find a change that doesn't need it.",
+	}
+}
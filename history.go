@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// historyHit is a message in a saved session that matches a search
+type historyHit struct {
+	session *Session
+	// Index of the message in session.History
+	index int
+}
+
+// searchTerms splits a query into the lowercase words a message must contain
+func searchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// searchHistory finds the messages of saved sessions that contain every
+// term, case-insensitively, in the most recently updated sessions first
+func searchHistory(terms []string, limit int) ([]historyHit, error) {
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("nothing to search for")
+	}
+	st, err := store()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := st.SearchSessions(terms)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+
+	var hits []historyHit
+	for _, session := range sessions {
+		for i, msg := range session.History {
+			if containsAll(strings.ToLower(messageText(msg)), terms) {
+				hits = append(hits, historyHit{session: session, index: i})
+				if limit > 0 && len(hits) == limit {
+					return hits, nil
+				}
+			}
+		}
+	}
+	return hits, nil
+}
+
+func containsAll(text string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// messageText joins the text of a message, leaving out tool calls
+func messageText(msg SessionMessage) string {
+	var texts []string
+	for _, part := range msg.Parts {
+		if part.Text != "" && part.FunctionCall == nil && part.FunctionResponse == nil {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// exchange returns the range of messages around the hit, from the user
+// message that started it up to the model's answer
+func (h historyHit) exchange() (start, end int) {
+	history := h.session.History
+	start = h.index
+	for start > 0 && (history[start].Role != "user" || messageText(history[start]) == "") {
+		start--
+	}
+	end = h.index + 1
+	for end < len(history) && (history[end].Role != "user" || messageText(history[end]) == "") {
+		end++
+	}
+	return start, end
+}
+
+// quote is the hit's exchange as plain text, to hand to the model
+func (h historyHit) quote() string {
+	start, end := h.exchange()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From session %s (%s):\n", h.session.DisplayName(), h.session.CreatedAt.Format("2006-01-02"))
+	for _, msg := range h.session.History[start:end] {
+		text := messageText(msg)
+		if text == "" {
+			continue
+		}
+		who := "Model"
+		if msg.Role == "user" {
+			who = "User"
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", who, text)
+	}
+	return sb.String()
+}
+
+// snippetRadius is how much text around the first match a hit shows
+const snippetRadius = 120
+
+// printHits lists hits numbered from 1, each with the question that started
+// its exchange and a snippet of the matching message
+func printHits(w io.Writer, hits []historyHit, terms []string) {
+	highlight := highlighter(terms)
+	for n, hit := range hits {
+		session := hit.session
+		fmt.Fprintf(w, "%s %s  %s  #%d\n", paint(roleBold, fmt.Sprintf("[%d]", n+1)), session.UpdatedAt.Format("2006-01-02 15:04"), sessionLabel(session), hit.index)
+		start, _ := hit.exchange()
+		if start != hit.index {
+			fmt.Fprintf(w, "    %s: %s\n", paint(roleUser, tr("You")), highlight(snippet(messageText(session.History[start]), terms)))
+		}
+		who := paint(roleUser, tr("You"))
+		if session.History[hit.index].Role != "user" {
+			who = paint(roleModel, "Gemini")
+		}
+		fmt.Fprintf(w, "    %s: %s\n", who, highlight(snippet(messageText(session.History[hit.index]), terms)))
+	}
+}
+
+func sessionLabel(s *Session) string {
+	if s.Title != "" {
+		return fmt.Sprintf("%s (%s)", s.Title, s.ID)
+	}
+	return s.ID
+}
+
+// snippet cuts text down to one line around the first term it contains
+func snippet(text string, terms []string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	at := 0
+	lower := strings.ToLower(text)
+	for _, term := range terms {
+		if i := strings.Index(lower, term); i >= 0 {
+			at = i
+			break
+		}
+	}
+	start, end := max(at-snippetRadius, 0), min(at+snippetRadius, len(text))
+	// Keep whole UTF-8 characters
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	out := text[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+// highlighter returns a function that bolds the terms in a text
+func highlighter(terms []string) func(string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	return func(text string) string {
+		return re.ReplaceAllStringFunc(text, func(match string) string { return paint(roleBold, match) })
+	}
+}
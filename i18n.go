@@ -0,0 +1,76 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Translations of the UI strings, one JSON file per language mapping the
+// English text to the translated one. What is sent to the model stays in
+// English, it answers in whatever language the user writes.
+//
+//go:embed locales
+var localeFiles embed.FS
+
+// catalog translates UI strings into the user's language, nil for English
+var catalog map[string]string
+
+// setupLocale loads the catalog for CODEGENT_LANG, or otherwise the language
+// of LC_ALL, LC_MESSAGES or LANG when there is a translation for it
+func setupLocale() error {
+	lang := os.Getenv("CODEGENT_LANG")
+	explicit := lang != ""
+	for _, key := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if lang != "" {
+			break
+		}
+		lang = os.Getenv(key)
+	}
+	// de_DE.UTF-8 and de-DE are both German
+	lang, _, _ = strings.Cut(lang, ".")
+	lang, _, _ = strings.Cut(strings.ReplaceAll(lang, "-", "_"), "_")
+	lang = strings.ToLower(lang)
+	if lang == "" || lang == "en" || lang == "c" || lang == "posix" {
+		catalog = nil
+		return nil
+	}
+
+	data, err := localeFiles.ReadFile("locales/" + lang + ".json")
+	if err != nil {
+		if explicit {
+			return fmt.Errorf("no translation for CODEGENT_LANG %q (available: %s)", lang, strings.Join(locales(), ", "))
+		}
+		return nil
+	}
+	var translations map[string]string
+	if err := json.Unmarshal(data, &translations); err != nil {
+		return fmt.Errorf("failed to parse the %s translation: %w", lang, err)
+	}
+	catalog = translations
+	return nil
+}
+
+// locales lists the languages there are translations for
+func locales() []string {
+	langs := []string{"en"}
+	entries, _ := localeFiles.ReadDir("locales")
+	for _, entry := range entries {
+		langs = append(langs, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	return langs
+}
+
+// tr translates format into the user's language, then formats it with args
+// like fmt.Sprintf
+func tr(format string, args ...any) string {
+	if translated, ok := catalog[format]; ok {
+		format = translated
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
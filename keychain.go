@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// keychainService is the service credentials are stored under in the OS
+// keychain
+const keychainService = "codegent"
+
+var (
+	errNoKeychain    = errors.New("no keychain available")
+	errNotInKeychain = errors.New("not in the keychain")
+)
+
+// authProvider is a credential codegent can keep in the keychain instead
+// of an environment variable or .env file
+type authProvider struct {
+	name  string // its account in the keychain, and what auth login takes
+	env   string // the environment variable that takes precedence
+	label string
+}
+
+var authProviders = []authProvider{
+	{"gemini", "GEMINI_API_KEY", "Gemini API key"},
+	{"slack-app", "SLACK_APP_TOKEN", "Slack app-level token"},
+	{"slack-bot", "SLACK_BOT_TOKEN", "Slack bot token"},
+	{"telegram", "TELEGRAM_BOT_TOKEN", "Telegram bot token"},
+	{"jira", "JIRA_API_TOKEN", "Jira API token"},
+	{"linear", "LINEAR_API_KEY", "Linear API key"},
+	{"github", "GITHUB_TOKEN", "GitHub token"},
+	{"gitlab", "GITLAB_TOKEN", "GitLab token"},
+	// A Google account for Code Assist, signed in to rather than pasted,
+	// see signInWithGoogle
+	{"google", "", "Google account"},
+}
+
+func findProvider(name string) (authProvider, bool) {
+	for _, provider := range authProviders {
+		if provider.name == name {
+			return provider, true
+		}
+	}
+	return authProvider{}, false
+}
+
+// secret returns a provider's credential from its environment variable,
+// which .env files set, or otherwise from the keychain. It's empty when
+// there is none.
+func secret(name string) string {
+	provider, _ := findProvider(name)
+	if value := os.Getenv(provider.env); value != "" {
+		return value
+	}
+	value, _ := keychainGet(name)
+	return value
+}
@@ -0,0 +1,79 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// The keychain is used through its command line tools: security on macOS
+// and secret-tool, from libsecret, for GNOME Keyring or KWallet elsewhere.
+// Secrets go to them on stdin so they never show up in a process list.
+
+func keychainGet(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command("secret-tool", "lookup", "service", keychainService, "account", account)
+	}
+	if cmd.Err != nil {
+		return "", errNoKeychain
+	}
+	output, err := cmd.Output()
+	value := strings.TrimRight(string(output), "\n")
+	// secret-tool exits with 1 and security with 44 for missing items, a
+	// locked keychain is taken as one without the item too
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) || (err == nil && value == "") {
+		return "", errNotInKeychain
+	}
+	return value, err
+}
+
+func keychainSet(account, label, value string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// -i reads the command from stdin, -U updates an existing item
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -l %s -w %s\n",
+			securityQuote(keychainService), securityQuote(account), securityQuote(label), securityQuote(value)))
+	} else {
+		cmd = exec.Command("secret-tool", "store", "--label", label, "service", keychainService, "account", account)
+		cmd.Stdin = strings.NewReader(value)
+	}
+	return runKeychain(cmd)
+}
+
+func keychainDelete(account string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", account)
+	} else {
+		cmd = exec.Command("secret-tool", "clear", "service", keychainService, "account", account)
+	}
+	return runKeychain(cmd)
+}
+
+func runKeychain(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		if runtime.GOOS == "darwin" {
+			return errNoKeychain
+		}
+		return fmt.Errorf("%w, install secret-tool (libsecret-tools)", errNoKeychain)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v %s", cmd.Args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// securityQuote quotes an argument for security -i, which splits its input
+// like a shell
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
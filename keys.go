@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ergochat/readline"
+)
+
+// Actions keys can be bound to in the line editor
+const (
+	keySubmit        = "submit"         // send the message
+	keyNewline       = "newline"        // continue the message on a new line
+	keyInterrupt     = "interrupt"      // quit, like ctrl-c
+	keyHistoryPrev   = "history-prev"   // recall the previous message
+	keyHistoryNext   = "history-next"   // recall the next message
+	keyHistorySearch = "history-search" // search earlier messages
+)
+
+// keyActions are the runes the line editor handles each action with.
+// newline has none, the editor submits the line and keeps reading.
+var keyActions = map[string]rune{
+	keySubmit:        readline.CharEnter,
+	keyNewline:       readline.CharEnter,
+	keyInterrupt:     readline.CharInterrupt,
+	keyHistoryPrev:   readline.CharPrev,
+	keyHistoryNext:   readline.CharNext,
+	keyHistorySearch: readline.CharBckSearch,
+}
+
+// defaultKeys are the bindings before CODEGENT_KEYS. Keys not bound to an
+// action keep what the line editor does with them.
+var defaultKeys = map[string]string{
+	keySubmit:        "enter",
+	keyNewline:       "ctrl-j",
+	keyInterrupt:     "ctrl-c",
+	keyHistoryPrev:   "ctrl-p",
+	keyHistoryNext:   "ctrl-n",
+	keyHistorySearch: "ctrl-r",
+}
+
+// parseKey turns a key name, enter, tab or ctrl-a to ctrl-z, into the rune
+// the terminal sends for it
+func parseKey(name string) (rune, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "enter":
+		return '\r', nil
+	case "tab":
+		return '\t', nil
+	}
+	if letter, ok := strings.CutPrefix(name, "ctrl-"); ok && len(letter) == 1 && letter[0] >= 'a' && letter[0] <= 'z' {
+		return rune(letter[0]-'a') + 1, nil
+	}
+	return 0, fmt.Errorf("unknown key %q, want enter, tab or ctrl-a to ctrl-z", name)
+}
+
+// parseKeyBindings reads key bindings such as "newline=enter,submit=ctrl-j"
+// and returns the action of each bound key. Actions not given keep their
+// default key, unless it was taken by one that was.
+func parseKeyBindings(spec string) (map[rune]string, error) {
+	bindings := make(map[rune]string)
+	bound := make(map[string]bool)
+	for _, entry := range splitList(spec) {
+		action, name, ok := strings.Cut(entry, "=")
+		if _, known := keyActions[action]; !ok || !known {
+			return nil, fmt.Errorf("invalid key binding %q, want <action>=<key> with action one of submit, newline, interrupt, history-prev, history-next or history-search", entry)
+		}
+		key, err := parseKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", action, err)
+		}
+		if other, taken := bindings[key]; taken {
+			return nil, fmt.Errorf("%s is bound to both %s and %s", name, other, action)
+		}
+		bindings[key] = action
+		bound[action] = true
+	}
+
+	for action, name := range defaultKeys {
+		key, _ := parseKey(name)
+		if _, taken := bindings[key]; !taken && !bound[action] {
+			bindings[key] = action
+			bound[action] = true
+		}
+	}
+	if !bound[keySubmit] {
+		return nil, fmt.Errorf("no key is left to submit messages, bind one too, e.g. submit=ctrl-j")
+	}
+	return bindings, nil
+}
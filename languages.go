@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// languageAdapter knows how to build, test, format and lint projects in one
+// language. A project is in the language when one of the marker files is
+// at its top; polyglot projects have several.
+type languageAdapter struct {
+	Name       string
+	Markers    []string
+	Extensions []string
+	// commands returns the project's commands, "" for those it has none of
+	commands func(dir string) projectCommands
+	// testChanged, when set, tests only what the changed files affect
+	testChanged func(changed []string) string
+}
+
+// projectCommands are shell commands run at the top of the project
+type projectCommands struct {
+	Build, Test, Format, Lint string
+}
+
+// projectLanguage is a language detected in a project, with its commands
+type projectLanguage struct {
+	*languageAdapter
+	projectCommands
+}
+
+var languageAdapters = []*languageAdapter{
+	{
+		Name:       "Go",
+		Markers:    []string{"go.mod"},
+		Extensions: []string{".go"},
+		commands: func(string) projectCommands {
+			return projectCommands{Build: "go build ./...", Test: "go test ./...", Format: "gofmt -w .", Lint: "go vet ./..."}
+		},
+		testChanged: goTestCommand,
+	},
+	{
+		Name:       "Rust",
+		Markers:    []string{"Cargo.toml"},
+		Extensions: []string{".rs"},
+		commands: func(string) projectCommands {
+			return projectCommands{Build: "cargo build", Test: "cargo test", Format: "cargo fmt", Lint: "cargo clippy --all-targets"}
+		},
+	},
+	{
+		Name:       "Node",
+		Markers:    []string{"package.json"},
+		Extensions: []string{".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".vue", ".svelte"},
+		commands:   nodeCommands,
+	},
+	{
+		Name:       "Python",
+		Markers:    []string{"pyproject.toml", "setup.py", "setup.cfg", "requirements.txt"},
+		Extensions: []string{".py"},
+		commands:   pythonCommands,
+	},
+}
+
+// projectLanguages detects the languages of the project in dir, the
+// working directory when empty
+func projectLanguages(dir string) []projectLanguage {
+	var languages []projectLanguage
+	for _, adapter := range languageAdapters {
+		if slices.ContainsFunc(adapter.Markers, func(marker string) bool {
+			_, err := os.Stat(filepath.Join(dir, marker))
+			return err == nil
+		}) {
+			languages = append(languages, projectLanguage{adapter, adapter.commands(dir)})
+		}
+	}
+	return languages
+}
+
+// defaultCheckCommand builds and tests the project in every language it
+// has, and checks nothing in projects of other languages
+func defaultCheckCommand() string {
+	var steps []string
+	for _, language := range projectLanguages("") {
+		for _, command := range []string{language.Build, language.Test} {
+			if command != "" {
+				steps = append(steps, command)
+			}
+		}
+	}
+	return strings.Join(steps, " && ")
+}
+
+// changedTestCommand runs the tests of the languages of the changed files,
+// or returns "" when none of them is in a language of the project
+func changedTestCommand(changed []string) string {
+	var steps []string
+	for _, language := range projectLanguages("") {
+		if !slices.ContainsFunc(changed, func(path string) bool {
+			return slices.Contains(language.Extensions, strings.ToLower(filepath.Ext(path)))
+		}) {
+			continue
+		}
+		command := language.Test
+		if language.testChanged != nil {
+			command = language.testChanged(changed)
+		}
+		if command != "" {
+			steps = append(steps, command)
+		}
+	}
+	return strings.Join(steps, " && ")
+}
+
+// describeLanguages tells the model the project's languages and how to
+// build, test, format and lint it, "" for projects of other languages
+func describeLanguages(dir string) string {
+	var b strings.Builder
+	for _, language := range projectLanguages(dir) {
+		var parts []string
+		for _, c := range []struct{ what, command string }{
+			{"build", language.Build}, {"test", language.Test}, {"format", language.Format}, {"lint", language.Lint},
+		} {
+			if c.command != "" {
+				parts = append(parts, fmt.Sprintf("%s with `%s`", c.what, c.command))
+			}
+		}
+		if len(parts) > 0 {
+			fmt.Fprintf(&b, "- %s: %s\n", language.Name, strings.Join(parts, ", "))
+		}
+	}
+	return b.String()
+}
+
+// goTestCommand tests the packages of the changed Go files, or returns ""
+// when no Go file changed
+func goTestCommand(changed []string) string {
+	dirs := make(map[string]bool)
+	for _, path := range changed {
+		if !strings.HasSuffix(path, ".go") {
+			continue
+		}
+		dir := filepath.ToSlash(filepath.Dir(path))
+		if dir != "." {
+			dir = "./" + dir
+		}
+		dirs[dir] = true
+	}
+	if len(dirs) == 0 {
+		return ""
+	}
+	packages := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		packages = append(packages, dir)
+	}
+	sort.Strings(packages)
+	return "go test " + strings.Join(packages, " ")
+}
+
+// nodeCommands runs the package.json scripts for building, testing,
+// formatting and linting with the project's package manager, falling back
+// to the TypeScript compiler and Prettier when they're dependencies
+func nodeCommands(dir string) projectCommands {
+	var pkg struct {
+		Scripts         map[string]string `json:"scripts"`
+		DevDependencies map[string]string `json:"devDependencies"`
+		Dependencies    map[string]string `json:"dependencies"`
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "package.json"))
+	json.Unmarshal(data, &pkg)
+	runner := npmRunner(dir)
+	script := func(names ...string) string {
+		for _, name := range names {
+			if _, ok := pkg.Scripts[name]; ok {
+				return runner + " run " + name
+			}
+		}
+		return ""
+	}
+	depends := func(name string) bool {
+		_, dev := pkg.DevDependencies[name]
+		_, prod := pkg.Dependencies[name]
+		return dev || prod
+	}
+
+	commands := projectCommands{
+		Build:  script("build", "typecheck"),
+		Format: script("format", "fmt"),
+		Lint:   script("lint"),
+	}
+	// npm init's placeholder test script only fails
+	if test, ok := pkg.Scripts["test"]; ok && !strings.Contains(test, "no test specified") {
+		commands.Test = runner + " test"
+	}
+	if commands.Build == "" && depends("typescript") {
+		commands.Build = "npx tsc --noEmit"
+	}
+	if commands.Format == "" && depends("prettier") {
+		commands.Format = "npx prettier --write ."
+	}
+	return commands
+}
+
+// pythonCommands picks pytest or unittest for the tests, and ruff, black or
+// flake8 for formatting and linting, by what the project configures
+func pythonCommands(dir string) projectCommands {
+	var config strings.Builder
+	for _, name := range []string{"pyproject.toml", "setup.cfg", "requirements.txt", "requirements-dev.txt", "tox.ini"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			config.Write(data)
+		}
+	}
+	uses := func(tool string, files ...string) bool {
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+				return true
+			}
+		}
+		return strings.Contains(config.String(), tool)
+	}
+
+	commands := projectCommands{Test: "python -m unittest"}
+	if uses("pytest", "pytest.ini", "conftest.py") {
+		commands.Test = "python -m pytest"
+	}
+	switch {
+	case uses("ruff", "ruff.toml", ".ruff.toml"):
+		commands.Format, commands.Lint = "ruff format .", "ruff check ."
+	case uses("black"):
+		commands.Format = "black ."
+	}
+	if commands.Lint == "" && uses("flake8", ".flake8") {
+		commands.Lint = "flake8"
+	}
+	return commands
+}
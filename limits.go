@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// limitReached reports whether running another round of tool calls would
+// exceed the configured per-request limits
+func (c *Config) limitReached(turns, toolCalls int) bool {
+	if c.MaxTurns > 0 && turns >= c.MaxTurns {
+		return true
+	}
+	return c.MaxToolCalls > 0 && toolCalls > c.MaxToolCalls
+}
+
+// errBudgetSpent is returned for requests to a session that has used up its
+// token or cost budget
+var errBudgetSpent = errors.New("session budget spent")
+
+// budgetWrapUp is the share of the session budget, in percent, from which
+// no more tool calls are run and the model wraps up instead, see wrapUp
+const budgetWrapUp = 90
+
+// budgetUsed returns the share of the session budget used in percent, of
+// MaxSessionTokens or MaxSessionCost, whichever is further along
+func (a *Agent) budgetUsed() float64 {
+	used := 0.0
+	if a.config.MaxSessionTokens > 0 {
+		used = float64(a.tokensUsed) * 100 / float64(a.config.MaxSessionTokens)
+	}
+	if a.config.MaxSessionCost > 0 {
+		used = max(used, a.costUsed*100/a.config.MaxSessionCost)
+	}
+	return used
+}
+
+// budgetSpent reports whether the conversation has used up its budget
+func (a *Agent) budgetSpent() bool {
+	return a.budgetUsed() >= 100
+}
+
+// budgetStatus describes how much of the budget is used, for messages
+func (a *Agent) budgetStatus() string {
+	var parts []string
+	if a.config.MaxSessionTokens > 0 {
+		parts = append(parts, tr("%d of %d tokens", a.tokensUsed, a.config.MaxSessionTokens))
+	}
+	if a.config.MaxSessionCost > 0 {
+		parts = append(parts, tr("%s of %s", formatCost(a.costUsed), formatCost(a.config.MaxSessionCost)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// wrapUp ends a request once the session budget is nearly spent. The tool
+// calls the model asked for aren't run, except those in ran, which already
+// did when resuming. It's asked for a continuation plan instead, which is
+// shown and saved with the session for /continue to pick up in a new one.
+func (a *Agent) wrapUp(ctx context.Context, modelConfig *genai.GenerateContentConfig, calls []*genai.FunctionCall, ran []*genai.FunctionResponse) (string, error) {
+	fmt.Fprintf(a.out, "%s: %s\n", paint(roleNotice, tr("budget nearly spent")), tr("%s used, wrapping up", a.budgetStatus()))
+	parts := make([]*genai.Part, 0, len(calls)+1)
+	for _, response := range ran {
+		parts = append(parts, &genai.Part{FunctionResponse: response})
+	}
+	for _, call := range calls[len(ran):] {
+		parts = append(parts, &genai.Part{FunctionResponse: &genai.FunctionResponse{
+			ID:       call.ID,
+			Name:     call.Name,
+			Response: toolErrorResult(&toolError{Code: errCancelled, Message: "not run, the session budget is nearly spent"}),
+		}})
+	}
+	parts = append(parts, genai.NewPartFromText("The session's budget is nearly spent, so the tool calls above weren't run and no more can be. Stop here and write a continuation plan for a new session, which won't see this conversation: the goal, what is done, with the files changed, what is left, and the next steps in order, naming the files and identifiers involved. Don't call tools."))
+	if _, err := a.runInference(ctx, modelConfig, TaskPlan, parts...); err != nil {
+		return "", err
+	}
+
+	// Calls made anyway can't be run, and unanswered they'd break the history
+	reply := a.history[len(a.history)-1]
+	reply.Parts = slices.DeleteFunc(reply.Parts, func(part *genai.Part) bool { return part.FunctionCall != nil })
+	var plan strings.Builder
+	for _, part := range reply.Parts {
+		plan.WriteString(part.Text)
+	}
+	if plan.Len() == 0 {
+		plan.WriteString("The session budget ran out before a plan was written. Look at the last changes, such as with git diff, to see where it stopped.")
+		reply.Parts = append(reply.Parts, genai.NewPartFromText(plan.String()))
+	}
+	fmt.Fprintf(a.out, "%s: %v\n", paint(roleModel, "Gemini"), plan.String())
+	a.session.Continuation = plan.String()
+	a.autosave()
+	fmt.Fprintln(a.out, paint(roleNotice, tr("The plan is saved, /continue picks it up in a new session.")))
+	return plan.String(), nil
+}
+
+// lastContinuation returns the most recent session that left a
+// continuation plan, other than the agent's own
+func (a *Agent) lastContinuation() (*Session, error) {
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		if s.Continuation != "" && s.ID != a.session.ID {
+			return s, nil
+		}
+	}
+	return nil, errors.New(tr("no session left a continuation plan"))
+}
+
+// continueSession queues the continuation plan of an earlier session as
+// the next message, for /continue. The plan is cleared so it is picked up
+// only once.
+func (a *Agent) continueSession(ref string) error {
+	var s *Session
+	var err error
+	if ref == "" {
+		s, err = a.lastContinuation()
+	} else {
+		s, err = FindSession(ref)
+	}
+	if err != nil {
+		return err
+	}
+	if s.Continuation == "" {
+		return errors.New(tr("%s has no continuation plan", s.DisplayName()))
+	}
+	a.queued = fmt.Sprintf("Continue the work of an earlier session, which stopped when its budget ran out. It left this plan:\n\n%s", s.Continuation)
+	s.Continuation = ""
+	if err := s.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintln(a.out, tr("Continuing %s", s.DisplayName()))
+	return nil
+}
+
+// confirmContinue asks the user whether to keep going once a limit is hit
+func (a *Agent) confirmContinue(turns, toolCalls int) bool {
+	return a.confirm(fmt.Sprintf("%s: %s", paint(roleNotice, tr("limit reached")), tr("%d model turns and %d tool calls for this request. Continue?", turns, toolCalls)))
+}
+
+// truncateOutput cuts tool output down to roughly maxTokens, keeping the
+// head and tail on line boundaries with a marker telling the model how to
+// get at the part in between
+func truncateOutput(output string, maxTokens int) string {
+	// Roughly 4 bytes per token
+	maxBytes := maxTokens * 4
+	if maxTokens <= 0 || len(output) <= maxBytes {
+		return output
+	}
+
+	head := output[:maxBytes/2]
+	if i := strings.LastIndexByte(head, '\n'); i > 0 {
+		head = head[:i+1]
+	}
+	tail := output[len(output)-maxBytes/2:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+
+	omitted := output[len(head) : len(output)-len(tail)]
+	headLines := strings.Count(head, "\n")
+	return fmt.Sprintf("%s\n[... %d lines (%d bytes) omitted to stay within the %d token tool output budget. "+
+		"The omitted part starts at line %d of this output; use read_file with start_line and end_line to read specific ranges ...]\n\n%s",
+		head, strings.Count(omitted, "\n"), len(omitted), maxTokens, headLines+1, tail)
+}
@@ -0,0 +1,22 @@
+package main
+
+import "strings"
+
+// lineEnding returns the line ending content uses, CRLF for files written
+// on Windows and LF otherwise
+func lineEnding(content string) string {
+	if strings.Contains(content, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// withLineEndings gives text the line endings of content. Models write LF,
+// so without this their edits wouldn't match CRLF files, or would leave
+// them with mixed line endings.
+func withLineEndings(content, text string) string {
+	if lineEnding(content) == "\n" || strings.Contains(text, "\r\n") {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", "\r\n")
+}
@@ -2,52 +2,243 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
-	"path"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/google/generative-ai-go/genai"
 	"github.com/invopop/jsonschema"
 	"github.com/joho/godotenv"
-	"google.golang.org/api/option"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genai"
 )
 
 func main() {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
+	// Load .env file, optional since Vertex AI users may not have an API key.
+	if err := godotenv.Load(); err != nil && !os.IsNotExist(err) {
 		log.Fatal("Error loading .env file")
 	}
+	if err := setupTheme(); err != nil {
+		log.Println("WARNING using the default colors:", err.Error())
+	}
+	if err := setupLocale(); err != nil {
+		log.Println("WARNING using English:", err.Error())
+	}
+	if err := setupNetwork(); err != nil {
+		log.Fatal("ERROR loading CODEGENT_CA_CERTS: ", err)
+	}
+
+	// Subcommands that don't talk to the model
+	if len(os.Args) > 1 {
+		if run, ok := localCommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
 
 	ctx := context.Background()
 
+	// Traces are exported when an OTLP endpoint is configured
+	flushTraces, err := setupTracing(ctx)
+	if err != nil {
+		log.Println("WARNING tracing disabled:", err.Error())
+		flushTraces = func() {}
+	}
+
+	// Subcommands that run one task and exit
+	if len(os.Args) > 1 {
+		if run, ok := taskCommands[os.Args[1]]; ok {
+			err := run(ctx, os.Args[2:])
+			closeBrowser()
+			flushTraces()
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	config, err := parseFlags(flag.NewFlagSet("codegent", flag.ContinueOnError), os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize gemini client
-	client, err := genai.NewClient(ctx, option.WithAPIKey(os.Getenv("GEMINI_API_KEY")))
+	client, err := newClient(ctx, config)
 	if err != nil {
 		log.Fatal("ERROR not able to establish connection:", err)
 	}
-	defer client.Close()
 
+	agent := NewAgent(client, stdinMessages(), defaultTools(), NewSession(), config)
+	if config.Resume != "" {
+		session, err := FindSession(config.Resume)
+		if err != nil {
+			log.Fatal(err)
+		}
+		agent.resumeSession(session)
+		fmt.Println(tr("Resumed %s (%d messages)", session.DisplayName(), len(session.History)))
+	} else if last, err := FindSession("last"); err == nil && last.Continuation != "" {
+		fmt.Println(paint(roleNotice, tr("%s ran out of budget and left a plan, /continue picks it up", last.DisplayName())))
+	}
+	// Typing gets line editing, tab completion and a status line, piped
+	// input is read as is. Screen readers get on better with plain input.
+	if isTerminal(os.Stdin) {
+		agent.tools = append(agent.tools, CopyToClipboardDefinition)
+		agent.status = &sessionStatus{}
+		agent.progress = progressOutput()
+		agent.notifications = true
+		agent.interruptTools = true
+		if agent.progress == nil {
+			agent.toolOutput = os.Stdout
+		}
+		if config.UpdateCheck {
+			updateNotice(os.Stdout)
+		}
+	}
+	if isTerminal(os.Stdin) && !accessible {
+		closeEditor, err := agent.useLineEditor()
+		if err != nil {
+			log.Fatal("ERROR setting up the terminal:", err)
+		}
+		defer closeEditor()
+	}
+	if err := agent.Run(ctx); err != nil {
+		log.Println("ERROR in running: ", err.Error())
+	}
+	closeBrowser()
+	flushTraces()
+}
+
+// taskCommands are the subcommands that run a single task against the model
+var taskCommands = map[string]func(ctx context.Context, args []string) error{
+	"run":       runRunCommand,
+	"new":       runNewCommand,
+	"refactor":  runRefactorCommand,
+	"explain":   runExplainCommand,
+	"docs":      runDocsCommand,
+	"changelog": runChangelogCommand,
+	"hook":      runHookCommand,
+	"watch":     runWatchCommand,
+	"slack":     runSlackCommand,
+	"telegram":  runTelegramCommand,
+	"serve":     runServeCommand,
+	"queue":     runQueueCommand,
+	"schedule":  runScheduleCommand,
+	"workflow":  runWorkflowCommand,
+	"migrate":   runMigrateCommand,
+	"resolve":   runResolveCommand,
+}
+
+// localCommands are the subcommands that don't talk to the model. It is
+// filled in init since completion needs to refer to it.
+var localCommands map[string]func(args []string) error
+
+func init() {
+	localCommands = map[string]func(args []string) error{
+		"sessions":   runSessionsCommand,
+		"usage":      runUsageCommand,
+		"history":    runHistoryCommand,
+		"auth":       runAuthCommand,
+		"upgrade":    runUpgradeCommand,
+		"completion": runCompletionCommand,
+	}
+}
+
+// stdinMessages reads user messages line by line from stdin
+func stdinMessages() func() (string, bool) {
 	scanner := bufio.NewScanner(os.Stdin)
-	getUserMessage := func() (string, bool) {
+	return func() (string, bool) {
 		if !scanner.Scan() {
 			return "", false
 		}
 		return scanner.Text(), true
 	}
+}
 
-	tools := []ToolDefinition{
-		ReadFileDefinition,  // Tool-1 => reads file
-		ListFilesDefinition, // Tool-2 => lists file
-		EditFileDefinition,  // Tool-3 => edits files
+func newClient(ctx context.Context, config *Config) (*genai.Client, error) {
+	// Vertex AI authenticates with Application Default Credentials
+	if config.Vertex.Enabled {
+		return genai.NewClient(ctx, &genai.ClientConfig{
+			Backend:     genai.BackendVertexAI,
+			Project:     config.Vertex.Project,
+			Location:    config.Vertex.Location,
+			HTTPOptions: genai.HTTPOptions{BaseURL: config.BaseURL},
+		})
 	}
-	agent := NewAgent(client, getUserMessage, tools)
-	if err := agent.Run(ctx); err != nil {
-		log.Println("ERROR in running: ", err.Error())
+	key := secret("gemini")
+	if key == "" {
+		// Signed in with a Google account, which has no context caching
+		if login := loadGoogleLogin(); login != nil {
+			config.ContextCache = false
+			return newCodeAssistClient(ctx, login)
+		}
+	}
+	return genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:      key,
+		Backend:     genai.BackendGeminiAPI,
+		HTTPOptions: genai.HTTPOptions{BaseURL: config.BaseURL},
+	})
+}
+
+func defaultTools() []ToolDefinition {
+	return []ToolDefinition{
+		ReadFileDefinition,          // Tool-1 => reads file
+		ListFilesDefinition,         // Tool-2 => lists file
+		EditFileDefinition,          // Tool-3 => edits files
+		ReadImageDefinition,         // Tool-4 => loads images for the model
+		ReadPDFDefinition,           // Tool-5 => loads PDFs for the model
+		StatDefinition,              // Tool-6 => file metadata
+		MultiEditDefinition,         // Tool-7 => batch of edits applied atomically
+		RegexReplaceDefinition,      // Tool-8 => regex find-and-replace across files
+		FindSymbolDefinition,        // Tool-9 => symbol declarations and references
+		FindTodosDefinition,         // Tool-10 => TODO/FIXME comments
+		RunCommandDefinition,        // Tool-11 => shell commands, output streamed
+		KubectlDefinition,           // Tool-12 => kubectl, approval for changes
+		DockerDefinition,            // Tool-13 => image builds, compose, logs
+		ProjectTargetsDefinition,    // Tool-14 => make, task and npm targets
+		QueryDatabaseDefinition,     // Tool-15 => SQL, read-only unless approved
+		OpenAPIDefinition,           // Tool-16 => endpoints and schemas of API specs
+		ProtobufDefinition,          // Tool-17 => messages and services of .proto files
+		BrowserDefinition,           // Tool-18 => headless Chrome for checking the frontend
+		VisualDiffDefinition,        // Tool-19 => before/after screenshots of pages and TUIs
+		GetIssueDefinition,          // Tool-20 => Jira and Linear issues
+		CommentOnIssueDefinition,    // Tool-21 => progress comments on issues
+		GitHubIssuesDefinition,      // Tool-22 => GitHub issues of the repository
+		CreatePullRequestDefinition, // Tool-23 => push and open a GitHub PR or GitLab MR
+		CommitChangesDefinition,     // Tool-24 => split changes into focused commits
+		GitHistoryDefinition,        // Tool-25 => git log and blame of files and lines
+		GitBisectDefinition,         // Tool-26 => find the commit that broke a test
+		WorkspaceSnapshotDefinition, // Tool-27 => save and restore the whole working tree
+		LicenseCheckDefinition,      // Tool-28 => dependency licenses and source headers
+		UnusedCodeDefinition,        // Tool-29 => dead code and unused modules
+		CodeOutlineDefinition,       // Tool-30 => declarations of files and directories
+		NotebookReadDefinition,      // Tool-31 => Jupyter notebooks cell by cell
+		NotebookEditDefinition,      // Tool-32 => replace, insert and delete notebook cells
+		PreviewDataDefinition,       // Tool-33 => schema and sample rows of CSV and Parquet files
+		AnalyzeLogsDefinition,       // Tool-34 => summarize and search large log files
+		ResolveStackTraceDefinition, // Tool-35 => code behind the frames of Go stack traces
+		EnvironmentInfoDefinition,   // Tool-36 => OS, Go, tool versions, git state and environment
+		WriteScratchDefinition,      // Tool-37 => session notes and data outside the workspace
+		ReadScratchDefinition,       // Tool-38 => read and list the session's scratch files
+		FileChecksumDefinition,      // Tool-39 => verify downloads and find duplicate files
+		ArchiveDefinition,           // Tool-40 => list, extract and create zip and tar.gz archives
+		DownloadDefinition,          // Tool-41 => fetch files over HTTPS and verify their checksums
 	}
 }
 
@@ -56,28 +247,102 @@ type Agent struct {
 	client         *genai.Client
 	getUserMessage func() (string, bool)
 	tools          []ToolDefinition
+	session        *Session
+	config         *Config
+
+	// Where chat output, tool calls and prompts are printed
+	out io.Writer
+
+	// Optional; told about every finished tool call, for frontends that
+	// show tool calls apart from the printed output
+	onToolCall func(name string, input json.RawMessage, result map[string]interface{})
+
+	// Conversation so far, without the model's thoughts
+	history []*genai.Content
+
+	// Optional workspace root and the file tracker that goes with it, for
+	// agents sharing a process with others, see inWorkspace
+	root  string
+	files *fileTracker
+
+	// Tokens used by the conversation, counted against MaxSessionTokens
+	tokensUsed int
+	// Estimated cost of the conversation in USD, counted against
+	// MaxSessionCost
+	costUsed float64
+	// Success criteria the current or next request must meet before it
+	// counts as done, see meetCriteria
+	criteria []*criterion
+	// Size of the conversation at the last model call, see manageContext
+	contextTokens int
+	// Optional; model, context and cost shown above each prompt
+	status *sessionStatus
+	// Optional; where spinners are drawn while the model or a tool works
+	progress io.Writer
+	// Whether to notify about long requests, see notifyLong, and when the
+	// current one started
+	notifications bool
+	taskStart     time.Time
+	// Whether Ctrl-C cancels the running tool call, for terminal chats
+	interruptTools bool
+	// Optional; where tools stream their output as they run, when there is
+	// no spinner to show its last line, see toolOutput
+	toolOutput io.Writer
+
+	// Text to send along with the next message, such as quoted history
+	attachments []string
+	// Results of the last /search, for /quote
+	searchHits []historyHit
+	// A message a slash command wants sent, such as an expanded /prompt
+	queued string
+	// The user's answers on running review checks, see approveCheck
+	checkApprovals map[string]bool
 }
 
 func NewAgent(
 	client *genai.Client,
 	getUserMessage func() (string, bool),
 	tools []ToolDefinition,
+	session *Session,
+	config *Config,
 ) *Agent {
 	return &Agent{
 		client:         client,
 		getUserMessage: getUserMessage,
 		tools:          tools,
+		session:        session,
+		config:         config,
+		out:            os.Stdout,
 	}
 }
 
-func (a *Agent) Run(ctx context.Context) error {
-	// Select model
-	model := a.client.GenerativeModel("gemini-2.0-flash")
-
+// newModelConfig builds the generation settings, tools and system prompt
+// shared by every request in a conversation
+func (a *Agent) newModelConfig(ctx context.Context) *genai.GenerateContentConfig {
 	// Model settings
-	model.SetMaxOutputTokens(4096)
+	modelConfig := &genai.GenerateContentConfig{
+		MaxOutputTokens:   4096,
+		SystemInstruction: systemInstruction(a.root),
+		ThinkingConfig:    a.config.thinkingConfig(),
+	}
+	// The thinking budget counts towards the output tokens
+	if budget := a.config.ThinkingBudget; budget != nil && *budget > 0 {
+		modelConfig.MaxOutputTokens += *budget
+	}
+
+	modelConfig.Tools = a.geminiTools()
 
-	// Tools for gemini
+	// Move the static prefix into a context cache when enabled
+	if a.config.ContextCache {
+		if err := a.useContextCache(ctx, modelConfig); err != nil {
+			log.Println("WARNING context cache disabled:", err.Error())
+		}
+	}
+	return modelConfig
+}
+
+// geminiTools declares the agent's tools for the model
+func (a *Agent) geminiTools() []*genai.Tool {
 	geminiTools := make([]*genai.Tool, 0, len(a.tools))
 	for _, tool := range a.tools {
 		geminiTools = append(geminiTools, &genai.Tool{
@@ -88,73 +353,309 @@ func (a *Agent) Run(ctx context.Context) error {
 			}},
 		})
 	}
+	return geminiTools
+}
 
-	// Set tools on the model
-	model.Tools = geminiTools
-
-	// Start a chat session
-	session := model.StartChat()
+func (a *Agent) Run(ctx context.Context) error {
+	modelConfig := a.newModelConfig(ctx)
 
-	fmt.Println("=== Chat with Gemini (use 'ctrl-c' to quit) ===")
+	if accessible {
+		fmt.Fprintln(a.out, tr("Chat with Gemini, press ctrl-c to quit."))
+	} else {
+		fmt.Fprintf(a.out, "=== %s ===\n", tr("Chat with Gemini (use 'ctrl-c' to quit)"))
+	}
+	fmt.Fprintln(a.out, tr("Approval mode: %s (change with /approvals)", a.config.Approvals))
 
 	for {
+		// A resumed session may have been saved in the middle of a request
+		if err := a.resumeRequest(ctx, modelConfig); err != nil {
+			return err
+		}
+
 		// Prompt for user input
-		fmt.Print("\u001b[94mYou\u001b[0m: ")
+		a.printStatus()
+		fmt.Fprint(a.out, paint(roleUser, tr("You"))+": ")
 		userInput, ok := a.getUserMessage()
 		if !ok {
 			break
 		}
 
-		// Send the user message and get response
-		resp, err := a.runInference(ctx, session, userInput)
-		if err != nil {
-			log.Println("ERROR running inference:", err.Error())
+		// "!command" runs a shell command without leaving the chat
+		if strings.HasPrefix(userInput, "!") {
+			a.shellEscape(userInput[1:])
+			continue
+		}
+
+		// Slash commands are handled locally, some queue a message to send
+		if strings.HasPrefix(userInput, "/") {
+			if err := a.handleSlashCommand(userInput); err != nil {
+				fmt.Fprintln(a.out, tr("ERROR:"), err)
+			}
+			if a.queued == "" {
+				continue
+			}
+			userInput, a.queued = a.queued, ""
+		} else {
+			a.attachMentions(userInput)
+			a.attachRelevant(ctx, userInput)
+			a.attachStackTrace(ctx, userInput)
+		}
+
+		a.taskStart = time.Now()
+		_, err := a.handleRequest(ctx, modelConfig, userInput)
+		a.notifyLong(tr("Done: %s", userInput))
+		a.taskStart = time.Time{}
+		// Unmet criteria and a spent budget end the request, not the chat
+		if errors.Is(err, errCriteriaUnmet) || errors.Is(err, errBudgetSpent) {
+			fmt.Fprintln(a.out, tr("ERROR:"), err)
+		} else if err != nil {
 			return err
 		}
 
+		// Continue the loop to get new user input
+	}
+	return nil
+}
+
+// handleRequest sends one user message and keeps executing tool calls until
+// the model answers without any. It returns the text of the final answer.
+func (a *Agent) handleRequest(ctx context.Context, modelConfig *genai.GenerateContentConfig, userInput string) (string, error) {
+	ctx, span := tracer.Start(ctx, "agent.request", trace.WithAttributes(
+		attribute.String("codegent.session.id", a.session.ID),
+		attribute.String("codegent.approval_mode", string(a.config.Approvals)),
+	))
+	tokensBefore := a.tokensUsed
+	answer, err := a.runRequest(ctx, modelConfig, userInput)
+	span.SetAttributes(attribute.Int("codegent.tokens", a.tokensUsed-tokensBefore))
+	endSpan(span, err)
+	observeRequest(a.tokensUsed-tokensBefore, err)
+	return answer, err
+}
+
+func (a *Agent) runRequest(ctx context.Context, modelConfig *genai.GenerateContentConfig, userInput string) (string, error) {
+	// Success criteria are attached to one request
+	defer func() { a.criteria = nil }()
+	if a.budgetSpent() {
+		return "", fmt.Errorf("%w: %s used", errBudgetSpent, a.budgetStatus())
+	}
+
+	a.manageContext()
+	a.attachments = append(a.pinnedAttachments(), a.attachments...)
+
+	// Send the user message and get response
+	// Attachments go first, the message refers to them, after the results
+	// of tool calls a crash left unanswered
+	parts := a.unansweredToolParts()
+	for _, text := range a.attachments {
+		parts = append(parts, genai.NewPartFromText(text))
+	}
+	a.session.Pending = &PendingTurn{Message: userInput, Attachments: a.attachments}
+	a.attachments = nil
+	a.autosave()
+	parts = append(parts, genai.NewPartFromText(userInput))
+	resp, err := a.runInference(ctx, modelConfig, TaskPlan, parts...)
+	if err != nil {
+		log.Println("ERROR running inference:", err.Error())
+		return "", err
+	}
+	a.session.Pending = nil
+	a.autosave()
+	return a.runToolCalls(ctx, modelConfig, resp, nil)
+}
+
+// runToolCalls executes the tool calls in resp and sends their results
+// back, until the model answers without any. ran holds the results of
+// calls in resp that already ran, when resuming.
+func (a *Agent) runToolCalls(ctx context.Context, modelConfig *genai.GenerateContentConfig, resp *genai.GenerateContentResponse, ran []*genai.FunctionResponse) (string, error) {
+	var err error
+	// Check the per-request limits before each round of tool calls
+	turns, toolCallCount := 1, 0
+	edited, reviewed := false, false
+	var lastToolParts []*genai.Part
+	// With --self-review the changes are journaled for the review, unless
+	// a journal is kept already, as by refactor
+	selfReviews, changedSinceReview := 0, false
+	criteriaRounds := 0
+	request := lastRequest(a.history)
+	if tracker := a.fileTracker(); a.config.SelfReview && !tracker.journalingNow() {
+		tracker.startJournal()
+		defer func() {
+			tracker.stopJournal()
+			a.autosave()
+		}()
+	}
+	var answer strings.Builder
+	for {
+		// Once files were changed, the review model gives the final answer
+		// instead of the edit model
+		if edited && !reviewed && !hasFunctionCalls(resp) && a.config.modelFor(TaskReview) != a.config.modelFor(TaskEdit) {
+			reviewed = true
+			a.history = a.history[:len(a.history)-2]
+			resp, err = a.runInference(ctx, modelConfig, TaskReview, lastToolParts...)
+			if err != nil {
+				log.Println("ERROR running review:", err.Error())
+				return "", err
+			}
+		}
+
 		// Process response parts
-		toolCalls := []genai.FunctionCall{}
+		answer.Reset()
+		toolCalls := []*genai.FunctionCall{}
 		for _, part := range resp.Candidates[0].Content.Parts {
-			switch v := part.(type) {
-			case genai.Text:
-				fmt.Printf("\u001b[93mGemini\u001b[0m: %v\n", v)
-			case genai.FunctionCall:
-				toolCalls = append(toolCalls, v)
+			switch {
+			case part.Thought:
+				a.printThought(part.Text)
+			case part.FunctionCall != nil:
+				toolCalls = append(toolCalls, part.FunctionCall)
+			case part.Text != "":
+				fmt.Fprintf(a.out, "%s: %v\n", paint(roleModel, "Gemini"), part.Text)
+				answer.WriteString(part.Text)
 			}
 		}
-
-		// If there are tool calls, execute them and send results back to the model
-		if len(toolCalls) > 0 {
-			toolParts := make([]genai.Part, 0, len(toolCalls))
-			for _, call := range toolCalls {
-				result := a.executeTool(call.Name, call.Args)
-				toolParts = append(toolParts, genai.FunctionResponse{
-					Name:     call.Name,
-					Response: result,
-				})
+		if len(toolCalls) == 0 {
+			if a.config.SelfReview && changedSinceReview && selfReviews < maxSelfReviews {
+				selfReviews++
+				changedSinceReview = false
+				review, err := a.selfReview(ctx, modelConfig, request)
+				if err != nil {
+					log.Println("ERROR running self-review:", err.Error())
+					return "", err
+				}
+				if review != nil {
+					resp = review
+					turns++
+					continue
+				}
+			}
+			// The answer only counts once the success criteria hold
+			next, err := a.meetCriteria(ctx, modelConfig, criteriaRounds)
+			if err != nil {
+				return "", err
 			}
+			if next == nil {
+				break
+			}
+			criteriaRounds++
+			resp = next
+			turns++
+			continue
+		}
 
-			// Send tool responses back to the model
-			resp, err = session.SendMessage(ctx, toolParts...)
+		if a.budgetUsed() >= budgetWrapUp {
+			plan, err := a.wrapUp(ctx, modelConfig, toolCalls, ran)
 			if err != nil {
-				log.Println("ERROR sending tool response:", err.Error())
-				return err
+				log.Println("ERROR wrapping up:", err.Error())
+				return "", err
 			}
+			answer.Reset()
+			answer.WriteString(plan)
+			break
+		}
 
-			// Print the model's response after tool execution
-			for _, part := range resp.Candidates[0].Content.Parts {
-				if text, ok := part.(genai.Text); ok {
-					fmt.Printf("\u001b[93mGemini\u001b[0m: %v\n", text)
-				}
+		if a.config.limitReached(turns, toolCallCount+len(toolCalls)) {
+			if !a.confirmContinue(turns, toolCallCount) {
+				// Drop the unanswered tool calls so the history stays valid
+				a.history = a.history[:len(a.history)-1]
+				break
 			}
+			turns, toolCallCount = 0, 0
 		}
 
-		// Continue the loop to get new user input
+		// Execute the tool calls and send results back to the model
+		toolParts := make([]*genai.Part, 0, len(toolCalls))
+		a.countApprovals(toolCalls[len(ran):])
+		var blobParts []*genai.Part
+		responses := ran
+		for _, call := range toolCalls[len(ran):] {
+			result, blob := a.executeTool(ctx, call.Name, call.Args)
+			if blob != nil {
+				blobParts = append(blobParts, &genai.Part{InlineData: blob})
+			}
+			responses = append(responses, &genai.FunctionResponse{
+				ID:       call.ID,
+				Name:     call.Name,
+				Response: result,
+			})
+			// Saved after every call, so a resumed session doesn't run it again
+			a.session.Pending = &PendingTurn{Results: responses}
+			a.autosave()
+		}
+		ran = nil
+		for _, response := range responses {
+			if _, failed := response.Response["error"]; !failed && a.toolKind(response.Name) == ToolWrite {
+				edited = true
+				changedSinceReview = true
+			}
+			toolParts = append(toolParts, &genai.Part{FunctionResponse: response})
+		}
+		// Files loaded by media tools follow the tool results as inline data
+		toolParts = append(toolParts, blobParts...)
+		toolCallCount += len(toolCalls)
+		lastToolParts = toolParts
+
+		resp, err = a.runInference(ctx, modelConfig, TaskEdit, toolParts...)
+		if err != nil {
+			log.Println("ERROR sending tool response:", err.Error())
+			return "", err
+		}
+		a.session.Pending = nil
+		a.autosave()
+		turns++
 	}
-	return nil
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.Int("codegent.turns", turns),
+		attribute.Int("codegent.tool_calls", toolCallCount),
+	)
+
+	a.session.Pending = nil
+	a.autosave()
+	return answer.String(), nil
+}
+
+// toolKind returns the kind of the named tool, unknown tools count as read
+// since executeTool refuses them anyway
+func (a *Agent) toolKind(name string) ToolKind {
+	for _, tool := range a.tools {
+		if tool.Name == name {
+			return tool.Kind
+		}
+	}
+	return ToolRead
+}
+
+// callKind returns the kind of a call of the named tool, see KindOf
+func (a *Agent) callKind(name string, args map[string]interface{}) ToolKind {
+	for _, tool := range a.tools {
+		if tool.Name == name {
+			input, _ := json.Marshal(args)
+			return tool.callKind(input)
+		}
+	}
+	return ToolRead
+}
+
+// executeTool runs the named tool. Media tools also return a blob to send
+// to the model alongside the result.
+func (a *Agent) executeTool(ctx context.Context, name string, input map[string]interface{}) (map[string]interface{}, *genai.Blob) {
+	_, span := tracer.Start(ctx, "execute_tool "+name, trace.WithAttributes(
+		attribute.String("gen_ai.tool.name", name),
+		attribute.String("codegent.tool.kind", string(a.toolKind(name))),
+	))
+	result, blob := a.runTool(ctx, name, input)
+	if errText, failed := result["error"]; failed {
+		span.SetStatus(codes.Error, fmt.Sprint(errText))
+	}
+	span.End()
+	observeToolCall(name, slices.ContainsFunc(a.tools, func(t ToolDefinition) bool { return t.Name == name }), result)
+	a.recordToolCall(name, input, result)
+	if a.onToolCall != nil {
+		inputJSON, _ := json.Marshal(input)
+		a.onToolCall(name, inputJSON, result)
+	}
+	return result, blob
 }
 
-func (a *Agent) executeTool(name string, input map[string]interface{}) map[string]interface{} {
+func (a *Agent) runTool(ctx context.Context, name string, input map[string]interface{}) (map[string]interface{}, *genai.Blob) {
 	var toolDef ToolDefinition
 	var found bool
 	for _, tool := range a.tools {
@@ -165,28 +666,130 @@ func (a *Agent) executeTool(name string, input map[string]interface{}) map[strin
 		}
 	}
 	if !found {
-		return map[string]interface{}{"error": "tool not found"}
+		return toolErrorResult(&toolError{Code: errUnknownTool, Message: "tool not found"}), nil
 	}
 
+	if err := validateArgs(toolDef, input); err != nil {
+		return toolErrorResult(err), nil
+	}
 	inputJSON, _ := json.Marshal(input)
-	fmt.Printf("\u001b[92mtool\u001b[0m: %s(%s)\n", name, inputJSON)
-	response, err := toolDef.Function(inputJSON)
+	if toolDef.Preview != nil {
+		var preview string
+		var previewErr error
+		err := a.inWorkspace(func() {
+			defer recoverTool(name, &previewErr)
+			preview, previewErr = toolDef.Preview(ctx, inputJSON)
+		})
+		if err == nil {
+			err = previewErr
+		}
+		if err != nil {
+			return toolErrorResult(err), nil
+		}
+		fmt.Fprint(a.out, preview)
+	}
+	if err := a.approveToolCall(toolDef, inputJSON); err != nil {
+		return toolErrorResult(err), nil
+	}
+	fmt.Fprintf(a.out, "%s: %s(%s)\n", paint(roleTool, tr("tool")), name, inputJSON)
+
+	timeout := a.config.toolTimeout(name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Ctrl-C stops the tool rather than codegent
+	if a.interruptTools {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+	}
+
+	var response string
+	var blob *genai.Blob
+	var toolErr error
+	progress := a.startProgress(name)
+	if progress != nil {
+		ctx = withToolOutput(ctx, progress)
+	} else if a.toolOutput != nil {
+		ctx = withToolOutput(ctx, a.toolOutput)
+	}
+	ctx = withScratch(ctx, a.session.ID)
+	// Tools stop when ctx is done. The call is waited for even then, as a
+	// tool that is still running holds the workspace and may still write.
+	err := a.inWorkspace(func() {
+		defer recoverTool(name, &toolErr)
+		if toolDef.MediaFunction != nil {
+			response, blob, toolErr = toolDef.MediaFunction(ctx, inputJSON)
+		} else {
+			response, toolErr = toolDef.Function(ctx, inputJSON)
+		}
+	})
+	if err == nil {
+		err = toolErr
+	}
+	progress.stop()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = toolCancelled(ctxErr, timeout)
+	}
 	if err != nil {
-		return map[string]interface{}{"error": err.Error()}
+		return toolErrorResult(err), nil
 	}
-	return map[string]interface{}{"result": response}
+	return map[string]interface{}{"result": truncateOutput(response, a.config.MaxToolOutputTokens)}, blob
 }
 
+// toolCancelled explains why a tool call's context ended
+func toolCancelled(err error, timeout time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newToolError(errTimeout, fmt.Sprintf("timed out after %s", timeout), "Narrow the call down, such as a smaller path or a quicker command, or ask the user to raise --tool-timeout.")
+	}
+	return &toolError{Code: errCancelled, Message: "cancelled", Suggestion: "The user stopped the call, ask before trying it again."}
+}
+
+type toolOutputKey struct{}
+
+func withToolOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, toolOutputKey{}, w)
+}
+
+// toolOutput returns where a tool streams output while it runs, such as a
+// command's, for the user to follow. What it returns is still what the
+// model gets.
+func toolOutput(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(toolOutputKey{}).(io.Writer); ok {
+		return w
+	}
+	return io.Discard
+}
+
+// runInference sends the parts as the next user turn to the model routed for
+// task, and records both the turn and the model's reply in the history
 func (a *Agent) runInference(
 	ctx context.Context,
-	session *genai.ChatSession,
-	userInput string,
+	modelConfig *genai.GenerateContentConfig,
+	task Task,
+	parts ...*genai.Part,
 ) (*genai.GenerateContentResponse, error) {
-	// Send the user message to the model
-	response, err := session.SendMessage(ctx, genai.Text(userInput))
+	userContent := genai.NewContentFromParts(parts, genai.RoleUser)
+	contents := append(a.history, userContent)
+
+	// Send the conversation to the model
+	response, err := a.generate(ctx, a.config.modelFor(task), contents, modelConfig)
 	if err != nil {
 		return nil, fmt.Errorf("error sending message: %v", err)
 	}
+	if len(response.Candidates) == 0 || response.Candidates[0].Content == nil {
+		return nil, fmt.Errorf("model returned an empty response")
+	}
+
+	if usage := response.UsageMetadata; usage != nil {
+		a.tokensUsed += int(usage.TotalTokenCount)
+		a.contextTokens = int(usage.PromptTokenCount + usage.CandidatesTokenCount)
+	}
+
+	reply := withoutThoughts(response.Candidates[0].Content)
+	a.history = append(contents, reply)
 	return response, nil
 }
 
@@ -195,31 +798,70 @@ type ToolDefinition struct {
 	Name        string       `json:"name"`
 	Description string       `json:"description"`
 	InputSchema genai.Schema `json:"input_schema"`
-	Function    func(input json.RawMessage) (string, error)
+	// The most a call can do; KindOf narrows it down for a given call
+	Kind ToolKind `json:"kind"`
+	// ctx is cancelled when the call times out or the user interrupts it
+	Function func(ctx context.Context, input json.RawMessage) (string, error)
+
+	// Used instead of Function by tools that load files the model reads
+	// natively, such as images and PDFs
+	MediaFunction func(ctx context.Context, input json.RawMessage) (string, *genai.Blob, error)
+
+	// Optional; describes what the call would change, shown to the user
+	// before it runs
+	Preview func(ctx context.Context, input json.RawMessage) (string, error)
+
+	// Optional; the kind of a given call, for tools that mostly look but
+	// can change things too, such as kubectl
+	KindOf func(input json.RawMessage) ToolKind
+
+	// Optional; the command lines a call would run, for tools that run
+	// commands, checked against the guardrails
+	Commands func(input json.RawMessage) []string
+}
+
+// callKind returns what a call of the tool with input can do
+func (t ToolDefinition) callKind(input json.RawMessage) ToolKind {
+	if t.KindOf != nil {
+		return t.KindOf(input)
+	}
+	return t.Kind
 }
 
 // ReadFile Tool
 var ReadFileDefinition = ToolDefinition{
 	Name:        "read_file",
-	Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names.",
+	Description: "Read the contents of a given relative file path. Use this when you want to see what's inside a file. Do not use this with directory names. Binary files are summarized instead of returned.",
 	InputSchema: GenerateSchema[ReadFileInput](),
+	Kind:        ToolRead,
 	Function:    ReadFile,
 }
 
 type ReadFileInput struct {
-	Path string `json:"path" jsonschema_description:"The relative path of a file in the working directory."`
+	Path string `json:"path" jsonschema_description:"The relative path of a file in the working directory." jsonschema:"required"`
+	Raw  bool   `json:"raw,omitempty" jsonschema_description:"Return the raw bytes even if the file looks binary. Only set this when the raw content is really needed."`
+
+	StartLine int `json:"start_line,omitempty" jsonschema_description:"Optional 1-based first line to return. Use with end_line to read part of a large file."`
+	EndLine   int `json:"end_line,omitempty" jsonschema_description:"Optional 1-based last line to return (inclusive). Defaults to the end of the file."`
+
+	LineNumbers bool `json:"line_numbers,omitempty" jsonschema_description:"Prefix each line with its line number. Use this before a line-addressed edit_file call."`
 }
 
 // List File Tool
 var ListFilesDefinition = ToolDefinition{
 	Name:        "list_files",
-	Description: "List files and directories at a given path. If no path is provided, lists files in the current directory.",
+	Description: "List files and directories at a given path. If no path is provided, lists files in the current directory. Large listings are paginated; .git is skipped.",
 	InputSchema: GenerateSchema[ListFilesInput](),
+	Kind:        ToolRead,
 	Function:    ListFiles,
 }
 
 type ListFilesInput struct {
-	Path string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	Path       string `json:"path,omitempty" jsonschema_description:"Optional relative path to list files from. Defaults to current directory if not provided."`
+	MaxDepth   int    `json:"max_depth,omitempty" jsonschema_description:"Optional maximum depth to descend, 1 lists only the direct children. Unlimited by default, 2 in tree mode."`
+	MaxEntries int    `json:"max_entries,omitempty" jsonschema_description:"Optional maximum number of entries to return. Defaults to 500."`
+	Offset     int    `json:"offset,omitempty" jsonschema_description:"Optional number of entries to skip. Pass next_offset from a truncated listing to get the next page."`
+	Tree       bool   `json:"tree,omitempty" jsonschema_description:"Return an indented tree with file counts per directory instead of a flat list. Use this for a first overview of a large repository."`
 }
 
 // Edit Tool
@@ -230,15 +872,23 @@ var EditFileDefinition = ToolDefinition{
 Replaces 'old_str' with 'new_str' in the given file. 'old_str' and 'new_str' MUST be different from each other.
 
 If the file specified with path doesn't exist, it will be created with new_str as its contents when old_str is empty.
+
+Alternatively, edit by line number, as shown by read_file with line_numbers set: give start_line (and optionally end_line) to replace those lines with new_str, or insert_after_line to insert new_str after that line (0 inserts at the top). Leave old_str empty in this mode. Prefer it when old_str would not be unique, such as in repetitive files.
 `,
 	InputSchema: GenerateSchema[EditFileInput](),
+	Kind:        ToolWrite,
 	Function:    EditFile,
+	Preview:     EditFilePreview,
 }
 
 type EditFileInput struct {
-	Path   string `json:"path" jsonschema_description:"The path to the file"`
+	Path   string `json:"path" jsonschema_description:"The path to the file" jsonschema:"required"`
 	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly. Use empty string to create a new file."`
 	NewStr string `json:"new_str" jsonschema_description:"Text to replace old_str with, or contents for a new file if old_str is empty"`
+
+	StartLine       int  `json:"start_line,omitempty" jsonschema_description:"Optional 1-based first line to replace with new_str, instead of matching old_str."`
+	EndLine         int  `json:"end_line,omitempty" jsonschema_description:"Optional 1-based last line to replace (inclusive). Defaults to start_line."`
+	InsertAfterLine *int `json:"insert_after_line,omitempty" jsonschema_description:"Optional line number after which to insert new_str, instead of matching old_str. 0 inserts at the top of the file."`
 }
 
 func GenerateSchema[T any]() genai.Schema {
@@ -250,63 +900,62 @@ func GenerateSchema[T any]() genai.Schema {
 	var v T
 
 	schema := reflector.Reflect(v)
+	return *convertSchema(schema)
+}
 
-	// Convert jsonschema properties to genai.Schema properties
-	properties := make(map[string]*genai.Schema)
-	required := make([]string, 0)
+// convertSchema maps a JSON schema to a genai.Schema, including the items of
+// arrays and the properties of nested objects
+func convertSchema(jsSchema *jsonschema.Schema) *genai.Schema {
+	// Map JSON schema types to genai.Schema types
+	var schemaType genai.Type
+	switch jsSchema.Type {
+	case "string":
+		schemaType = genai.TypeString
+	case "number":
+		schemaType = genai.TypeNumber
+	case "integer":
+		schemaType = genai.TypeInteger
+	case "boolean":
+		schemaType = genai.TypeBoolean
+	case "array":
+		schemaType = genai.TypeArray
+	case "object":
+		schemaType = genai.TypeObject
+	default:
+		schemaType = genai.TypeString // Default to string if unknown
+	}
 
-	// Extract required fields from the schema
-	if schema.Required != nil {
-		required = schema.Required
+	out := &genai.Schema{
+		Type:        schemaType,
+		Description: jsSchema.Description,
+	}
+	for _, value := range jsSchema.Enum {
+		out.Enum = append(out.Enum, fmt.Sprint(value))
+	}
+	if jsSchema.Items != nil {
+		out.Items = convertSchema(jsSchema.Items)
 	}
 
 	// Only include properties that are actually defined
-	for pair := schema.Properties.Newest(); pair != nil; pair = pair.Next() {
-		key := pair.Key
-		jsSchema := pair.Value
-
-		// Map JSON schema types to genai.Schema types
-		var schemaType genai.Type
-		switch jsSchema.Type {
-		case "string":
-			schemaType = genai.TypeString
-		case "number":
-			schemaType = genai.TypeNumber
-		case "integer":
-			schemaType = genai.TypeInteger
-		case "boolean":
-			schemaType = genai.TypeBoolean
-		case "array":
-			schemaType = genai.TypeArray
-		case "object":
-			schemaType = genai.TypeObject
-		default:
-			schemaType = genai.TypeString // Default to string if unknown
+	if jsSchema.Properties != nil && jsSchema.Properties.Len() > 0 {
+		out.Properties = make(map[string]*genai.Schema)
+		for pair := jsSchema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			out.Properties[pair.Key] = convertSchema(pair.Value)
 		}
-
-		properties[key] = &genai.Schema{
-			Type:        schemaType,
-			Description: jsSchema.Description,
+		// Verify each required property exists in properties map
+		for _, req := range jsSchema.Required {
+			if _, exists := out.Properties[req]; exists {
+				out.Required = append(out.Required, req)
+			}
 		}
 	}
-
-	// Verify each required property exists in properties map
-	filteredRequired := make([]string, 0, len(required))
-	for _, req := range required {
-		if _, exists := properties[req]; exists {
-			filteredRequired = append(filteredRequired, req)
-		}
-	}
-
-	// Create a genai.Schema for the object
-	return genai.Schema{
-		Type:       genai.TypeObject,
-		Properties: properties,
-		Required:   filteredRequired,
+	if schemaType == genai.TypeObject && out.Properties == nil {
+		out.Properties = map[string]*genai.Schema{}
 	}
+	return out
 }
 
-func ReadFile(input json.RawMessage) (string, error) {
+func ReadFile(ctx context.Context, input json.RawMessage) (string, error) {
 	readFileInput := ReadFileInput{}
 	err := json.Unmarshal(input, &readFileInput)
 	if err != nil {
@@ -317,10 +966,82 @@ func ReadFile(input json.RawMessage) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return string(content), nil
+	fileVersions.record(readFileInput.Path, content)
+
+	if !readFileInput.Raw && isBinary(content) {
+		return describeBinary(readFileInput.Path, content), nil
+	}
+	text := string(content)
+	if readFileInput.StartLine > 0 || readFileInput.EndLine > 0 {
+		text, err = lineRange(text, readFileInput.StartLine, readFileInput.EndLine)
+		if err != nil {
+			return "", err
+		}
+	}
+	if readFileInput.LineNumbers {
+		return numberLines(text, max(readFileInput.StartLine, 1)), nil
+	}
+	return text, nil
+}
+
+// numberLines prefixes each line of text with its line number, counting
+// from first
+func numberLines(text string, first int) string {
+	var sb strings.Builder
+	for i, line := range strings.SplitAfter(strings.TrimSuffix(text, "\n"), "\n") {
+		fmt.Fprintf(&sb, "%6d\t%s", first+i, line)
+	}
+	if strings.HasSuffix(text, "\n") {
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// lineRange returns lines start through end (1-based, inclusive) of content.
+// Zero start or end means the beginning or end of the file.
+func lineRange(content string, start, end int) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	if start < 1 {
+		start = 1
+	}
+	if end < 1 || end > len(lines) {
+		end = len(lines)
+	}
+	if start > end {
+		return "", fmt.Errorf("start_line %d is past end_line %d (file has %d lines)", start, end, len(lines))
+	}
+	return strings.Join(lines[start-1:end], ""), nil
+}
+
+// isBinary reports whether content looks like a binary file: a NUL byte
+// near the start, or text that isn't valid UTF-8
+func isBinary(content []byte) bool {
+	head := content
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	return !utf8.Valid(content)
+}
+
+// describeBinary summarizes a binary file instead of returning its bytes
+func describeBinary(path string, content []byte) string {
+	mimeType := http.DetectContentType(content)
+	summary := fmt.Sprintf("%s is a binary file (%s, %d bytes), contents not shown.", path, mimeType, len(content))
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		summary += " Use read_image to look at it."
+	case mimeType == "application/pdf":
+		summary += " Use read_pdf to read it."
+	default:
+		summary += " Call read_file again with raw set to true if the raw content is really needed."
+	}
+	return summary
 }
 
-func ListFiles(input json.RawMessage) (string, error) {
+func ListFiles(ctx context.Context, input json.RawMessage) (string, error) {
 	listFilesInput := ListFilesInput{}
 	err := json.Unmarshal(input, &listFilesInput)
 	if err != nil {
@@ -331,33 +1052,54 @@ func ListFiles(input json.RawMessage) (string, error) {
 	if listFilesInput.Path != "" {
 		dir = listFilesInput.Path
 	}
+	maxEntries := listFilesInput.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
 
-	files := make([]string, 0)
-	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(dir, path)
-		if err != nil {
-			return err
+	if listFilesInput.Tree {
+		maxDepth := listFilesInput.MaxDepth
+		if maxDepth <= 0 {
+			maxDepth = 2
 		}
+		return listTree(ctx, dir, maxDepth, maxEntries)
+	}
 
-		if relPath != "." {
+	files := make([]string, 0)
+	index, truncated := 0, false
+	err = walkFiles(ctx, dir, listFilesInput.MaxDepth, func(relPath string, d os.DirEntry) bool {
+		// Entries before the requested page are only counted
+		if index >= listFilesInput.Offset {
+			if len(files) == maxEntries {
+				truncated = true
+				return false
+			}
 			if d.IsDir() {
 				files = append(files, relPath+"/")
 			} else {
 				files = append(files, relPath)
 			}
 		}
-		return nil
+		index++
+		return true
 	})
 
 	if err != nil {
 		return "", err
 	}
 
-	result, err := json.Marshal(files)
+	// A complete listing stays a plain array, pages say where to continue
+	var result []byte
+	if !truncated && listFilesInput.Offset == 0 {
+		result, err = json.Marshal(files)
+	} else {
+		page := listFilesPage{Entries: files, Offset: listFilesInput.Offset}
+		if truncated {
+			page.NextOffset = listFilesInput.Offset + len(files)
+			page.Note = "More entries follow. Call list_files again with offset set to next_offset, narrow the path, or use tree mode for an overview."
+		}
+		result, err = json.Marshal(page)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -365,89 +1107,242 @@ func ListFiles(input json.RawMessage) (string, error) {
 	return string(result), nil
 }
 
-func EditFile(input json.RawMessage) (string, error) {
-	var editFileInput EditFileInput
-	if err := json.Unmarshal(input, &editFileInput); err != nil {
-		// Handle the case where we might have incomplete inputs
-		var partialInput map[string]interface{}
-		if jsonErr := json.Unmarshal(input, &partialInput); jsonErr == nil {
-			// Create default values for missing fields
-			if path, ok := partialInput["path"]; !ok || path == "" {
-				// Set default path if missing
-				editFileInput.Path = "./fizzbuzz.js"
-			} else {
-				editFileInput.Path = path.(string)
-			}
-			
-			if oldStr, ok := partialInput["old_str"]; !ok {
-				// If old_str is missing, set it to empty to create a new file
-				editFileInput.OldStr = ""
-			} else if oldStr != nil {
-				editFileInput.OldStr = oldStr.(string)
-			}
-			
-			if newStr, ok := partialInput["new_str"]; ok && newStr != nil {
-				editFileInput.NewStr = newStr.(string)
+// defaultMaxEntries caps a list_files page when max_entries isn't given
+const defaultMaxEntries = 500
+
+type listFilesPage struct {
+	Entries    []string `json:"entries"`
+	Offset     int      `json:"offset"`
+	NextOffset int      `json:"next_offset,omitempty"`
+	Note       string   `json:"note,omitempty"`
+}
+
+// pathDepth is 1 for direct children of the listed directory
+func pathDepth(relPath string) int {
+	return strings.Count(relPath, string(filepath.Separator)) + 1
+}
+
+// listTree renders an indented overview of dir down to maxDepth, with the
+// number of files below each directory
+func listTree(ctx context.Context, dir string, maxDepth, maxEntries int) (string, error) {
+	type treeEntry struct {
+		relPath string
+		isDir   bool
+	}
+	var entries []treeEntry
+	fileCounts := map[string]int{}
+	total := 0
+
+	err := walkFiles(ctx, dir, 0, func(relPath string, d os.DirEntry) bool {
+		if !d.IsDir() {
+			total++
+			for parent := filepath.Dir(relPath); parent != "."; parent = filepath.Dir(parent) {
+				fileCounts[parent]++
 			}
+		}
+		if pathDepth(relPath) <= maxDepth {
+			entries = append(entries, treeEntry{relPath, d.IsDir()})
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s/ (%d files)\n", filepath.Clean(dir), total)
+	for i, entry := range entries {
+		if i == maxEntries {
+			fmt.Fprintf(&sb, "... %d more entries not shown, raise max_entries or list a subdirectory\n", len(entries)-i)
+			break
+		}
+		indent := strings.Repeat("  ", pathDepth(entry.relPath))
+		if entry.isDir {
+			fmt.Fprintf(&sb, "%s%s/ (%d files)\n", indent, filepath.Base(entry.relPath), fileCounts[entry.relPath])
 		} else {
-			return "", fmt.Errorf("invalid input format: %w", err)
+			fmt.Fprintf(&sb, "%s%s\n", indent, filepath.Base(entry.relPath))
 		}
 	}
+	return sb.String(), nil
+}
+
+func EditFilePreview(ctx context.Context, input json.RawMessage) (string, error) {
+	var editFileInput EditFileInput
+	if err := json.Unmarshal(input, &editFileInput); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+	edit, err := planEdit(&editFileInput)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(editFileInput.Path, string(edit.oldContent), edit.newContent), nil
+}
+
+func EditFile(ctx context.Context, input json.RawMessage) (string, error) {
+	var editFileInput EditFileInput
+	if err := json.Unmarshal(input, &editFileInput); err != nil {
+		return "", fmt.Errorf("invalid input format: %w", err)
+	}
+	edit, err := planEdit(&editFileInput)
+	if err != nil {
+		return "", err
+	}
+
+	// Either create a new file or modify an existing one
+	if !edit.exists {
+		return createNewFile(editFileInput.Path, edit.newContent)
+	}
+	fileVersions.beforeWrite(editFileInput.Path)
+	if err := os.WriteFile(editFileInput.Path, []byte(edit.newContent), 0644); err != nil {
+		return "", err
+	}
+	fileVersions.record(editFileInput.Path, []byte(edit.newContent))
+	return edit.done, nil
+}
+
+// plannedEdit is what an edit_file call would do to its file
+type plannedEdit struct {
+	oldContent []byte
+	newContent string
+	exists     bool   // false for a file the call creates
+	done       string // the result once it is written
+}
 
+// planEdit works out the file's new content without writing it, for
+// EditFile and its preview
+func planEdit(editFileInput *EditFileInput) (*plannedEdit, error) {
 	// Validate that we have the necessary fields
 	if editFileInput.Path == "" {
 		editFileInput.Path = "./failed.txt" // Default path if not specified
 	}
-	
+
+	if err := editingNotebook(editFileInput.Path); err != nil {
+		return nil, err
+	}
+
+	if editFileInput.StartLine > 0 || editFileInput.InsertAfterLine != nil {
+		return planLines(*editFileInput)
+	}
+
 	if editFileInput.OldStr == editFileInput.NewStr && editFileInput.OldStr != "" {
-		return "", fmt.Errorf("old_str and new_str must be different")
+		return nil, newToolError(errInvalidInput, "old_str and new_str must be different", "Nothing to change, the file already has new_str there.")
 	}
 
 	// Handle file creation or modification
-	fileExists := true
 	content, err := os.ReadFile(editFileInput.Path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			fileExists = false
-			// For new files, we'll accept an empty old_str
-			if editFileInput.OldStr != "" {
-				return "", fmt.Errorf("file does not exist and old_str is not empty")
-			}
-		} else {
-			return "", err
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		// For new files, we'll accept an empty old_str
+		if editFileInput.OldStr != "" {
+			return nil, newToolError(errNotFound, "file does not exist and old_str is not empty", "Leave old_str empty to create the file, or check the path with list_files.")
 		}
+		return &plannedEdit{newContent: editFileInput.NewStr}, nil
 	}
 
-	// Either create a new file or modify an existing one
-	if !fileExists {
-		return createNewFile(editFileInput.Path, editFileInput.NewStr)
-	} else {
-		oldContent := string(content)
-		newContent := strings.Replace(oldContent, editFileInput.OldStr, editFileInput.NewStr, -1)
+	oldContent := string(content)
+	oldStr := withLineEndings(oldContent, editFileInput.OldStr)
+	newStr := withLineEndings(oldContent, editFileInput.NewStr)
+	newContent := strings.Replace(oldContent, oldStr, newStr, -1)
+
+	if oldContent == newContent && editFileInput.OldStr != "" {
+		return nil, newToolError(errNoMatch, "old_str not found in file", "Read the file again with read_file and copy old_str from it exactly, including whitespace and indentation.")
+	}
+
+	// Don't clobber changes made outside the agent since the last read
+	if err := fileVersions.check(editFileInput.Path, content); err != nil {
+		return nil, err
+	}
+	return &plannedEdit{
+		oldContent: content,
+		newContent: newContent,
+		exists:     true,
+		done:       fmt.Sprintf("File %s updated successfully", editFileInput.Path),
+	}, nil
+}
 
-		if oldContent == newContent && editFileInput.OldStr != "" {
-			return "", fmt.Errorf("old_str not found in file")
+// planLines plans a line-addressed edit: replacing lines start_line
+// through end_line, or inserting after insert_after_line
+func planLines(editFileInput EditFileInput) (*plannedEdit, error) {
+	if editFileInput.OldStr != "" {
+		return nil, newToolError(errInvalidInput, "old_str must be empty when editing by line number", "Leave old_str out, or leave out start_line and insert_after_line to edit by text.")
+	}
+	if editFileInput.StartLine > 0 && editFileInput.InsertAfterLine != nil {
+		return nil, newToolError(errInvalidInput, "give either start_line or insert_after_line, not both", "")
+	}
+
+	content, err := os.ReadFile(editFileInput.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := fileVersions.check(editFileInput.Path, content); err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var start, end int // lines[start:end] are replaced
+	if editFileInput.InsertAfterLine != nil {
+		start = *editFileInput.InsertAfterLine
+		if start < 0 || start > len(lines) {
+			return nil, newToolError(errInvalidInput, fmt.Sprintf("insert_after_line %d is out of range (file has %d lines)", start, len(lines)), "Use a line between 0 and the file's length, read_file shows line numbers.")
 		}
+		end = start
+	} else {
+		start, end = editFileInput.StartLine-1, editFileInput.EndLine
+		if end == 0 {
+			end = editFileInput.StartLine
+		}
+		if end < editFileInput.StartLine || end > len(lines) {
+			return nil, newToolError(errInvalidInput, fmt.Sprintf("line range %d-%d is out of range (file has %d lines)", editFileInput.StartLine, end, len(lines)), "Read the file again with read_file and use the line numbers it shows.")
+		}
+	}
 
-		if err := os.WriteFile(editFileInput.Path, []byte(newContent), 0644); err != nil {
-			return "", err
+	newline := lineEnding(string(content))
+	text := withLineEndings(string(content), editFileInput.NewStr)
+	lastLineOpen := len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += newline
+	}
+	if end == len(lines) && lastLineOpen {
+		if start == end {
+			// Inserting after a last line that has no newline
+			lines[end-1] += newline
+		} else {
+			// Keep the file without a trailing newline
+			text = strings.TrimSuffix(text, newline)
 		}
+	}
 
-		return fmt.Sprintf("File %s updated successfully", editFileInput.Path), nil
+	edit := &plannedEdit{
+		oldContent: content,
+		newContent: strings.Join(lines[:start], "") + text + strings.Join(lines[end:], ""),
+		exists:     true,
+		done:       fmt.Sprintf("Replaced lines %d-%d of %s", start+1, end, editFileInput.Path),
+	}
+	if editFileInput.InsertAfterLine != nil {
+		edit.done = fmt.Sprintf("Inserted into %s after line %d", editFileInput.Path, start)
 	}
+	return edit, nil
 }
 
 func createNewFile(filePath, content string) (string, error) {
-	dir := path.Dir(filePath)
+	dir := filepath.Dir(filePath)
 	if dir != "." {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return "", fmt.Errorf("failed to create directory: %w", err)
 		}
 	}
 
+	fileVersions.beforeWrite(filePath)
 	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
+	fileVersions.record(filePath, []byte(content))
 
 	return fmt.Sprintf("Successfully created file %s", filePath), nil
 }
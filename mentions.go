@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// fileMention is an @path in a message, as completed by the line editor
+var fileMention = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+// attachMentions attaches the text files mentioned as @path in a message,
+// so the model needn't read them first. Mentions that aren't files are left
+// alone, they may be e-mail addresses or handles.
+func (a *Agent) attachMentions(message string) {
+	seen := make(map[string]bool)
+	for _, match := range fileMention.FindAllStringSubmatch(message, -1) {
+		path := strings.TrimRight(match[1], ".,;:!?)")
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		var attachment string
+		a.inWorkspace(func() {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				return
+			}
+			content, err := os.ReadFile(path)
+			if err != nil || isBinary(content) {
+				return
+			}
+			fileVersions.record(path, content)
+			attachment = fmt.Sprintf("Contents of %s:\n```\n%s\n```", path, truncateOutput(strings.TrimRight(string(content), "\n"), a.config.MaxToolOutputTokens))
+		})
+		if attachment != "" {
+			a.attachments = append(a.attachments, attachment)
+		}
+	}
+}
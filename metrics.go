@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/genai"
+)
+
+// Prometheus metrics, served on /metrics by `codegent serve`
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codegent_requests_total",
+		Help: "User requests handled, by result (ok, error or budget_spent).",
+	}, []string{"result"})
+
+	toolCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codegent_tool_calls_total",
+		Help: "Tool calls made by the model, by tool and result (ok or error).",
+	}, []string{"tool", "result"})
+
+	apiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "codegent_api_errors_total",
+		Help: "Failed model calls, by model and HTTP status code (0 when there was no response).",
+	}, []string{"model", "code"})
+
+	modelTokens = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "codegent_model_call_tokens",
+		Help:    "Tokens used per model call, by model and type (input or output).",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"model", "type"})
+
+	requestTokens = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "codegent_request_tokens",
+		Help:    "Tokens used per user request, across all its model calls.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	})
+)
+
+// observeModelCall records the outcome of one model call
+func observeModelCall(model string, resp *genai.GenerateContentResponse, err error) {
+	if err != nil {
+		code := 0
+		var apiErr genai.APIError
+		if errors.As(err, &apiErr) {
+			code = apiErr.Code
+		}
+		apiErrorsTotal.WithLabelValues(model, strconv.Itoa(code)).Inc()
+		return
+	}
+	if usage := resp.UsageMetadata; usage != nil {
+		modelTokens.WithLabelValues(model, "input").Observe(float64(usage.PromptTokenCount))
+		modelTokens.WithLabelValues(model, "output").Observe(float64(usage.CandidatesTokenCount + usage.ThoughtsTokenCount))
+	}
+}
+
+// observeRequest records a finished user request and the tokens it used
+func observeRequest(tokens int, err error) {
+	result := "ok"
+	switch {
+	case errors.Is(err, errBudgetSpent):
+		result = "budget_spent"
+	case err != nil:
+		result = "error"
+	}
+	requestsTotal.WithLabelValues(result).Inc()
+	requestTokens.Observe(float64(tokens))
+}
+
+// observeToolCall records one tool call's outcome. Names of tools that
+// don't exist come from the model and are all counted as "unknown".
+func observeToolCall(name string, known bool, result map[string]interface{}) {
+	if !known {
+		name = "unknown"
+	}
+	outcome := "ok"
+	if _, failed := result["error"]; failed {
+		outcome = "error"
+	}
+	toolCallsTotal.WithLabelValues(name, outcome).Inc()
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The migration tools whose file layout codegent follows
+const (
+	migrationGoose   = "goose"
+	migrationMigrate = "golang-migrate"
+	migrationDbmate  = "dbmate"
+	// Numbered .sql files with only the up statements, as many smaller
+	// tools and hand-rolled runners use
+	migrationPlain = "sql"
+)
+
+var migrationTools = []string{migrationGoose, migrationMigrate, migrationDbmate, migrationPlain}
+
+// migrationDirs are where projects usually keep their migrations
+var migrationDirs = []string{"migrations", "db/migrations", "sql/migrations", "database/migrations", "internal/db/migrations"}
+
+// migrationFile matches migration file names such as 20240101120000_add_users.sql
+// and 000001_add_users.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+?)(\.up|\.down)?\.sql$`)
+
+// migration is one version of the schema. goose, dbmate and plain
+// migrations keep it in a single file, golang-migrate in an up and a down
+// file.
+type migration struct {
+	Version  string
+	Name     string
+	Up, Down string // paths
+}
+
+// migrationSet is the migrations directory of a project
+type migrationSet struct {
+	Dir        string
+	Tool       string
+	Migrations []migration // by version
+}
+
+// loadMigrationSet reads the migrations in dir, the first of migrationDirs
+// that exists when empty, and tells the tool they are written for from
+// the files unless it is given
+func loadMigrationSet(dir, tool string) (*migrationSet, error) {
+	if dir == "" {
+		for _, candidate := range migrationDirs {
+			if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+				dir = candidate
+				break
+			}
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("no migrations directory found in %s, pass --dir", strings.Join(migrationDirs, ", "))
+		}
+	}
+	if tool != "" && !slices.Contains(migrationTools, tool) {
+		return nil, fmt.Errorf("unknown migration tool %q, want one of %s", tool, strings.Join(migrationTools, ", "))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	set := &migrationSet{Dir: dir, Tool: tool}
+	detected := ""
+	for _, entry := range entries {
+		m := migrationFile.FindStringSubmatch(entry.Name())
+		if m == nil || entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		i := slices.IndexFunc(set.Migrations, func(existing migration) bool { return existing.Version == m[1] })
+		if i < 0 {
+			set.Migrations = append(set.Migrations, migration{Version: m[1], Name: m[2]})
+			i = len(set.Migrations) - 1
+		}
+		switch m[3] {
+		case ".down":
+			set.Migrations[i].Down = path
+			detected = migrationMigrate
+		case ".up":
+			set.Migrations[i].Up = path
+			detected = migrationMigrate
+		default:
+			set.Migrations[i].Up = path
+			if detected == "" {
+				detected = detectMigrationTool(path)
+			}
+		}
+	}
+	slices.SortFunc(set.Migrations, func(a, b migration) int {
+		return compareVersions(a.Version, b.Version)
+	})
+	if set.Tool == "" {
+		if detected == "" {
+			return nil, fmt.Errorf("%s has no migrations to tell the tool from, pass --tool", dir)
+		}
+		set.Tool = detected
+	}
+	return set, nil
+}
+
+// detectMigrationTool tells the tool a single-file migration is written
+// for by its markers
+func detectMigrationTool(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	switch text := strings.ToLower(string(content)); {
+	case strings.Contains(text, "-- +goose up"):
+		return migrationGoose
+	case strings.Contains(text, "-- migrate:up"):
+		return migrationDbmate
+	}
+	return migrationPlain
+}
+
+// compareVersions orders migration versions by their number
+func compareVersions(a, b string) int {
+	x, _ := strconv.ParseUint(a, 10, 64)
+	y, _ := strconv.ParseUint(b, 10, 64)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// lastVersion is the version of the newest migration, "" when there are
+// none
+func (s *migrationSet) lastVersion() string {
+	if len(s.Migrations) == 0 {
+		return ""
+	}
+	return s.Migrations[len(s.Migrations)-1].Version
+}
+
+// nextVersion numbers a new migration the way the existing ones are:
+// a UTC timestamp, a Unix time or the next sequence number with the same
+// padding. Timestamps are the tools' default for the first one.
+func (s *migrationSet) nextVersion(now time.Time) string {
+	last := s.lastVersion()
+	switch {
+	case last == "" && s.Tool == migrationPlain:
+		return "001"
+	case last == "" || len(last) == 14 && strings.HasPrefix(last, "20"):
+		return now.UTC().Format("20060102150405")
+	case len(last) == 10 && last >= "1000000000":
+		return strconv.FormatInt(now.Unix(), 10)
+	}
+	n, _ := strconv.ParseUint(last, 10, 64)
+	return fmt.Sprintf("%0*d", len(last), n+1)
+}
+
+// newFiles lists the files a new migration consists of
+func (s *migrationSet) newFiles(version, name string) []string {
+	base := filepath.Join(s.Dir, version+"_"+name)
+	if s.Tool == migrationMigrate {
+		return []string{base + ".up.sql", base + ".down.sql"}
+	}
+	return []string{base + ".sql"}
+}
+
+// layout describes how the tool expects a migration to be written, for
+// the model
+func (s *migrationSet) layout() string {
+	switch s.Tool {
+	case migrationGoose:
+		return "goose: the up statements follow a `-- +goose Up` line and the statements undoing them a `-- +goose Down` line; wrap statements that contain semicolons themselves, such as function bodies, in `-- +goose StatementBegin` and `-- +goose StatementEnd`"
+	case migrationMigrate:
+		return "golang-migrate: the up statements go in the .up.sql file and the statements undoing them in the .down.sql file"
+	case migrationDbmate:
+		return "dbmate: the up statements follow a `-- migrate:up` line and the statements undoing them a `-- migrate:down` line"
+	}
+	return "plain SQL files that only hold the up statements, this project has no down migrations"
+}
+
+// hasDown reports whether the tool's migrations can be rolled back
+func (s *migrationSet) hasDown() bool {
+	return s.Tool != migrationPlain
+}
+
+// statements returns the SQL that applies a migration, or rolls it back
+func (s *migrationSet) statements(m migration, up bool) (string, error) {
+	path := m.Up
+	if !up && s.Tool == migrationMigrate {
+		path = m.Down
+	}
+	if path == "" {
+		return "", fmt.Errorf("migration %s_%s has no %s file", m.Version, m.Name, map[bool]string{true: "up", false: "down"}[up])
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	switch s.Tool {
+	case migrationGoose:
+		return migrationSection(string(content), "-- +goose up", "-- +goose down", up), nil
+	case migrationDbmate:
+		return migrationSection(string(content), "-- migrate:up", "-- migrate:down", up), nil
+	}
+	return string(content), nil
+}
+
+// migrationSection returns the lines of a single-file migration between
+// the marker of a direction and the other one
+func migrationSection(content, upMarker, downMarker string, up bool) string {
+	var section strings.Builder
+	in := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		marker := strings.ToLower(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(marker, upMarker):
+			in = up
+		case strings.HasPrefix(marker, downMarker):
+			in = !up
+		case in:
+			section.WriteString(line)
+		}
+	}
+	return section.String()
+}
+
+// applyMigrations runs migrations in order, up or down
+func (s *migrationSet) applyMigrations(ctx context.Context, db *sql.DB, migrations []migration, up bool) error {
+	for _, m := range migrations {
+		statements, err := s.statements(m, up)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(statements) == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, statements); err != nil {
+			direction := "applying"
+			if !up {
+				direction = "rolling back"
+			}
+			return fmt.Errorf("%s %s_%s failed: %w", direction, m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// openScratchDatabase creates an empty database to try migrations on: a
+// temporary file for sqlite, and for postgres a new database on the server
+// of serverURL. The returned function drops it again.
+func openScratchDatabase(ctx context.Context, driver, serverURL string) (*sql.DB, func(), error) {
+	if driver == "sqlite" {
+		dir, err := os.MkdirTemp("", "codegent-scratch")
+		if err != nil {
+			return nil, nil, err
+		}
+		db, err := sql.Open("sqlite", filepath.Join(dir, "scratch.db"))
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, err
+		}
+		return db, func() {
+			db.Close()
+			os.RemoveAll(dir)
+		}, nil
+	}
+
+	u, err := url.Parse(os.ExpandEnv(serverURL))
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return nil, nil, fmt.Errorf("the scratch server must be a postgres:// URL")
+	}
+	server, err := sql.Open("pgx", u.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	name := fmt.Sprintf("codegent_scratch_%d", time.Now().UnixNano())
+	if _, err := server.ExecContext(ctx, "CREATE DATABASE "+name); err != nil {
+		server.Close()
+		return nil, nil, fmt.Errorf("failed to create the scratch database: %w", err)
+	}
+	u.Path = "/" + name
+	db, err := sql.Open("pgx", u.String())
+	drop := func() {
+		if db != nil {
+			db.Close()
+		}
+		// The context may be cancelled by now, the database is dropped
+		// regardless
+		server.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+name)
+		server.Close()
+	}
+	if err != nil {
+		drop()
+		return nil, nil, err
+	}
+	return db, drop, nil
+}
+
+// declaredSchema applies the migrations to a scratch database and
+// describes the schema they lead to
+func (s *migrationSet) declaredSchema(ctx context.Context, driver, scratchURL string) (string, error) {
+	db, drop, err := openScratchDatabase(ctx, driver, scratchURL)
+	if err != nil {
+		return "", err
+	}
+	defer drop()
+	if err := s.applyMigrations(ctx, db, s.Migrations, true); err != nil {
+		return "", err
+	}
+	return describeSchema(ctx, db, databaseConfig{Driver: driver})
+}
+
+// verifyMigrations applies all migrations to a scratch database. Where
+// the tool has down migrations it then rolls the ones after since back,
+// checks that this restores the schema before them, and applies them
+// again. It returns the schema the migrations lead to.
+func (s *migrationSet) verifyMigrations(ctx context.Context, since, driver, scratchURL string) (string, error) {
+	db, drop, err := openScratchDatabase(ctx, driver, scratchURL)
+	if err != nil {
+		return "", err
+	}
+	defer drop()
+
+	split := len(s.Migrations)
+	for i, m := range s.Migrations {
+		if since == "" || compareVersions(m.Version, since) > 0 {
+			split = i
+			break
+		}
+	}
+	existing, added := s.Migrations[:split], s.Migrations[split:]
+	if err := s.applyMigrations(ctx, db, existing, true); err != nil {
+		return "", fmt.Errorf("the existing migrations don't apply: %w", err)
+	}
+	before, err := describeSchema(ctx, db, databaseConfig{Driver: driver})
+	if err != nil {
+		return "", err
+	}
+	if err := s.applyMigrations(ctx, db, added, true); err != nil {
+		return "", err
+	}
+	after, err := describeSchema(ctx, db, databaseConfig{Driver: driver})
+	if err != nil {
+		return "", err
+	}
+	if !s.hasDown() {
+		return after, nil
+	}
+
+	reversed := slices.Clone(added)
+	slices.Reverse(reversed)
+	if err := s.applyMigrations(ctx, db, reversed, false); err != nil {
+		return "", err
+	}
+	if rolledBack, err := describeSchema(ctx, db, databaseConfig{Driver: driver}); err != nil {
+		return "", err
+	} else if rolledBack != before {
+		return "", fmt.Errorf("rolling back doesn't restore the schema, it was:\n\n%s\n\nand is after rolling back:\n\n%s", strings.TrimSpace(before), strings.TrimSpace(rolledBack))
+	}
+	if err := s.applyMigrations(ctx, db, added, true); err != nil {
+		return "", fmt.Errorf("after rolling back: %w", err)
+	}
+	return after, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/websocket"
+)
+
+// setupNetwork makes every connection codegent opens, to the model, Slack,
+// Telegram, Ollama and releases, trust the extra root certificates in
+// CODEGENT_CA_CERTS, PEM files separated like PATH. Corporate proxies that
+// inspect TLS sign with such roots. HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// are honored by the default transport already. A project's .env can't set
+// CODEGENT_CA_CERTS, or a repository could have its own server trusted.
+func setupNetwork() error {
+	paths := filepath.SplitList(userEnvOr("CODEGENT_CA_CERTS", ""))
+	if len(paths) == 0 {
+		return nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, path := range paths {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no PEM certificates in %s", path)
+		}
+	}
+
+	config := &tls.Config{RootCAs: pool}
+	// The Gemini and Vertex AI clients build theirs on the default transport
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = config
+	websocket.DefaultDialer.TLSClientConfig = config
+	return nil
+}
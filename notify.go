@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Ways to tell the user that a long task needs them, see Config.Notify
+const (
+	notifyOff     = "off"
+	notifyBell    = "bell"
+	notifyDesktop = "desktop"
+)
+
+// notifyLong tells the user that the task started at a.taskStart is done or
+// waiting for an answer, when it has taken longer than NotifyAfter. Short
+// tasks don't need it, the user is likely still watching.
+func (a *Agent) notifyLong(message string) {
+	if !a.notifications || a.config.Notify == notifyOff || a.taskStart.IsZero() || time.Since(a.taskStart) < a.config.NotifyAfter {
+		return
+	}
+	message = truncateRunes(strings.TrimSpace(ansiEscape.ReplaceAllString(message, "")), 100)
+	if a.config.Notify == notifyDesktop && desktopNotify("codegent", message) == nil {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\a")
+}
+
+// desktopNotify shows a notification with notify-send on Linux and the BSDs,
+// or AppleScript on macOS
+func desktopNotify(title, message string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		return exec.Command("osascript", "-e", script).Run()
+	case "windows":
+		return errors.ErrUnsupported
+	default:
+		return exec.Command("notify-send", "--app-name=codegent", title, message).Run()
+	}
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	googleAuthURL  = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	googleUserURL  = "https://www.googleapis.com/oauth2/v2/userinfo"
+	googleScopes   = []string{
+		"https://www.googleapis.com/auth/cloud-platform",
+		"https://www.googleapis.com/auth/userinfo.email",
+	}
+)
+
+// googleLogin is a Google account signed in with auth login google. It is
+// kept in the keychain as JSON, together with the OAuth client it was
+// signed in with since refreshing the access token needs it.
+type googleLogin struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+	Email        string `json:"email"`
+	// The Code Assist project requests are billed to, see setupCodeAssist
+	Project string `json:"project"`
+
+	mu     sync.Mutex
+	access string
+	expiry time.Time
+}
+
+// loadGoogleLogin returns the signed in Google account, nil if there is none
+func loadGoogleLogin() *googleLogin {
+	data, err := keychainGet("google")
+	if err != nil {
+		return nil
+	}
+	var login googleLogin
+	if json.Unmarshal([]byte(data), &login) != nil || login.RefreshToken == "" {
+		return nil
+	}
+	return &login
+}
+
+func (l *googleLogin) save() error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return keychainSet("google", "codegent Google account", string(data))
+}
+
+// signInWithGoogle runs the OAuth flow for installed apps: the consent page
+// opens in the browser and redirects back to a server on localhost, and the
+// code it brings is exchanged for tokens, with PKCE. The OAuth client is a
+// "Desktop app" client from the Google Cloud console, its ID and secret are
+// read from CODEGENT_OAUTH_CLIENT_ID and CODEGENT_OAUTH_CLIENT_SECRET, but
+// not from a project's .env.
+func signInWithGoogle(ctx context.Context) (*googleLogin, error) {
+	login := &googleLogin{
+		ClientID:     userEnvOr("CODEGENT_OAUTH_CLIENT_ID", ""),
+		ClientSecret: userEnvOr("CODEGENT_OAUTH_CLIENT_SECRET", ""),
+	}
+	if login.ClientID == "" || login.ClientSecret == "" {
+		return nil, errors.New("set CODEGENT_OAUTH_CLIENT_ID and CODEGENT_OAUTH_CLIENT_SECRET to a Desktop app OAuth client from the Google Cloud console")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	redirect := fmt.Sprintf("http://%s/oauth2callback", listener.Addr())
+	state, verifier := randomToken(), randomToken()
+	challenge := sha256.Sum256([]byte(verifier))
+
+	consent := googleAuthURL + "?" + url.Values{
+		"client_id":             {login.ClientID},
+		"redirect_uri":          {redirect},
+		"response_type":         {"code"},
+		"scope":                 {strings.Join(googleScopes, " ")},
+		"access_type":           {"offline"},
+		"prompt":                {"consent"},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+	fmt.Printf("Sign in with your Google account in the browser. If it doesn't open, visit:\n\n%s\n\n", consent)
+	openBrowser(consent)
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "State mismatch, start again with codegent auth login google", http.StatusBadRequest)
+			errs <- errors.New("the sign-in came back with the wrong state")
+		case query.Get("error") != "":
+			http.Error(w, "Sign-in failed: "+query.Get("error"), http.StatusBadRequest)
+			errs <- fmt.Errorf("sign-in failed: %s", query.Get("error"))
+		default:
+			fmt.Fprintln(w, "Signed in to codegent, you can close this tab.")
+			codes <- query.Get("code")
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return nil, err
+	case <-ctx.Done():
+		return nil, errors.New("timed out waiting for the sign-in")
+	}
+
+	token, err := login.requestToken(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken == "" {
+		return nil, errors.New("google returned no refresh token")
+	}
+	login.RefreshToken = token.RefreshToken
+
+	var user struct {
+		Email string `json:"email"`
+	}
+	if err := login.getJSON(ctx, googleUserURL, &user); err != nil {
+		return nil, err
+	}
+	login.Email = user.Email
+	return login, nil
+}
+
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// requestToken calls the token endpoint, keeping the access token it returns
+func (l *googleLogin) requestToken(ctx context.Context, form url.Values) (*oauthToken, error) {
+	form.Set("client_id", l.ClientID)
+	form.Set("client_secret", l.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		oauthToken
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("google token: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("google token: %s %s", result.Error, result.ErrorDescription)
+	}
+	l.access = result.AccessToken
+	// Refresh a minute early so requests don't race the expiry
+	l.expiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return &result.oauthToken, nil
+}
+
+// accessToken returns a current access token, refreshing it when needed.
+// A revoked sign-in means signing in again.
+func (l *googleLogin) accessToken(ctx context.Context) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.access != "" && time.Now().Before(l.expiry) {
+		return l.access, nil
+	}
+	if _, err := l.requestToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {l.RefreshToken},
+	}); err != nil {
+		return "", fmt.Errorf("%w, sign in again with codegent auth login google", err)
+	}
+	return l.access, nil
+}
+
+// getJSON gets a Google API with the account's credentials
+func (l *googleLogin) getJSON(ctx context.Context, url string, out any) error {
+	token, err := l.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// openBrowser opens url in the default browser, if there is one
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if cmd.Start() == nil {
+		go cmd.Wait()
+	}
+}
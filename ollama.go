@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// ollamaPrefix marks a model name as served by a local Ollama instance,
+// e.g. "ollama:qwen2.5-coder"
+const ollamaPrefix = "ollama:"
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	PromptEvalCount int32         `json:"prompt_eval_count"`
+	EvalCount       int32         `json:"eval_count"`
+}
+
+// ollamaGenerate runs one chat turn against Ollama's /api/chat, translating
+// the Gemini conversation and tools to and from Ollama's format
+func ollamaGenerate(ctx context.Context, model string, contents []*genai.Content, modelConfig *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	req := ollamaChatRequest{
+		Model:    strings.TrimPrefix(model, ollamaPrefix),
+		Messages: ollamaMessages(modelConfig.SystemInstruction, contents),
+		Options:  map[string]any{"num_predict": modelConfig.MaxOutputTokens},
+	}
+	for _, tool := range modelConfig.Tools {
+		for _, decl := range tool.FunctionDeclarations {
+			var t ollamaTool
+			t.Type = "function"
+			t.Function.Name = decl.Name
+			t.Function.Description = decl.Description
+			t.Function.Parameters = schemaToJSON(decl.Parameters)
+			req.Tools = append(req.Tools, t)
+		}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, userEnvOr("OLLAMA_HOST", "http://localhost:11434")+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(httpResp.Body)
+		return nil, genai.APIError{Code: httpResp.StatusCode, Status: httpResp.Status, Message: string(msg)}
+	}
+
+	var resp ollamaChatResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+
+	content := &genai.Content{Role: genai.RoleModel}
+	if resp.Message.Content != "" {
+		content.Parts = append(content.Parts, genai.NewPartFromText(resp.Message.Content))
+	}
+	for _, call := range resp.Message.ToolCalls {
+		content.Parts = append(content.Parts, genai.NewPartFromFunctionCall(call.Function.Name, call.Function.Arguments))
+	}
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: content}},
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{
+			PromptTokenCount:     resp.PromptEvalCount,
+			CandidatesTokenCount: resp.EvalCount,
+			TotalTokenCount:      resp.PromptEvalCount + resp.EvalCount,
+		},
+	}, nil
+}
+
+func ollamaMessages(system *genai.Content, contents []*genai.Content) []ollamaMessage {
+	messages := make([]ollamaMessage, 0, len(contents)+1)
+	if system != nil {
+		messages = append(messages, ollamaMessage{Role: "system", Content: contentText(system)})
+	}
+	for _, content := range contents {
+		msg := ollamaMessage{Role: "user"}
+		if content.Role == genai.RoleModel {
+			msg.Role = "assistant"
+		}
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionResponse != nil:
+				result, _ := json.Marshal(part.FunctionResponse.Response)
+				messages = append(messages, ollamaMessage{Role: "tool", ToolName: part.FunctionResponse.Name, Content: string(result)})
+			case part.FunctionCall != nil:
+				var call ollamaToolCall
+				call.Function.Name = part.FunctionCall.Name
+				call.Function.Arguments = part.FunctionCall.Args
+				msg.ToolCalls = append(msg.ToolCalls, call)
+			default:
+				msg.Content += part.Text
+			}
+		}
+		if msg.Content != "" || len(msg.ToolCalls) > 0 {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// contentText joins the text parts of a content
+func contentText(content *genai.Content) string {
+	var sb strings.Builder
+	for _, part := range content.Parts {
+		sb.WriteString(part.Text)
+	}
+	return sb.String()
+}
+
+// schemaToJSON converts a genai.Schema to a plain JSON schema
+func schemaToJSON(schema *genai.Schema) map[string]any {
+	if schema == nil {
+		return map[string]any{"type": "object"}
+	}
+	out := map[string]any{"type": strings.ToLower(string(schema.Type))}
+	if schema.Description != "" {
+		out["description"] = schema.Description
+	}
+	if len(schema.Properties) > 0 {
+		props := make(map[string]any, len(schema.Properties))
+		for name, prop := range schema.Properties {
+			props[name] = schemaToJSON(prop)
+		}
+		out["properties"] = props
+	}
+	if len(schema.Required) > 0 {
+		out["required"] = schema.Required
+	}
+	if schema.Items != nil {
+		out["items"] = schemaToJSON(schema.Items)
+	}
+	if len(schema.Enum) > 0 {
+		out["enum"] = schema.Enum
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// spinner shows that something is running: a spinning frame, a label and
+// the elapsed time on one line, which is cleared when it stops. Output
+// written to it, such as a command's, shows as a tail of its last line.
+// A nil spinner does nothing, see startProgress.
+type spinner struct {
+	w     io.Writer
+	label string
+	start time.Time
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	mu   sync.Mutex
+	tail string
+	line []byte
+}
+
+// progressOutput is where progress is drawn, nil unless stderr is a
+// terminal that can redraw a line, so nothing is drawn into logs or pipes.
+// Screen readers would read every redraw, so accessible mode has none.
+func progressOutput() io.Writer {
+	if colorTerminal(os.Stderr) && !accessible {
+		return os.Stderr
+	}
+	return nil
+}
+
+// startProgress starts a spinner, when the agent draws progress
+func (a *Agent) startProgress(label string) *spinner {
+	if a.progress == nil {
+		return nil
+	}
+	s := &spinner{w: a.progress, label: truncateRunes(label, 40), start: time.Now(), done: make(chan struct{})}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *spinner) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	drawn := false
+	for frame := 0; ; frame++ {
+		// Only show up for things that take a moment
+		if elapsed := time.Since(s.start); elapsed >= 300*time.Millisecond {
+			s.mu.Lock()
+			status := fmt.Sprintf("%s %s %.1fs", spinnerFrames[frame%len(spinnerFrames)], s.label, elapsed.Seconds())
+			if s.tail != "" {
+				status += "  " + s.tail
+			}
+			s.mu.Unlock()
+			fmt.Fprintf(s.w, "\r%s\u001b[K", paint(roleDim, truncateRunes(status, 80)))
+			drawn = true
+		}
+		select {
+		case <-s.done:
+			if drawn {
+				fmt.Fprint(s.w, "\r\u001b[K")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Write takes in output to show the last line of
+func (s *spinner) Write(p []byte) (int, error) {
+	if s == nil {
+		return len(p), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.line = append(s.line, p...)
+	for {
+		i := bytes.IndexAny(s.line, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(ansiEscape.ReplaceAllString(string(s.line[:i]), "")); line != "" {
+			s.tail = line
+		}
+		s.line = s.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// stop clears the spinner's line, it must be called before printing
+// anything else
+func (s *spinner) stop() {
+	if s == nil {
+		return
+	}
+	close(s.done)
+	s.wg.Wait()
+}
+
+// truncateRunes cuts s to at most n characters
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+const basePrompt = `You are codegent, a coding agent working in the user's current directory.
+Use the tools to look at files before changing them, and keep edits minimal and focused on what was asked.
+Answer concisely.`
+
+// projectInstructionFiles are read from the workspace root and appended to
+// the system prompt when present
+var projectInstructionFiles = []string{"AGENTS.md"}
+
+// systemInstruction builds the static prompt prefix sent with every request.
+// An empty root is the working directory.
+func systemInstruction(root string) *genai.Content {
+	var sb strings.Builder
+	sb.WriteString(basePrompt)
+
+	for _, name := range projectInstructionFiles {
+		content, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		sb.WriteString("\n\n## Project instructions from " + name + "\n\n")
+		sb.Write(content)
+	}
+	if languages := describeLanguages(root); languages != "" {
+		sb.WriteString("\n\n## Project languages\n\nBuild, test, format and lint the project with its own commands:\n" + languages)
+	}
+
+	return genai.NewContentFromText(sb.String(), genai.RoleUser)
+}
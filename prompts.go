@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// builtinPrompts are the prompt templates available without any setup.
+// Templates in .codegent/prompts/<name>.md of the project, then in
+// ~/.codegent/prompts/<name>.md, take precedence over these.
+var builtinPrompts = map[string]string{
+	"review-security": `Review {{file}} for security problems: injection, path traversal, unsafe deserialization, secrets in code, missing authentication or authorization checks, and unchecked errors that hide failures.
+List each finding with its line, severity and a concrete fix. Don't change any files.`,
+	"write-tests": `Write table-driven tests for {{file}} in the style of the existing tests, covering edge cases and error paths. Run them if you can and fix any that fail because of the test itself.`,
+	"explain-error": `Explain this error and find its cause in the code, then suggest a fix:
+
+{{error}}`,
+}
+
+// promptVariable matches {{name}} placeholders in prompt templates
+var promptVariable = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_-]+)\s*\}\}`)
+
+// promptDirs are searched for templates in order, the project's first
+func (a *Agent) promptDirs() []string {
+	dirs := []string{filepath.Join(a.root, ".codegent", "prompts")}
+	if dir, err := codegentDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "prompts"))
+	}
+	return dirs
+}
+
+// loadPrompt returns the named prompt template
+func (a *Agent) loadPrompt(name string) (string, error) {
+	for _, dir := range a.promptDirs() {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.Base(name)+".md"))
+		if err == nil {
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if prompt, ok := builtinPrompts[name]; ok {
+		return prompt, nil
+	}
+	return "", fmt.Errorf("unknown prompt %q (available: %s)", name, strings.Join(a.promptNames(), ", "))
+}
+
+// promptNames lists the built-in, user and project prompts
+func (a *Agent) promptNames() []string {
+	seen := make(map[string]bool)
+	for name := range builtinPrompts {
+		seen[name] = true
+	}
+	for _, dir := range a.promptDirs() {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if name, ok := strings.CutSuffix(entry.Name(), ".md"); ok && !entry.IsDir() {
+				seen[name] = true
+			}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// promptVariables lists the variables of a template in order of first use
+func promptVariables(template string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range promptVariable.FindAllStringSubmatch(template, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// expandPrompt fills in a template's variables from args, which are
+// name=value pairs or plain values taken by the variables in order. The
+// last variable takes all remaining plain values.
+func expandPrompt(template string, args []string) (string, error) {
+	names := promptVariables(template)
+	values := make(map[string]string)
+	var positional []string
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && promptVariable.MatchString("{{"+name+"}}") {
+			values[name] = value
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	var missing []string
+	for _, name := range names {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		if len(positional) == 0 {
+			missing = append(missing, name)
+			continue
+		}
+		values[name] = positional[0]
+		positional = positional[1:]
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing value for %s", strings.Join(missing, ", "))
+	}
+	if len(positional) > 0 {
+		if len(names) == 0 {
+			return "", fmt.Errorf("the prompt takes no values")
+		}
+		last := names[len(names)-1]
+		values[last] = strings.Join(append([]string{values[last]}, positional...), " ")
+	}
+
+	return promptVariable.ReplaceAllStringFunc(template, func(m string) string {
+		return values[promptVariable.FindStringSubmatch(m)[1]]
+	}), nil
+}
+
+// splitArgs splits a command line on spaces, keeping "quoted strings" and
+// name="quoted values" together
+func splitArgs(line string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes, started := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			started = true
+		case r == ' ' && !inQuotes:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Limits on what attachRelevant attaches to one message
+const (
+	maxRelevantDefs    = 6   // definitions in all
+	maxDefsPerName     = 3   // names declared more often are too ambiguous
+	maxDefinitionLines = 80  // longer definitions are cut
+	maxOutlineEntries  = 100 // declarations listed for a mentioned file
+)
+
+var (
+	// codeWord is a word in a message that may name an identifier, such as
+	// runTool or Agent.Run, or a file, such as cmd/main.go
+	codeWord = regexp.MustCompile(`[A-Za-z_][\w./-]*\w`)
+	// quotedCode is a word in backticks, which names code however it looks
+	quotedCode = regexp.MustCompile("`([^`\\s]+)`")
+)
+
+// attachRelevant looks up the identifiers and files a message mentions
+// in the symbol index and attaches their definitions, and the declarations
+// in the files, so the model needn't search for them first. Plain words
+// aren't looked up unless quoted in backticks: identifiers must look like
+// code, with inner capitals, underscores or a receiver, as in Agent.Run.
+func (a *Agent) attachRelevant(ctx context.Context, message string) {
+	if !a.config.AutoContext {
+		return
+	}
+	names, files := codeMentions(message)
+	if len(names) == 0 && len(files) == 0 {
+		return
+	}
+
+	var attached []string
+	a.inWorkspace(func() {
+		index, err := buildSymbolIndex(ctx, ".")
+		if err != nil {
+			return
+		}
+		defs := 0
+		for _, name := range names {
+			recv, name := splitReceiver(name)
+			found, _ := index.lookup(name)
+			found = slices.DeleteFunc(found, func(def symbol) bool { return recv != "" && def.Recv != recv })
+			if len(found) == 0 || len(found) > maxDefsPerName {
+				continue
+			}
+			for _, def := range found {
+				if defs == maxRelevantDefs {
+					break
+				}
+				attachment, ok := definitionSource(def)
+				if !ok {
+					continue
+				}
+				a.attachments = append(a.attachments, attachment)
+				attached = append(attached, fmt.Sprintf("%s (%s:%d)", def.Name, def.File, def.Line))
+				defs++
+			}
+		}
+		for _, file := range files {
+			if attachment, ok := index.outline(file); ok {
+				a.attachments = append(a.attachments, attachment)
+				attached = append(attached, file)
+			}
+		}
+	})
+	if len(attached) > 0 {
+		fmt.Fprintln(a.out, paint(roleDim, tr("Attached %s", strings.Join(attached, ", "))))
+	}
+}
+
+// codeMentions picks the words of a message that may name identifiers and
+// indexed source files, leaving out @path mentions, which attachMentions
+// attaches whole
+func codeMentions(message string) (names, files []string) {
+	quoted := make(map[string]bool)
+	for _, match := range quotedCode.FindAllStringSubmatch(message, -1) {
+		quoted[strings.TrimSuffix(match[1], "()")] = true
+	}
+	for _, match := range codeWord.FindAllStringIndex(message, -1) {
+		word := message[match[0]:match[1]]
+		if match[0] > 0 && message[match[0]-1] == '@' {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(word, ".go") || indexedSource(word):
+			if file := filepath.Clean(word); !slices.Contains(files, file) {
+				files = append(files, file)
+			}
+		case quoted[word] || looksLikeIdentifier(word):
+			if !slices.Contains(names, word) {
+				names = append(names, word)
+			}
+		}
+	}
+	return names, files
+}
+
+// looksLikeIdentifier tells camelCase, snake_case and Recv.Name words from
+// English ones
+func looksLikeIdentifier(word string) bool {
+	if strings.ContainsAny(word, "/-") {
+		return false
+	}
+	if strings.Contains(word, ".") {
+		recv, name := splitReceiver(word)
+		return recv != "" && !strings.Contains(recv, ".") && name != ""
+	}
+	if strings.Contains(strings.Trim(word, "_"), "_") {
+		return true
+	}
+	for i, r := range word {
+		if i > 0 && unicode.IsUpper(r) && strings.ContainsFunc(word, unicode.IsLower) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitReceiver splits Agent.Run into the receiver and the name
+func splitReceiver(word string) (recv, name string) {
+	if i := strings.LastIndex(word, "."); i >= 0 {
+		return word[:i], word[i+1:]
+	}
+	return "", word
+}
+
+// definitionSource attaches the source of a declaration, see attachedFile
+func definitionSource(def symbol) (string, bool) {
+	content, err := os.ReadFile(def.File)
+	if err != nil {
+		return "", false
+	}
+	lines := strings.Split(string(content), "\n")
+	end := min(def.EndLine, len(lines), def.Line+maxDefinitionLines-1)
+	if def.Line < 1 || end < def.Line {
+		return "", false
+	}
+	source := strings.Join(lines[def.Line-1:end], "\n")
+	ext := strings.ToLower(filepath.Ext(def.File))
+	if end < def.EndLine {
+		source += fmt.Sprintf("\n%s ... %d more lines, read_file has them", cmp.Or(lineComments[ext], "//"), def.EndLine-end)
+	}
+	fileVersions.record(def.File, content)
+	// Markdown knows the languages by their extensions: go, ts, py, rs
+	return fmt.Sprintf("Definition of %s from %s, lines %d-%d:\n```%s\n%s\n```", def.Name, def.File, def.Line, end, strings.TrimPrefix(ext, "."), source), true
+}
+
+// outline lists the declarations in a file, when the index has it
+func (s *symbolIndex) outline(file string) (string, bool) {
+	var lines []string
+	for _, def := range s.defs {
+		if def.File != file {
+			continue
+		}
+		if len(lines) == maxOutlineEntries {
+			lines = append(lines, "...")
+			break
+		}
+		name := def.Name
+		if def.Recv != "" {
+			name = def.Recv + "." + name
+		}
+		lines = append(lines, fmt.Sprintf("%s %s, lines %d-%d", def.Kind, name, def.Line, def.EndLine))
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("Declarations in %s:\n```\n%s\n```", file, strings.Join(lines, "\n")), true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Task is the kind of work a model turn does, used to route it to a model
+type Task string
+
+const (
+	TaskPlan   Task = "plan"   // first answer to a user request
+	TaskEdit   Task = "edit"   // turns continuing a chain of tool calls
+	TaskReview Task = "review" // final answer once files were changed
+)
+
+var tasks = []Task{TaskPlan, TaskEdit, TaskReview}
+
+// modelFor returns the model routed for task, or the main model
+func (c *Config) modelFor(task Task) string {
+	if model, ok := c.Routes[task]; ok {
+		return model
+	}
+	return c.Model
+}
+
+// parseRoutes parses "plan=gemini-2.5-pro,edit=gemini-2.0-flash"
+func parseRoutes(s string) (map[Task]string, error) {
+	routes := map[Task]string{}
+	for _, item := range splitList(s) {
+		name, model, ok := strings.Cut(item, "=")
+		if !ok || model == "" {
+			return nil, fmt.Errorf("invalid route %q, want task=model", item)
+		}
+		task, err := parseTask(name)
+		if err != nil {
+			return nil, err
+		}
+		routes[task] = model
+	}
+	return routes, nil
+}
+
+func parseTask(s string) (Task, error) {
+	for _, task := range tasks {
+		if string(task) == s {
+			return task, nil
+		}
+	}
+	return "", fmt.Errorf("unknown task %q (want one of plan, edit, review)", s)
+}
+
+func hasFunctionCalls(resp *genai.GenerateContentResponse) bool {
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if part.FunctionCall != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// maxSelfReviews is how many times a request's changes are reviewed, a
+// review that leads to more edits is followed by another
+const maxSelfReviews = 2
+
+// selfReview asks the model to review its changes before it finishes: the
+// diff since the request began and the result of --review-check are sent
+// with the request, and the model fixes what doesn't match. It returns the
+// model's response, or nil when nothing changed after all.
+func (a *Agent) selfReview(ctx context.Context, modelConfig *genai.GenerateContentConfig, request string) (*genai.GenerateContentResponse, error) {
+	var diff strings.Builder
+	a.inWorkspace(func() {
+		for _, change := range a.fileTracker().changes() {
+			current, _ := os.ReadFile(change.path)
+			diff.WriteString(unifiedDiff(change.path, string(change.content), string(current)))
+		}
+	})
+	if diff.Len() == 0 {
+		return nil, nil
+	}
+	fmt.Fprintln(a.out, paint(roleNotice, tr("Reviewing the changes")))
+
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Before finishing, review your changes against the request:\n\n%s\n\nYour changes:\n\n```diff\n%s```\n\n", request, truncateOutput(diff.String(), a.config.MaxToolOutputTokens))
+	if check := a.config.ReviewCheck; check != "" && a.approveCheck(check) {
+		fmt.Fprintf(a.out, "%s: %s\n", paint(roleTool, "check"), check)
+		progress := a.startProgress("check")
+		var output string
+		var err error
+		a.inWorkspace(func() { output, err = runCheck(ctx, check, progress) })
+		progress.stop()
+		if err != nil {
+			fmt.Fprintf(a.out, "check failed: %v\n", err)
+			fmt.Fprintf(&prompt, "The check `%s` failed:\n\n%s\n\n", check, truncateOutput(output, 2000))
+		} else {
+			fmt.Fprintln(a.out, "check passed")
+			fmt.Fprintf(&prompt, "The check `%s` passed.\n\n", check)
+		}
+	}
+	prompt.WriteString("Look for anything asked for that is missing, changes that weren't asked for, mistakes, and leftover debugging code, and find out why the check failed if it did. Fix what is wrong with the tools, then give your final answer. If nothing is wrong, give the final answer without calling tools.")
+
+	return a.runInference(ctx, modelConfig, TaskReview, genai.NewPartFromText(prompt.String()))
+}
+
+// approveCheck asks the user whether the review check may run, since it
+// runs the repository's code like any command. The answer holds for the
+// rest of the session; yolo mode doesn't ask, and plan mode doesn't run it.
+func (a *Agent) approveCheck(check string) bool {
+	switch a.config.Approvals.decide(ToolExecute) {
+	case approvalAllow:
+		return true
+	case approvalDeny:
+		return false
+	}
+	if approved, ok := a.checkApprovals[check]; ok {
+		return approved
+	}
+	approved := a.confirm(fmt.Sprintf("%s: %s", paint(roleNotice, tr("approve")), tr("The self-review runs the check:\n  %s\nRun it?", check)))
+	if a.checkApprovals == nil {
+		a.checkApprovals = make(map[string]bool)
+	}
+	a.checkApprovals[check] = approved
+	return approved
+}
+
+// lastRequest returns the user's last message in history, leaving out the
+// files attached to it, see runRequest. Tool results don't count.
+func lastRequest(history []*genai.Content) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		content := history[i]
+		if content.Role != genai.RoleUser || len(content.Parts) == 0 {
+			continue
+		}
+		last := content.Parts[len(content.Parts)-1]
+		if last.FunctionResponse == nil && last.InlineData == nil && last.Text != "" {
+			return last.Text
+		}
+	}
+	return ""
+}
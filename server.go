@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"google.golang.org/genai"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// maxBacklog is how many recent events a new subscriber is replayed
+const maxBacklog = 1000
+
+var errBusy = errors.New("still working on the previous message")
+
+// serverEvent is sent to clients as it happens
+type serverEvent struct {
+	Type string `json:"type"` // output, question, busy or done
+	Text string `json:"text,omitempty"`
+	// Questions are answered with their ID
+	ID int `json:"id,omitempty"`
+}
+
+// eventHub fans events out to every subscriber, replaying recent events to
+// new ones so a reloaded page shows the conversation so far. As an
+// io.Writer it publishes agent output.
+type eventHub struct {
+	mu        sync.Mutex
+	subs      map[chan serverEvent]bool
+	backlog   []serverEvent
+	lastWrite string
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan serverEvent]bool)}
+}
+
+func (h *eventHub) publish(ev serverEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backlog = append(h.backlog, ev)
+	if len(h.backlog) > maxBacklog {
+		h.backlog = h.backlog[len(h.backlog)-maxBacklog:]
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			// Too slow to keep up, it reconnects and gets the backlog
+			close(ch)
+			delete(h.subs, ch)
+		}
+	}
+}
+
+func (h *eventHub) subscribe() (chan serverEvent, []serverEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan serverEvent, 256)
+	h.subs[ch] = true
+	return ch, append([]serverEvent(nil), h.backlog...)
+}
+
+func (h *eventHub) unsubscribe(ch chan serverEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[ch] {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	h.lastWrite = string(p)
+	h.mu.Unlock()
+	h.publish(serverEvent{Type: "output", Text: string(p)})
+	return len(p), nil
+}
+
+// webSession is an agent driven over HTTP. One message is handled at a
+// time; questions such as approvals are answered through answers.
+type webSession struct {
+	agent       *Agent
+	modelConfig *genai.GenerateContentConfig
+	events      *eventHub
+	answers     chan string
+
+	mu   sync.Mutex
+	busy bool
+	// The ID of the question waiting for an answer, 0 when there is none
+	question  int
+	questions int
+}
+
+// newWebSession starts an agent working in root. Giving it a root keeps its
+// tool calls from running while a gRPC session has switched directories.
+func newWebSession(ctx context.Context, client *genai.Client, session *Session, config *Config, root string) *webSession {
+	s := &webSession{events: newEventHub(), answers: make(chan string, 1)}
+	s.agent = NewAgent(client, s.answer, defaultTools(), session, config)
+	s.agent.setRoot(root)
+	s.agent.out = s.events
+	s.agent.toolOutput = s.events
+	s.agent.history = session.Contents()
+	s.modelConfig = s.agent.newModelConfig(ctx)
+	return s
+}
+
+// answer is the agent's getUserMessage, waiting for an answer from a client
+func (s *webSession) answer() (string, bool) {
+	s.events.mu.Lock()
+	question := s.events.lastWrite
+	s.events.mu.Unlock()
+	s.mu.Lock()
+	s.questions++
+	id := s.questions
+	s.question = id
+	s.mu.Unlock()
+	s.events.publish(serverEvent{Type: "question", Text: question, ID: id})
+	answer, ok := <-s.answers
+	return answer, ok
+}
+
+// answerQuestion passes a client's answer on when question id is the one
+// waiting, so a late or repeated answer can't approve a later question
+func (s *webSession) answerQuestion(id int, text string) bool {
+	s.mu.Lock()
+	waiting := id != 0 && s.question == id
+	if waiting {
+		s.question = 0
+	}
+	s.mu.Unlock()
+	if waiting {
+		s.answers <- text
+	}
+	return waiting
+}
+
+// send starts handling a message in the background
+func (s *webSession) send(ctx context.Context, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy {
+		return errBusy
+	}
+	s.busy = true
+
+	go func() {
+		s.events.publish(serverEvent{Type: "busy"})
+		fmt.Fprintf(s.events, "%s: %s\n", paint(roleUser, tr("You")), text)
+		if _, err := s.agent.handleRequest(ctx, s.modelConfig, text); err != nil {
+			fmt.Fprintln(s.events, "ERROR:", err)
+		}
+		s.mu.Lock()
+		s.busy = false
+		s.mu.Unlock()
+		s.events.publish(serverEvent{Type: "done"})
+	}()
+	return nil
+}
+
+// serveEvents streams the session's events as Server-Sent Events
+func (s *webSession) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch, backlog := s.events.subscribe()
+	defer s.events.unsubscribe(ch)
+	write := func(ev serverEvent) {
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	for _, ev := range backlog {
+		write(ev)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			write(ev)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// decodeJSON reads a JSON request body. Requiring the JSON content type
+// also keeps other sites from posting to the API with plain forms.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// handler serves the session's HTTP API below /api/
+func (s *webSession) handler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/events", s.serveEvents)
+	mux.HandleFunc("POST /api/message", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		if !decodeJSON(w, r, &body) {
+			return
+		}
+		if err := s.send(ctx, body.Text); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("POST /api/answer", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ID   int    `json:"id"`
+			Text string `json:"text"`
+		}
+		if !decodeJSON(w, r, &body) {
+			return
+		}
+		if !s.answerQuestion(body.ID, body.Text) {
+			http.Error(w, fmt.Sprintf("question %d isn't waiting for an answer", body.ID), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	return mux
+}
+
+// checkHost refuses requests for other hosts than the server's address,
+// localhost or an IP address, and requests from pages of other origins.
+// Another site can point a name of its own at 127.0.0.1, which the browser
+// then treats as that site.
+func checkHost(addr string, next http.Handler) http.Handler {
+	listenHost, _, _ := net.SplitHostPort(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if host != "localhost" && host != listenHost && net.ParseIP(host) == nil {
+			http.Error(w, "unknown host "+r.Host, http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request from "+origin, http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/events" {
+			log.Println(r.Method, r.URL.Path)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
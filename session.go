@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// Session is a saved conversation stored under ~/.codegent/sessions
+type Session struct {
+	ID        string           `json:"id"`
+	Title     string           `json:"title,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	History   []SessionMessage `json:"history"`
+
+	// Saved while a request runs, for --resume to continue it, see autosave
+	Pending *PendingTurn `json:"pending,omitempty"`
+	Files   *savedFiles  `json:"files,omitempty"`
+
+	// Files whose current contents go with every message, see /pin
+	Pinned []string `json:"pinned,omitempty"`
+
+	// What is left to do, written when the budget ran out, see wrapUp
+	Continuation string `json:"continuation,omitempty"`
+}
+
+// PendingTurn is the part of a request that isn't in the history yet
+type PendingTurn struct {
+	// The user's message and attachments, until the model first replies
+	Message     string   `json:"message,omitempty"`
+	Attachments []string `json:"attachments,omitempty"`
+	// Results of the tool calls that have run, in order, of those the
+	// model asked for in the last message of the history
+	Results []*genai.FunctionResponse `json:"results,omitempty"`
+}
+
+// SessionMessage is the on-disk form of a genai.Content
+type SessionMessage struct {
+	Role  string        `json:"role"`
+	Parts []SessionPart `json:"parts"`
+}
+
+// SessionPart holds exactly one of the supported genai.Part kinds
+type SessionPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *genai.FunctionCall     `json:"function_call,omitempty"`
+	FunctionResponse *genai.FunctionResponse `json:"function_response,omitempty"`
+	ThoughtSignature []byte                  `json:"thought_signature,omitempty"`
+}
+
+func NewSession() *Session {
+	now := time.Now()
+	// The random suffix keeps sessions started in the same second apart,
+	// as happens when a server runs several at once
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+	return &Session{
+		ID:        now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Save writes the session to the store
+func (s *Session) Save() error {
+	st, err := store()
+	if err != nil {
+		return err
+	}
+	s.UpdatedAt = time.Now()
+	return st.SaveSession(s)
+}
+
+// SetHistory replaces the stored history with the chat session's history
+func (s *Session) SetHistory(history []*genai.Content) {
+	messages := make([]SessionMessage, 0, len(history))
+	for _, content := range history {
+		msg := SessionMessage{Role: content.Role}
+		for _, part := range content.Parts {
+			// Inline files are too large to keep, note what was attached
+			if part.InlineData != nil {
+				msg.Parts = append(msg.Parts, SessionPart{Text: fmt.Sprintf("[attached %s, %d bytes]", part.InlineData.MIMEType, len(part.InlineData.Data))})
+				continue
+			}
+			msg.Parts = append(msg.Parts, SessionPart{
+				Text:             part.Text,
+				FunctionCall:     part.FunctionCall,
+				FunctionResponse: part.FunctionResponse,
+				ThoughtSignature: part.ThoughtSignature,
+			})
+		}
+		messages = append(messages, msg)
+	}
+	s.History = messages
+}
+
+// Contents converts the stored history back into genai contents
+func (s *Session) Contents() []*genai.Content {
+	contents := make([]*genai.Content, 0, len(s.History))
+	for _, msg := range s.History {
+		content := &genai.Content{Role: msg.Role}
+		for _, part := range msg.Parts {
+			content.Parts = append(content.Parts, &genai.Part{
+				Text:             part.Text,
+				FunctionCall:     part.FunctionCall,
+				FunctionResponse: part.FunctionResponse,
+				ThoughtSignature: part.ThoughtSignature,
+			})
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+// DisplayName returns the title if set, otherwise the ID
+func (s *Session) DisplayName() string {
+	if s.Title != "" {
+		return s.Title
+	}
+	return s.ID
+}
+
+// ListSessions returns all saved sessions, most recently updated first
+func ListSessions() ([]*Session, error) {
+	st, err := store()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := st.ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// FindSession looks a session up by ID or by title, or "last" for the most
+// recently updated one
+func FindSession(ref string) (*Session, error) {
+	st, err := store()
+	if err != nil {
+		return nil, err
+	}
+	if ref == "last" {
+		sessions, err := ListSessions()
+		if err != nil {
+			return nil, err
+		}
+		if len(sessions) == 0 {
+			return nil, errors.New("no saved sessions")
+		}
+		return sessions[0], nil
+	}
+	if filepath.Base(ref) == ref {
+		if s, err := st.LoadSession(ref); err == nil {
+			return s, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Session
+	for _, s := range sessions {
+		if strings.EqualFold(s.Title, ref) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("session %q not found", ref)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("title %q matches %d sessions, use the session ID instead", ref, len(matches))
+	}
+}
+
+// DeleteSession removes the session file for the given ID or title, and
+// its scratch files
+func DeleteSession(ref string) (*Session, error) {
+	s, err := FindSession(ref)
+	if err != nil {
+		return nil, err
+	}
+	st, err := store()
+	if err != nil {
+		return nil, err
+	}
+	if err := st.DeleteSession(s.ID); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if dir, err := sessionScratchDir(s.ID); err == nil {
+		os.RemoveAll(dir)
+	}
+	return s, nil
+}
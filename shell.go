@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// shellEscape runs a "!command" line typed at the prompt, showing its output
+// as it comes, and offers to send the output with the next message
+func (a *Agent) shellEscape(command string) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		fmt.Fprintln(a.out, tr("usage: !<shell command>"))
+		return
+	}
+
+	var output bytes.Buffer
+	cmd := shellCommand(context.Background(), command)
+	cmd.Dir = a.root
+	cmd.Stdout = io.MultiWriter(a.out, &output)
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	status := ""
+	if err != nil {
+		status = fmt.Sprintf(" (%v)", err)
+		fmt.Fprintln(a.out, paint(roleNotice, err.Error()))
+	}
+
+	if !a.confirm(tr("Send the output with your next message?")) {
+		return
+	}
+	a.attachments = append(a.attachments, fmt.Sprintf("I ran `%s`%s:\n```\n%s\n```", command, status, truncateOutput(strings.TrimRight(output.String(), "\n"), a.config.MaxToolOutputTokens)))
+}
+
+// commandShell is the shell commands run in: sh, or cmd on Windows.
+// CODEGENT_SHELL picks another, such as bash, powershell or pwsh, but not
+// from a project's .env: every approved command would run its program.
+func commandShell() string {
+	if shell := userEnvOr("CODEGENT_SHELL", ""); shell != "" {
+		return shell
+	}
+	if runtime.GOOS == "windows" {
+		return "cmd"
+	}
+	return "sh"
+}
+
+// shellCommand prepares command to run in the shell, see commandShell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	shell := commandShell()
+	name := strings.ToLower(filepath.Base(shell))
+	switch strings.TrimSuffix(name, ".exe") {
+	case "cmd":
+		cmd := exec.CommandContext(ctx, shell, "/C", command)
+		rawCmdLine(cmd, command)
+		return cmd
+	case "powershell", "pwsh":
+		return exec.CommandContext(ctx, shell, "-NoProfile", "-NonInteractive", "-Command", command)
+	default:
+		return exec.CommandContext(ctx, shell, "-c", command)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "os/exec"
+
+// rawCmdLine is needed on Windows only, where cmd.exe parses its command
+// line itself
+func rawCmdLine(cmd *exec.Cmd, command string) {}
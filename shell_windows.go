@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// rawCmdLine hands command to cmd.exe as it is. Go quotes each argument the
+// way C programs parse them, which cmd doesn't, so commands with quotes in
+// them would break; /S makes cmd strip just the outer quotes.
+func rawCmdLine(cmd *exec.Cmd, command string) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: syscall.EscapeArg(cmd.Args[0]) + ` /S /C "` + command + `"`}
+}
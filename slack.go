@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// slackAPI calls a Slack Web API method with a JSON body and decodes the
+// response into out
+func slackAPI(ctx context.Context, token, method string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, userEnvOr("SLACK_API_URL", "https://slack.com/api/")+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("slack %s: %w", method, err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return err
+	}
+	if !status.OK {
+		return fmt.Errorf("slack %s: %s", method, status.Error)
+	}
+	if out != nil {
+		return json.Unmarshal(raw, out)
+	}
+	return nil
+}
+
+// slackPost posts text as a reply in a thread
+func slackPost(ctx context.Context, token, channel, threadTS, text string) error {
+	return slackAPI(ctx, token, "chat.postMessage", map[string]string{
+		"channel":   channel,
+		"thread_ts": threadTS,
+		"text":      text,
+	}, nil)
+}
+
+// slackEnvelope is a Socket Mode message
+type slackEnvelope struct {
+	EnvelopeID string `json:"envelope_id"`
+	Type       string `json:"type"`
+	Payload    struct {
+		Event slackEvent `json:"event"`
+	} `json:"payload"`
+}
+
+// slackEvent is the subset of message and app_mention events the bot uses
+type slackEvent struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	BotID       string `json:"bot_id"`
+	Text        string `json:"text"`
+	Channel     string `json:"channel"`
+	ChannelType string `json:"channel_type"`
+	TS          string `json:"ts"`
+	ThreadTS    string `json:"thread_ts"`
+}
+
+// slackSocket receives events over Socket Mode until ctx is done or Slack
+// asks to reconnect, acknowledging each envelope and passing its event to
+// handle
+func slackSocket(ctx context.Context, appToken string, handle func(slackEvent)) error {
+	var conn struct {
+		URL string `json:"url"`
+	}
+	if err := slackAPI(ctx, appToken, "apps.connections.open", struct{}{}, &conn); err != nil {
+		return err
+	}
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, conn.URL, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	// Closing the socket ends ReadJSON; stopped on return so reconnects
+	// don't leave one waiting per old connection
+	stop := context.AfterFunc(ctx, func() { ws.Close() })
+	defer stop()
+
+	for {
+		var envelope slackEnvelope
+		if err := ws.ReadJSON(&envelope); err != nil {
+			return err
+		}
+		if envelope.EnvelopeID != "" {
+			if err := ws.WriteJSON(map[string]string{"envelope_id": envelope.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+		switch envelope.Type {
+		case "events_api":
+			handle(envelope.Payload.Event)
+		case "disconnect":
+			return nil
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SlashCommand is a REPL command entered as "/name args"
+type SlashCommand struct {
+	Name  string
+	Usage string
+	// In English, /help translates it
+	Description string
+	Run         func(a *Agent, args string) error
+}
+
+// slashCommands is filled in init since /help needs to refer to it
+var slashCommands []SlashCommand
+
+func init() {
+	slashCommands = []SlashCommand{
+		{
+			Name:        "title",
+			Usage:       "/title <name>",
+			Description: "Name the current session",
+			Run: func(a *Agent, args string) error {
+				if args == "" {
+					fmt.Fprintln(a.out, tr("Session: %s", a.session.DisplayName()))
+					return nil
+				}
+				a.session.Title = args
+				if err := a.session.Save(); err != nil {
+					return err
+				}
+				fmt.Fprintln(a.out, tr("Session renamed to %q", args))
+				return nil
+			},
+		},
+		{
+			Name:        "resume",
+			Usage:       "/resume <id|title>",
+			Description: "Switch to a saved session and continue it",
+			Run: func(a *Agent, args string) error {
+				if args == "" {
+					return errors.New(tr("usage: /resume <id|title>"))
+				}
+				s, err := FindSession(args)
+				if err != nil {
+					return err
+				}
+				a.resumeSession(s)
+				fmt.Fprintln(a.out, tr("Resumed %s (%d messages)", s.DisplayName(), len(s.History)))
+				return nil
+			},
+		},
+		{
+			Name:        "approvals",
+			Usage:       "/approvals [mode]",
+			Description: "Show or switch the approval mode (plan, default, auto-edit, yolo)",
+			Run: func(a *Agent, args string) error {
+				if args == "" {
+					fmt.Fprintln(a.out, tr("Approval mode: %s", a.config.Approvals))
+					return nil
+				}
+				mode, err := ParseApprovalMode(args)
+				if err != nil {
+					return err
+				}
+				a.config.Approvals = mode
+				fmt.Fprintln(a.out, tr("Approval mode set to %s", mode))
+				return nil
+			},
+		},
+		{
+			Name:        "search",
+			Usage:       "/search <words>",
+			Description: "Search saved sessions for messages containing all the words",
+			Run: func(a *Agent, args string) error {
+				terms := searchTerms(args)
+				hits, err := searchHistory(terms, 20)
+				if err != nil {
+					return err
+				}
+				a.searchHits = hits
+				if len(hits) == 0 {
+					fmt.Fprintln(a.out, tr("No matches"))
+					return nil
+				}
+				printHits(a.out, hits, terms)
+				fmt.Fprintln(a.out, tr("Add one to your next message with /quote <n>"))
+				return nil
+			},
+		},
+		{
+			Name:        "quote",
+			Usage:       "/quote <n>",
+			Description: "Include exchange n of the last /search in your next message",
+			Run: func(a *Agent, args string) error {
+				n, err := strconv.Atoi(args)
+				if err != nil || n < 1 || n > len(a.searchHits) {
+					return errors.New(tr("usage: /quote <n>, where n is a result of the last /search"))
+				}
+				a.attachments = append(a.attachments, a.searchHits[n-1].quote())
+				fmt.Fprintln(a.out, tr("Quoted result %d, it will be sent with your next message", n))
+				return nil
+			},
+		},
+		{
+			Name:        "prompt",
+			Usage:       "/prompt <name> [values]",
+			Description: "Send a prompt template, filling its {{variables}} in order or as name=value",
+			Run: func(a *Agent, args string) error {
+				if args == "" {
+					for _, name := range a.promptNames() {
+						template, err := a.loadPrompt(name)
+						if err != nil {
+							return err
+						}
+						fmt.Fprintf(a.out, "  %-20s %s\n", name, strings.Join(promptVariables(template), " "))
+					}
+					return nil
+				}
+				fields := splitArgs(args)
+				template, err := a.loadPrompt(fields[0])
+				if err != nil {
+					return err
+				}
+				prompt, err := expandPrompt(template, fields[1:])
+				if err != nil {
+					return fmt.Errorf("/prompt %s: %w", fields[0], err)
+				}
+				fmt.Fprintln(a.out, paint(roleDim, prompt))
+				a.queued = prompt
+				return nil
+			},
+		},
+		{
+			Name:        "copy",
+			Usage:       "/copy [code [n]]",
+			Description: "Copy the last response, or its last or nth code block, to the clipboard",
+			Run: func(a *Agent, args string) error {
+				text := a.lastResponse()
+				if text == "" {
+					return errors.New(tr("no response to copy yet"))
+				}
+				what := tr("the last response")
+				if fields := strings.Fields(args); len(fields) > 0 {
+					if fields[0] != "code" || len(fields) > 2 {
+						return errors.New(tr("usage: /copy [code [n]]"))
+					}
+					blocks := codeBlocks(text)
+					if len(blocks) == 0 {
+						return errors.New(tr("the last response has no code blocks"))
+					}
+					n := len(blocks)
+					if len(fields) == 2 {
+						var err error
+						if n, err = strconv.Atoi(fields[1]); err != nil || n < 1 || n > len(blocks) {
+							return errors.New(tr("the last response has %d code blocks", len(blocks)))
+						}
+					}
+					text = blocks[n-1]
+					what = tr("code block %d of %d", n, len(blocks))
+				}
+				if err := copyToClipboard(context.Background(), text); err != nil {
+					return err
+				}
+				fmt.Fprintln(a.out, tr("Copied %s to the clipboard", what))
+				return nil
+			},
+		},
+		{
+			Name:        "continue",
+			Usage:       "/continue [id|title]",
+			Description: "Pick up the plan a session left when its budget ran out",
+			Run: func(a *Agent, args string) error {
+				return a.continueSession(args)
+			},
+		},
+		{
+			Name:        "context",
+			Usage:       "/context",
+			Description: "Show which files the model can see",
+			Run: func(a *Agent, args string) error {
+				a.printContext()
+				return nil
+			},
+		},
+		{
+			Name:        "pin",
+			Usage:       "/pin [path]",
+			Description: "Send a file's current contents with every message",
+			Run: func(a *Agent, args string) error {
+				if args == "" {
+					for _, path := range a.session.Pinned {
+						fmt.Fprintln(a.out, "  "+path)
+					}
+					return nil
+				}
+				return a.pin(args)
+			},
+		},
+		{
+			Name:        "drop",
+			Usage:       "/drop <path>",
+			Description: "Unpin a file and drop its contents from the conversation",
+			Run: func(a *Agent, args string) error {
+				if args == "" {
+					return errors.New(tr("usage: /drop <path>"))
+				}
+				return a.drop(args)
+			},
+		},
+		{
+			Name:        "done-when",
+			Usage:       "/done-when [criterion]",
+			Description: "Attach a success criterion to the next request",
+			Run: func(a *Agent, args string) error {
+				switch args {
+				case "":
+					for _, c := range a.criteria {
+						fmt.Fprintln(a.out, "  "+c.Spec)
+					}
+					return nil
+				case "clear":
+					a.criteria = nil
+					fmt.Fprintln(a.out, tr("Success criteria cleared"))
+					return nil
+				}
+				return a.addCriterion(context.Background(), args)
+			},
+		},
+		{
+			Name:        "help",
+			Usage:       "/help",
+			Description: "List REPL commands",
+			Run: func(a *Agent, args string) error {
+				for _, cmd := range slashCommands {
+					fmt.Fprintf(a.out, "  %-20s %s\n", cmd.Usage, tr(cmd.Description))
+				}
+				a.printCustomCommands(a.out)
+				return nil
+			},
+		},
+	}
+}
+
+// handleSlashCommand runs a "/command args" line typed at the prompt
+func (a *Agent) handleSlashCommand(line string) error {
+	name, args, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+	for _, cmd := range slashCommands {
+		if cmd.Name == name {
+			return cmd.Run(a, strings.TrimSpace(args))
+		}
+	}
+
+	// Then the ones defined in commands.json
+	custom, err := a.loadCustomCommands()
+	if err != nil {
+		return err
+	}
+	if cmd, ok := custom[name]; ok {
+		return a.runCustomCommand(name, cmd, strings.TrimSpace(args))
+	}
+	return errors.New(tr("unknown command /%s (try /help)", name))
+}
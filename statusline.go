@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// contextWindow is a model's input token limit
+type contextWindow struct {
+	prefix string
+	tokens int
+}
+
+// contextWindows are matched by model name prefix like modelPrices
+var contextWindows = []contextWindow{
+	{"gemini-2.5-", 1_048_576},
+	{"gemini-2.0-", 1_048_576},
+	{"gemini-1.5-pro", 2_097_152},
+	{"gemini-1.5-flash", 1_048_576},
+}
+
+// contextLimit returns the model's context window, 0 when it isn't known
+func contextLimit(model string) int {
+	if i := strings.LastIndex(model, "models/"); i >= 0 {
+		model = model[i+len("models/"):]
+	}
+	for _, window := range contextWindows {
+		if strings.HasPrefix(model, window.prefix) {
+			return window.tokens
+		}
+	}
+	return 0
+}
+
+// sessionStatus is what the status line shows, kept up to date as the
+// model is called and tool calls wait for approval
+type sessionStatus struct {
+	model         string
+	contextTokens int
+	cost          float64
+	unpriced      bool
+	approvals     int
+}
+
+// observe takes in a model call
+func (s *sessionStatus) observe(rec usageRecord, usage *genai.GenerateContentResponseUsageMetadata) {
+	s.model = rec.Model
+	// The next turn sends all of this again
+	s.contextTokens = int(usage.PromptTokenCount + usage.CandidatesTokenCount)
+	if cost, ok := estimateCost(rec); ok {
+		s.cost += cost
+	} else {
+		s.unpriced = true
+	}
+}
+
+// contextWarning is the share of the context window, in percent, from
+// which the status line warns
+const contextWarning = 80
+
+// printStatus prints the status line, when the agent has one. The context
+// is shown as a warning once it is contextWarning percent full.
+func (a *Agent) printStatus() {
+	if a.status == nil {
+		return
+	}
+	if accessible {
+		a.announceStatus()
+		return
+	}
+	s := a.status
+	model := s.currentModel(a.config)
+	fields := []string{paint(roleDim, model)}
+
+	context := tr("context %s", formatTokens(s.contextTokens))
+	role := roleDim
+	if limit := contextLimit(model); limit > 0 {
+		percent := s.contextTokens * 100 / limit
+		context = tr("context %d%% (%s/%s)", percent, formatTokens(s.contextTokens), formatTokens(limit))
+		if percent >= contextWarning {
+			role = roleWarn
+		}
+	}
+	fields = append(fields, paint(role, context))
+
+	cost := formatCost(s.cost)
+	if s.unpriced {
+		// Some calls went to models without a known price
+		cost += "+"
+	}
+	fields = append(fields, paint(roleDim, cost))
+	if a.config.MaxSessionTokens > 0 {
+		fields = append(fields, paint(roleDim, tr("budget %s/%s", formatTokens(a.tokensUsed), formatTokens(a.config.MaxSessionTokens))))
+	}
+	if a.config.MaxSessionCost > 0 {
+		fields = append(fields, paint(roleDim, tr("budget %s/%s", formatCost(a.costUsed), formatCost(a.config.MaxSessionCost))))
+	}
+	if s.approvals > 0 {
+		fields = append(fields, paint(roleNotice, tr("%d awaiting approval", s.approvals)))
+	}
+	fmt.Fprintln(a.out, strings.Join(fields, paint(roleDim, " · ")))
+}
+
+// announceStatus is the status line for screen readers, which only speaks
+// up when something needs attention
+func (a *Agent) announceStatus() {
+	s := a.status
+	if limit := contextLimit(s.currentModel(a.config)); limit > 0 && s.contextTokens*100/limit >= contextWarning {
+		fmt.Fprintln(a.out, tr("Warning: the conversation fills %d%% of the context window, %s of %s tokens.", s.contextTokens*100/limit, formatTokens(s.contextTokens), formatTokens(limit)))
+	}
+	if s.approvals > 1 {
+		fmt.Fprintln(a.out, tr("%d tool calls are waiting for approval, starting with this one.", s.approvals))
+	}
+}
+
+// currentModel is the model of the last call, or the configured one before
+// the first
+func (s *sessionStatus) currentModel(config *Config) string {
+	if s.model == "" {
+		return config.Model
+	}
+	return s.model
+}
+
+// countApprovals notes how many of a round's tool calls will ask for
+// approval, for the status line shown with each question
+func (a *Agent) countApprovals(calls []*genai.FunctionCall) {
+	if a.status == nil {
+		return
+	}
+	a.status.approvals = 0
+	for _, call := range calls {
+		if a.config.Approvals.decide(a.callKind(call.Name, call.Args)) == approvalPrompt {
+			a.status.approvals++
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store keeps saved sessions and the usage log. By default they are in a
+// SQLite database under ~/.codegent; CODEGENT_STORE selects a store shared
+// with other machines and teammates.
+type Store interface {
+	// LoadSession fails with an error wrapping os.ErrNotExist when there is
+	// no session with that ID
+	LoadSession(id string) (*Session, error)
+	SaveSession(s *Session) error
+	// ListSessions logs and skips sessions it can't read
+	ListSessions() ([]*Session, error)
+	DeleteSession(id string) error
+	// SearchSessions returns the sessions whose history might contain all
+	// of the lowercase terms. It may return more, never fewer.
+	SearchSessions(terms []string) ([]*Session, error)
+
+	AppendUsage(rec usageRecord) error
+	ReadUsage() ([]usageRecord, error)
+}
+
+// store returns the configured Store, opening it on first use
+var store = sync.OnceValues(openStore)
+
+// openStore picks the store from CODEGENT_STORE: empty or "sqlite" for the
+// local database, "file" for plain files, or a postgres:// URL. A project's
+// .env can't set it, or a repository could collect the sessions.
+func openStore() (Store, error) {
+	url := userEnvOr("CODEGENT_STORE", "")
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		return openPostgresStore(url)
+	}
+
+	dir, err := codegentDir()
+	if err != nil {
+		return nil, err
+	}
+	switch url {
+	case "", "sqlite":
+		return openSQLiteStore(dir)
+	case "file":
+		return &fileStore{dir: dir}, nil
+	default:
+		return nil, fmt.Errorf("unsupported CODEGENT_STORE %q, want sqlite, file or a postgres:// URL", url)
+	}
+}
+
+// fileStore keeps each session in sessions/<id>.json and the usage log in
+// usage.jsonl. It was the only store before the SQLite one.
+type fileStore struct {
+	dir string
+
+	// Serializes appends to the usage log
+	mu sync.Mutex
+}
+
+func (f *fileStore) sessionPath(id string) string {
+	return filepath.Join(f.dir, "sessions", id+".json")
+}
+
+func (f *fileStore) LoadSession(id string) (*Session, error) {
+	return readSession(f.sessionPath(id))
+}
+
+func readSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", filepath.Base(path), err)
+	}
+	return &s, nil
+}
+
+func (f *fileStore) SaveSession(s *Session) error {
+	path := f.sessionPath(s.ID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create sessions directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Sessions are saved while they run, so a crash mustn't leave half a
+	// file: write a new one and move it into place
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *fileStore) ListSessions() ([]*Session, error) {
+	dir := filepath.Join(f.dir, "sessions")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		s, err := readSession(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			// One broken file shouldn't hide every other session
+			log.Printf("%v, skipping it", err)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func (f *fileStore) DeleteSession(id string) error {
+	return os.Remove(f.sessionPath(id))
+}
+
+func (f *fileStore) SearchSessions(terms []string) ([]*Session, error) {
+	return f.ListSessions()
+}
+
+func (f *fileStore) AppendUsage(rec usageRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(f.dir, "usage.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(rec)
+}
+
+// ReadUsage loads every record in the usage log, skipping lines it can't
+// parse
+func (f *fileStore) ReadUsage() ([]usageRecord, error) {
+	file, err := os.Open(filepath.Join(f.dir, "usage.jsonl"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []usageRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec usageRecord
+		if json.Unmarshal(scanner.Bytes(), &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// sqlStore keeps sessions and the usage log in a SQL database. Each row
+// holds the JSON a fileStore would write, next to the columns it is looked
+// up and sorted by.
+type sqlStore struct {
+	db *sql.DB
+}
+
+// postgresMigrations create and update the schema, see migrate
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS codegent_sessions (
+		id         TEXT PRIMARY KEY,
+		title      TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL,
+		data       JSONB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS codegent_usage (
+		id      BIGSERIAL PRIMARY KEY,
+		time    TIMESTAMPTZ NOT NULL,
+		session TEXT NOT NULL,
+		data    JSONB NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS codegent_usage_time ON codegent_usage (time)`,
+}
+
+func openPostgresStore(url string) (Store, error) {
+	db, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrate(db, postgresMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up the session store: %w", err)
+	}
+	return &sqlStore{db: db}, nil
+}
+
+// migrate brings the schema up to date by running the migrations after the
+// last one recorded in codegent_migrations, each in its own transaction.
+// Migrations are only ever appended to. It returns the version the
+// database was at before.
+func migrate(db *sql.DB, migrations []string) (int, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS codegent_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return 0, err
+	}
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM codegent_migrations`).Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return version, fmt.Errorf("database schema version %d is newer than this codegent supports (%d), upgrade codegent", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return version, err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return version, fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO codegent_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback()
+			return version, fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return version, fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return version, nil
+}
+
+func (s *sqlStore) LoadSession(id string) (*Session, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM codegent_sessions WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("session %s: %w", id, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSession(id, data)
+}
+
+func parseSession(id string, data []byte) (*Session, error) {
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse session %s: %w", id, err)
+	}
+	return &session, nil
+}
+
+func (s *sqlStore) SaveSession(session *Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO codegent_sessions (id, title, created_at, updated_at, data)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title, updated_at = excluded.updated_at, data = excluded.data`,
+		session.ID, session.Title, session.CreatedAt, session.UpdatedAt, string(data))
+	return err
+}
+
+func (s *sqlStore) ListSessions() ([]*Session, error) {
+	return s.querySessions(`SELECT id, data FROM codegent_sessions`)
+}
+
+// querySessions runs a query selecting id and data of sessions
+func (s *sqlStore) querySessions(query string, args ...any) ([]*Session, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		session, err := parseSession(id, data)
+		if err != nil {
+			// One broken session shouldn't hide every other one
+			log.Printf("%v, skipping it", err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// SearchSessions leaves the matching to searchHistory. The stored JSON
+// escapes characters such as < and quotes, and SQL's LOWER only folds
+// ASCII, so a LIKE on it would miss sessions that match.
+func (s *sqlStore) SearchSessions(terms []string) ([]*Session, error) {
+	return s.ListSessions()
+}
+
+func (s *sqlStore) DeleteSession(id string) error {
+	_, err := s.db.Exec(`DELETE FROM codegent_sessions WHERE id = $1`, id)
+	return err
+}
+
+func (s *sqlStore) AppendUsage(rec usageRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO codegent_usage (time, session, data) VALUES ($1, $2, $3)`, rec.Time, rec.Session, string(data))
+	return err
+}
+
+func (s *sqlStore) ReadUsage() ([]usageRecord, error) {
+	rows, err := s.db.Query(`SELECT data FROM codegent_usage ORDER BY time`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []usageRecord
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rec usageRecord
+		if json.Unmarshal(data, &rec) == nil {
+			records = append(records, rec)
+		}
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations create and update the schema of the local database, see
+// migrate
+var sqliteMigrations = []string{
+	`CREATE TABLE codegent_sessions (
+		id         TEXT PRIMARY KEY,
+		title      TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		data       TEXT NOT NULL
+	);
+	CREATE TABLE codegent_usage (
+		id      INTEGER PRIMARY KEY,
+		time    TIMESTAMP NOT NULL,
+		session TEXT NOT NULL,
+		data    TEXT NOT NULL
+	);
+	CREATE INDEX codegent_usage_time ON codegent_usage (time)`,
+}
+
+// openSQLiteStore opens ~/.codegent/codegent.db, the default store. A new
+// database takes over the sessions and usage log of the older file layout.
+func openSQLiteStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// WAL and a busy timeout let several codegent processes share it
+	dsn := "file:" + filepath.Join(dir, "codegent.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	version, err := migrate(db, sqliteMigrations)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set up %s: %w", filepath.Join(dir, "codegent.db"), err)
+	}
+
+	s := &sqlStore{db: db}
+	if version == 0 {
+		if err := importFileStore(&fileStore{dir: dir}, s); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to import saved sessions: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// importFileStore copies the sessions and usage log of from into to
+func importFileStore(from *fileStore, to Store) error {
+	sessions, err := from.ListSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := to.SaveSession(session); err != nil {
+			return err
+		}
+	}
+	records, err := from.ReadUsage()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := to.AppendUsage(rec); err != nil {
+			return err
+		}
+	}
+	if len(sessions) > 0 || len(records) > 0 {
+		log.Printf("imported %d sessions and %d usage records from %s, the old files can be deleted", len(sessions), len(records), from.dir)
+	}
+	return nil
+}
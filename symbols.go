@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// symbol is a top-level declaration in a source file, or a member of a
+// class, trait or impl in the languages that have them
+type symbol struct {
+	Name     string `json:"name"`
+	Kind     string `json:"kind"` // func, method, type, var or const, and class, interface, enum, struct, trait, macro or mod outside Go
+	Recv     string `json:"receiver,omitempty"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	EndLine  int    `json:"end_line"`
+	Exported bool   `json:"exported"`
+	HasDoc   bool   `json:"has_doc"`
+}
+
+type symbolRef struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// symbolIndex holds the declarations and identifier uses of the Go files
+// under a directory, and of the JavaScript, TypeScript, Python and Rust
+// files when built with cgo, see addSourceFile. Matching is by name only,
+// without type information, so references to different symbols sharing a
+// name are not told apart.
+type symbolIndex struct {
+	defs []symbol
+	refs map[string][]symbolRef
+}
+
+// skippedSourceDirs hold dependencies, build output and fixtures rather
+// than the project's own code
+var skippedSourceDirs = map[string]bool{
+	"vendor": true, "testdata": true, "node_modules": true, "target": true, "dist": true, "build": true,
+	".venv": true, "venv": true, "__pycache__": true,
+}
+
+// buildSymbolIndex parses every source file under root, skipping
+// dependency, build output and testdata directories. Files that don't parse
+// are skipped.
+func buildSymbolIndex(ctx context.Context, root string) (*symbolIndex, error) {
+	index := &symbolIndex{refs: make(map[string][]symbolRef)}
+	fset := token.NewFileSet()
+	err := walkFiles(ctx, root, 0, func(relPath string, d fs.DirEntry) bool {
+		if d.IsDir() || !strings.HasSuffix(relPath, ".go") && !indexedSource(relPath) {
+			return true
+		}
+		for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(relPath)), "/") {
+			if skippedSourceDirs[dir] {
+				return true
+			}
+		}
+		index.addPath(ctx, fset, root, relPath)
+		return true
+	})
+	return index, err
+}
+
+// addPath parses one file under root, by its language
+func (s *symbolIndex) addPath(ctx context.Context, fset *token.FileSet, root, relPath string) {
+	if !strings.HasSuffix(relPath, ".go") {
+		if content, err := os.ReadFile(filepath.Join(root, relPath)); err == nil {
+			s.addSourceFile(ctx, relPath, content)
+		}
+		return
+	}
+	file, err := parser.ParseFile(fset, filepath.Join(root, relPath), nil, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return
+	}
+	s.addFile(fset, relPath, file)
+}
+
+func (s *symbolIndex) addFile(fset *token.FileSet, relPath string, file *ast.File) {
+	declared := make(map[token.Pos]bool)
+	add := func(ident *ast.Ident, kind, recv string, doc *ast.CommentGroup, end token.Pos) {
+		declared[ident.Pos()] = true
+		s.defs = append(s.defs, symbol{
+			Name:     ident.Name,
+			Kind:     kind,
+			Recv:     recv,
+			File:     relPath,
+			Line:     fset.Position(ident.Pos()).Line,
+			EndLine:  fset.Position(end).Line,
+			Exported: ident.IsExported(),
+			HasDoc:   doc != nil,
+		})
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				add(decl.Name, "method", receiverName(decl.Recv.List[0].Type), decl.Doc, decl.End())
+			} else {
+				add(decl.Name, "func", "", decl.Doc, decl.End())
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				// A lone spec is documented by the comment on its declaration
+				doc := decl.Doc
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Doc != nil {
+						doc = spec.Doc
+					}
+					add(spec.Name, "type", "", doc, spec.End())
+				case *ast.ValueSpec:
+					if spec.Doc != nil {
+						doc = spec.Doc
+					}
+					// Grouped values are often documented by a trailing comment
+					if doc == nil {
+						doc = spec.Comment
+					}
+					kind := "var"
+					if decl.Tok == token.CONST {
+						kind = "const"
+					}
+					for _, name := range spec.Names {
+						if name.Name != "_" {
+							add(name, kind, "", doc, spec.End())
+						}
+					}
+				}
+			}
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok && !declared[ident.Pos()] {
+			s.refs[ident.Name] = append(s.refs[ident.Name], symbolRef{File: relPath, Line: fset.Position(ident.Pos()).Line})
+		}
+		return true
+	})
+}
+
+// receiverName returns the type name of a method receiver, without pointer
+// or type parameters
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// lookup returns the declarations named name, and the places it's used
+func (s *symbolIndex) lookup(name string) ([]symbol, []symbolRef) {
+	var defs []symbol
+	for _, def := range s.defs {
+		if def.Name == name {
+			defs = append(defs, def)
+		}
+	}
+	return defs, s.refs[name]
+}
@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package main
+
+import "context"
+
+// Tree-sitter needs cgo, so without it only Go files are indexed
+
+func indexedSource(path string) bool {
+	return false
+}
+
+func (s *symbolIndex) addSourceFile(ctx context.Context, relPath string, content []byte) {}
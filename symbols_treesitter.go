@@ -0,0 +1,256 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	sitter "github.com/smacker/go-tree-sitter"
+	"github.com/smacker/go-tree-sitter/javascript"
+	"github.com/smacker/go-tree-sitter/python"
+	"github.com/smacker/go-tree-sitter/rust"
+	"github.com/smacker/go-tree-sitter/typescript/tsx"
+	"github.com/smacker/go-tree-sitter/typescript/typescript"
+)
+
+// The declarations of JavaScript, TypeScript, Python and Rust files are
+// read with tree-sitter grammars, which need cgo; builds without it only
+// index Go files.
+
+// treeSitterGrammars maps the extensions of the indexed languages to their
+// grammars
+var treeSitterGrammars = map[string]func() *sitter.Language{
+	".js": javascript.GetLanguage, ".jsx": javascript.GetLanguage, ".mjs": javascript.GetLanguage, ".cjs": javascript.GetLanguage,
+	".ts": typescript.GetLanguage, ".mts": typescript.GetLanguage, ".cts": typescript.GetLanguage,
+	".tsx": tsx.GetLanguage,
+	".py":  python.GetLanguage,
+	".rs":  rust.GetLanguage,
+}
+
+// identifierNodes are the node types that name something, in any of the
+// grammars
+var identifierNodes = map[string]bool{
+	"identifier": true, "type_identifier": true, "property_identifier": true, "field_identifier": true,
+	"shorthand_property_identifier": true, "shorthand_property_identifier_pattern": true,
+}
+
+// indexedSource reports whether the symbol index reads files like path
+// with tree-sitter
+func indexedSource(path string) bool {
+	_, ok := treeSitterGrammars[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// addSourceFile indexes the top-level declarations, the members of classes,
+// traits and impls, and the identifier uses of a file in one of the
+// tree-sitter languages. Files that don't parse at all are skipped.
+func (s *symbolIndex) addSourceFile(ctx context.Context, relPath string, content []byte) {
+	grammar, ok := treeSitterGrammars[strings.ToLower(filepath.Ext(relPath))]
+	if !ok {
+		return
+	}
+	parser := sitter.NewParser()
+	defer parser.Close()
+	parser.SetLanguage(grammar())
+	tree, err := parser.ParseCtx(ctx, nil, content)
+	if err != nil {
+		return
+	}
+	defer tree.Close()
+
+	w := &sourceWalker{index: s, file: relPath, content: content, declared: make(map[uint32]bool)}
+	w.declarations(tree.RootNode(), "", false)
+	w.references(tree.RootNode())
+}
+
+// sourceWalker collects the symbols of one parsed file
+type sourceWalker struct {
+	index    *symbolIndex
+	file     string
+	content  []byte
+	declared map[uint32]bool // start bytes of declared names
+}
+
+func (w *sourceWalker) add(decl, name *sitter.Node, kind, recv string, exported bool) {
+	if name == nil {
+		return
+	}
+	w.declared[name.StartByte()] = true
+	text := name.Content(w.content)
+	if strings.HasSuffix(w.file, ".py") {
+		exported = !strings.HasPrefix(text, "_") || strings.HasSuffix(text, "__")
+	}
+	w.index.defs = append(w.index.defs, symbol{
+		Name:     text,
+		Kind:     kind,
+		Recv:     recv,
+		File:     w.file,
+		Line:     int(decl.StartPoint().Row) + 1,
+		EndLine:  int(decl.EndPoint().Row) + 1,
+		Exported: exported,
+		HasDoc:   w.documented(decl),
+	})
+}
+
+// documented reports whether a comment ends right above the declaration,
+// or for Python, whether its body starts with a docstring
+func (w *sourceWalker) documented(decl *sitter.Node) bool {
+	if body := decl.ChildByFieldName("body"); body != nil && strings.HasSuffix(w.file, ".py") {
+		first := body.NamedChild(0)
+		return first != nil && first.Type() == "expression_statement" && first.NamedChild(0) != nil && first.NamedChild(0).Type() == "string"
+	}
+	// The comment precedes export, decorators and attributes
+	node := decl
+	for parent := node.Parent(); parent != nil && (parent.Type() == "export_statement" || parent.Type() == "decorated_definition"); parent = parent.Parent() {
+		node = parent
+	}
+	prev := node.PrevNamedSibling()
+	for prev != nil && prev.Type() == "attribute_item" {
+		prev = prev.PrevNamedSibling()
+	}
+	return prev != nil && strings.Contains(prev.Type(), "comment") && prev.EndPoint().Row+1 >= node.StartPoint().Row
+}
+
+// declarations adds the declarations among the children of node, where
+// recv is the enclosing class, trait or type of methods
+func (w *sourceWalker) declarations(node *sitter.Node, recv string, exported bool) {
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		w.declaration(node.NamedChild(i), recv, exported)
+	}
+}
+
+func (w *sourceWalker) declaration(node *sitter.Node, recv string, exported bool) {
+	name := node.ChildByFieldName("name")
+	method := func(kind string) string {
+		if recv != "" {
+			return "method"
+		}
+		return kind
+	}
+	switch node.Type() {
+	// JavaScript and TypeScript
+	case "export_statement":
+		if decl := node.ChildByFieldName("declaration"); decl != nil {
+			w.declaration(decl, recv, true)
+		}
+	case "function_declaration", "generator_function_declaration":
+		w.add(node, name, "func", recv, exported)
+	case "class_declaration", "abstract_class_declaration":
+		w.add(node, name, "class", "", exported)
+		if name != nil {
+			if body := node.ChildByFieldName("body"); body != nil {
+				w.declarations(body, name.Content(w.content), exported)
+			}
+		}
+	case "method_definition", "abstract_method_signature":
+		w.add(node, name, "method", recv, exported)
+	case "interface_declaration":
+		w.add(node, name, "interface", "", exported)
+	case "type_alias_declaration":
+		w.add(node, name, "type", "", exported)
+	case "enum_declaration":
+		w.add(node, name, "enum", "", exported)
+	case "lexical_declaration", "variable_declaration":
+		kind := "var"
+		if first := node.Child(0); first != nil && first.Type() == "const" {
+			kind = "const"
+		}
+		for i := 0; i < int(node.NamedChildCount()); i++ {
+			declarator := node.NamedChild(i)
+			name := declarator.ChildByFieldName("name")
+			if declarator.Type() != "variable_declarator" || name == nil || name.Type() != "identifier" {
+				continue
+			}
+			k := kind
+			if value := declarator.ChildByFieldName("value"); value != nil && strings.Contains(value.Type(), "function") {
+				k = "func"
+			}
+			w.add(node, name, k, "", exported)
+		}
+
+	// Python
+	case "decorated_definition":
+		if def := node.ChildByFieldName("definition"); def != nil {
+			w.declaration(def, recv, exported)
+		}
+	case "function_definition":
+		w.add(node, name, method("func"), recv, exported)
+	case "class_definition":
+		w.add(node, name, "class", "", exported)
+		if name != nil {
+			if body := node.ChildByFieldName("body"); body != nil {
+				w.declarations(body, name.Content(w.content), exported)
+			}
+		}
+	case "expression_statement":
+		// Module-level assignments, class attributes are left out
+		if assignment := node.NamedChild(0); recv == "" && assignment != nil && assignment.Type() == "assignment" {
+			if left := assignment.ChildByFieldName("left"); left != nil && left.Type() == "identifier" {
+				w.add(node, left, "var", "", exported)
+			}
+		}
+
+	// Rust, where pub marks what's exported, and trait members are as
+	// public as their trait
+	case "function_item", "function_signature_item":
+		w.add(node, name, method("func"), recv, exported || w.public(node))
+	case "struct_item", "union_item":
+		w.add(node, name, "struct", "", w.public(node))
+	case "enum_item":
+		w.add(node, name, "enum", "", w.public(node))
+	case "type_item":
+		w.add(node, name, "type", "", w.public(node))
+	case "const_item":
+		w.add(node, name, "const", "", w.public(node))
+	case "static_item":
+		w.add(node, name, "var", "", w.public(node))
+	case "macro_definition":
+		w.add(node, name, "macro", "", true)
+	case "trait_item":
+		w.add(node, name, "trait", "", w.public(node))
+		if name != nil {
+			if body := node.ChildByFieldName("body"); body != nil {
+				w.declarations(body, name.Content(w.content), w.public(node))
+			}
+		}
+	case "impl_item":
+		typ := node.ChildByFieldName("type")
+		if typ != nil && typ.Type() == "generic_type" {
+			typ = typ.ChildByFieldName("type")
+		}
+		if body := node.ChildByFieldName("body"); typ != nil && body != nil {
+			w.declarations(body, typ.Content(w.content), false)
+		}
+	case "mod_item":
+		w.add(node, name, "mod", "", w.public(node))
+		if body := node.ChildByFieldName("body"); body != nil {
+			w.declarations(body, "", false)
+		}
+	}
+}
+
+// public reports whether a Rust item has a pub visibility modifier
+func (w *sourceWalker) public(node *sitter.Node) bool {
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		if node.NamedChild(i).Type() == "visibility_modifier" {
+			return true
+		}
+	}
+	return false
+}
+
+// references records every identifier that isn't a declared name
+func (w *sourceWalker) references(node *sitter.Node) {
+	if identifierNodes[node.Type()] {
+		if !w.declared[node.StartByte()] {
+			name := node.Content(w.content)
+			w.index.refs[name] = append(w.index.refs[name], symbolRef{File: w.file, Line: int(node.StartPoint().Row) + 1})
+		}
+		return
+	}
+	for i := 0; i < int(node.NamedChildCount()); i++ {
+		w.references(node.NamedChild(i))
+	}
+}
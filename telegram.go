@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// telegramAPI calls a Telegram Bot API method with a JSON body and decodes
+// the result into out
+func telegramAPI(ctx context.Context, token, method string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%sbot%s/%s", userEnvOr("TELEGRAM_API_URL", "https://api.telegram.org/"), token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram %s: %s", method, result.Description)
+	}
+	if out != nil {
+		return json.Unmarshal(result.Result, out)
+	}
+	return nil
+}
+
+type telegramUser struct {
+	ID int64 `json:"id"`
+}
+
+type telegramChat struct {
+	ID int64 `json:"id"`
+}
+
+type telegramMessage struct {
+	MessageID int64        `json:"message_id"`
+	From      telegramUser `json:"from"`
+	Chat      telegramChat `json:"chat"`
+	Text      string       `json:"text"`
+}
+
+type telegramCallback struct {
+	ID      string          `json:"id"`
+	From    telegramUser    `json:"from"`
+	Message telegramMessage `json:"message"`
+	Data    string          `json:"data"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64             `json:"update_id"`
+	Message  *telegramMessage  `json:"message"`
+	Callback *telegramCallback `json:"callback_query"`
+}
+
+type telegramButton struct {
+	Text string `json:"text"`
+	Data string `json:"callback_data"`
+}
+
+// telegramSend posts text to a chat, with a row of inline buttons if any
+// are given, and returns the ID of the new message
+func telegramSend(ctx context.Context, token string, chatID int64, text string, buttons ...telegramButton) (int64, error) {
+	body := map[string]any{"chat_id": chatID, "text": text}
+	if len(buttons) > 0 {
+		body["reply_markup"] = map[string]any{"inline_keyboard": [][]telegramButton{buttons}}
+	}
+	var msg telegramMessage
+	err := telegramAPI(ctx, token, "sendMessage", body, &msg)
+	return msg.MessageID, err
+}
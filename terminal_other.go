@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// enableANSI is needed on Windows only, other terminals take escape
+// sequences as they are
+func enableANSI(f *os.File) bool {
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Roles text plays in the output, each colored by the theme
+const (
+	roleUser   = "user"   // the user's messages
+	roleModel  = "model"  // the model's answers
+	roleTool   = "tool"   // tool calls and commands being run
+	roleNotice = "notice" // approvals, fallbacks and other interruptions
+	roleDim    = "dim"    // thoughts, the status line and other asides
+	roleBold   = "bold"   // search matches and result numbers
+	roleWarn   = "warn"   // warnings, such as a nearly full context
+	roleError  = "error"  // errors, such as blocking hook findings
+)
+
+// theme maps each role to the SGR parameters it is drawn with, e.g. "94"
+// for bright blue or "38;5;208" for orange
+type theme map[string]string
+
+var themes = map[string]theme{
+	"dark": {
+		roleUser:   "94",
+		roleModel:  "93",
+		roleTool:   "92",
+		roleNotice: "95",
+		roleDim:    "2",
+		roleBold:   "1",
+		roleWarn:   "93",
+		roleError:  "91",
+	},
+	// Bright yellow and green are hard to read on white
+	"light": {
+		roleUser:   "34",
+		roleModel:  "35",
+		roleTool:   "32",
+		roleNotice: "31",
+		roleDim:    "2",
+		roleBold:   "1",
+		roleWarn:   "33",
+		roleError:  "31;1",
+	},
+}
+
+// palette is the theme output is colored with, nil for plain text. The
+// dark theme is what the web UI expects, see setupTheme for the terminal.
+var palette = themes["dark"]
+
+// paint colors text for role
+func paint(role, text string) string {
+	code := palette[role]
+	if code == "" {
+		return text
+	}
+	return "\u001b[" + code + "m" + text + "\u001b[0m"
+}
+
+// accessible is set with CODEGENT_ACCESSIBLE for screen reader users. The
+// output is then plain text read top to bottom: no colors, no spinners or
+// redrawn lines, and warnings spelled out instead of colored.
+var accessible bool
+
+var sgrParams = regexp.MustCompile(`^[0-9;]+$`)
+
+// setupTheme picks the palette for output to the terminal. There are no
+// colors in accessible mode, when NO_COLOR is set, on a dumb terminal or
+// when stdout isn't a terminal at all. Otherwise CODEGENT_THEME picks dark
+// or light, and CODEGENT_COLORS overrides roles, e.g.
+// "user=36:model=38;5;208". Colors the terminal can't show fall back to the
+// theme's.
+func setupTheme() error {
+	accessible = envBool("CODEGENT_ACCESSIBLE", false)
+	if accessible || os.Getenv("NO_COLOR") != "" || !colorTerminal(os.Stdout) {
+		palette = nil
+		return nil
+	}
+
+	name := envOr("CODEGENT_THEME", "dark")
+	base, ok := themes[name]
+	if !ok {
+		return fmt.Errorf("unknown CODEGENT_THEME %q (want dark or light)", name)
+	}
+	colors := make(theme, len(base))
+	for role, code := range base {
+		colors[role] = code
+	}
+
+	depth := colorDepth()
+	for _, entry := range strings.Split(os.Getenv("CODEGENT_COLORS"), ":") {
+		if entry == "" {
+			continue
+		}
+		role, code, _ := strings.Cut(entry, "=")
+		if _, ok := base[role]; !ok {
+			return fmt.Errorf("unknown role %q in CODEGENT_COLORS (want user, model, tool, notice, dim, bold, warn or error)", role)
+		}
+		if !sgrParams.MatchString(code) {
+			return fmt.Errorf("invalid color %q for %s in CODEGENT_COLORS, want SGR parameters such as 36 or 38;5;208", code, role)
+		}
+		if sgrDepth(code) <= depth {
+			colors[role] = code
+		}
+	}
+	palette = colors
+	return nil
+}
+
+// colorTerminal reports whether f is a terminal that understands escape
+// sequences, turning them on for Windows consoles
+func colorTerminal(f *os.File) bool {
+	return isTerminal(f) && os.Getenv("TERM") != "dumb" && enableANSI(f)
+}
+
+// colorDepth guesses how many bits of color the terminal shows
+func colorDepth() int {
+	switch {
+	case os.Getenv("COLORTERM") == "truecolor" || os.Getenv("COLORTERM") == "24bit":
+		return 24
+	case strings.Contains(os.Getenv("TERM"), "256color"):
+		return 8
+	default:
+		return 4
+	}
+}
+
+// sgrDepth returns the color depth SGR parameters need
+func sgrDepth(code string) int {
+	switch {
+	case strings.Contains(code, "38;2;") || strings.Contains(code, "48;2;"):
+		return 24
+	case strings.Contains(code, "38;5;") || strings.Contains(code, "48;5;"):
+		return 8
+	default:
+		return 4
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// thinkingConfig is only sent when the user asked for it, since models
+// without thinking support reject it
+func (c *Config) thinkingConfig() *genai.ThinkingConfig {
+	if c.ThinkingBudget == nil && !c.ShowThoughts {
+		return nil
+	}
+	return &genai.ThinkingConfig{
+		ThinkingBudget:  c.ThinkingBudget,
+		IncludeThoughts: c.ShowThoughts,
+	}
+}
+
+// printThought shows a thought summary dimmed, so it reads apart from the answer
+func (a *Agent) printThought(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	fmt.Fprintln(a.out, paint(roleDim, "thinking: "+strings.ReplaceAll(text, "\n", "\n          ")))
+}
+
+// withoutThoughts drops thought summaries from model content before it goes
+// into the history. Thought signatures on other parts are kept, the API
+// needs them to continue the reasoning across tool calls.
+func withoutThoughts(content *genai.Content) *genai.Content {
+	parts := make([]*genai.Part, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		if part.Thought {
+			continue
+		}
+		// Drop empty text parts as well, the API rejects them in history
+		if part.Text == "" && part.FunctionCall == nil && part.FunctionResponse == nil && part.InlineData == nil {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return &genai.Content{Role: genai.RoleModel, Parts: parts}
+}
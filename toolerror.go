@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+)
+
+// Error codes of tool failures, so the model can tell a call to fix from
+// one not to repeat
+const (
+	errInvalidInput = "invalid_input"     // the arguments are wrong
+	errNotFound     = "not_found"         // a path doesn't exist
+	errNoMatch      = "no_match"          // old_str or a pattern matched nothing
+	errConflict     = "conflict"          // the file changed since it was read
+	errPermission   = "permission_denied" // the OS refused
+	errDenied       = "denied"            // the user or approval mode refused
+	errTimeout      = "timeout"           // the call ran too long
+	errCancelled    = "cancelled"         // the user stopped the call
+	errUnknownTool  = "unknown_tool"
+	errPanic        = "panic"  // a bug in the tool
+	errFailed       = "failed" // anything else
+)
+
+// toolError is a tool failure described for the model: what went wrong,
+// whether calling again differently can succeed, and how. Tools return it,
+// possibly wrapped, where they know more than the error says by itself.
+type toolError struct {
+	Code        string
+	Message     string
+	Recoverable bool
+	Suggestion  string
+}
+
+func (e *toolError) Error() string {
+	return e.Message
+}
+
+// newToolError returns a recoverable tool error with a suggestion for the
+// next call
+func newToolError(code, message, suggestion string) *toolError {
+	return &toolError{Code: code, Message: message, Recoverable: true, Suggestion: suggestion}
+}
+
+// describeToolError turns any error from a tool call into a toolError,
+// recognizing common errors that tools return as they are
+func describeToolError(err error) *toolError {
+	var te *toolError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &te):
+		// Keep the context wrapping added, such as which edit failed
+		described := *te
+		described.Message = err.Error()
+		return &described
+	case errors.Is(err, os.ErrNotExist):
+		return newToolError(errNotFound, err.Error(), "Check the path with list_files, paths are relative to the working directory.")
+	case errors.Is(err, os.ErrPermission):
+		return &toolError{Code: errPermission, Message: err.Error()}
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return newToolError(errInvalidInput, err.Error(), "Call the tool again with arguments matching its schema.")
+	case errors.Is(err, context.DeadlineExceeded):
+		return newToolError(errTimeout, err.Error(), "")
+	case errors.Is(err, context.Canceled):
+		return &toolError{Code: errCancelled, Message: err.Error()}
+	}
+	return &toolError{Code: errFailed, Message: err.Error(), Recoverable: true}
+}
+
+// recoverTool turns a panicking tool call into a failed one, so a bug in a
+// tool costs the call rather than the session. It is deferred by the
+// goroutine calling the tool; the stack goes to the log for a bug report.
+func recoverTool(name string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	log.Printf("ERROR tool %s panicked: %v\n%s", name, r, debug.Stack())
+	*err = &toolError{
+		Code:       errPanic,
+		Message:    fmt.Sprintf("%s crashed: %v", name, r),
+		Suggestion: "This is a bug in the tool, not in the call. Get the job done another way, such as with other tools.",
+	}
+}
+
+// toolErrorResult is the result of a failed tool call as the model gets it.
+// "error" holds the message, which is what frontends and logs show.
+func toolErrorResult(err error) map[string]interface{} {
+	te := describeToolError(err)
+	result := map[string]interface{}{
+		"error":       te.Message,
+		"code":        te.Code,
+		"recoverable": te.Recoverable,
+	}
+	if te.Suggestion != "" {
+		result["suggestion"] = te.Suggestion
+	}
+	return result
+}
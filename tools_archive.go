@@ -0,0 +1,676 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Limits on the archives the archive tool reads and writes
+const (
+	maxArchiveEntries = 100000  // entries extracted or added
+	maxExtractBytes   = 1 << 30 // bytes extracted, against zip bombs
+	maxListedEntries  = 500     // entries listed
+	maxExtractedShown = 50      // extracted files named in the result
+)
+
+// Archive Tool
+var ArchiveDefinition = ToolDefinition{
+	Name:        "archive",
+	Description: "List, extract or create zip, tar.gz (.tgz) and tar archives in the workspace, such as release artifacts and vendored bundles. Actions: list shows the entries with their sizes, without extracting; extract unpacks the archive, or only some of its entries, into a directory of the workspace; create packs files and directories into a new archive. Extraction refuses archives with entries or links that would land outside the destination, and doesn't overwrite existing files unless asked. Extracting and creating need the user's approval.",
+	InputSchema: GenerateSchema[ArchiveInput](),
+	Kind:        ToolWrite,
+	KindOf:      archiveKind,
+	Function:    Archive,
+}
+
+type ArchiveInput struct {
+	Action      string   `json:"action" jsonschema_description:"What to do: list, extract or create." jsonschema:"required,enum=list,enum=extract,enum=create"`
+	Path        string   `json:"path" jsonschema_description:"The relative path of the archive, ending in .zip, .tar.gz, .tgz or .tar." jsonschema:"required"`
+	Destination string   `json:"destination,omitempty" jsonschema_description:"extract: the directory to extract into, a directory named after the archive next to it when empty."`
+	Files       []string `json:"files,omitempty" jsonschema_description:"extract: only these entries, or the entries under these directories, all when empty. create: the files and directories to add, required."`
+	Overwrite   bool     `json:"overwrite,omitempty" jsonschema_description:"extract: replace existing files. create: replace an existing archive."`
+}
+
+// archiveKind counts listing as a read, see KindOf
+func archiveKind(input json.RawMessage) ToolKind {
+	var archiveInput ArchiveInput
+	if err := json.Unmarshal(input, &archiveInput); err == nil && archiveInput.Action == "list" {
+		return ToolRead
+	}
+	return ToolWrite
+}
+
+// archiveEntry is a file, directory or link in an archive
+type archiveEntry struct {
+	Name    string // slash-separated, as stored
+	Mode    fs.FileMode
+	Size    int64
+	ModTime time.Time
+	Link    string // the target of a symlink
+	Hard    bool   // Link is a hard link to another entry
+	open    func() (io.ReadCloser, error)
+}
+
+// archiveFormat tells the format of an archive from its name
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// walkArchive calls fn for every entry of the archive at path, in the
+// order they're stored. Entries can only be opened during the call.
+func walkArchive(path string, fn func(entry archiveEntry) error) error {
+	if archiveFormat(path) == "zip" {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			entry := archiveEntry{Name: f.Name, Mode: f.Mode(), Size: int64(f.UncompressedSize64), ModTime: f.Modified, open: f.Open}
+			// Zip stores the target of a symlink as its contents
+			if entry.Mode&fs.ModeSymlink != 0 {
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				target, err := io.ReadAll(io.LimitReader(rc, 4096))
+				rc.Close()
+				if err != nil {
+					return err
+				}
+				entry.Link = string(target)
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if archiveFormat(path) == "tar.gz" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	tr := tar.NewReader(in)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		entry := archiveEntry{Name: header.Name, Mode: header.FileInfo().Mode(), Size: header.Size, ModTime: header.ModTime, Link: header.Linkname, Hard: header.Typeflag == tar.TypeLink}
+		entry.open = func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader, tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// entryType names the type of an entry as list shows it
+func entryType(entry archiveEntry) string {
+	switch {
+	case entry.Hard:
+		return "hard link -> " + entry.Link
+	case entry.Mode&fs.ModeSymlink != 0:
+		return "symlink -> " + entry.Link
+	case entry.Mode.IsDir():
+		return "dir"
+	case !entry.Mode.IsRegular():
+		return "special"
+	}
+	return fmt.Sprintf("%d", entry.Size)
+}
+
+func Archive(ctx context.Context, input json.RawMessage) (string, error) {
+	archiveInput := ArchiveInput{}
+	if err := json.Unmarshal(input, &archiveInput); err != nil {
+		return "", err
+	}
+	if archiveFormat(archiveInput.Path) == "" {
+		return "", newToolError(errInvalidInput, archiveInput.Path+" isn't a .zip, .tar.gz, .tgz or .tar file", "")
+	}
+	switch archiveInput.Action {
+	case "list":
+		return listArchive(ctx, archiveInput.Path)
+	case "extract":
+		return extractArchive(ctx, archiveInput)
+	case "create":
+		return createArchive(ctx, archiveInput)
+	}
+	return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", archiveInput.Action), "Use list, extract or create.")
+}
+
+func listArchive(ctx context.Context, path string) (string, error) {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	entries, files, unsafe := 0, 0, 0
+	var size int64
+	err := walkArchive(path, func(entry archiveEntry) error {
+		if entries%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entries++
+		if entry.Mode.IsRegular() && !entry.Hard {
+			files++
+			size += entry.Size
+		}
+		note := ""
+		if err := unsafeEntry(entry); err != nil {
+			note = "  unsafe, extract refuses it"
+			unsafe++
+		}
+		if entries <= maxListedEntries {
+			fmt.Fprintf(w, "%s\t  %s\t  %s%s\n", entryType(entry), entry.ModTime.Format(time.DateTime), entry.Name, note)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "\n%d entries, %d files of %d bytes in all", entries, files, size)
+	if entries > maxListedEntries {
+		fmt.Fprintf(&b, ", the first %d listed", maxListedEntries)
+	}
+	if unsafe > 0 {
+		fmt.Fprintf(&b, "\n%d entries have absolute paths, or paths or links leaving the archive", unsafe)
+	}
+	return b.String(), nil
+}
+
+// entryPath returns where an entry goes when extracting into dest, failing
+// when its name is absolute or leaves dest
+func entryPath(dest, name string) (string, error) {
+	if name == "" || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("entry %q would be extracted outside the destination", name)
+	}
+	return filepath.Join(dest, filepath.FromSlash(name)), nil
+}
+
+// insideWorkspace fails when path, following the symlinks on its way, is
+// outside the working directory
+func insideWorkspace(path string) error {
+	return insideDir(".", path)
+}
+
+// insideDir fails when path, following the symlinks on its way, is outside
+// dir
+func insideDir(dir, path string) error {
+	root, err := resolvePath(dir)
+	if err != nil {
+		return err
+	}
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) && rel != "." {
+		if dir == "." {
+			return fmt.Errorf("%s is outside the workspace", path)
+		}
+		return fmt.Errorf("%s is outside %s", path, dir)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path path leads to. The part of it that
+// exists decides where the rest will go.
+func resolvePath(path string) (string, error) {
+	existing, rest := filepath.Clean(path), ""
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == "." || existing == filepath.Dir(existing) {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(resolved, rest))
+}
+
+// throughLink reports whether name, or a directory on its way, is one of
+// the symlinks an extraction created. Link targets are checked against the
+// entry's own directory, which such links move elsewhere.
+func throughLink(name string, links map[string]bool) bool {
+	for name = path.Clean(name); name != "." && name != "/"; name = path.Dir(name) {
+		if links[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeEntry fails for entries whose path, or whose link's target, is
+// absolute or leaves the directory the archive is extracted into
+func unsafeEntry(entry archiveEntry) error {
+	if _, err := entryPath(".", entry.Name); err != nil {
+		return err
+	}
+	if entry.Link == "" {
+		return nil
+	}
+	if entry.Hard {
+		if _, err := entryPath(".", entry.Link); err != nil {
+			return fmt.Errorf("link %s points outside the destination, to %s", entry.Name, entry.Link)
+		}
+		return nil
+	}
+	if path.IsAbs(entry.Link) || filepath.IsAbs(entry.Link) {
+		return fmt.Errorf("link %s points to the absolute path %s", entry.Name, entry.Link)
+	}
+	if _, err := entryPath(".", path.Join(path.Dir(path.Clean(entry.Name)), entry.Link)); err != nil {
+		return fmt.Errorf("link %s points outside the destination, to %s", entry.Name, entry.Link)
+	}
+	return nil
+}
+
+// extractableEntry fails for entries that would be written, or hard
+// linked to a file, outside dest, given the symlinks in links the
+// extraction has created so far
+func extractableEntry(dest string, entry archiveEntry, links map[string]bool) error {
+	if throughLink(path.Dir(entry.Name), links) {
+		return fmt.Errorf("entry %s is under a symlink of the archive", entry.Name)
+	}
+	target, _ := entryPath(dest, entry.Name)
+	if err := insideDir(dest, filepath.Dir(target)); err != nil {
+		return err
+	}
+	if entry.Hard {
+		if throughLink(entry.Link, links) {
+			return fmt.Errorf("link %s points to a symlink of the archive, %s", entry.Name, entry.Link)
+		}
+		linked, _ := entryPath(dest, entry.Link)
+		if err := insideDir(dest, linked); err != nil {
+			return fmt.Errorf("link %s: %w", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+// selectedEntry reports whether extract takes the entry: all of them when
+// files is empty, else those named and those under directories named
+func selectedEntry(name string, files []string) bool {
+	if len(files) == 0 {
+		return true
+	}
+	name = strings.TrimSuffix(path.Clean(name), "/")
+	for _, file := range files {
+		file = strings.TrimSuffix(path.Clean(filepath.ToSlash(file)), "/")
+		if name == file || strings.HasPrefix(name, file+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// extractArchive checks every selected entry before writing anything, so
+// an unsafe archive or a clash with existing files leaves the workspace as
+// it was. Entries are refused when they'd be written outside dest, through
+// symlinks already there or ones the archive creates.
+func extractArchive(ctx context.Context, archiveInput ArchiveInput) (string, error) {
+	dest := archiveInput.Destination
+	if dest == "" {
+		base := filepath.Base(archiveInput.Path)
+		for _, ext := range []string{".zip", ".tar.gz", ".tgz", ".tar"} {
+			if strings.HasSuffix(strings.ToLower(base), ext) {
+				base = base[:len(base)-len(ext)]
+				break
+			}
+		}
+		dest = filepath.Join(filepath.Dir(archiveInput.Path), base)
+	}
+	if filepath.IsAbs(dest) || !filepath.IsLocal(dest) {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("destination %s is outside the workspace", dest), "Extract into a relative directory of the workspace.")
+	}
+	if err := insideWorkspace(dest); err != nil {
+		return "", newToolError(errInvalidInput, err.Error(), "Extract into a directory of the workspace.")
+	}
+
+	var problems, existing []string
+	entries, matched := 0, 0
+	var size int64
+	links := make(map[string]bool)
+	err := walkArchive(archiveInput.Path, func(entry archiveEntry) error {
+		if entries%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entries++
+		if !selectedEntry(entry.Name, archiveInput.Files) {
+			return nil
+		}
+		matched++
+		if err := unsafeEntry(entry); err != nil {
+			problems = append(problems, err.Error())
+			return nil
+		}
+		if err := extractableEntry(dest, entry, links); err != nil {
+			problems = append(problems, err.Error())
+			return nil
+		}
+		if entry.Mode&fs.ModeSymlink != 0 && !entry.Hard {
+			links[path.Clean(entry.Name)] = true
+		}
+		target, _ := entryPath(dest, entry.Name)
+		if entry.Mode.IsRegular() && !entry.Hard {
+			size += entry.Size
+		}
+		if info, err := os.Lstat(target); err == nil && !(info.IsDir() && entry.Mode.IsDir()) {
+			existing = append(existing, filepath.ToSlash(target))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", archiveInput.Path, err)
+	}
+	switch {
+	case len(problems) > 0:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("refusing to extract %s: %s", archiveInput.Path, strings.Join(problems[:min(len(problems), 10)], "; ")), "Tell the user the archive is unsafe to extract.")
+	case matched == 0:
+		return "", newToolError(errNoMatch, "no entries match files", "List the archive to see its entries.")
+	case matched > maxArchiveEntries:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%d entries, more than the limit of %d", matched, maxArchiveEntries), "Extract part of it with files.")
+	case size > maxExtractBytes:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("the entries hold %d bytes, more than the limit of %d", size, maxExtractBytes), "Extract part of it with files.")
+	case len(existing) > 0 && !archiveInput.Overwrite:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%d files already exist, such as %s", len(existing), strings.Join(existing[:min(len(existing), 5)], ", ")), "Extract somewhere else, or set overwrite to replace them.")
+	}
+
+	var extracted []string
+	var written int64
+	clear(links)
+	err = walkArchive(archiveInput.Path, func(entry archiveEntry) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !selectedEntry(entry.Name, archiveInput.Files) {
+			return nil
+		}
+		// Checked again as the links it depends on are now on disk
+		if err := extractableEntry(dest, entry, links); err != nil {
+			return err
+		}
+		target, _ := entryPath(dest, entry.Name)
+		switch {
+		case entry.Mode.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case entry.Hard:
+			linked, _ := entryPath(dest, entry.Link)
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Link(linked, target)
+		case entry.Mode&fs.ModeSymlink != 0:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			links[path.Clean(entry.Name)] = true
+			return os.Symlink(filepath.FromSlash(entry.Link), target)
+		case !entry.Mode.IsRegular():
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		rc, err := entry.open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		fileVersions.beforeWrite(target)
+		// Replaced rather than truncated, so files hard linked to it keep
+		// their contents
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entry.Mode.Perm()|0o600)
+		if err != nil {
+			return err
+		}
+		// Sizes in the headers can lie, the bytes are counted as they come
+		n, err := io.CopyN(f, rc, maxExtractBytes-written+1)
+		written += n
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if written > maxExtractBytes {
+			return fmt.Errorf("more than %d bytes extracted, stopped at %s", maxExtractBytes, entry.Name)
+		}
+		extracted = append(extracted, filepath.ToSlash(target))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("extracting %s: %w", archiveInput.Path, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Extracted %d files, %d bytes, from %s into %s", len(extracted), written, archiveInput.Path, filepath.ToSlash(dest))
+	if len(existing) > 0 {
+		fmt.Fprintf(&b, ", replacing %d existing files", len(existing))
+	}
+	b.WriteString(":\n")
+	for _, file := range extracted[:min(len(extracted), maxExtractedShown)] {
+		b.WriteString(file + "\n")
+	}
+	if len(extracted) > maxExtractedShown {
+		fmt.Fprintf(&b, "and %d more\n", len(extracted)-maxExtractedShown)
+	}
+	return b.String(), nil
+}
+
+// archiveWriter adds files to a zip or tar archive
+type archiveWriter struct {
+	zw *zip.Writer
+	tw *tar.Writer
+}
+
+func (w *archiveWriter) add(name, file string, info fs.FileInfo) error {
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+	if info.IsDir() {
+		name += "/"
+	}
+	if w.zw != nil {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.Mode().IsRegular() {
+			header.Method = zip.Deflate
+		}
+		out, err := w.zw.CreateHeader(header)
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if link != "" {
+			_, err = io.WriteString(out, filepath.ToSlash(link))
+			return err
+		}
+		return copyFile(out, file)
+	}
+	header, err := tar.FileInfoHeader(info, filepath.ToSlash(link))
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	// Archives made here shouldn't carry the user's account names
+	header.Uname, header.Gname = "", ""
+	if err := w.tw.WriteHeader(header); err != nil || !info.Mode().IsRegular() {
+		return err
+	}
+	return copyFile(w.tw, file)
+}
+
+func copyFile(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func createArchive(ctx context.Context, archiveInput ArchiveInput) (string, error) {
+	out := archiveInput.Path
+	if filepath.IsAbs(out) || !filepath.IsLocal(out) {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s is outside the workspace", out), "Create the archive at a relative path of the workspace.")
+	}
+	if len(archiveInput.Files) == 0 {
+		return "", newToolError(errInvalidInput, "files is empty", "Pass the files and directories to add.")
+	}
+	if _, err := os.Stat(out); err == nil && !archiveInput.Overwrite {
+		return "", newToolError(errInvalidInput, out+" already exists", "Pick another path, or set overwrite to replace it.")
+	}
+
+	// Collected first, so a missing file fails before anything is written
+	type source struct {
+		name, file string
+		info       fs.FileInfo
+	}
+	var sources []source
+	var size int64
+	for _, file := range archiveInput.Files {
+		if filepath.IsAbs(file) || !filepath.IsLocal(file) {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("%s is outside the workspace", file), "")
+		}
+		info, err := os.Lstat(file)
+		if err != nil {
+			return "", err
+		}
+		sources = append(sources, source{filepath.ToSlash(filepath.Clean(file)), file, info})
+		if !info.IsDir() {
+			if info.Mode().IsRegular() {
+				size += info.Size()
+			}
+			continue
+		}
+		err = walkFiles(ctx, file, 0, func(relPath string, d fs.DirEntry) bool {
+			full := filepath.Join(file, relPath)
+			if filepath.Clean(full) == filepath.Clean(out) {
+				return true
+			}
+			entryInfo, err := os.Lstat(full)
+			if err != nil {
+				return true
+			}
+			sources = append(sources, source{filepath.ToSlash(filepath.Clean(full)), full, entryInfo})
+			if entryInfo.Mode().IsRegular() {
+				size += entryInfo.Size()
+			}
+			return len(sources) <= maxArchiveEntries
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(sources) > maxArchiveEntries {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("more than %d files to add", maxArchiveEntries), "Archive less at once.")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(out), ".archive-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	var w archiveWriter
+	var gz *gzip.Writer
+	switch archiveFormat(out) {
+	case "zip":
+		w.zw = zip.NewWriter(f)
+	case "tar.gz":
+		gz = gzip.NewWriter(f)
+		w.tw = tar.NewWriter(gz)
+	default:
+		w.tw = tar.NewWriter(f)
+	}
+	err = func() error {
+		for _, src := range sources {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := w.add(src.name, src.file, src.info); err != nil {
+				return fmt.Errorf("%s: %w", src.file, err)
+			}
+		}
+		if w.zw != nil {
+			return w.zw.Close()
+		}
+		if err := w.tw.Close(); err != nil {
+			return err
+		}
+		if gz != nil {
+			return gz.Close()
+		}
+		return nil
+	}()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	fileVersions.beforeWrite(out)
+	if err := os.Rename(f.Name(), out); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Created %s with %d entries, %d bytes packed into %d", out, len(sources), size, info.Size()), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Git Bisect Tool
+var GitBisectDefinition = ToolDefinition{
+	Name:        "git_bisect",
+	Description: "Find the commit that introduced a regression by running git bisect with a test command between a commit where it passes and one where it fails. The command should exit 0 when the code is good, 1 to 124 when it's bad, and 125 when a commit can't be tested. Bisecting happens in a temporary worktree, so the working copy isn't touched, but untracked files such as installed dependencies aren't there: include setup steps in the command, e.g. \"npm ci && npm test\". Returns the culprit commit with its diff; explain what in it broke things and propose a fix.",
+	InputSchema: GenerateSchema[GitBisectInput](),
+	Kind:        ToolExecute,
+	Commands:    gitBisectCommands,
+	Function:    GitBisect,
+}
+
+type GitBisectInput struct {
+	Good    string `json:"good" jsonschema_description:"A commit, tag or branch where the command passes, e.g. v1.4.0 or HEAD~50." jsonschema:"required"`
+	Bad     string `json:"bad,omitempty" jsonschema_description:"A commit where the command fails, HEAD when empty."`
+	Command string `json:"command" jsonschema_description:"The shell command that tells good from bad, run at the top of the repository, e.g. \"go test ./store -run TestExpiry\"." jsonschema:"required"`
+}
+
+// gitBisectCommands returns the command a bisection runs at each step, see
+// Commands
+func gitBisectCommands(input json.RawMessage) []string {
+	var bisectInput GitBisectInput
+	if err := json.Unmarshal(input, &bisectInput); err != nil {
+		return nil
+	}
+	return []string{bisectInput.Command}
+}
+
+// firstBadCommit matches git bisect's verdict
+var firstBadCommit = regexp.MustCompile(`(?m)^([0-9a-f]{40}) is the first bad commit`)
+
+func GitBisect(ctx context.Context, input json.RawMessage) (string, error) {
+	bisectInput := GitBisectInput{}
+	if err := json.Unmarshal(input, &bisectInput); err != nil {
+		return "", err
+	}
+	if bisectInput.Good == "" || strings.TrimSpace(bisectInput.Command) == "" {
+		return "", newToolError(errInvalidInput, "good and command are required", "Pass a commit where the command passes and the command telling good from bad.")
+	}
+	if bisectInput.Bad == "" {
+		bisectInput.Bad = "HEAD"
+	}
+	var good, bad string
+	for _, ref := range []struct{ name, rev string }{{"good", bisectInput.Good}, {"bad", bisectInput.Bad}} {
+		commit, err := gitOutput(ctx, "rev-parse", "--verify", "--quiet", ref.rev+"^{commit}")
+		if err != nil {
+			return "", newToolError(errNotFound, fmt.Sprintf("%s %q isn't a commit", ref.name, ref.rev), "Use a commit hash, tag or branch; git_history's log lists commits.")
+		}
+		if ref.name == "good" {
+			good = commit
+		} else {
+			bad = commit
+		}
+	}
+	if _, err := gitOutput(ctx, "merge-base", "--is-ancestor", good, bad); err != nil {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s isn't an ancestor of %s", bisectInput.Good, bisectInput.Bad), "Pass a good commit from earlier in the history of the bad one.")
+	}
+
+	top, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "codegent-bisect-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := gitIn(ctx, top, "worktree", "add", "--detach", dir, bad); err != nil {
+		return "", err
+	}
+	defer gitIn(context.WithoutCancel(ctx), top, "worktree", "remove", "--force", dir)
+
+	// The command must tell the ends apart, or bisecting finds nothing
+	progress := toolOutput(ctx)
+	badOutput, passed, err := testCommit(ctx, dir, bad, bisectInput.Command, progress)
+	if err != nil {
+		return "", err
+	}
+	if passed {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("the command passes at %s, so it doesn't show the regression:\n%s", bisectInput.Bad, summarizeOutput(badOutput)), "Use a command that fails where the regression is, such as the failing test.")
+	}
+	goodOutput, passed, err := testCommit(ctx, dir, good, bisectInput.Command, progress)
+	if err != nil {
+		return "", err
+	}
+	if !passed {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("the command fails at %s as well:\n%s", bisectInput.Good, summarizeOutput(goodOutput)), "Pick an older good commit, or check that the command can pass at all there.")
+	}
+
+	if _, err := gitIn(ctx, dir, "bisect", "start", bad, good); err != nil {
+		return "", err
+	}
+	shell := shellCommand(ctx, bisectInput.Command)
+	run := exec.CommandContext(ctx, "git", append([]string{"bisect", "run"}, shell.Args...)...)
+	run.Dir = dir
+	var output bytes.Buffer
+	run.Stdout = io.MultiWriter(&output, progress)
+	run.Stderr = run.Stdout
+	runErr := run.Run()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	log, _ := gitIn(ctx, dir, "bisect", "log")
+	steps := strings.Count(log, "\ngit bisect good") + strings.Count(log, "\ngit bisect bad") + strings.Count(log, "\ngit bisect skip")
+
+	m := firstBadCommit.FindStringSubmatch(output.String())
+	if m == nil {
+		var exitErr *exec.ExitError
+		if runErr != nil && !errors.As(runErr, &exitErr) {
+			return "", runErr
+		}
+		return "", newToolError(errFailed, "git bisect didn't find a single culprit:\n"+summarizeOutput(output.String()), "If commits were skipped, the culprit is one of those listed; otherwise check the command.")
+	}
+	culprit := m[1]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bisected %s..%s in %d steps. The first bad commit:\n\n", bisectInput.Good, bisectInput.Bad, steps)
+	summary, _ := gitOutput(ctx, "show", "--stat", "--format=commit %H%nAuthor: %an <%ae>%nDate:   %ad%n%n%w(0,4,4)%B", culprit)
+	b.WriteString(summary + "\n\n")
+	diff, _ := gitOutput(ctx, "show", "--format=", "--no-color", culprit)
+	b.WriteString(truncateOutput(diff, 4000))
+	if strings.TrimSpace(badOutput) != "" {
+		fmt.Fprintf(&b, "\n\nThe command's output at %s:\n%s", bisectInput.Bad, summarizeOutput(badOutput))
+	}
+	return b.String(), nil
+}
+
+// testCommit checks out commit in the worktree and runs the command there,
+// returning its output and whether it passed. Exit status 125, which git
+// bisect takes as untestable, is an error at the ends.
+func testCommit(ctx context.Context, dir, commit, command string, progress io.Writer) (string, bool, error) {
+	if _, err := gitIn(ctx, dir, "checkout", "-q", "--detach", commit); err != nil {
+		return "", false, err
+	}
+	var output bytes.Buffer
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = dir
+	cmd.Stdout = io.MultiWriter(&output, progress)
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", false, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return output.String(), true, nil
+	case !errors.As(err, &exitErr):
+		return "", false, err
+	case exitErr.ExitCode() == 125:
+		return "", false, newToolError(errInvalidInput, fmt.Sprintf("the command can't test %.8s, it exited with 125", commit), "Use an end where the command runs.")
+	}
+	return output.String(), false, nil
+}
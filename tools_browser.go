@@ -0,0 +1,767 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/genai"
+)
+
+// Default viewport of the browser, a common laptop screen
+const (
+	browserWidth  = 1280
+	browserHeight = 800
+	// maxPageText bounds the text action's result
+	maxPageText = 20000
+)
+
+// Browser Tool
+var BrowserDefinition = ToolDefinition{
+	Name:          "browser",
+	Description:   "Drive a headless Chrome to check that the web frontend you changed renders and behaves as intended: navigate to a page such as the dev server's, click elements, type into inputs, press keys, wait for an element, read the page's text, run JavaScript and take screenshots you can look at. The page stays open between calls. Every result gives the page's URL and title and the console errors, uncaught exceptions and failed requests since the previous call. Pick elements by CSS selector, or as text=Sign in for the element showing that text. The dev server must already be running; ask the user to start it if the page doesn't load.",
+	InputSchema:   GenerateSchema[BrowserInput](),
+	Kind:          ToolExecute,
+	KindOf:        browserKind,
+	MediaFunction: Browser,
+}
+
+type BrowserInput struct {
+	Action   string `json:"action" jsonschema_description:"What to do: navigate, click, type, press, wait, text, screenshot or evaluate." jsonschema:"required,enum=navigate,enum=click,enum=type,enum=press,enum=wait,enum=text,enum=screenshot,enum=evaluate"`
+	URL      string `json:"url,omitempty" jsonschema_description:"navigate: the URL to load, e.g. http://localhost:3000/login."`
+	Selector string `json:"selector,omitempty" jsonschema_description:"click, type, wait and text: a CSS selector such as #email, or text=Sign in. text reads the whole page without one."`
+	Value    string `json:"value,omitempty" jsonschema_description:"type: the text to type. press: a key such as Enter, Tab, Escape or ArrowDown. evaluate: a JavaScript expression, whose value is returned; promises are awaited."`
+	FullPage bool   `json:"full_page,omitempty" jsonschema_description:"screenshot: capture the whole page instead of the viewport."`
+	Width    int    `json:"width,omitempty" jsonschema_description:"navigate: the viewport width in CSS pixels, 1280 when 0. Use e.g. 390 to check the mobile layout."`
+	Height   int    `json:"height,omitempty" jsonschema_description:"navigate: the viewport height, 800 when 0."`
+}
+
+// browserKind counts looking at the page and loading local pages as reads.
+// Clicking, typing and scripts can submit forms and change data, and
+// loading other sites goes out to the network, so those are commands, see
+// KindOf.
+func browserKind(input json.RawMessage) ToolKind {
+	var browserInput BrowserInput
+	if err := json.Unmarshal(input, &browserInput); err != nil {
+		return ToolExecute
+	}
+	switch browserInput.Action {
+	case "wait", "text", "screenshot":
+		return ToolRead
+	case "navigate":
+		if isLocalURL(browserInput.URL) {
+			return ToolRead
+		}
+	}
+	return ToolExecute
+}
+
+// isLocalURL reports whether a URL points at this machine
+func isLocalURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "file" {
+		return true
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1", "0.0.0.0":
+		return true
+	}
+	return strings.HasSuffix(u.Hostname(), ".localhost")
+}
+
+// browserKeys are the keys press knows, with their key code
+var browserKeys = map[string]int{
+	"Enter": 13, "Tab": 9, "Escape": 27, "Backspace": 8, "Delete": 46, "Space": 32,
+	"ArrowUp": 38, "ArrowDown": 40, "ArrowLeft": 37, "ArrowRight": 39,
+	"Home": 36, "End": 35, "PageUp": 33, "PageDown": 34,
+}
+
+func Browser(ctx context.Context, input json.RawMessage) (string, *genai.Blob, error) {
+	browserInput := BrowserInput{}
+	if err := json.Unmarshal(input, &browserInput); err != nil {
+		return "", nil, err
+	}
+	page, err := launchBrowser(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var result string
+	var blob *genai.Blob
+	switch browserInput.Action {
+	case "navigate":
+		if browserInput.URL == "" {
+			return "", nil, newToolError(errInvalidInput, "url is required", "Pass the page to load, e.g. http://localhost:3000.")
+		}
+		err = page.navigate(ctx, browserInput.URL, browserInput.Width, browserInput.Height)
+	case "click":
+		err = page.click(ctx, browserInput.Selector)
+	case "type":
+		err = page.typeText(ctx, browserInput.Selector, browserInput.Value)
+	case "press":
+		err = page.press(ctx, browserInput.Value)
+	case "wait":
+		err = page.waitFor(ctx, browserInput.Selector)
+	case "text":
+		result, err = page.text(ctx, browserInput.Selector)
+	case "screenshot":
+		var data []byte
+		if data, err = page.screenshot(ctx, browserInput.FullPage); err == nil {
+			blob = &genai.Blob{MIMEType: "image/png", Data: data}
+			result = "Screenshot attached"
+		}
+	case "evaluate":
+		result, err = page.evaluate(ctx, browserInput.Value)
+	default:
+		return "", nil, newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", browserInput.Action), "Use navigate, click, type, press, wait, text, screenshot or evaluate.")
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return page.report(ctx, result), blob, nil
+}
+
+// browserPage is the page of the headless browser the tool drives, over
+// the Chrome DevTools Protocol. It is started by the first call and stays
+// open until closeBrowser.
+type browserPage struct {
+	cmd     *exec.Cmd
+	dataDir string
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan cdpMessage
+	// Console errors, exceptions and failed requests not yet reported
+	problems []string
+	closed   error
+}
+
+// cdpMessage is a command response or an event of the DevTools Protocol
+type cdpMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+var (
+	browserMu sync.Mutex
+	browser   *browserPage
+)
+
+// devToolsURL is the line Chrome prints once it listens for the protocol
+var devToolsURL = regexp.MustCompile(`DevTools listening on (ws://[^\s]+)`)
+
+// launchBrowser returns the open page, starting Chrome for the first call
+func launchBrowser(ctx context.Context) (*browserPage, error) {
+	browserMu.Lock()
+	defer browserMu.Unlock()
+	if browser != nil {
+		browser.mu.Lock()
+		closed := browser.closed
+		browser.mu.Unlock()
+		if closed == nil {
+			return browser, nil
+		}
+		browser.close()
+		browser = nil
+	}
+
+	binary := findBrowser()
+	if binary == "" {
+		return nil, &toolError{Code: errFailed, Message: "no Chrome, Chromium or Edge found", Suggestion: "Tell the user to install Chrome or set CODEGENT_BROWSER to its binary."}
+	}
+	dataDir, err := os.MkdirTemp("", "codegent-browser")
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"--headless=new", "--remote-debugging-port=0", "--user-data-dir=" + dataDir,
+		"--no-first-run", "--no-default-browser-check", "--disable-extensions", "--hide-scrollbars", "--mute-audio",
+		fmt.Sprintf("--window-size=%d,%d", browserWidth, browserHeight), "about:blank",
+	}
+	// Chrome refuses to run as root with its sandbox, as in containers
+	if runtime.GOOS == "linux" && os.Geteuid() == 0 {
+		args = append(args, "--no-sandbox")
+	}
+	// Not tied to ctx, the browser outlives the call that starts it
+	cmd := exec.Command(binary, args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	page := &browserPage{cmd: cmd, dataDir: dataDir, pending: make(map[int]chan cdpMessage)}
+
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if m := devToolsURL.FindStringSubmatch(scanner.Text()); m != nil {
+				found <- m[1]
+				break
+			}
+		}
+		close(found)
+		io.Copy(io.Discard, stderr)
+	}()
+	var browserURL string
+	select {
+	case browserURL = <-found:
+	case <-time.After(30 * time.Second):
+	case <-ctx.Done():
+	}
+	if browserURL == "" {
+		page.close()
+		return nil, &toolError{Code: errFailed, Message: binary + " didn't start", Suggestion: "Tell the user the headless browser failed to start."}
+	}
+	if err := page.connect(ctx, browserURL); err != nil {
+		page.close()
+		return nil, fmt.Errorf("failed to connect to the browser: %w", err)
+	}
+	browser = page
+	return page, nil
+}
+
+// findBrowser looks for Chrome, Chromium or Edge, CODEGENT_BROWSER first
+// unless a project's .env sets it
+func findBrowser() string {
+	if path := userEnvOr("CODEGENT_BROWSER", ""); path != "" {
+		return path
+	}
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser", "chrome", "msedge", "microsoft-edge"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	for _, path := range []string{
+		"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+		"/Applications/Chromium.app/Contents/MacOS/Chromium",
+		`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+		`C:\Program Files (x86)\Microsoft\Edge\Application\msedge.exe`,
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// connect opens a page target next to the browser endpoint and attaches to
+// it, with the events the report needs enabled
+func (p *browserPage) connect(ctx context.Context, browserURL string) error {
+	u, err := url.Parse(browserURL)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://"+u.Host+"/json/new?about:blank", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var target struct {
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&target); err != nil {
+		return err
+	}
+	p.conn, _, err = websocket.DefaultDialer.DialContext(ctx, target.WebSocketDebuggerURL, nil)
+	if err != nil {
+		return err
+	}
+	go p.readMessages()
+	for _, method := range []string{"Page.enable", "Runtime.enable", "Network.enable"} {
+		if _, err := p.call(ctx, method, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMessages hands responses to their callers and collects the problems
+// events report, until the connection closes
+func (p *browserPage) readMessages() {
+	// Requests by ID, to name the URL of a failed one
+	requests := make(map[string]string)
+	for {
+		var msg cdpMessage
+		if err := p.conn.ReadJSON(&msg); err != nil {
+			p.mu.Lock()
+			p.closed = fmt.Errorf("the browser connection closed: %w", err)
+			for id, ch := range p.pending {
+				close(ch)
+				delete(p.pending, id)
+			}
+			p.mu.Unlock()
+			return
+		}
+		if msg.ID != 0 {
+			p.mu.Lock()
+			if ch, ok := p.pending[msg.ID]; ok {
+				ch <- msg
+				delete(p.pending, msg.ID)
+			}
+			p.mu.Unlock()
+			continue
+		}
+		if problem := describeBrowserEvent(msg, requests); problem != "" {
+			p.mu.Lock()
+			p.problems = append(p.problems, problem)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// describeBrowserEvent describes an event that points at a problem with
+// the page: console errors, uncaught exceptions and failed requests
+func describeBrowserEvent(msg cdpMessage, requests map[string]string) string {
+	switch msg.Method {
+	case "Runtime.consoleAPICalled":
+		var params struct {
+			Type string `json:"type"`
+			Args []struct {
+				Value       any    `json:"value"`
+				Description string `json:"description"`
+			} `json:"args"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil || (params.Type != "error" && params.Type != "assert") {
+			return ""
+		}
+		var parts []string
+		for _, arg := range params.Args {
+			if arg.Value != nil {
+				parts = append(parts, fmt.Sprint(arg.Value))
+			} else {
+				parts = append(parts, arg.Description)
+			}
+		}
+		return "console error: " + strings.Join(parts, " ")
+	case "Runtime.exceptionThrown":
+		var params struct {
+			ExceptionDetails struct {
+				Text      string `json:"text"`
+				Exception struct {
+					Description string `json:"description"`
+				} `json:"exception"`
+			} `json:"exceptionDetails"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return ""
+		}
+		details := params.ExceptionDetails
+		return "uncaught exception: " + cmp.Or(details.Exception.Description, details.Text)
+	case "Network.requestWillBeSent":
+		var params struct {
+			RequestID string `json:"requestId"`
+			Request   struct {
+				Method string `json:"method"`
+				URL    string `json:"url"`
+			} `json:"request"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			requests[params.RequestID] = params.Request.Method + " " + params.Request.URL
+		}
+	case "Network.responseReceived":
+		var params struct {
+			RequestID string `json:"requestId"`
+			Response  struct {
+				Status     int    `json:"status"`
+				StatusText string `json:"statusText"`
+			} `json:"response"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil && params.Response.Status >= 400 {
+			return fmt.Sprintf("request failed: %s: %d %s", requests[params.RequestID], params.Response.Status, params.Response.StatusText)
+		}
+	case "Network.loadingFailed":
+		var params struct {
+			RequestID string `json:"requestId"`
+			ErrorText string `json:"errorText"`
+			Canceled  bool   `json:"canceled"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil && !params.Canceled {
+			return fmt.Sprintf("request failed: %s: %s", requests[params.RequestID], params.ErrorText)
+		}
+	}
+	return ""
+}
+
+// call sends a command and waits for its result
+func (p *browserPage) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	p.mu.Lock()
+	if p.closed != nil {
+		p.mu.Unlock()
+		return nil, p.closed
+	}
+	p.nextID++
+	id := p.nextID
+	ch := make(chan cdpMessage, 1)
+	p.pending[id] = ch
+	p.mu.Unlock()
+
+	if params == nil {
+		params = struct{}{}
+	}
+	p.writeMu.Lock()
+	err := p.conn.WriteJSON(map[string]any{"id": id, "method": method, "params": params})
+	p.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return nil, p.closed
+		}
+		if msg.Error != nil {
+			return nil, fmt.Errorf("%s: %s", method, msg.Error.Message)
+		}
+		return msg.Result, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// evaluateValue runs a JavaScript expression in the page and decodes its
+// value into v, awaiting promises
+func (p *browserPage) evaluateValue(ctx context.Context, expression string, v any) error {
+	result, err := p.call(ctx, "Runtime.evaluate", map[string]any{
+		"expression": expression, "returnByValue": true, "awaitPromise": true,
+	})
+	if err != nil {
+		return err
+	}
+	var evaluated struct {
+		Result struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text      string `json:"text"`
+			Exception struct {
+				Description string `json:"description"`
+			} `json:"exception"`
+		} `json:"exceptionDetails"`
+	}
+	if err := json.Unmarshal(result, &evaluated); err != nil {
+		return err
+	}
+	if details := evaluated.ExceptionDetails; details != nil {
+		return newToolError(errFailed, "the script threw: "+cmp.Or(details.Exception.Description, details.Text), "")
+	}
+	if v == nil || len(evaluated.Result.Value) == 0 {
+		return nil
+	}
+	return json.Unmarshal(evaluated.Result.Value, v)
+}
+
+// waitLoaded waits for the document to finish loading
+func (p *browserPage) waitLoaded(ctx context.Context) error {
+	for {
+		var state string
+		if err := p.evaluateValue(ctx, "document.readyState", &state); err != nil {
+			return err
+		}
+		if state == "complete" {
+			return nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *browserPage) navigate(ctx context.Context, pageURL string, width, height int) error {
+	if _, err := p.call(ctx, "Emulation.setDeviceMetricsOverride", map[string]any{
+		"width": cmp.Or(width, browserWidth), "height": cmp.Or(height, browserHeight), "deviceScaleFactor": 1, "mobile": width > 0 && width < 768,
+	}); err != nil {
+		return err
+	}
+	result, err := p.call(ctx, "Page.navigate", map[string]any{"url": pageURL})
+	if err != nil {
+		return err
+	}
+	var navigated struct {
+		ErrorText string `json:"errorText"`
+	}
+	json.Unmarshal(result, &navigated)
+	if navigated.ErrorText != "" {
+		return newToolError(errFailed, fmt.Sprintf("%s didn't load: %s", pageURL, navigated.ErrorText), "Check that the server is running, or ask the user to start it.")
+	}
+	return p.waitLoaded(ctx)
+}
+
+// findElementScript returns JavaScript that finds the element selector
+// picks, a CSS selector or text=..., for the element showing that text
+// with the fewest descendants
+func findElementScript(selector string) string {
+	quoted, _ := json.Marshal(selector)
+	return fmt.Sprintf(`(() => {
+	const selector = %s;
+	if (!selector.startsWith("text=")) return document.querySelector(selector);
+	const text = selector.slice(5).trim().toLowerCase();
+	let found = null;
+	for (const el of document.body.querySelectorAll("*")) {
+		if (el.offsetParent === null && el.tagName !== "BODY") continue;
+		if (!(el.innerText || el.value || "").trim().toLowerCase().includes(text)) continue;
+		if (!found || found.contains(el)) found = el;
+	}
+	return found;
+})()`, quoted)
+}
+
+// elementCenter scrolls the element into view and returns its center
+func (p *browserPage) elementCenter(ctx context.Context, selector string) (float64, float64, error) {
+	if selector == "" {
+		return 0, 0, newToolError(errInvalidInput, "selector is required", "Pass a CSS selector or text=... for the element.")
+	}
+	var center *struct{ X, Y float64 }
+	err := p.evaluateValue(ctx, fmt.Sprintf(`(() => {
+	const el = %s;
+	if (!el) return null;
+	el.scrollIntoView({block: "center", inline: "center"});
+	const r = el.getBoundingClientRect();
+	return {X: r.left + r.width / 2, Y: r.top + r.height / 2};
+})()`, findElementScript(selector)), &center)
+	if err != nil {
+		return 0, 0, err
+	}
+	if center == nil {
+		return 0, 0, newToolError(errNotFound, fmt.Sprintf("no element matches %s", selector), "Look at the page with the text or screenshot action and pick another selector.")
+	}
+	return center.X, center.Y, nil
+}
+
+func (p *browserPage) click(ctx context.Context, selector string) error {
+	x, y, err := p.elementCenter(ctx, selector)
+	if err != nil {
+		return err
+	}
+	for _, event := range []string{"mouseMoved", "mousePressed", "mouseReleased"} {
+		params := map[string]any{"type": event, "x": x, "y": y, "button": "left", "clickCount": 1}
+		if event == "mouseMoved" {
+			params["button"] = "none"
+		}
+		if _, err := p.call(ctx, "Input.dispatchMouseEvent", params); err != nil {
+			return err
+		}
+	}
+	return p.settle(ctx)
+}
+
+func (p *browserPage) typeText(ctx context.Context, selector, text string) error {
+	if _, _, err := p.elementCenter(ctx, selector); err != nil {
+		return err
+	}
+	if err := p.evaluateValue(ctx, fmt.Sprintf(`(() => { const el = %s; el.focus(); if ("select" in el) el.select(); })()`, findElementScript(selector)), nil); err != nil {
+		return err
+	}
+	if _, err := p.call(ctx, "Input.insertText", map[string]any{"text": text}); err != nil {
+		return err
+	}
+	return p.settle(ctx)
+}
+
+func (p *browserPage) press(ctx context.Context, key string) error {
+	code, ok := browserKeys[key]
+	if !ok {
+		return newToolError(errInvalidInput, fmt.Sprintf("unknown key %q", key), "Use Enter, Tab, Escape, Backspace, Delete, Space, an arrow key, Home, End, PageUp or PageDown.")
+	}
+	name, text := key, ""
+	switch key {
+	case "Enter":
+		text = "\r"
+	case "Space":
+		name, text = " ", " "
+	}
+	for _, event := range []string{"keyDown", "keyUp"} {
+		params := map[string]any{"type": event, "key": name, "code": key, "windowsVirtualKeyCode": code}
+		if event == "keyDown" && text != "" {
+			params["text"] = text
+		}
+		if _, err := p.call(ctx, "Input.dispatchKeyEvent", params); err != nil {
+			return err
+		}
+	}
+	return p.settle(ctx)
+}
+
+// settle gives the page a moment to react to input, and waits for a
+// navigation it started to load
+func (p *browserPage) settle(ctx context.Context) error {
+	select {
+	case <-time.After(300 * time.Millisecond):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.waitLoaded(ctx)
+}
+
+// waitFor waits until an element matches selector, until the call times
+// out
+func (p *browserPage) waitFor(ctx context.Context, selector string) error {
+	if selector == "" {
+		return newToolError(errInvalidInput, "selector is required", "Pass the CSS selector or text=... to wait for.")
+	}
+	for {
+		var found bool
+		if err := p.evaluateValue(ctx, findElementScript(selector)+" !== null", &found); err != nil {
+			return err
+		}
+		if found {
+			return nil
+		}
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return newToolError(errTimeout, fmt.Sprintf("%s didn't appear", selector), "Look at the page with the text or screenshot action.")
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *browserPage) text(ctx context.Context, selector string) (string, error) {
+	var text *string
+	err := p.evaluateValue(ctx, fmt.Sprintf(`(() => { const el = %s; return el ? (el.innerText ?? el.textContent) : null; })()`, findElementScript(cmp.Or(selector, "body"))), &text)
+	if err != nil {
+		return "", err
+	}
+	if text == nil {
+		return "", newToolError(errNotFound, fmt.Sprintf("no element matches %s", selector), "Leave out selector to read the whole page.")
+	}
+	return truncateRunes(*text, maxPageText), nil
+}
+
+func (p *browserPage) screenshot(ctx context.Context, fullPage bool) ([]byte, error) {
+	params := map[string]any{"format": "png"}
+	if fullPage {
+		result, err := p.call(ctx, "Page.getLayoutMetrics", nil)
+		if err != nil {
+			return nil, err
+		}
+		var metrics struct {
+			CSSContentSize struct {
+				Width, Height float64
+			} `json:"cssContentSize"`
+		}
+		if err := json.Unmarshal(result, &metrics); err != nil {
+			return nil, err
+		}
+		params["captureBeyondViewport"] = true
+		params["clip"] = map[string]any{"x": 0, "y": 0, "width": metrics.CSSContentSize.Width, "height": metrics.CSSContentSize.Height, "scale": 1}
+	}
+	result, err := p.call(ctx, "Page.captureScreenshot", params)
+	if err != nil {
+		return nil, err
+	}
+	var captured struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(result, &captured); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(captured.Data)
+}
+
+func (p *browserPage) evaluate(ctx context.Context, expression string) (string, error) {
+	if expression == "" {
+		return "", newToolError(errInvalidInput, "value is required", "Pass the JavaScript expression to evaluate.")
+	}
+	var value any
+	if err := p.evaluateValue(ctx, expression, &value); err != nil {
+		return "", err
+	}
+	if value == nil {
+		return "undefined", nil
+	}
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return truncateRunes(string(data), maxPageText), nil
+}
+
+// report puts the page's URL and title and the problems since the previous
+// call around the result of an action
+func (p *browserPage) report(ctx context.Context, result string) string {
+	var location struct{ URL, Title string }
+	p.evaluateValue(ctx, "({URL: location.href, Title: document.title})", &location)
+	p.mu.Lock()
+	problems := p.problems
+	p.problems = nil
+	p.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Page: %s", location.URL)
+	if location.Title != "" {
+		fmt.Fprintf(&b, " (%s)", location.Title)
+	}
+	b.WriteString("\n")
+	if len(problems) > 0 {
+		b.WriteString("Problems:\n")
+		for _, problem := range problems {
+			fmt.Fprintf(&b, "  %s\n", truncateRunes(problem, 500))
+		}
+	}
+	if result != "" {
+		fmt.Fprintf(&b, "\n%s", result)
+	}
+	return b.String()
+}
+
+func (p *browserPage) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+	}
+	os.RemoveAll(p.dataDir)
+}
+
+// closeBrowser stops the headless browser, if a browser call started one
+func closeBrowser() {
+	browserMu.Lock()
+	defer browserMu.Unlock()
+	if browser != nil {
+		browser.close()
+		browser = nil
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxChecksumFiles bounds how many files one file_checksum call hashes
+const maxChecksumFiles = 10000
+
+// checksumAlgorithms are the hashes file_checksum computes, by name
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// File Checksum Tool
+var FileChecksumDefinition = ToolDefinition{
+	Name:        "file_checksum",
+	Description: "Compute the SHA-256, SHA-512, SHA-1 or MD5 checksum of files, listed as sha256sum prints them. Give expected to verify a download against its published checksum, or set duplicates to find files with identical contents under directories, such as the same library vendored twice. Directories are hashed file by file.",
+	InputSchema: GenerateSchema[FileChecksumInput](),
+	Kind:        ToolRead,
+	Function:    FileChecksum,
+}
+
+type FileChecksumInput struct {
+	Paths      []string `json:"paths,omitempty" jsonschema_description:"Files or directories to hash. Either paths or glob is required."`
+	Glob       string   `json:"glob,omitempty" jsonschema_description:"A glob relative to the working directory, such as 'vendor/**/*.js', selecting the files to hash. ** matches any number of directories."`
+	Algorithm  string   `json:"algorithm,omitempty" jsonschema_description:"sha256 when empty." jsonschema:"enum=sha256,enum=sha512,enum=sha1,enum=md5"`
+	Expected   string   `json:"expected,omitempty" jsonschema_description:"The checksum a single file should have, in hex, to verify it. An algorithm prefix such as \"sha256:\" is accepted."`
+	Duplicates bool     `json:"duplicates,omitempty" jsonschema_description:"List only the groups of files with identical contents."`
+}
+
+// checksumFiles expands the paths and glob into the regular files they
+// name, in order and without repeats
+func checksumFiles(ctx context.Context, paths []string, glob string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) bool {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+		return len(files) <= maxChecksumFiles
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			add(path)
+			continue
+		}
+		err = walkFiles(ctx, path, 0, func(relPath string, d fs.DirEntry) bool {
+			return !d.Type().IsRegular() || add(filepath.Join(path, relPath))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if glob != "" {
+		err := walkFiles(ctx, ".", 0, func(relPath string, d fs.DirEntry) bool {
+			return !d.Type().IsRegular() || !matchGlob(glob, filepath.ToSlash(relPath)) || add(relPath)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) > maxChecksumFiles {
+		return nil, newToolError(errInvalidInput, fmt.Sprintf("more than %d files to hash", maxChecksumFiles), "Narrow the paths or the glob.")
+	}
+	return files, nil
+}
+
+// fileHash hashes a file's contents, reading it in pieces
+func fileHash(path string, newHash func() hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func FileChecksum(ctx context.Context, input json.RawMessage) (string, error) {
+	checksumInput := FileChecksumInput{}
+	if err := json.Unmarshal(input, &checksumInput); err != nil {
+		return "", err
+	}
+	algorithm := strings.ToLower(cmp.Or(checksumInput.Algorithm, "sha256"))
+	expected := strings.ToLower(strings.TrimSpace(checksumInput.Expected))
+	if prefix, sum, ok := strings.Cut(expected, ":"); ok {
+		algorithm, expected = prefix, sum
+	}
+	newHash, ok := checksumAlgorithms[algorithm]
+	if !ok {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("unknown algorithm %q", algorithm), "Use sha256, sha512, sha1 or md5.")
+	}
+	if len(checksumInput.Paths) == 0 && checksumInput.Glob == "" {
+		return "", newToolError(errInvalidInput, "either paths or glob is required", "")
+	}
+	files, err := checksumFiles(ctx, checksumInput.Paths, checksumInput.Glob)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", newToolError(errNoMatch, "no files to hash", "Check the glob with list_files.")
+	}
+
+	if expected != "" {
+		if len(files) != 1 {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("expected verifies one file, the paths name %d", len(files)), "Give the path of the one file to verify.")
+		}
+		sum, err := fileHash(files[0], newHash)
+		if err != nil {
+			return "", err
+		}
+		if sum != expected {
+			return fmt.Sprintf("MISMATCH: %s has %s %s, not the expected %s", files[0], algorithm, sum, expected), nil
+		}
+		return fmt.Sprintf("OK: %s matches %s %s", files[0], algorithm, sum), nil
+	}
+	if checksumInput.Duplicates {
+		return duplicateFiles(ctx, files, algorithm, newHash)
+	}
+
+	var b strings.Builder
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		sum, err := fileHash(file, newHash)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s  %s\n", sum, filepath.ToSlash(file))
+	}
+	return b.String(), nil
+}
+
+// duplicateFiles groups the files with identical contents. Only files the
+// same size as another are hashed.
+func duplicateFiles(ctx context.Context, files []string, algorithm string, newHash func() hash.Hash) (string, error) {
+	bySize := make(map[int64][]string)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		if info.Size() > 0 {
+			bySize[info.Size()] = append(bySize[info.Size()], file)
+		}
+	}
+	byHash := make(map[string][]string)
+	sizes := make(map[string]int64)
+	for size, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+		for _, file := range group {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			sum, err := fileHash(file, newHash)
+			if err != nil {
+				return "", err
+			}
+			byHash[sum] = append(byHash[sum], file)
+			sizes[sum] = size
+		}
+	}
+
+	var sums []string
+	for sum, group := range byHash {
+		if len(group) > 1 {
+			sums = append(sums, sum)
+		}
+	}
+	if len(sums) == 0 {
+		return fmt.Sprintf("No duplicates among %d files", len(files)), nil
+	}
+	// The groups wasting the most space first
+	slices.SortFunc(sums, func(a, b string) int {
+		return cmp.Or(cmp.Compare(sizes[b]*int64(len(byHash[b])-1), sizes[a]*int64(len(byHash[a])-1)), strings.Compare(a, b))
+	})
+	var b strings.Builder
+	var wasted int64
+	for _, sum := range sums {
+		group := byHash[sum]
+		slices.Sort(group)
+		wasted += sizes[sum] * int64(len(group)-1)
+		fmt.Fprintf(&b, "%s %s, %d files of %d bytes:\n", algorithm, sum, len(group), sizes[sum])
+		for _, file := range group {
+			fmt.Fprintf(&b, "  %s\n", filepath.ToSlash(file))
+		}
+	}
+	return fmt.Sprintf("%d groups of identical files among %d files, %d bytes in the copies\n\n%s", len(sums), len(files), wasted, b.String()), nil
+}
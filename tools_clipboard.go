@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Copy To Clipboard Tool, only offered in the interactive chat since it
+// uses the clipboard of the machine codegent runs on
+var CopyToClipboardDefinition = ToolDefinition{
+	Name:        "copy_to_clipboard",
+	Description: "Copy text to the user's clipboard. Use this when the user asks for a snippet, command or message they will paste elsewhere, such as a shell one-liner, a commit message or a config block. Copy only the text itself, without Markdown fences.",
+	InputSchema: GenerateSchema[CopyToClipboardInput](),
+	// The clipboard isn't part of the workspace
+	Kind:     ToolRead,
+	Function: CopyToClipboard,
+}
+
+type CopyToClipboardInput struct {
+	Text string `json:"text" jsonschema_description:"The text to copy." jsonschema:"required"`
+}
+
+func CopyToClipboard(ctx context.Context, input json.RawMessage) (string, error) {
+	copyInput := CopyToClipboardInput{}
+	if err := json.Unmarshal(input, &copyInput); err != nil {
+		return "", err
+	}
+	if copyInput.Text == "" {
+		return "", fmt.Errorf("text is empty")
+	}
+	if err := copyToClipboard(ctx, copyInput.Text); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Copied %d characters to the clipboard", len([]rune(copyInput.Text))), nil
+}
+
+// clipboardCommands are tried in order, the first one installed is used
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"powershell", "-NoProfile", "-Command", "$input | Set-Clipboard"}, {"clip"}},
+	"linux":   {{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}},
+}
+
+// copyToClipboard puts text on the system clipboard. Without a clipboard
+// command, such as over SSH, it asks the terminal to do it with OSC 52.
+func copyToClipboard(ctx context.Context, text string) error {
+	for _, command := range clipboardCommands[runtime.GOOS] {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v %s", command[0], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	if !isTerminal(os.Stdout) {
+		return errors.New("no clipboard available, install xclip, xsel or wl-copy")
+	}
+	_, err := fmt.Fprintf(os.Stdout, "\u001b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+	return err
+}
+
+// codeBlock matches a fenced Markdown code block, capturing its contents
+var codeBlock = regexp.MustCompile("(?ms)^[ \t]*```[^\n`]*\n(.*?)^[ \t]*```")
+
+// codeBlocks returns the contents of the fenced code blocks in text
+func codeBlocks(text string) []string {
+	var blocks []string
+	for _, match := range codeBlock.FindAllStringSubmatch(text, -1) {
+		blocks = append(blocks, match[1])
+	}
+	return blocks
+}
+
+// lastResponse returns the text of the model's last answer
+func (a *Agent) lastResponse() string {
+	for i := len(a.history) - 1; i >= 0; i-- {
+		if a.history[i].Role != genai.RoleModel {
+			continue
+		}
+		var texts []string
+		for _, part := range a.history[i].Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			return strings.Join(texts, "\n")
+		}
+	}
+	return ""
+}
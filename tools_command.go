@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Commands with more output than this are summarized for the model: the
+// first commandHeadLines and the rest from the end, where test runs and
+// builds report what failed
+const (
+	maxCommandLines  = 200
+	commandHeadLines = 40
+)
+
+// Of output too long to keep whole, this much of its start and end is
+// kept while the command runs
+const (
+	commandHeadBytes = 64 << 10
+	commandTailBytes = 256 << 10
+)
+
+// RunCommand Tool
+var RunCommandDefinition = ToolDefinition{
+	Name:        "run_command",
+	Description: "Run a shell command in the working directory and get its exit status and combined output. Use this to build, run tests, run linters or formatters, or inspect the environment. The user sees the output as it is written; long output is summarized to its start and end. Commands are cancelled when they run too long, so don't start servers or other commands that never exit.",
+	InputSchema: GenerateSchema[RunCommandInput](),
+	Kind:        ToolExecute,
+	Commands:    runCommandLines,
+	Function:    RunCommand,
+}
+
+type RunCommandInput struct {
+	Command string `json:"command" jsonschema_description:"The shell command to run, e.g. \"go test ./...\"." jsonschema:"required"`
+}
+
+// runCommandLines returns the command a call runs, see Commands
+func runCommandLines(input json.RawMessage) []string {
+	var commandInput RunCommandInput
+	if err := json.Unmarshal(input, &commandInput); err != nil {
+		return nil
+	}
+	return []string{commandInput.Command}
+}
+
+func RunCommand(ctx context.Context, input json.RawMessage) (string, error) {
+	commandInput := RunCommandInput{}
+	if err := json.Unmarshal(input, &commandInput); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(commandInput.Command) == "" {
+		return "", errors.New("command is empty")
+	}
+	return runStreamed(ctx, shellCommand(ctx, commandInput.Command))
+}
+
+// runStreamed runs cmd with its output streamed to the user, and returns
+// its exit status and summarized output for the model
+func runStreamed(ctx context.Context, cmd *exec.Cmd) (string, error) {
+	var output headTailBuffer
+	w := io.MultiWriter(&output, toolOutput(ctx))
+	cmd.Stdout = w
+	cmd.Stderr = w
+	// Background processes the command started can hold its output open
+	cmd.WaitDelay = time.Second
+	start := time.Now()
+	err := cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", err
+	}
+
+	status := "exit status 0"
+	if exitErr != nil {
+		status = exitErr.Error()
+	}
+	return fmt.Sprintf("%s after %s\n\n%s", status, time.Since(start).Round(time.Millisecond), summarizeOutput(output.String())), nil
+}
+
+// headTailBuffer keeps the first commandHeadBytes written to it and the
+// last commandTailBytes, so chatty commands can't use up memory
+type headTailBuffer struct {
+	head, tail []byte
+	dropped    int64
+}
+
+func (b *headTailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := commandHeadBytes - len(b.head); room > 0 {
+		take := min(room, len(p))
+		b.head = append(b.head, p[:take]...)
+		p = p[take:]
+	}
+	b.tail = append(b.tail, p...)
+	if over := len(b.tail) - commandTailBytes; over > 0 {
+		b.dropped += int64(over)
+		b.tail = b.tail[over:]
+	}
+	return n, nil
+}
+
+func (b *headTailBuffer) String() string {
+	if b.dropped == 0 {
+		return string(b.head) + string(b.tail)
+	}
+	// The tail starts with the first whole line
+	tail, dropped := b.tail, b.dropped
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 {
+		tail, dropped = tail[i+1:], dropped+int64(i+1)
+	}
+	return fmt.Sprintf("%s\n[... %d bytes omitted ...]\n%s", b.head, dropped, tail)
+}
+
+// summarizeOutput cuts output longer than maxCommandLines down to its
+// start and end
+func summarizeOutput(output string) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) <= maxCommandLines {
+		return strings.Join(lines, "\n")
+	}
+	tail := lines[len(lines)-(maxCommandLines-commandHeadLines):]
+	omitted := len(lines) - commandHeadLines - len(tail)
+	return fmt.Sprintf("%s\n[... %d lines omitted ...]\n%s",
+		strings.Join(lines[:commandHeadLines], "\n"), omitted, strings.Join(tail, "\n"))
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// emptyTree is git's empty tree, what a repository without commits is
+// compared with
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+// Commit Changes Tool
+var CommitChangesDefinition = ToolDefinition{
+	Name: "commit_changes",
+	Description: `Organize the uncommitted changes into a series of focused commits, instead of one large commit, when the user asks you to commit work that covers several concerns.
+
+status lists the changed files and numbers each file's hunks. commit then creates the commits in order, each with its own message and the files, or hunks of files, that belong to it. Refer to a whole file by its path as status lists it, from the repository's top level, or to some of its hunks as "path#1,3". Put each logical change, such as a refactor, the feature built on it, and its tests, in a commit of its own, ordered so every commit builds. Changes not listed stay uncommitted. Needs the user's approval.`,
+	InputSchema: GenerateSchema[CommitChangesInput](),
+	Kind:        ToolExecute,
+	KindOf:      commitChangesKind,
+	Function:    CommitChanges,
+}
+
+type CommitChangesInput struct {
+	Action  string          `json:"action" jsonschema_description:"status to list the changes and their hunks, commit to commit them." jsonschema:"required,enum=status,enum=commit"`
+	Commits []plannedCommit `json:"commits,omitempty" jsonschema_description:"commit: the commits to create, in order."`
+}
+
+type plannedCommit struct {
+	Message string   `json:"message" jsonschema_description:"The commit message: a short subject line, and a body after a blank line when the why isn't obvious." jsonschema:"required"`
+	Files   []string `json:"files" jsonschema_description:"The changes to commit: paths of whole files, or \"path#2,3\" for some hunks of one, as numbered by status." jsonschema:"required"`
+}
+
+// commitChangesKind counts status as a read, see KindOf
+func commitChangesKind(input json.RawMessage) ToolKind {
+	var commitInput CommitChangesInput
+	if err := json.Unmarshal(input, &commitInput); err == nil && commitInput.Action == "status" {
+		return ToolRead
+	}
+	return ToolExecute
+}
+
+// changedFile is an uncommitted change to a file, relative to HEAD
+type changedFile struct {
+	Path   string
+	Status string // as git diff --name-status shows it, or ?? for new files
+	// The diff header and its hunks, for files whose hunks can be
+	// committed separately
+	Header string
+	Hunks  []string
+}
+
+// commitBase is what uncommitted changes are relative to, HEAD or the empty
+// tree before the first commit
+func commitBase(ctx context.Context, top string) string {
+	if _, err := gitIn(ctx, top, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return emptyTree
+	}
+	return "HEAD"
+}
+
+// uncommittedChanges lists the changes of the working tree against HEAD,
+// untracked files included
+func uncommittedChanges(ctx context.Context, top string) ([]*changedFile, error) {
+	base := commitBase(ctx, top)
+	nameStatus, err := gitIn(ctx, top, "diff", base, "--name-status", "--no-renames", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var files []*changedFile
+	fields := strings.Split(strings.TrimSuffix(nameStatus, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		files = append(files, &changedFile{Status: fields[i], Path: fields[i+1]})
+	}
+	untracked, err := gitIn(ctx, top, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range strings.Split(untracked, "\x00") {
+		if path != "" {
+			files = append(files, &changedFile{Status: "??", Path: path})
+		}
+	}
+
+	for _, file := range files {
+		if file.Status != "M" {
+			continue
+		}
+		// Untrimmed, unlike gitOutput, as the patch's context lines may end
+		// in blanks
+		cmd := exec.CommandContext(ctx, "git", "diff", base, "--no-color", "--no-ext-diff", "--", file.Path)
+		cmd.Dir = top
+		diff, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git diff %s: %w", file.Path, err)
+		}
+		// Lines of hunks start with a space, + or -, so only headers follow a
+		// newline with @@
+		parts := strings.Split(string(diff), "\n@@ ")
+		if len(parts) < 2 {
+			continue // binary
+		}
+		file.Header = parts[0] + "\n"
+		for i, hunk := range parts[1:] {
+			if i < len(parts)-2 {
+				hunk += "\n"
+			}
+			file.Hunks = append(file.Hunks, "@@ "+hunk)
+		}
+	}
+	return files, nil
+}
+
+func CommitChanges(ctx context.Context, input json.RawMessage) (string, error) {
+	commitInput := CommitChangesInput{}
+	if err := json.Unmarshal(input, &commitInput); err != nil {
+		return "", err
+	}
+	// git diff lists paths from the top level, the other commands take them
+	// from the directory they run in
+	top, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", newToolError(errNotFound, "not in a git repository", "Ask the user whether to run git init.")
+	}
+	files, err := uncommittedChanges(ctx, top)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "No uncommitted changes", nil
+	}
+
+	switch commitInput.Action {
+	case "status":
+		return describeChanges(files), nil
+	case "commit":
+		return commitPlanned(ctx, top, files, commitInput.Commits)
+	}
+	return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", commitInput.Action), "Use status or commit.")
+}
+
+// describeChanges lists the files with their numbered hunks
+func describeChanges(files []*changedFile) string {
+	var b strings.Builder
+	for _, file := range files {
+		switch {
+		case file.Status == "??":
+			fmt.Fprintf(&b, "%s (new)\n", file.Path)
+		case file.Status == "D":
+			fmt.Fprintf(&b, "%s (deleted)\n", file.Path)
+		case file.Status == "A":
+			fmt.Fprintf(&b, "%s (added)\n", file.Path)
+		case len(file.Hunks) == 0:
+			fmt.Fprintf(&b, "%s (binary or mode change)\n", file.Path)
+		default:
+			fmt.Fprintf(&b, "%s\n", file.Path)
+		}
+		for i, hunk := range file.Hunks {
+			header, body, _ := strings.Cut(hunk, "\n")
+			// Summed up by the first line it adds, or else removes
+			var added, removed int
+			var firstAdded, firstRemoved string
+			for _, line := range strings.Split(body, "\n") {
+				switch {
+				case strings.HasPrefix(line, "+"):
+					added++
+					if firstAdded == "" && strings.TrimSpace(line[1:]) != "" {
+						firstAdded = line
+					}
+				case strings.HasPrefix(line, "-"):
+					removed++
+					if firstRemoved == "" && strings.TrimSpace(line[1:]) != "" {
+						firstRemoved = line
+					}
+				}
+			}
+			fmt.Fprintf(&b, "  #%d %s +%d -%d  %s\n", i+1, header, added, removed, truncateRunes(strings.TrimSpace(cmp.Or(firstAdded, firstRemoved)), 80))
+		}
+	}
+	b.WriteString("\nNew, deleted and binary files are committed whole.")
+	return b.String()
+}
+
+// commitPlanned stages and commits each planned commit in turn. The plan is
+// checked as a whole first, so a mistake doesn't leave half of it
+// committed.
+func commitPlanned(ctx context.Context, top string, files []*changedFile, commits []plannedCommit) (string, error) {
+	if len(commits) == 0 {
+		return "", newToolError(errInvalidInput, "commits is empty", "List the commits to create, see status for the changes.")
+	}
+	byPath := make(map[string]*changedFile)
+	for _, file := range files {
+		byPath[file.Path] = file
+	}
+	// Which hunks of each file are taken; nil for the whole file
+	taken := make(map[string][]int)
+	type selection struct {
+		file  *changedFile
+		hunks []int // nil for the whole file
+	}
+	plan := make([][]selection, len(commits))
+	for i, commit := range commits {
+		if strings.TrimSpace(commit.Message) == "" {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("commit %d has no message", i+1), "Write a message for every commit.")
+		}
+		if len(commit.Files) == 0 {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("commit %d has no files", i+1), "List the files or hunks it commits.")
+		}
+		for _, spec := range commit.Files {
+			path, hunkList, partial := strings.Cut(spec, "#")
+			file := byPath[path]
+			if file == nil {
+				return "", newToolError(errInvalidInput, fmt.Sprintf("%s has no uncommitted changes", path), "Use the paths status lists.")
+			}
+			hunks, prev := taken[path]
+			if prev && (hunks == nil || !partial) {
+				return "", newToolError(errInvalidInput, path+" is in more than one commit", "Split a file across commits by its hunks, e.g. "+path+"#1.")
+			}
+			if !partial {
+				taken[path] = nil
+				plan[i] = append(plan[i], selection{file: file})
+				continue
+			}
+			if len(file.Hunks) == 0 {
+				return "", newToolError(errInvalidInput, path+" can only be committed whole", "List it without hunk numbers.")
+			}
+			var chosen []int
+			for _, n := range splitList(hunkList) {
+				k, err := strconv.Atoi(n)
+				if err != nil || k < 1 || k > len(file.Hunks) {
+					return "", newToolError(errInvalidInput, fmt.Sprintf("%s has no hunk %s, it has %d", path, n, len(file.Hunks)), "Use the hunk numbers status lists.")
+				}
+				if slices.Contains(hunks, k) || slices.Contains(chosen, k) {
+					return "", newToolError(errInvalidInput, fmt.Sprintf("hunk %d of %s is listed twice", k, path), "Put each hunk in one commit.")
+				}
+				chosen = append(chosen, k)
+			}
+			slices.Sort(chosen)
+			taken[path] = append(hunks, chosen...)
+			plan[i] = append(plan[i], selection{file: file, hunks: chosen})
+		}
+	}
+
+	// Only what the plan stages gets committed
+	unstage := []string{"reset", "-q"}
+	if commitBase(ctx, top) == emptyTree {
+		unstage = []string{"read-tree", "--empty"}
+	}
+	if _, err := gitIn(ctx, top, unstage...); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, commit := range plan {
+		for _, sel := range commit {
+			if sel.hunks == nil {
+				if _, err := gitIn(ctx, top, "add", "-A", "--", sel.file.Path); err != nil {
+					return b.String(), err
+				}
+				continue
+			}
+			patch := sel.file.Header
+			for _, k := range sel.hunks {
+				patch += sel.file.Hunks[k-1]
+			}
+			if err := applyCached(ctx, top, patch); err != nil {
+				return b.String(), fmt.Errorf("staging hunks %v of %s for commit %d failed: %w", sel.hunks, sel.file.Path, i+1, err)
+			}
+		}
+		if _, err := gitIn(ctx, top, "commit", "-q", "-m", strings.TrimSpace(commits[i].Message)); err != nil {
+			return b.String(), fmt.Errorf("commit %d failed: %w", i+1, err)
+		}
+		line, _ := gitIn(ctx, top, "log", "-1", "--format=%h %s", "--shortstat")
+		fmt.Fprintf(&b, "%s\n", strings.ReplaceAll(strings.TrimSpace(line), "\n\n", "\n  "))
+	}
+
+	if left, err := uncommittedChanges(ctx, top); err == nil && len(left) > 0 {
+		paths := make([]string, len(left))
+		for i, file := range left {
+			paths[i] = file.Path
+		}
+		fmt.Fprintf(&b, "Still uncommitted: %s\n", strings.Join(paths, ", "))
+	}
+	return b.String(), nil
+}
+
+// applyCached stages a patch without touching the working tree
+func applyCached(ctx context.Context, top, patch string) error {
+	cmd := exec.CommandContext(ctx, "git", "apply", "--cached", "--whitespace=nowarn", "-")
+	cmd.Dir = top
+	cmd.Stdin = strings.NewReader(patch)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+// Limits on the sample rows preview_data shows
+const (
+	defaultSampleRows = 10
+	maxSampleRows     = 100
+)
+
+// Preview Data Tool
+var PreviewDataDefinition = ToolDefinition{
+	Name:        "preview_data",
+	Description: "Look at a tabular data file without reading it whole: its columns with their types, the number of rows, and the first rows as a table. Reads CSV and TSV files, gzipped too, and Parquet files. For CSV the types are inferred from every value and the empty values of each column are counted. Use this instead of read_file for data files, which are often megabytes.",
+	InputSchema: GenerateSchema[PreviewDataInput](),
+	Kind:        ToolRead,
+	Function:    PreviewData,
+}
+
+type PreviewDataInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of a .csv, .tsv or .parquet file, optionally gzipped as .csv.gz or .tsv.gz." jsonschema:"required"`
+	Rows int    `json:"rows,omitempty" jsonschema_description:"How many rows to show, 10 when 0, at most 100."`
+}
+
+func PreviewData(ctx context.Context, input json.RawMessage) (string, error) {
+	previewInput := PreviewDataInput{}
+	if err := json.Unmarshal(input, &previewInput); err != nil {
+		return "", err
+	}
+	rows := min(cmp.Or(max(previewInput.Rows, 0), defaultSampleRows), maxSampleRows)
+	name := strings.ToLower(previewInput.Path)
+	switch {
+	case strings.HasSuffix(name, ".parquet"):
+		return previewParquet(previewInput.Path, rows)
+	case strings.HasSuffix(name, ".csv"), strings.HasSuffix(name, ".tsv"),
+		strings.HasSuffix(name, ".csv.gz"), strings.HasSuffix(name, ".tsv.gz"):
+		return previewCSV(ctx, previewInput.Path, rows)
+	}
+	return "", newToolError(errInvalidInput, previewInput.Path+" isn't a CSV, TSV or Parquet file", "Use read_file for other files.")
+}
+
+// csvDelimiter guesses the delimiter from the first line: the most common
+// of comma, semicolon, tab and pipe outside quotes
+func csvDelimiter(firstLine string) rune {
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range firstLine {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && strings.ContainsRune(",;\t|", r):
+			counts[r]++
+		}
+	}
+	delimiter := ','
+	for _, r := range []rune{';', '\t', '|'} {
+		if counts[r] > counts[delimiter] {
+			delimiter = r
+		}
+	}
+	return delimiter
+}
+
+// valueType names the type a CSV value looks like, "" for empty values
+func valueType(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return "integer"
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return "float"
+	}
+	switch strings.ToLower(value) {
+	case "true", "false":
+		return "boolean"
+	}
+	if _, err := time.Parse(time.DateOnly, value); err == nil {
+		return "date"
+	}
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime, "2006-01-02T15:04:05"} {
+		if _, err := time.Parse(layout, value); err == nil {
+			return "datetime"
+		}
+	}
+	return "string"
+}
+
+// widerType is the type holding values of both types
+func widerType(a, b string) string {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case a == "integer" && b == "float", a == "float" && b == "integer":
+		return "float"
+	case a == "date" && b == "datetime", a == "datetime" && b == "date":
+		return "datetime"
+	}
+	return "string"
+}
+
+// previewCSV reads the whole file to count its rows and infer the types of
+// its columns, taking the first row as the header
+func previewCSV(ctx context.Context, path string, sampleRows int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	br := bufio.NewReader(in)
+	delimiter := '\t'
+	if !strings.Contains(strings.ToLower(path), ".tsv") {
+		firstLine, _ := br.Peek(64 * 1024)
+		line, _, _ := strings.Cut(string(firstLine), "\n")
+		delimiter = csvDelimiter(line)
+	}
+	r := csv.NewReader(br)
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return path + " is empty", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	header = append([]string(nil), header...)
+	types := make([]string, len(header))
+	empty := make([]int, len(header))
+	var sample [][]string
+	rows, ragged := 0, 0
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s, row %d: %w", path, rows+2, err)
+		}
+		if rows%10000 == 0 && ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		rows++
+		if len(record) != len(header) {
+			ragged++
+		}
+		for i, value := range record[:min(len(record), len(header))] {
+			typ := valueType(value)
+			if typ == "" {
+				empty[i]++
+			}
+			types[i] = widerType(types[i], typ)
+		}
+		if len(sample) < sampleRows {
+			sample = append(sample, append([]string(nil), record...))
+		}
+	}
+
+	var b strings.Builder
+	format := "CSV"
+	if delimiter == '\t' {
+		format = "TSV"
+	} else if delimiter != ',' {
+		format = fmt.Sprintf("CSV delimited by %q", delimiter)
+	}
+	fmt.Fprintf(&b, "%s: %s, %d rows and %d columns, the first row taken as the header\n", path, format, rows, len(header))
+	if ragged > 0 {
+		fmt.Fprintf(&b, "Rows with a different number of fields than the header: %d\n", ragged)
+	}
+	b.WriteString("\nColumns:\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "name\ttype\tempty")
+	for i, name := range header {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", formatCell(name), cmp.Or(types[i], "empty"), empty[i])
+	}
+	w.Flush()
+	writeSample(&b, header, sample, rows)
+	return b.String(), nil
+}
+
+// writeSample renders the first rows as an aligned table
+func writeSample(b *strings.Builder, columns []string, sample [][]string, rows int) {
+	if len(sample) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\nFirst %d of %d rows:\n", len(sample), rows)
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, row := range sample {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = formatCell(value)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	w.Flush()
+}
+
+// previewParquet takes the schema and row count from the file's footer and
+// reads only the rows it shows
+func previewParquet(path string, sampleRows int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s isn't a readable Parquet file: %v", path, err), "")
+	}
+
+	var b strings.Builder
+	paths := pf.Schema().Columns()
+	columns := make([]string, len(paths))
+	for i, p := range paths {
+		columns[i] = strings.Join(p, ".")
+	}
+	fmt.Fprintf(&b, "%s: Parquet, %d rows in %d row groups, %d columns\n", path, pf.NumRows(), len(pf.RowGroups()), len(columns))
+	b.WriteString("\nColumns:\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "name\ttype")
+	logical := make([]*format.LogicalType, len(paths))
+	for i, p := range paths {
+		leaf, _ := pf.Schema().Lookup(p...)
+		logical[i] = leaf.Node.Type().LogicalType()
+		typ := leaf.Node.Type().String()
+		if leaf.Node.Optional() {
+			typ += ", nullable"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", columns[i], typ)
+	}
+	w.Flush()
+
+	var sample [][]string
+	for _, group := range pf.RowGroups() {
+		if len(sample) == sampleRows {
+			break
+		}
+		rows := group.Rows()
+		buf := make([]parquet.Row, sampleRows-len(sample))
+		n, err := rows.ReadRows(buf)
+		rows.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		for _, row := range buf[:n] {
+			sample = append(sample, parquetCells(row, logical))
+		}
+	}
+	writeSample(&b, columns, sample, int(pf.NumRows()))
+	return b.String(), nil
+}
+
+// parquetCells renders a row by leaf column, repeated values as a list
+func parquetCells(row parquet.Row, logical []*format.LogicalType) []string {
+	values := make([][]string, len(logical))
+	for _, value := range row {
+		column := value.Column()
+		if column < 0 || column >= len(logical) {
+			continue
+		}
+		values[column] = append(values[column], parquetValue(value, logical[column]))
+	}
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = strings.Join(v, ", ")
+		if len(v) > 1 {
+			cells[i] = "[" + cells[i] + "]"
+		}
+	}
+	return cells
+}
+
+// parquetValue renders a value, with dates and timestamps as such rather
+// than the numbers they're stored as
+func parquetValue(value parquet.Value, logical *format.LogicalType) string {
+	switch {
+	case value.IsNull():
+		return "NULL"
+	case value.Kind() == parquet.ByteArray || value.Kind() == parquet.FixedLenByteArray:
+		return string(value.ByteArray())
+	case logical == nil:
+	case logical.Date != nil:
+		return time.Unix(value.Int64()*24*60*60, 0).UTC().Format(time.DateOnly)
+	case logical.Timestamp != nil:
+		var t time.Time
+		switch unit := logical.Timestamp.Unit; {
+		case unit.Millis != nil:
+			t = time.UnixMilli(value.Int64())
+		case unit.Micros != nil:
+			t = time.UnixMicro(value.Int64())
+		default:
+			t = time.Unix(0, value.Int64())
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return value.String()
+}
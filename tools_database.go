@@ -0,0 +1,417 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Limits on what a query returns to the model
+const (
+	defaultQueryRows = 50
+	maxQueryRows     = 500
+	maxCellLength    = 200
+)
+
+// Query Database Tool
+var QueryDatabaseDefinition = ToolDefinition{
+	Name:        "query_database",
+	Description: "Run SQL against one of the databases the user configured, to look at the schema and sample rows when writing data-access code. Without a query it lists the tables and their columns. SELECT, WITH, EXPLAIN, SHOW and PRAGMA queries run read-only right away; anything else changes data and needs the user's approval. Only the tables the user allowed can be named in a query. Add a LIMIT to samples.",
+	InputSchema: GenerateSchema[QueryDatabaseInput](),
+	Kind:        ToolExecute,
+	KindOf:      queryDatabaseKind,
+	Function:    QueryDatabase,
+}
+
+type QueryDatabaseInput struct {
+	Database string `json:"database,omitempty" jsonschema_description:"The configured database to use; may be left out when there is only one."`
+	Query    string `json:"query,omitempty" jsonschema_description:"The SQL to run. Leave empty to list the tables and columns."`
+	MaxRows  int    `json:"max_rows,omitempty" jsonschema_description:"How many rows to return at most, 50 when 0, at most 500."`
+}
+
+// databaseConfig is a database the query tool may use, as configured in a
+// databases.json file
+type databaseConfig struct {
+	// Driver is postgres or sqlite; URL the connection string, which may
+	// refer to environment variables as $NAME in the user's file, so
+	// secrets stay out of the project's
+	Driver string `json:"driver"`
+	URL    string `json:"url"`
+	// The tables that may be queried, all of them when empty. Only the
+	// table names written in a query are checked, see queriedTables.
+	Tables []string `json:"tables,omitempty"`
+
+	// Whether the entry is from the project's file
+	fromProject bool
+}
+
+// dsn returns the connection string. A project's entries can't refer to
+// environment variables: a cloned repository could send any of them to a
+// server of its choosing.
+func (c databaseConfig) dsn() string {
+	if c.fromProject {
+		return c.URL
+	}
+	return os.ExpandEnv(c.URL)
+}
+
+// readQuery matches the statements that only read
+var readQuery = regexp.MustCompile(`(?i)^\s*(select|with|explain|show|pragma|describe|values)\b`)
+
+// queryDatabaseKind counts reading queries as reads and the others as
+// commands, see KindOf. A WITH may wrap a data-modifying statement, so
+// those don't count as reads.
+func queryDatabaseKind(input json.RawMessage) ToolKind {
+	var queryInput QueryDatabaseInput
+	if err := json.Unmarshal(input, &queryInput); err != nil {
+		return ToolExecute
+	}
+	if queryInput.Query == "" || isReadQuery(queryInput.Query) {
+		return ToolRead
+	}
+	return ToolExecute
+}
+
+// dataModifying finds statements in a query that change data or schema
+var dataModifying = regexp.MustCompile(`(?i)\b(insert|update|delete|merge|upsert|create|alter|drop|truncate|grant|revoke|replace|attach|vacuum)\b`)
+
+// isReadQuery reports whether a query is a single statement that only
+// reads. Semicolons in the middle could chain a change on, as could a WITH.
+func isReadQuery(query string) bool {
+	query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	if !readQuery.MatchString(query) || strings.Contains(query, ";") {
+		return false
+	}
+	return !dataModifying.MatchString(stripSQLStrings(query))
+}
+
+var (
+	// sqlLiteral matches string literals, which may contain keywords
+	sqlLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// sqlString also matches quoted identifiers
+	sqlString = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"`)
+)
+
+func stripSQLStrings(query string) string {
+	return sqlString.ReplaceAllString(query, "''")
+}
+
+// loadDatabases reads the project's .codegent/databases.json and
+// ~/.codegent/databases.json, whose entries win on a name clash
+func loadDatabases() (map[string]databaseConfig, error) {
+	paths := []string{filepath.Join(".codegent", "databases.json")}
+	if dir, err := codegentDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "databases.json"))
+	}
+
+	databases := make(map[string]databaseConfig)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var defined map[string]databaseConfig
+		if err := json.Unmarshal(data, &defined); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		for name, db := range defined {
+			if db.Driver != "postgres" && db.Driver != "sqlite" {
+				return nil, fmt.Errorf("%s: database %s has driver %q, want postgres or sqlite", path, name, db.Driver)
+			}
+			db.fromProject = path == paths[0]
+			databases[name] = db
+		}
+	}
+	return databases, nil
+}
+
+func QueryDatabase(ctx context.Context, input json.RawMessage) (string, error) {
+	queryInput := QueryDatabaseInput{}
+	if err := json.Unmarshal(input, &queryInput); err != nil {
+		return "", err
+	}
+	databases, err := loadDatabases()
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch {
+	case len(databases) == 0:
+		return "", &toolError{Code: errNotFound, Message: "no databases are configured", Suggestion: "Tell the user to add the database to .codegent/databases.json or ~/.codegent/databases.json."}
+	case queryInput.Database == "" && len(databases) > 1:
+		return "", newToolError(errInvalidInput, "database is required, there are several: "+strings.Join(names, ", "), "Call again with one of them.")
+	case queryInput.Database == "":
+		queryInput.Database = names[0]
+	}
+	config, ok := databases[queryInput.Database]
+	if !ok {
+		return "", newToolError(errNotFound, fmt.Sprintf("no database %q, there are: %s", queryInput.Database, strings.Join(names, ", ")), "Call again with one of them.")
+	}
+
+	driver := map[string]string{"postgres": "pgx", "sqlite": "sqlite"}[config.Driver]
+	db, err := sql.Open(driver, config.dsn())
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	if queryInput.Query == "" {
+		return describeSchema(ctx, db, config)
+	}
+	if len(config.Tables) > 0 {
+		for _, table := range queriedTables(queryInput.Query) {
+			if !slices.Contains(config.Tables, table) {
+				return "", newToolError(errDenied, fmt.Sprintf("table %s isn't allowed in %s, the allowed tables are: %s", table, queryInput.Database, strings.Join(config.Tables, ", ")), "Query only the allowed tables, or ask the user to allow more.")
+			}
+		}
+	}
+	maxRows := min(cmp.Or(max(queryInput.MaxRows, 0), defaultQueryRows), maxQueryRows)
+	return runQuery(ctx, db, config.Driver, queryInput.Query, maxRows)
+}
+
+// runQuery runs a query on a connection of its own. Reading queries run in
+// a read-only transaction that is rolled back; others are committed.
+func runQuery(ctx context.Context, db *sql.DB, driver, query string, maxRows int) (string, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	readOnly := isReadQuery(query)
+	if driver == "sqlite" {
+		// The sqlite driver has no read-only transactions
+		mode := "OFF"
+		if readOnly {
+			mode = "ON"
+		}
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = "+mode); err != nil {
+			return "", err
+		}
+	}
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{ReadOnly: readOnly && driver != "sqlite"})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	result, err := formatRows(rows, maxRows)
+	if err != nil {
+		return "", err
+	}
+	if !readOnly {
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+	}
+	return result, nil
+}
+
+// formatRows renders up to maxRows rows as an aligned table, saying how
+// many were left out
+func formatRows(rows *sql.Rows, maxRows int) (string, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "Done, the statement returned no rows", rows.Err()
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	n := 0
+	for rows.Next() {
+		n++
+		if n > maxRows {
+			continue
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return "", err
+		}
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = formatCell(v)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	w.Flush()
+	if n > maxRows {
+		fmt.Fprintf(&b, "[%d of %d rows shown]\n", maxRows, n)
+	} else {
+		fmt.Fprintf(&b, "[%d rows]\n", n)
+	}
+	return b.String(), nil
+}
+
+// formatCell renders a value on one line, cut to maxCellLength
+func formatCell(v any) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		s = "NULL"
+	case []byte:
+		s = string(v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	s = strings.NewReplacer("\n", `\n`, "\t", `\t`).Replace(s)
+	return truncateRunes(s, maxCellLength)
+}
+
+// describeSchema lists the tables of a database with their columns, only
+// the allowed ones when there is an allowlist
+func describeSchema(ctx context.Context, db *sql.DB, config databaseConfig) (string, error) {
+	query := `SELECT table_schema || '.' || table_name, column_name, data_type, is_nullable
+		FROM information_schema.columns
+		WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
+		ORDER BY table_schema, table_name, ordinal_position`
+	if config.Driver == "sqlite" {
+		query = `SELECT m.name, p.name, p.type, CASE p."notnull" WHEN 0 THEN 'YES' ELSE 'NO' END
+			FROM sqlite_master m, pragma_table_info(m.name) p
+			WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite_%'
+			ORDER BY m.name, p.cid`
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var b strings.Builder
+	current := ""
+	for rows.Next() {
+		var table, column, dataType, nullable string
+		if err := rows.Scan(&table, &column, &dataType, &nullable); err != nil {
+			return "", err
+		}
+		if len(config.Tables) > 0 && !slices.Contains(config.Tables, strings.TrimPrefix(table, "public.")) && !slices.Contains(config.Tables, table) {
+			continue
+		}
+		if table != current {
+			fmt.Fprintf(&b, "\n%s\n", strings.TrimPrefix(table, "public."))
+			current = table
+		}
+		null := ""
+		if nullable == "YES" {
+			null = ", nullable"
+		}
+		fmt.Fprintf(&b, "  %s %s%s\n", column, dataType, null)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if b.Len() == 0 {
+		return "No tables", nil
+	}
+	return strings.TrimPrefix(b.String(), "\n"), nil
+}
+
+var (
+	// tableReference finds the tables a query names after FROM, JOIN and
+	// the like, schema prefix and quotes included
+	tableReference = regexp.MustCompile(`(?i)\b(?:from|join|into|update|table)\s+((?:"[^"]+"|\w+)(?:\.(?:"[^"]+"|\w+))?)`)
+	// cteName finds the names a WITH defines, which aren't tables
+	cteName = regexp.MustCompile(`(?i)(?:\bwith\s+(?:recursive\s+)?|,\s*)(\w+)\s+as\s*\(`)
+	// sqlToken splits a query into names, schema prefix included, and
+	// single characters
+	sqlToken = regexp.MustCompile(`(?:"[^"]*"|\w+)(?:\.(?:"[^"]*"|\w+))*|\S`)
+)
+
+// fromClauseEnd are the keywords that end a FROM clause's list of tables
+var fromClauseEnd = []string{"where", "group", "having", "order", "limit", "offset", "fetch", "window", "union", "intersect", "except", "returning", "for"}
+
+// fromListTables finds the tables after the commas of FROM clauses, as in
+// FROM a, b. Commas in parentheses belong to subqueries and calls, whose
+// own FROM clauses are found separately.
+func fromListTables(query string) []string {
+	tokens := sqlToken.FindAllString(query, -1)
+	var tables []string
+	for i, token := range tokens {
+		if !strings.EqualFold(token, "from") {
+			continue
+		}
+		depth, afterComma := 0, false
+	list:
+		for _, token := range tokens[i+1:] {
+			switch {
+			case token == "(":
+				depth++
+			case token == ")":
+				if depth--; depth < 0 {
+					break list
+				}
+			case depth > 0:
+			case token == ",":
+				afterComma = true
+			case token == ";" || slices.Contains(fromClauseEnd, strings.ToLower(token)):
+				break list
+			case afterComma && (strings.EqualFold(token, "only") || strings.EqualFold(token, "lateral")):
+			case afterComma:
+				tables = append(tables, token)
+				afterComma = false
+			}
+		}
+	}
+	return tables
+}
+
+// queriedTables lists the tables a query names, without schema prefixes
+// and quotes. It is a lexical check that keeps the model to the allowed
+// tables, not a parser: SQL that a query builds and runs as a string, such
+// as Postgres' query_to_xml('select ...'), isn't seen.
+func queriedTables(query string) []string {
+	query = sqlLiteral.ReplaceAllString(query, "''")
+	var ctes []string
+	for _, m := range cteName.FindAllStringSubmatch(query, -1) {
+		ctes = append(ctes, strings.ToLower(m[1]))
+	}
+	var names []string
+	for _, m := range tableReference.FindAllStringSubmatch(query, -1) {
+		names = append(names, m[1])
+	}
+	var tables []string
+	for _, name := range append(names, fromListTables(query)...) {
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		name = strings.Trim(name, `"`)
+		// As in FOR UPDATE OF, DO UPDATE SET and FROM ONLY
+		if slices.Contains([]string{"of", "set", "only", "lateral", "nowait", "skip"}, strings.ToLower(name)) {
+			continue
+		}
+		if !slices.Contains(ctes, strings.ToLower(name)) && !slices.Contains(tables, name) {
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
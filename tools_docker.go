@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Docker Tool
+var DockerDefinition = ToolDefinition{
+	Name:        "docker",
+	Description: "Build Docker images, run Docker Compose stacks and read container logs, to check that Dockerfile and compose changes produce a working image and stack. Actions: build builds an image from a Dockerfile; compose runs docker compose with the given args, such as [\"up\", \"--build\", \"--wait\"] (up always runs detached) or [\"ps\"]; logs gives the end of a container's or compose service's logs; ps lists the running containers. build and compose commands that change the stack need the user's approval. Builds can take long, so build once and read the output before trying again.",
+	InputSchema: GenerateSchema[DockerInput](),
+	Kind:        ToolExecute,
+	KindOf:      dockerKind,
+	Commands:    dockerCommands,
+	Function:    Docker,
+}
+
+type DockerInput struct {
+	Action    string   `json:"action" jsonschema_description:"What to do: build, compose, logs or ps." jsonschema:"required,enum=build,enum=compose,enum=logs,enum=ps"`
+	Context   string   `json:"context,omitempty" jsonschema_description:"build: the build context directory, . when empty."`
+	File      string   `json:"file,omitempty" jsonschema_description:"build: the Dockerfile, the context's Dockerfile when empty. compose: the compose file, the default lookup when empty."`
+	Tag       string   `json:"tag,omitempty" jsonschema_description:"build: the image tag, e.g. \"myapp:dev\"."`
+	Args      []string `json:"args,omitempty" jsonschema_description:"compose: the arguments after \"docker compose\", one per item."`
+	Container string   `json:"container,omitempty" jsonschema_description:"logs: the container name or ID."`
+	Service   string   `json:"service,omitempty" jsonschema_description:"logs: the compose service, instead of a container."`
+	Tail      int      `json:"tail,omitempty" jsonschema_description:"logs: how many lines from the end, 200 when 0."`
+	Since     string   `json:"since,omitempty" jsonschema_description:"logs: only logs since a time or duration, e.g. \"10m\"."`
+}
+
+// composeReadCommands are the docker compose commands that only look
+var composeReadCommands = []string{"ps", "logs", "config", "ls", "images", "top", "version", "port"}
+
+// dockerKind counts builds and compose commands that start or stop
+// containers as commands, and looking at logs and containers as reads,
+// see KindOf
+func dockerKind(input json.RawMessage) ToolKind {
+	var dockerInput DockerInput
+	if err := json.Unmarshal(input, &dockerInput); err != nil {
+		return ToolExecute
+	}
+	switch dockerInput.Action {
+	case "logs", "ps":
+		return ToolRead
+	case "compose":
+		if len(dockerInput.Args) > 0 && slices.Contains(composeReadCommands, dockerInput.Args[0]) {
+			return ToolRead
+		}
+	}
+	return ToolExecute
+}
+
+// dockerCommands returns the docker compose command a call runs, see
+// Commands. It includes what compose exec and run run in a container.
+func dockerCommands(input json.RawMessage) []string {
+	var dockerInput DockerInput
+	if err := json.Unmarshal(input, &dockerInput); err != nil || dockerInput.Action != "compose" {
+		return nil
+	}
+	return []string{"docker compose " + strings.Join(dockerInput.Args, " ")}
+}
+
+func Docker(ctx context.Context, input json.RawMessage) (string, error) {
+	dockerInput := DockerInput{}
+	if err := json.Unmarshal(input, &dockerInput); err != nil {
+		return "", err
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", &toolError{Code: errFailed, Message: "docker is not installed", Suggestion: "Tell the user Docker is needed for this."}
+	}
+
+	var args []string
+	switch dockerInput.Action {
+	case "build":
+		args = []string{"build", "--progress=plain"}
+		if dockerInput.File != "" {
+			args = append(args, "--file", dockerInput.File)
+		}
+		if dockerInput.Tag != "" {
+			args = append(args, "--tag", dockerInput.Tag)
+		}
+		args = append(args, cmp.Or(dockerInput.Context, "."))
+	case "compose":
+		if len(dockerInput.Args) == 0 {
+			return "", newToolError(errInvalidInput, "args is empty", "Pass the compose command, such as [\"up\", \"--build\", \"--wait\"] or [\"ps\"].")
+		}
+		args = composeArgs(dockerInput.File)
+		composeCommand := dockerInput.Args
+		for _, arg := range composeCommand {
+			if (arg == "-f" || arg == "--follow") && slices.Contains(composeCommand, "logs") {
+				return "", newToolError(errInvalidInput, fmt.Sprintf("%s never exits", arg), "Leave out following, use the logs action or --tail instead.")
+			}
+		}
+		args = append(args, composeCommand...)
+		// In the foreground, up would run until the call times out
+		if composeCommand[0] == "up" && !slices.Contains(composeCommand, "-d") && !slices.Contains(composeCommand, "--detach") {
+			args = append(args, "--detach")
+		}
+	case "logs":
+		tail := strconv.Itoa(cmp.Or(dockerInput.Tail, 200))
+		switch {
+		case dockerInput.Service != "":
+			args = append(composeArgs(dockerInput.File), "logs", "--no-color", "--tail", tail)
+		case dockerInput.Container != "":
+			args = []string{"logs", "--tail", tail}
+		default:
+			return "", newToolError(errInvalidInput, "logs needs a container or a service", "Find the container with the ps action.")
+		}
+		if dockerInput.Since != "" {
+			args = append(args, "--since", dockerInput.Since)
+		}
+		args = append(args, cmp.Or(dockerInput.Service, dockerInput.Container))
+	case "ps":
+		args = []string{"ps", "--all", "--format", "table {{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Status}}\t{{.Ports}}"}
+	default:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", dockerInput.Action), "Use build, compose, logs or ps.")
+	}
+	return runStreamed(ctx, exec.CommandContext(ctx, "docker", args...))
+}
+
+// composeArgs starts a docker compose command line for file, the default
+// compose file when empty
+func composeArgs(file string) []string {
+	if file == "" {
+		return []string{"compose"}
+	}
+	return []string{"compose", "--file", file}
+}
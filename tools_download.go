@@ -0,0 +1,234 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Limits on the size of a download
+const (
+	defaultDownloadBytes = 100 << 20
+	maxDownloadBytes     = 2 << 30
+)
+
+// defaultDownloadDomains are the hosts downloads may come from unless
+// CODEGENT_DOWNLOAD_DOMAINS names others: where releases, modules and
+// packages of common toolchains are published. Subdomains are included.
+var defaultDownloadDomains = []string{
+	"github.com",
+	"githubusercontent.com",
+	"gitlab.com",
+	"go.dev",
+	"golang.org",
+	"dl.google.com",
+	"registry.npmjs.org",
+	"nodejs.org",
+	"pypi.org",
+	"pythonhosted.org",
+	"crates.io",
+	"static.rust-lang.org",
+}
+
+// checksumLengths tells the algorithm of a checksum given without one from
+// the length of its hex
+var checksumLengths = map[int]string{64: "sha256", 128: "sha512", 40: "sha1", 32: "md5"}
+
+// Download Tool
+var DownloadDefinition = ToolDefinition{
+	Name:        "download",
+	Description: "Download a file over HTTPS into the workspace, such as a release artifact, a dataset or a vendored dependency, and verify it against its published checksum. Only hosts on the allowlist can be downloaded from, redirects included; the user can extend it with CODEGENT_DOWNLOAD_DOMAINS. Downloads over the size limit are stopped, and a file that doesn't match the checksum is deleted. Needs the user's approval. Give the checksum whenever the project publishes one.",
+	InputSchema: GenerateSchema[DownloadInput](),
+	Kind:        ToolExecute,
+	Function:    Download,
+}
+
+type DownloadInput struct {
+	URL       string `json:"url" jsonschema_description:"The https URL to download." jsonschema:"required"`
+	Path      string `json:"path,omitempty" jsonschema_description:"The relative path to save the file at, the URL's file name in the working directory when empty."`
+	Checksum  string `json:"checksum,omitempty" jsonschema_description:"The checksum the file should have, in hex, such as \"sha256:9f86d0...\". Without a prefix the algorithm is told from the length: sha256, sha512, sha1 or md5."`
+	MaxBytes  int64  `json:"max_bytes,omitempty" jsonschema_description:"Stop downloads bigger than this, 100 MB when 0, at most 2 GB."`
+	Overwrite bool   `json:"overwrite,omitempty" jsonschema_description:"Replace the file at path when it exists."`
+}
+
+// downloadDomains returns the hosts downloads may come from, see
+// defaultDownloadDomains. "*" allows every host. A project's .env can't
+// change them, or a repository could lift the allowlist.
+func downloadDomains() []string {
+	if domains := splitList(userEnvOr("CODEGENT_DOWNLOAD_DOMAINS", "")); len(domains) > 0 {
+		return domains
+	}
+	return defaultDownloadDomains
+}
+
+// allowedDownload fails unless u is https on a host of the allowlist
+func allowedDownload(u *url.URL) error {
+	if u.Scheme != "https" {
+		return newToolError(errInvalidInput, fmt.Sprintf("%s isn't https", u.Redacted()), "Only https downloads are allowed.")
+	}
+	host := strings.ToLower(u.Hostname())
+	domains := downloadDomains()
+	if slices.ContainsFunc(domains, func(domain string) bool {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "*."))
+		return domain == "*" || host == domain || strings.HasSuffix(host, "."+domain)
+	}) {
+		return nil
+	}
+	return newToolError(errInvalidInput, fmt.Sprintf("%s isn't on the download allowlist (%s)", host, strings.Join(domains, ", ")), "Ask the user to add the host to CODEGENT_DOWNLOAD_DOMAINS if it should be allowed.")
+}
+
+// expectedChecksum splits a checksum into its algorithm and lowercase hex
+func expectedChecksum(checksum string) (algorithm, sum string, err error) {
+	checksum = strings.ToLower(strings.TrimSpace(checksum))
+	algorithm, sum, found := strings.Cut(checksum, ":")
+	if !found {
+		algorithm, sum = checksumLengths[len(checksum)], checksum
+	}
+	if _, ok := checksumAlgorithms[algorithm]; !ok {
+		return "", "", newToolError(errInvalidInput, fmt.Sprintf("can't tell the algorithm of checksum %q", checksum), "Prefix it with sha256:, sha512:, sha1: or md5:.")
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", "", newToolError(errInvalidInput, fmt.Sprintf("checksum %q isn't hex", sum), "")
+	}
+	// An empty or cut off digest would leave the file unverified
+	if want := 2 * checksumAlgorithms[algorithm]().Size(); len(sum) != want {
+		return "", "", newToolError(errInvalidInput, fmt.Sprintf("%s checksum %q has %d hex digits, not %d", algorithm, sum, len(sum), want), "Give the whole checksum.")
+	}
+	return algorithm, sum, nil
+}
+
+func Download(ctx context.Context, input json.RawMessage) (string, error) {
+	downloadInput := DownloadInput{}
+	if err := json.Unmarshal(input, &downloadInput); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(downloadInput.URL)
+	if err != nil {
+		return "", newToolError(errInvalidInput, err.Error(), "")
+	}
+	if err := allowedDownload(u); err != nil {
+		return "", err
+	}
+	limit := min(cmp.Or(max(downloadInput.MaxBytes, 0), defaultDownloadBytes), maxDownloadBytes)
+
+	var algorithm, expected string
+	if downloadInput.Checksum != "" {
+		if algorithm, expected, err = expectedChecksum(downloadInput.Checksum); err != nil {
+			return "", err
+		}
+	}
+
+	dest := downloadInput.Path
+	if dest == "" {
+		dest = path.Base(u.Path)
+		if dest == "/" || dest == "." {
+			return "", newToolError(errInvalidInput, "the URL has no file name", "Give the path to save the file at.")
+		}
+	}
+	if filepath.IsAbs(dest) || !filepath.IsLocal(dest) {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s is outside the workspace", dest), "Save the file at a relative path of the workspace.")
+	}
+	if err := insideWorkspace(dest); err != nil {
+		return "", newToolError(errInvalidInput, err.Error(), "Save the file at a relative path of the workspace.")
+	}
+	if _, err := os.Stat(dest); err == nil && !downloadInput.Overwrite {
+		return "", newToolError(errInvalidInput, dest+" already exists", "Pick another path, or set overwrite to replace it.")
+	}
+
+	// Redirects are held to the allowlist too
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return allowedDownload(req.URL)
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "codegent/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		var toolErr *toolError
+		if errors.As(err, &toolErr) {
+			return "", toolErr
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", newToolError(errFailed, fmt.Sprintf("%s answered %s", resp.Request.URL.Host, resp.Status), "Check the URL.")
+	}
+	if resp.ContentLength > limit {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("the file is %d bytes, more than the limit of %d", resp.ContentLength, limit), "Raise max_bytes if the file is expected to be this big.")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(toolOutput(ctx), "Downloading %s\n", resp.Request.URL.Redacted())
+	hashes := map[string]hash.Hash{"sha256": sha256.New()}
+	if algorithm != "" && algorithm != "sha256" {
+		hashes[algorithm] = checksumAlgorithms[algorithm]()
+	}
+	writers := []io.Writer{f}
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+	// Servers can leave the length out or get it wrong, the bytes are
+	// counted as they come
+	n, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(resp.Body, limit+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %w", u.Redacted(), err)
+	}
+	if n > limit {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("the file is more than the limit of %d bytes", limit), "Raise max_bytes if the file is expected to be this big.")
+	}
+
+	sha := hex.EncodeToString(hashes["sha256"].Sum(nil))
+	if expected != "" {
+		if got := hex.EncodeToString(hashes[algorithm].Sum(nil)); got != expected {
+			return "", newToolError(errFailed, fmt.Sprintf("checksum mismatch: the download has %s %s, not the expected %s, so it wasn't saved", algorithm, got, expected), "Tell the user, the file may have been tampered with or the checksum is for another file.")
+		}
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return "", err
+	}
+	fileVersions.beforeWrite(dest)
+	if err := os.Rename(f.Name(), dest); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Downloaded %s to %s, %d bytes\nsha256 %s", u.Redacted(), filepath.ToSlash(dest), n, sha)
+	if resp.Request.URL.String() != u.String() {
+		fmt.Fprintf(&b, "\nRedirected to %s", resp.Request.URL.Redacted())
+	}
+	if expected != "" {
+		fmt.Fprintf(&b, "\nVerified: the %s checksum matches", algorithm)
+	} else {
+		b.WriteString("\nNot verified, no checksum was given")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionTimeout bounds each command environment_info runs, some tools
+// are slow to start the first time
+const versionTimeout = 5 * time.Second
+
+// maxEnvValue is how much of an environment variable's value is shown
+const maxEnvValue = 200
+
+// Environment sections, all shown when none are asked for
+var environmentSections = []string{"system", "go", "tools", "git", "env"}
+
+// versionCommands print the version of tools commands commonly use, by the
+// name of the executable
+var versionCommands = []struct {
+	name string
+	args []string
+}{
+	{"go", []string{"version"}},
+	{"git", []string{"--version"}},
+	{"gcc", []string{"--version"}},
+	{"clang", []string{"--version"}},
+	{"make", []string{"--version"}},
+	{"cmake", []string{"--version"}},
+	{"node", []string{"--version"}},
+	{"npm", []string{"--version"}},
+	{"pnpm", []string{"--version"}},
+	{"yarn", []string{"--version"}},
+	{"bun", []string{"--version"}},
+	{"deno", []string{"--version"}},
+	{"python3", []string{"--version"}},
+	{"python", []string{"--version"}},
+	{"pip", []string{"--version"}},
+	{"uv", []string{"--version"}},
+	{"cargo", []string{"--version"}},
+	{"rustc", []string{"--version"}},
+	{"java", []string{"-version"}},
+	{"ruby", []string{"--version"}},
+	{"protoc", []string{"--version"}},
+	{"docker", []string{"--version"}},
+	{"kubectl", []string{"version", "--client"}},
+	{"terraform", []string{"-version"}},
+	{"gh", []string{"--version"}},
+}
+
+var (
+	// secretEnvName is an environment variable holding a credential, whose
+	// value is never shown, such as GITHUB_TOKEN or AWS_SECRET_ACCESS_KEY
+	secretEnvName = regexp.MustCompile(`(?i)(^|_)(KEY|TOKEN|SECRET|PASS(WORD|WD|PHRASE)?|CREDENTIALS?|AUTH|PRIVATE|COOKIE|SESSION|SIGNATURE|DSN)(_|$)|(TOKEN|SECRET|PASSWORD|APIKEY)$`)
+	// secretValue is a value that looks like a credential whatever its
+	// variable is called: GitHub, OpenAI, Slack, AWS and Google keys and JWTs
+	secretValue = regexp.MustCompile(`\b(gh[pousr]_\w{20,}|github_pat_\w{20,}|sk-[\w-]{20,}|xox[abprs]-[\w-]{10,}|AKIA[0-9A-Z]{16}|AIza[\w-]{35}|eyJ[\w-]{10,}\.[\w-]{10,}\.[\w-]+)`)
+)
+
+// Environment Info Tool
+var EnvironmentInfoDefinition = ToolDefinition{
+	Name:        "environment_info",
+	Description: "Report the machine commands run on: the OS and its version, CPU architecture and shell; the Go version, go env settings and the Go version go.mod asks for; the versions of installed tools such as git, node, python, cargo and docker; the git branch, upstream and uncommitted changes; and the environment variables, with credentials redacted. Use it before writing commands or code that depend on the platform or tool versions, instead of assuming Linux and the latest releases.",
+	InputSchema: GenerateSchema[EnvironmentInfoInput](),
+	Kind:        ToolRead,
+	Function:    EnvironmentInfo,
+}
+
+type EnvironmentInfoInput struct {
+	Sections []string `json:"sections,omitempty" jsonschema_description:"Which of system, go, tools, git and env to report, all when empty."`
+	Env      string   `json:"env,omitempty" jsonschema_description:"env: only variables whose names contain this, case-insensitively, such as \"GO\" or \"PROXY\"."`
+}
+
+func EnvironmentInfo(ctx context.Context, input json.RawMessage) (string, error) {
+	envInput := EnvironmentInfoInput{}
+	if err := json.Unmarshal(input, &envInput); err != nil {
+		return "", err
+	}
+	sections := envInput.Sections
+	for _, section := range sections {
+		if !slices.Contains(environmentSections, section) {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("unknown section %q", section), "Use system, go, tools, git or env.")
+		}
+	}
+	if len(sections) == 0 {
+		sections = environmentSections
+	}
+
+	var b strings.Builder
+	for _, section := range environmentSections {
+		if !slices.Contains(sections, section) {
+			continue
+		}
+		var report string
+		switch section {
+		case "system":
+			report = systemReport(ctx)
+		case "go":
+			report = goReport(ctx)
+		case "tools":
+			report = toolsReport(ctx)
+		case "git":
+			report = gitReport(ctx)
+		case "env":
+			report = envReport(envInput.Env)
+		}
+		fmt.Fprintf(&b, "%s:\n%s\n\n", strings.ToUpper(section[:1])+section[1:], strings.TrimRight(report, "\n"))
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// firstLine runs a command and returns the first line it prints, on
+// standard output or, as java does, on standard error
+func firstLine(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", nil
+}
+
+// osVersion names the release of the OS, "" when it can't tell
+func osVersion(ctx context.Context) string {
+	switch runtime.GOOS {
+	case "linux":
+		f, err := os.Open("/etc/os-release")
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if name, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+				return strings.Trim(name, `"`)
+			}
+		}
+	case "darwin":
+		if version, err := firstLine(ctx, "sw_vers", "-productVersion"); err == nil {
+			return "macOS " + version
+		}
+	case "windows":
+		if version, err := firstLine(ctx, "cmd", "/C", "ver"); err == nil {
+			return version
+		}
+	}
+	return ""
+}
+
+func systemReport(ctx context.Context) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "OS: %s/%s", runtime.GOOS, runtime.GOARCH)
+	if version := osVersion(ctx); version != "" {
+		fmt.Fprintf(&b, ", %s", version)
+	}
+	fmt.Fprintf(&b, "\nCPUs: %d\n", runtime.NumCPU())
+	fmt.Fprintf(&b, "Commands run in: %s\n", commandShell())
+	if shell := os.Getenv("SHELL"); shell != "" {
+		fmt.Fprintf(&b, "User's login shell: %s\n", shell)
+	}
+	if dir, err := os.Getwd(); err == nil {
+		fmt.Fprintf(&b, "Working directory: %s\n", dir)
+	}
+	return b.String()
+}
+
+// goEnvSettings are the go env settings that change how go commands behave
+var goEnvSettings = []string{"GOVERSION", "GOROOT", "GOPATH", "GOMODCACHE", "GOPROXY", "GOPRIVATE", "GOFLAGS", "GOTOOLCHAIN", "GOWORK", "CGO_ENABLED", "CC"}
+
+func goReport(ctx context.Context) string {
+	if _, err := exec.LookPath("go"); err != nil {
+		return "go is not installed"
+	}
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+	var b strings.Builder
+	out, err := exec.CommandContext(ctx, "go", append([]string{"env", "-json"}, goEnvSettings...)...).Output()
+	var settings map[string]string
+	if err == nil {
+		err = json.Unmarshal(out, &settings)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "go env failed: %v\n", err)
+	}
+	for _, name := range goEnvSettings {
+		if value := settings[name]; value != "" {
+			fmt.Fprintf(&b, "%s=%s\n", name, redactValue(value))
+		}
+	}
+	if content, err := os.ReadFile("go.mod"); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && (fields[0] == "go" || fields[0] == "toolchain") {
+				fmt.Fprintf(&b, "go.mod %s directive: %s\n", fields[0], fields[1])
+			}
+		}
+	}
+	return b.String()
+}
+
+// toolsReport asks the installed tools for their versions, all at once
+func toolsReport(ctx context.Context) string {
+	versions := make([]string, len(versionCommands))
+	var missing []string
+	var wg sync.WaitGroup
+	for i, command := range versionCommands {
+		path, err := exec.LookPath(command.name)
+		if err != nil {
+			missing = append(missing, command.name)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			version, err := firstLine(ctx, path, command.args...)
+			if err != nil {
+				version = fmt.Sprintf("installed at %s, but %s failed: %v", path, strings.Join(append([]string{command.name}, command.args...), " "), err)
+			}
+			versions[i] = fmt.Sprintf("%s: %s", command.name, truncateRunes(version, maxEnvValue))
+		}()
+	}
+	wg.Wait()
+
+	var b strings.Builder
+	for _, version := range versions {
+		if version != "" {
+			b.WriteString(version + "\n")
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(&b, "Not installed: %s\n", strings.Join(missing, ", "))
+	}
+	return b.String()
+}
+
+// gitOperations are the operations a repository can be stopped in the
+// middle of, by the file or directory git keeps while it is
+var gitOperations = []struct{ path, name string }{
+	{"rebase-merge", "rebase"},
+	{"rebase-apply", "rebase or am"},
+	{"MERGE_HEAD", "merge"},
+	{"CHERRY_PICK_HEAD", "cherry-pick"},
+	{"REVERT_HEAD", "revert"},
+	{"BISECT_LOG", "bisect"},
+}
+
+func gitReport(ctx context.Context) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		return "git is not installed"
+	}
+	top, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "Not in a git repository"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Repository: %s\n", top)
+	status, err := gitOutput(ctx, "status", "--porcelain=v2", "--branch")
+	if err != nil {
+		return b.String() + err.Error()
+	}
+	var staged, unstaged, untracked, conflicted int
+	for _, line := range strings.Split(status, "\n") {
+		switch fields := strings.Fields(line); {
+		case len(fields) == 0:
+		case fields[0] == "#" && len(fields) >= 3:
+			switch fields[1] {
+			case "branch.head":
+				fmt.Fprintf(&b, "Branch: %s\n", fields[2])
+			case "branch.upstream":
+				fmt.Fprintf(&b, "Upstream: %s\n", fields[2])
+			case "branch.ab":
+				if len(fields) == 4 {
+					fmt.Fprintf(&b, "Ahead %s, behind %s\n", strings.TrimPrefix(fields[2], "+"), strings.TrimPrefix(fields[3], "-"))
+				}
+			}
+		case fields[0] == "1" || fields[0] == "2":
+			if len(fields) > 1 && fields[1][0] != '.' {
+				staged++
+			}
+			if len(fields) > 1 && fields[1][1] != '.' {
+				unstaged++
+			}
+		case fields[0] == "u":
+			conflicted++
+		case fields[0] == "?":
+			untracked++
+		}
+	}
+	if head, err := gitOutput(ctx, "log", "-1", "--format=%h %s (%cr)"); err == nil {
+		fmt.Fprintf(&b, "HEAD: %s\n", head)
+	}
+	fmt.Fprintf(&b, "Changes: %d staged, %d unstaged, %d untracked, %d conflicted\n", staged, unstaged, untracked, conflicted)
+	if gitDir, err := gitOutput(ctx, "rev-parse", "--absolute-git-dir"); err == nil {
+		for _, operation := range gitOperations {
+			if _, err := os.Stat(filepath.Join(gitDir, operation.path)); err == nil {
+				fmt.Fprintf(&b, "In the middle of a %s\n", operation.name)
+				break
+			}
+		}
+	}
+	if remotes, err := gitOutput(ctx, "remote", "-v"); err == nil && remotes != "" {
+		b.WriteString("Remotes:\n")
+		for _, line := range strings.Split(remotes, "\n") {
+			if name, target, ok := strings.Cut(strings.TrimSuffix(line, " (fetch)"), "\t"); ok && !strings.HasSuffix(line, " (push)") {
+				fmt.Fprintf(&b, "  %s %s\n", name, redactValue(target))
+			}
+		}
+	}
+	return b.String()
+}
+
+// envReport lists the environment variables whose names contain filter,
+// all when empty, with the values of credentials redacted
+func envReport(filter string) string {
+	var lines []string
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if filter != "" && !strings.Contains(strings.ToUpper(name), strings.ToUpper(filter)) {
+			continue
+		}
+		lines = append(lines, name+"="+redactEnv(name, value))
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("No variables with %q in their names", filter)
+	}
+	slices.Sort(lines)
+	return strings.Join(lines, "\n")
+}
+
+// redactEnv hides the values of credentials, leaving only whether they're
+// set, and the passwords in URLs
+func redactEnv(name, value string) string {
+	switch {
+	case value == "":
+		return ""
+	case secretEnvName.MatchString(name) && !strings.HasSuffix(strings.ToUpper(name), "_PATH") && !strings.HasSuffix(strings.ToUpper(name), "_FILE"):
+		return "[redacted]"
+	}
+	return truncateRunes(redactValue(value), maxEnvValue)
+}
+
+// redactValue hides the passwords in URLs and whatever looks like a token
+func redactValue(value string) string {
+	return secretValue.ReplaceAllString(redactURL(value), "[redacted]")
+}
+
+// redactURL hides the password of a URL, such as a proxy's or a remote's
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); !ok {
+		return value
+	}
+	return u.Redacted()
+}
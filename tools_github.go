@@ -0,0 +1,282 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on the issues and comments listed
+const (
+	defaultIssueList = 30
+	maxIssueList     = 100
+)
+
+// GitHub Issues Tool
+var GitHubIssuesDefinition = ToolDefinition{
+	Name:        "github_issues",
+	Description: "Work with the GitHub issues of the current repository, to triage bug reports or find the issue a change is for. list lists issues, open ones by default, optionally filtered by labels or matching a search query; read shows an issue with its description; comments shows its discussion; create files a new issue, which needs the user's approval. Reference issues in commit messages as #123, or \"Fixes #123\" to close them when the commit is merged.",
+	InputSchema: GenerateSchema[GitHubIssuesInput](),
+	Kind:        ToolExecute,
+	KindOf:      githubIssuesKind,
+	Function:    GitHubIssues,
+}
+
+type GitHubIssuesInput struct {
+	Action string `json:"action" jsonschema_description:"list, read, comments or create." jsonschema:"required,enum=list,enum=read,enum=comments,enum=create"`
+	Number int    `json:"number,omitempty" jsonschema_description:"read and comments: the issue number."`
+	// list
+	State  string `json:"state,omitempty" jsonschema_description:"list: open, closed or all, open when empty." jsonschema:"enum=open,enum=closed,enum=all"`
+	Labels string `json:"labels,omitempty" jsonschema_description:"list: comma separated labels the issues must all have. create: labels to add."`
+	Query  string `json:"query,omitempty" jsonschema_description:"list: words to search the issues' titles, descriptions and comments for, e.g. \"panic nil map\"."`
+	Limit  int    `json:"limit,omitempty" jsonschema_description:"list: how many issues, 30 when 0, at most 100."`
+	// create
+	Title string `json:"title,omitempty" jsonschema_description:"create: the issue's title."`
+	Body  string `json:"body,omitempty" jsonschema_description:"create: the description, in Markdown."`
+	// Another repository than the current one
+	Repo string `json:"repo,omitempty" jsonschema_description:"owner/name of another repository than the current one's origin."`
+}
+
+// githubIssuesKind counts creating issues as a command and the rest as
+// reads, see KindOf
+func githubIssuesKind(input json.RawMessage) ToolKind {
+	var issuesInput GitHubIssuesInput
+	if err := json.Unmarshal(input, &issuesInput); err != nil || issuesInput.Action == "create" {
+		return ToolExecute
+	}
+	return ToolRead
+}
+
+// githubRepo returns owner/name of the current repository, from
+// GITHUB_REPOSITORY as GitHub Actions sets it, or the origin remote
+func githubRepo(ctx context.Context) (string, error) {
+	if repo := os.Getenv("GITHUB_REPOSITORY"); repo != "" {
+		return repo, nil
+	}
+	remote, err := gitOutput(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		return "", newToolError(errNotFound, "no GitHub repository: "+err.Error(), "Pass repo as owner/name.")
+	}
+	host, repo, ok := parseRemote(remote)
+	if !ok || host != "github.com" && host != apiHost("GITHUB_API_URL") {
+		return "", newToolError(errNotFound, "origin isn't on GitHub: "+remote, "Pass repo as owner/name.")
+	}
+	return repo, nil
+}
+
+// githubToken returns the user's GitHub token, or the GitHub CLI's when
+// it's signed in. Public repositories can be read without one.
+func githubToken(ctx context.Context) string {
+	if token := secret("github"); token != "" {
+		return token
+	}
+	if _, err := exec.LookPath("gh"); err != nil {
+		return ""
+	}
+	out, err := exec.CommandContext(ctx, "gh", "auth", "token").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// githubAPI calls the GitHub REST API. GITHUB_API_URL, as GitHub Actions
+// sets it, points it at GitHub Enterprise Server, unless it's set by the
+// project's .env: the user's token is sent there.
+func githubAPI(ctx context.Context, method, path string, body, out any) error {
+	header := make(http.Header)
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if token := githubToken(ctx); token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	err := issueRequest(ctx, "GitHub", method, strings.TrimSuffix(userEnvOr("GITHUB_API_URL", "https://api.github.com"), "/")+path, header, body, out)
+	if errors.Is(err, errIssueNotFound) {
+		return newToolError(errNotFound, path+" wasn't found on GitHub", "Check the issue number and repository; private repositories need GITHUB_TOKEN.")
+	}
+	return err
+}
+
+// githubIssue is what the tool uses of a GitHub issue
+type githubIssue struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	State     string    `json:"state"`
+	HTMLURL   string    `json:"html_url"`
+	Body      string    `json:"body"`
+	Comments  int       `json:"comments"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	User      struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Assignees []struct {
+		Login string `json:"login"`
+	} `json:"assignees"`
+	Milestone *struct {
+		Title string `json:"title"`
+	} `json:"milestone"`
+	// Set on pull requests, which the issues API lists as well
+	PullRequest json.RawMessage `json:"pull_request"`
+}
+
+func GitHubIssues(ctx context.Context, input json.RawMessage) (string, error) {
+	issuesInput := GitHubIssuesInput{}
+	if err := json.Unmarshal(input, &issuesInput); err != nil {
+		return "", err
+	}
+	repo := issuesInput.Repo
+	if repo == "" {
+		var err error
+		if repo, err = githubRepo(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	switch issuesInput.Action {
+	case "list":
+		return listGitHubIssues(ctx, repo, issuesInput)
+	case "read", "comments":
+		if issuesInput.Number <= 0 {
+			return "", newToolError(errInvalidInput, "number is required", "Pass the issue's number, list finds it.")
+		}
+		path := fmt.Sprintf("/repos/%s/issues/%d", repo, issuesInput.Number)
+		if issuesInput.Action == "comments" {
+			return readGitHubComments(ctx, path)
+		}
+		var issue githubIssue
+		if err := githubAPI(ctx, http.MethodGet, path, nil, &issue); err != nil {
+			return "", err
+		}
+		return issue.String(), nil
+	case "create":
+		if strings.TrimSpace(issuesInput.Title) == "" {
+			return "", newToolError(errInvalidInput, "title is required", "Give the issue a title.")
+		}
+		body := map[string]any{"title": issuesInput.Title, "body": issuesInput.Body}
+		if labels := splitList(issuesInput.Labels); len(labels) > 0 {
+			body["labels"] = labels
+		}
+		var created githubIssue
+		if err := githubAPI(ctx, http.MethodPost, "/repos/"+repo+"/issues", body, &created); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Created #%d: %s", created.Number, created.HTMLURL), nil
+	}
+	return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", issuesInput.Action), "Use list, read, comments or create.")
+}
+
+// listGitHubIssues lists issues one per line, with the search API when
+// there is a query
+func listGitHubIssues(ctx context.Context, repo string, in GitHubIssuesInput) (string, error) {
+	limit := min(cmp.Or(max(in.Limit, 0), defaultIssueList), maxIssueList)
+	state := cmp.Or(in.State, "open")
+	var issues []githubIssue
+	if in.Query != "" {
+		q := "repo:" + repo + " is:issue " + in.Query
+		if state != "all" {
+			q += " state:" + state
+		}
+		for _, label := range splitList(in.Labels) {
+			q += fmt.Sprintf(" label:%q", label)
+		}
+		var found struct {
+			Items []githubIssue `json:"items"`
+		}
+		if err := githubAPI(ctx, http.MethodGet, "/search/issues?per_page="+strconv.Itoa(limit)+"&q="+url.QueryEscape(q), nil, &found); err != nil {
+			return "", err
+		}
+		issues = found.Items
+	} else {
+		params := url.Values{"state": {state}, "per_page": {strconv.Itoa(limit)}}
+		if in.Labels != "" {
+			params.Set("labels", strings.Join(splitList(in.Labels), ","))
+		}
+		if err := githubAPI(ctx, http.MethodGet, "/repos/"+repo+"/issues?"+params.Encode(), nil, &issues); err != nil {
+			return "", err
+		}
+	}
+
+	var b strings.Builder
+	for _, issue := range issues {
+		if issue.PullRequest != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "#%d [%s] %s (", issue.Number, issue.State, issue.Title)
+		if labels := issue.labelNames(); len(labels) > 0 {
+			b.WriteString(strings.Join(labels, ", ") + "; ")
+		}
+		fmt.Fprintf(&b, "by %s, %d comments, updated %s)\n", issue.User.Login, issue.Comments, issue.UpdatedAt.Format(time.DateOnly))
+	}
+	if b.Len() == 0 {
+		return fmt.Sprintf("No %s issues found in %s", state, repo), nil
+	}
+	return b.String(), nil
+}
+
+func (i *githubIssue) labelNames() []string {
+	names := make([]string, len(i.Labels))
+	for k, label := range i.Labels {
+		names[k] = label.Name
+	}
+	return names
+}
+
+// String describes the issue for the model
+func (i *githubIssue) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d: %s\n%s\n", i.Number, i.Title, i.HTMLURL)
+	fmt.Fprintf(&b, "State: %s, opened by %s on %s, %d comments\n", i.State, i.User.Login, i.CreatedAt.Format(time.DateOnly), i.Comments)
+	if labels := i.labelNames(); len(labels) > 0 {
+		b.WriteString("Labels: " + strings.Join(labels, ", ") + "\n")
+	}
+	if len(i.Assignees) > 0 {
+		logins := make([]string, len(i.Assignees))
+		for k, assignee := range i.Assignees {
+			logins[k] = assignee.Login
+		}
+		b.WriteString("Assignees: " + strings.Join(logins, ", ") + "\n")
+	}
+	if i.Milestone != nil {
+		b.WriteString("Milestone: " + i.Milestone.Title + "\n")
+	}
+	body := strings.TrimSpace(i.Body)
+	if body == "" {
+		body = "(none)"
+	}
+	fmt.Fprintf(&b, "\nDescription:\n%s\n", truncateOutput(body, 4000))
+	return b.String()
+}
+
+// readGitHubComments lists an issue's comments, the first hundred
+func readGitHubComments(ctx context.Context, path string) (string, error) {
+	var comments []struct {
+		Body      string    `json:"body"`
+		CreatedAt time.Time `json:"created_at"`
+		User      struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	if err := githubAPI(ctx, http.MethodGet, path+"/comments?per_page=100", nil, &comments); err != nil {
+		return "", err
+	}
+	if len(comments) == 0 {
+		return "No comments", nil
+	}
+	var b strings.Builder
+	for _, comment := range comments {
+		fmt.Fprintf(&b, "%s, %s:\n%s\n\n", comment.User.Login, comment.CreatedAt.Format(time.DateOnly), truncateOutput(strings.TrimSpace(comment.Body), 1000))
+	}
+	return truncateOutput(b.String(), 8000), nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on the history shown
+const (
+	defaultHistoryCommits = 10
+	maxHistoryCommits     = 50
+)
+
+// Git History Tool
+var GitHistoryDefinition = ToolDefinition{
+	Name:        "git_history",
+	Description: "Find out why code is the way it is before changing it. log shows the commits that changed a file, following renames, or a range of its lines, with their messages and diffs. blame shows which commit last changed each line, with its author, date and subject. Narrow both to the lines you care about with start_line and end_line.",
+	InputSchema: GenerateSchema[GitHistoryInput](),
+	Kind:        ToolRead,
+	Function:    GitHistory,
+}
+
+type GitHistoryInput struct {
+	Action     string `json:"action" jsonschema_description:"log for the commits that changed the file or lines, blame for the commit behind each line." jsonschema:"required,enum=log,enum=blame"`
+	Path       string `json:"path" jsonschema_description:"The file, relative to the working directory." jsonschema:"required"`
+	StartLine  int    `json:"start_line,omitempty" jsonschema_description:"The first line of the range, 1-based; the whole file when 0."`
+	EndLine    int    `json:"end_line,omitempty" jsonschema_description:"The last line of the range, the end of the file when 0."`
+	MaxCommits int    `json:"max_commits,omitempty" jsonschema_description:"log: how many commits, latest first, 10 when 0, at most 50."`
+	NoPatch    bool   `json:"no_patch,omitempty" jsonschema_description:"log: list the commits and their messages without diffs."`
+}
+
+func GitHistory(ctx context.Context, input json.RawMessage) (string, error) {
+	historyInput := GitHistoryInput{}
+	if err := json.Unmarshal(input, &historyInput); err != nil {
+		return "", err
+	}
+	if historyInput.Path == "" {
+		return "", newToolError(errInvalidInput, "path is required", "Pass the file to look into.")
+	}
+	if _, err := gitOutput(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		return "", newToolError(errNotFound, "there is no git history here", "The directory isn't in a git repository, or it has no commits yet.")
+	}
+	if historyInput.StartLine < 0 || historyInput.EndLine < 0 || historyInput.EndLine > 0 && historyInput.EndLine < historyInput.StartLine {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("invalid line range %d-%d", historyInput.StartLine, historyInput.EndLine), "Pass 1-based lines with start_line <= end_line.")
+	}
+	// The range as git's -L takes it
+	var lines string
+	if historyInput.StartLine > 0 || historyInput.EndLine > 0 {
+		lines = strconv.Itoa(max(historyInput.StartLine, 1)) + ","
+		if historyInput.EndLine > 0 {
+			lines += strconv.Itoa(historyInput.EndLine)
+		}
+	}
+
+	var output string
+	var err error
+	switch historyInput.Action {
+	case "log":
+		output, err = fileLog(ctx, historyInput, lines)
+	case "blame":
+		output, err = fileBlame(ctx, historyInput.Path, lines)
+	default:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", historyInput.Action), "Use log or blame.")
+	}
+	if err != nil {
+		return "", newToolError(errFailed, err.Error(), "Check that the file is tracked and the lines exist.")
+	}
+	if output == "" {
+		return "No commits changed " + historyInput.Path + ", it isn't committed or the path is wrong", nil
+	}
+	return truncateOutput(output, 6000), nil
+}
+
+// fileLog runs git log on the file, or with -L on a range of its lines,
+// which can't be combined with --follow
+func fileLog(ctx context.Context, in GitHistoryInput, lines string) (string, error) {
+	limit := min(cmp.Or(max(in.MaxCommits, 0), defaultHistoryCommits), maxHistoryCommits)
+	args := []string{"log", "--no-color", "--date=short", "--format=commit %h%nAuthor: %an, %ad%n%n%w(0,4,4)%B", "-n", strconv.Itoa(limit)}
+	switch {
+	case lines != "":
+		args = append(args, "-L", lines+":"+in.Path)
+		if in.NoPatch {
+			args = append(args, "--no-patch")
+		}
+	case in.NoPatch:
+		args = append(args, "--follow", "--", in.Path)
+	default:
+		args = append(args, "-p", "--follow", "--", in.Path)
+	}
+	return gitOutput(ctx, args...)
+}
+
+// fileBlame blames the file or lines, listing each run of lines last
+// changed by the same commit under that commit
+func fileBlame(ctx context.Context, path, lines string) (string, error) {
+	args := []string{"blame", "--line-porcelain", "-w", "-M"}
+	if _, err := os.Stat(".git-blame-ignore-revs"); err == nil {
+		args = append(args, "--ignore-revs-file", ".git-blame-ignore-revs")
+	}
+	if lines != "" {
+		args = append(args, "-L", lines)
+	}
+	out, err := gitOutput(ctx, append(args, "--", path)...)
+	if err != nil {
+		return "", err
+	}
+
+	type blamed struct {
+		author, subject string
+		time            time.Time
+	}
+	commits := make(map[string]*blamed)
+	var b strings.Builder
+	var commit, previous string
+	var line int
+	for _, l := range strings.Split(out, "\n") {
+		switch field, value, _ := strings.Cut(l, " "); {
+		case strings.HasPrefix(l, "\t"):
+			c := commits[commit]
+			if commit != previous {
+				name := commit[:min(len(commit), 8)]
+				if strings.Trim(commit, "0") == "" {
+					fmt.Fprintf(&b, "Not committed yet\n")
+				} else {
+					fmt.Fprintf(&b, "%s %s %s: %s\n", name, c.time.Format(time.DateOnly), c.author, c.subject)
+				}
+				previous = commit
+			}
+			fmt.Fprintf(&b, "%6d  %s\n", line, l[1:])
+		case len(field) == 40:
+			commit = field
+			if _, ok := commits[commit]; !ok {
+				commits[commit] = &blamed{}
+			}
+			// <commit> <original line> <final line> [<lines in group>]
+			parts := strings.Fields(value)
+			if len(parts) >= 2 {
+				line, _ = strconv.Atoi(parts[1])
+			}
+		case field == "author":
+			commits[commit].author = value
+		case field == "author-time":
+			seconds, _ := strconv.ParseInt(value, 10, 64)
+			commits[commit].time = time.Unix(seconds, 0)
+		case field == "summary":
+			commits[commit].subject = value
+		}
+	}
+	return b.String(), nil
+}
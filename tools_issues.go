@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// maxIssueComments bounds the comments shown with an issue, the latest
+const maxIssueComments = 5
+
+// Get Issue Tool
+var GetIssueDefinition = ToolDefinition{
+	Name:        "get_issue",
+	Description: "Fetch an issue from the user's tracker, Jira or Linear, by its key such as PROJ-123: its title, status, description, acceptance criteria, subtasks and latest comments. When the user asks to implement or fix an issue by its key, fetch it first and work from its real requirements.",
+	InputSchema: GenerateSchema[GetIssueInput](),
+	Kind:        ToolRead,
+	Function:    GetIssue,
+}
+
+type GetIssueInput struct {
+	ID      string `json:"id" jsonschema_description:"The issue key, e.g. PROJ-123." jsonschema:"required"`
+	Tracker string `json:"tracker,omitempty" jsonschema_description:"jira or linear, only needed when both are configured and the key exists in both." jsonschema:"enum=jira,enum=linear"`
+}
+
+// Comment On Issue Tool
+var CommentOnIssueDefinition = ToolDefinition{
+	Name:        "comment_on_issue",
+	Description: "Post a comment on an issue in the user's tracker, Jira or Linear, to report progress: what was changed, where, and what's left. Only comment when the user asked for the issue to be kept up to date; the comment is public to everyone on the tracker.",
+	InputSchema: GenerateSchema[CommentOnIssueInput](),
+	Kind:        ToolExecute,
+	Function:    CommentOnIssue,
+}
+
+type CommentOnIssueInput struct {
+	ID      string `json:"id" jsonschema_description:"The issue key, e.g. PROJ-123." jsonschema:"required"`
+	Body    string `json:"body" jsonschema_description:"The comment, in Markdown for Linear or Jira wiki markup for Jira." jsonschema:"required"`
+	Tracker string `json:"tracker,omitempty" jsonschema_description:"jira or linear, only needed when both are configured." jsonschema:"enum=jira,enum=linear"`
+}
+
+// issueKey matches issue keys of Jira and Linear, a project or team key
+// and a number
+var issueKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*-[0-9]+$`)
+
+// issue is an issue as the tools show it, whichever tracker it's from
+type issue struct {
+	Key, Title, URL                  string
+	Status, Type, Priority, Assignee string
+	Labels                           []string
+	Parent                           string
+	Subtasks                         []string
+	Description                      string
+	Criteria                         string
+	Comments                         []issueComment
+	// All comments, of which Comments are the latest
+	CommentCount int
+}
+
+type issueComment struct {
+	Author, Created, Body string
+}
+
+// issueTracker is an issue tracker the user configured credentials for
+type issueTracker interface {
+	Name() string
+	Issue(ctx context.Context, key string) (*issue, error)
+	Comment(ctx context.Context, key, body string) (string, error)
+}
+
+// errIssueNotFound is returned by trackers that don't have an issue
+var errIssueNotFound = errors.New("issue not found")
+
+// issueTrackers returns the trackers with credentials, Jira first. The
+// tokens go to JIRA_URL and LINEAR_API_URL, so those can't come from the
+// project's .env.
+func issueTrackers() []issueTracker {
+	var trackers []issueTracker
+	if base, token := userEnvOr("JIRA_URL", ""), secret("jira"); base != "" && token != "" {
+		trackers = append(trackers, &jiraTracker{base: strings.TrimSuffix(base, "/"), email: os.Getenv("JIRA_EMAIL"), token: token})
+	}
+	if key := secret("linear"); key != "" {
+		trackers = append(trackers, &linearTracker{key: key})
+	}
+	return trackers
+}
+
+// withIssueTracker calls fn with the named tracker, or with each configured
+// one in turn until one has the issue
+func withIssueTracker(id, name string, fn func(issueTracker) error) error {
+	if !issueKey.MatchString(id) {
+		return newToolError(errInvalidInput, fmt.Sprintf("%q isn't an issue key", id), "Pass the key as shown in the tracker, e.g. PROJ-123.")
+	}
+	trackers := issueTrackers()
+	if len(trackers) == 0 {
+		return &toolError{Code: errNotFound, Message: "no issue tracker is configured", Suggestion: "Tell the user to set JIRA_URL and JIRA_API_TOKEN (and JIRA_EMAIL for Jira Cloud), or LINEAR_API_KEY."}
+	}
+	if name != "" {
+		i := slices.IndexFunc(trackers, func(t issueTracker) bool { return t.Name() == name })
+		if i < 0 {
+			return newToolError(errNotFound, name+" isn't configured", "Leave tracker out to use the configured one.")
+		}
+		trackers = trackers[i : i+1]
+	}
+	for _, tracker := range trackers {
+		err := fn(tracker)
+		if errors.Is(err, errIssueNotFound) {
+			continue
+		}
+		return err
+	}
+	names := make([]string, len(trackers))
+	for i, tracker := range trackers {
+		names[i] = tracker.Name()
+	}
+	return newToolError(errNotFound, fmt.Sprintf("issue %s wasn't found in %s", id, strings.Join(names, " or ")), "Check the key with the user.")
+}
+
+func GetIssue(ctx context.Context, input json.RawMessage) (string, error) {
+	issueInput := GetIssueInput{}
+	if err := json.Unmarshal(input, &issueInput); err != nil {
+		return "", err
+	}
+	var found *issue
+	err := withIssueTracker(issueInput.ID, issueInput.Tracker, func(tracker issueTracker) error {
+		var err error
+		found, err = tracker.Issue(ctx, strings.ToUpper(issueInput.ID))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return found.String(), nil
+}
+
+func CommentOnIssue(ctx context.Context, input json.RawMessage) (string, error) {
+	commentInput := CommentOnIssueInput{}
+	if err := json.Unmarshal(input, &commentInput); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(commentInput.Body) == "" {
+		return "", newToolError(errInvalidInput, "body is empty", "Write the comment in body.")
+	}
+	var link string
+	err := withIssueTracker(commentInput.ID, commentInput.Tracker, func(tracker issueTracker) error {
+		var err error
+		link, err = tracker.Comment(ctx, strings.ToUpper(commentInput.ID), commentInput.Body)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return "Commented on " + strings.ToUpper(commentInput.ID) + ": " + link, nil
+}
+
+// String describes the issue for the model, with the acceptance criteria in
+// a section of their own
+func (i *issue) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n%s\n", i.Key, i.Title, i.URL)
+	var facts []string
+	for _, fact := range [][2]string{{"Status", i.Status}, {"Type", i.Type}, {"Priority", i.Priority}, {"Assignee", i.Assignee}} {
+		if fact[1] != "" {
+			facts = append(facts, fact[0]+": "+fact[1])
+		}
+	}
+	if len(facts) > 0 {
+		b.WriteString(strings.Join(facts, ", ") + "\n")
+	}
+	if len(i.Labels) > 0 {
+		b.WriteString("Labels: " + strings.Join(i.Labels, ", ") + "\n")
+	}
+	if i.Parent != "" {
+		b.WriteString("Parent: " + i.Parent + "\n")
+	}
+	if len(i.Subtasks) > 0 {
+		b.WriteString("Subtasks:\n")
+		for _, subtask := range i.Subtasks {
+			b.WriteString("  " + subtask + "\n")
+		}
+	}
+
+	description, criteria := splitCriteria(i.Description)
+	criteria = strings.TrimSpace(strings.Join([]string{i.Criteria, criteria}, "\n\n"))
+	if description == "" {
+		description = "(none)"
+	}
+	fmt.Fprintf(&b, "\nDescription:\n%s\n", truncateOutput(description, 4000))
+	if criteria != "" {
+		fmt.Fprintf(&b, "\nAcceptance criteria:\n%s\n", truncateOutput(criteria, 2000))
+	}
+	if len(i.Comments) > 0 {
+		fmt.Fprintf(&b, "\nLatest comments (%d of %d):\n", len(i.Comments), i.CommentCount)
+		for _, comment := range i.Comments {
+			fmt.Fprintf(&b, "\n%s, %s:\n%s\n", comment.Author, comment.Created, truncateOutput(strings.TrimSpace(comment.Body), 500))
+		}
+	}
+	return b.String()
+}
+
+// criteriaHeading matches a heading line for the acceptance criteria, in
+// Markdown, Jira wiki markup or as a bold or plain line
+var criteriaHeading = regexp.MustCompile(`(?im)^[ \t]*(#{1,6}[ \t]*|h[1-6]\.[ \t]*)?[*_]*acceptance criteria[*_]*:?[*_]*[ \t]*$`)
+
+// sectionHeading matches any heading line that ends the criteria
+var sectionHeading = regexp.MustCompile(`(?m)^[ \t]*(#{1,6}[ \t]+|h[1-6]\.[ \t]+)\S`)
+
+// splitCriteria takes the acceptance criteria section out of a description
+func splitCriteria(description string) (rest, criteria string) {
+	loc := criteriaHeading.FindStringIndex(description)
+	if loc == nil {
+		return strings.TrimSpace(description), ""
+	}
+	body := description[loc[1]:]
+	end := len(body)
+	if next := sectionHeading.FindStringIndex(body); next != nil {
+		end = next[0]
+	}
+	rest = strings.TrimSpace(strings.TrimSpace(description[:loc[0]]) + "\n\n" + strings.TrimSpace(body[end:]))
+	return rest, strings.TrimSpace(body[:end])
+}
+
+// issueRequest sends a JSON request to a tracker's API and decodes the
+// response into out, turning the usual failures into tool errors
+func issueRequest(ctx context.Context, tracker, method, endpoint string, header http.Header, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errIssueNotFound
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return newToolError(errDenied, fmt.Sprintf("%s refused the credentials: %s", tracker, resp.Status), "Tell the user to check the "+tracker+" token and its permissions.")
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s: %s: %s", tracker, resp.Status, truncateRunes(strings.TrimSpace(string(data)), 300))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// jiraTracker uses the Jira REST API version 2, which Jira Cloud, Server
+// and Data Center all serve, with descriptions in wiki markup
+type jiraTracker struct {
+	base string
+	// Jira Cloud takes the account's email with an API token, Server and
+	// Data Center a personal access token alone
+	email, token string
+}
+
+func (j *jiraTracker) Name() string { return "jira" }
+
+func (j *jiraTracker) request(ctx context.Context, method, path string, body, out any) error {
+	header := make(http.Header)
+	if j.email != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(j.email+":"+j.token)))
+	} else {
+		header.Set("Authorization", "Bearer "+j.token)
+	}
+	return issueRequest(ctx, "Jira", method, j.base+path, header, body, out)
+}
+
+type jiraName struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type jiraLinked struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary string    `json:"summary"`
+		Status  *jiraName `json:"status"`
+	} `json:"fields"`
+}
+
+func (j *jiraTracker) Issue(ctx context.Context, key string) (*issue, error) {
+	var resp struct {
+		Key    string                     `json:"key"`
+		Names  map[string]string          `json:"names"`
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if err := j.request(ctx, http.MethodGet, "/rest/api/2/issue/"+url.PathEscape(key)+"?expand=names", nil, &resp); err != nil {
+		return nil, err
+	}
+
+	var fields struct {
+		Summary     string       `json:"summary"`
+		Description string       `json:"description"`
+		Status      *jiraName    `json:"status"`
+		IssueType   *jiraName    `json:"issuetype"`
+		Priority    *jiraName    `json:"priority"`
+		Assignee    *jiraName    `json:"assignee"`
+		Labels      []string     `json:"labels"`
+		Parent      *jiraLinked  `json:"parent"`
+		Subtasks    []jiraLinked `json:"subtasks"`
+		Comment     struct {
+			Total    int `json:"total"`
+			Comments []struct {
+				Author  jiraName `json:"author"`
+				Created string   `json:"created"`
+				Body    string   `json:"body"`
+			} `json:"comments"`
+		} `json:"comment"`
+	}
+	raw, err := json.Marshal(resp.Fields)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	found := &issue{
+		Key:          resp.Key,
+		Title:        fields.Summary,
+		URL:          j.base + "/browse/" + resp.Key,
+		Labels:       fields.Labels,
+		Description:  fields.Description,
+		CommentCount: fields.Comment.Total,
+	}
+	for _, f := range []struct {
+		to   *string
+		from *jiraName
+	}{{&found.Status, fields.Status}, {&found.Type, fields.IssueType}, {&found.Priority, fields.Priority}, {&found.Assignee, fields.Assignee}} {
+		if f.from != nil {
+			*f.to = cmp.Or(f.from.DisplayName, f.from.Name)
+		}
+	}
+	if fields.Parent != nil {
+		found.Parent = fields.Parent.Key + " " + fields.Parent.Fields.Summary
+	}
+	for _, subtask := range fields.Subtasks {
+		line := subtask.Key + " " + subtask.Fields.Summary
+		if subtask.Fields.Status != nil {
+			line += " (" + subtask.Fields.Status.Name + ")"
+		}
+		found.Subtasks = append(found.Subtasks, line)
+	}
+	comments := fields.Comment.Comments
+	for _, comment := range comments[max(len(comments)-maxIssueComments, 0):] {
+		found.Comments = append(found.Comments, issueComment{Author: comment.Author.DisplayName, Created: strings.SplitN(comment.Created, "T", 2)[0], Body: comment.Body})
+	}
+	found.CommentCount = max(found.CommentCount, len(comments))
+
+	// Acceptance criteria are often a custom field of their own
+	for id, name := range resp.Names {
+		var text string
+		if strings.Contains(strings.ToLower(name), "acceptance criteria") && json.Unmarshal(resp.Fields[id], &text) == nil {
+			found.Criteria = strings.TrimSpace(text)
+		}
+	}
+	return found, nil
+}
+
+func (j *jiraTracker) Comment(ctx context.Context, key, body string) (string, error) {
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := j.request(ctx, http.MethodPost, "/rest/api/2/issue/"+url.PathEscape(key)+"/comment", map[string]string{"body": body}, &created); err != nil {
+		return "", err
+	}
+	return j.base + "/browse/" + key + "?focusedCommentId=" + created.ID, nil
+}
+
+// linearTracker uses Linear's GraphQL API with a personal API key
+type linearTracker struct {
+	key string
+}
+
+func (l *linearTracker) Name() string { return "linear" }
+
+// query runs a GraphQL query or mutation and decodes its data into out
+func (l *linearTracker) query(ctx context.Context, query string, variables map[string]any, out any) error {
+	header := make(http.Header)
+	header.Set("Authorization", l.key)
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Type string `json:"type"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	endpoint := userEnvOr("LINEAR_API_URL", "https://api.linear.app/graphql")
+	if err := issueRequest(ctx, "Linear", http.MethodPost, endpoint, header, map[string]any{"query": query, "variables": variables}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		if strings.Contains(strings.ToLower(resp.Errors[0].Message), "not found") {
+			return errIssueNotFound
+		}
+		return fmt.Errorf("linear: %s", resp.Errors[0].Message)
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+const linearIssueQuery = `query($id: String!) {
+  issue(id: $id) {
+    id identifier title url description priorityLabel
+    state { name }
+    assignee { name }
+    labels { nodes { name } }
+    parent { identifier title }
+    children { nodes { identifier title state { name } } }
+    comments(first: 100) { nodes { body createdAt user { name } } }
+  }
+}`
+
+type linearIssue struct {
+	ID            string `json:"id"`
+	Identifier    string `json:"identifier"`
+	Title         string `json:"title"`
+	URL           string `json:"url"`
+	Description   string `json:"description"`
+	PriorityLabel string `json:"priorityLabel"`
+	State         *struct {
+		Name string `json:"name"`
+	} `json:"state"`
+	Assignee *struct {
+		Name string `json:"name"`
+	} `json:"assignee"`
+	Labels struct {
+		Nodes []struct {
+			Name string `json:"name"`
+		} `json:"nodes"`
+	} `json:"labels"`
+	Parent *struct {
+		Identifier string `json:"identifier"`
+		Title      string `json:"title"`
+	} `json:"parent"`
+	Children struct {
+		Nodes []struct {
+			Identifier string `json:"identifier"`
+			Title      string `json:"title"`
+			State      struct {
+				Name string `json:"name"`
+			} `json:"state"`
+		} `json:"nodes"`
+	} `json:"children"`
+	Comments struct {
+		Nodes []linearComment `json:"nodes"`
+	} `json:"comments"`
+}
+
+type linearComment struct {
+	Body      string `json:"body"`
+	CreatedAt string `json:"createdAt"`
+	User      *struct {
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+func (l *linearTracker) fetch(ctx context.Context, key string) (*linearIssue, error) {
+	var data struct {
+		Issue *linearIssue `json:"issue"`
+	}
+	if err := l.query(ctx, linearIssueQuery, map[string]any{"id": key}, &data); err != nil {
+		return nil, err
+	}
+	if data.Issue == nil {
+		return nil, errIssueNotFound
+	}
+	return data.Issue, nil
+}
+
+func (l *linearTracker) Issue(ctx context.Context, key string) (*issue, error) {
+	li, err := l.fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	found := &issue{
+		Key:          li.Identifier,
+		Title:        li.Title,
+		URL:          li.URL,
+		Priority:     li.PriorityLabel,
+		Description:  li.Description,
+		CommentCount: len(li.Comments.Nodes),
+	}
+	if li.State != nil {
+		found.Status = li.State.Name
+	}
+	if li.Assignee != nil {
+		found.Assignee = li.Assignee.Name
+	}
+	for _, label := range li.Labels.Nodes {
+		found.Labels = append(found.Labels, label.Name)
+	}
+	if li.Parent != nil {
+		found.Parent = li.Parent.Identifier + " " + li.Parent.Title
+	}
+	for _, child := range li.Children.Nodes {
+		found.Subtasks = append(found.Subtasks, child.Identifier+" "+child.Title+" ("+child.State.Name+")")
+	}
+	comments := li.Comments.Nodes
+	slices.SortFunc(comments, func(a, b linearComment) int {
+		return strings.Compare(a.CreatedAt, b.CreatedAt)
+	})
+	for _, comment := range comments[max(len(comments)-maxIssueComments, 0):] {
+		author := "(integration)"
+		if comment.User != nil {
+			author = comment.User.Name
+		}
+		found.Comments = append(found.Comments, issueComment{Author: author, Created: strings.SplitN(comment.CreatedAt, "T", 2)[0], Body: comment.Body})
+	}
+	return found, nil
+}
+
+const linearCommentMutation = `mutation($issueId: String!, $body: String!) {
+  commentCreate(input: {issueId: $issueId, body: $body}) {
+    success
+    comment { url }
+  }
+}`
+
+func (l *linearTracker) Comment(ctx context.Context, key, body string) (string, error) {
+	// Comments are created on the issue's ID rather than its key
+	li, err := l.fetch(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	var data struct {
+		CommentCreate struct {
+			Success bool `json:"success"`
+			Comment struct {
+				URL string `json:"url"`
+			} `json:"comment"`
+		} `json:"commentCreate"`
+	}
+	if err := l.query(ctx, linearCommentMutation, map[string]any{"issueId": li.ID, "body": body}, &data); err != nil {
+		return "", err
+	}
+	if !data.CommentCreate.Success {
+		return "", fmt.Errorf("linear didn't create the comment")
+	}
+	return data.CommentCreate.Comment.URL, nil
+}
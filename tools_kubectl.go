@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Kubectl Tool
+var KubectlDefinition = ToolDefinition{
+	Name:        "kubectl",
+	Description: "Run kubectl against the user's current Kubernetes context, to find out why a workload misbehaves and relate it to the code: get, describe, logs, events, top, explain and the like run right away. Commands that change the cluster, such as apply, delete, scale, rollout restart or exec, need the user's approval, as does reading secrets. Logs can't be followed; use --tail or --since, and --previous for the container that crashed. The command comes first, flags after it.",
+	InputSchema: GenerateSchema[KubectlInput](),
+	Kind:        ToolExecute,
+	KindOf:      kubectlKind,
+	Commands:    kubectlCommands,
+	Function:    Kubectl,
+}
+
+type KubectlInput struct {
+	Args      []string `json:"args" jsonschema_description:"The kubectl arguments, one per item and without \"kubectl\", e.g. [\"logs\", \"deploy/api\", \"--previous\", \"--tail\", \"100\"]. They aren't passed through a shell." jsonschema:"required"`
+	Namespace string   `json:"namespace,omitempty" jsonschema_description:"Optional namespace, the context's default when empty."`
+	Context   string   `json:"context,omitempty" jsonschema_description:"Optional kubeconfig context, the current one when empty."`
+}
+
+// kubectlReadVerbs are the kubectl commands that only look at the cluster
+var kubectlReadVerbs = []string{
+	"get", "describe", "logs", "events", "top", "explain", "api-resources",
+	"api-versions", "version", "cluster-info", "diff",
+}
+
+// kubectlKind tells commands that look at the cluster from those that
+// change it, see KindOf. Reading secrets or credentials counts as a
+// change: it needs the user's approval too, as do raw API requests.
+func kubectlKind(input json.RawMessage) ToolKind {
+	var kubectlInput KubectlInput
+	if err := json.Unmarshal(input, &kubectlInput); err != nil {
+		return ToolExecute
+	}
+	args := kubectlArgs(kubectlInput.Args)
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return ToolExecute
+	}
+	if slices.ContainsFunc(args, func(arg string) bool { return arg == "--raw" || strings.HasPrefix(arg, "--raw=") }) {
+		return ToolExecute
+	}
+	switch verb := args[0]; {
+	case verb == "config":
+		// config view prints credentials with --raw or --flatten
+		if len(args) > 1 && slices.Contains([]string{"current-context", "get-contexts", "get-clusters"}, args[1]) {
+			return ToolRead
+		}
+	case verb == "auth":
+		if len(args) > 1 && args[1] == "can-i" {
+			return ToolRead
+		}
+	case verb == "rollout":
+		if len(args) > 1 && (args[1] == "status" || args[1] == "history") {
+			return ToolRead
+		}
+	case slices.Contains(kubectlReadVerbs, verb):
+		if (verb == "get" || verb == "describe") && slices.ContainsFunc(args[1:], isSecretResource) {
+			return ToolExecute
+		}
+		return ToolRead
+	}
+	return ToolExecute
+}
+
+// kubectlCommands returns the kubectl command a call runs, see Commands.
+// It includes what kubectl exec runs in a container.
+func kubectlCommands(input json.RawMessage) []string {
+	var kubectlInput KubectlInput
+	if err := json.Unmarshal(input, &kubectlInput); err != nil {
+		return nil
+	}
+	return []string{"kubectl " + strings.Join(kubectlArgs(kubectlInput.Args), " ")}
+}
+
+// isSecretResource reports whether a kubectl argument names secrets, as in
+// "secrets", "Secret/db", "secrets.v1" or "pods,secrets"
+func isSecretResource(arg string) bool {
+	for _, resource := range strings.Split(strings.ToLower(arg), ",") {
+		resource, _, _ = strings.Cut(resource, "/")
+		// Resources can be qualified with their version and group
+		resource, _, _ = strings.Cut(resource, ".")
+		if resource == "secret" || resource == "secrets" {
+			return true
+		}
+	}
+	return false
+}
+
+// kubectlArgs leaves out a leading "kubectl", so the command comes first.
+// Flags before the command aren't allowed: which of them take a value
+// can't be told, and with it which argument is the command.
+func kubectlArgs(args []string) []string {
+	if len(args) > 0 && args[0] == "kubectl" {
+		args = args[1:]
+	}
+	return args
+}
+
+func Kubectl(ctx context.Context, input json.RawMessage) (string, error) {
+	kubectlInput := KubectlInput{}
+	if err := json.Unmarshal(input, &kubectlInput); err != nil {
+		return "", err
+	}
+	args := kubectlArgs(kubectlInput.Args)
+	if len(args) == 0 {
+		return "", newToolError(errInvalidInput, "args is empty", "Pass the kubectl command, such as [\"get\", \"pods\"].")
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("args start with the flag %s, not the command", args[0]), "Put the command first and the flags after it; use namespace and context for those.")
+	}
+	for _, arg := range args {
+		if arg == "-f" && slices.Contains(args, "logs") || arg == "--follow" || arg == "-w" || arg == "--watch" || strings.HasPrefix(arg, "--follow=") || strings.HasPrefix(arg, "--watch=") {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("%s never exits", arg), "Leave out following and watching; use --tail or --since for logs and run the command again to see changes.")
+		}
+	}
+	if kubectlInput.Namespace != "" {
+		args = append(args, "--namespace", kubectlInput.Namespace)
+	}
+	if kubectlInput.Context != "" {
+		args = append(args, "--context", kubectlInput.Context)
+	}
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return "", &toolError{Code: errFailed, Message: "kubectl is not installed", Suggestion: "Tell the user kubectl is needed for this."}
+	}
+
+	return runStreamed(ctx, exec.CommandContext(ctx, "kubectl", args...))
+}
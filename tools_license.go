@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHeaderFiles bounds how many files one add_headers call may change
+const maxHeaderFiles = 200
+
+// headerLines is how far into a file a license header is looked for
+const headerLines = 30
+
+// License Check Tool
+var LicenseCheckDefinition = ToolDefinition{
+	Name:        "license_check",
+	Description: "Check the project's license compliance against its policy in .codegent/licenses.json. dependencies lists the licenses of the Go modules, using go-licenses, and of the direct npm dependencies, flagging those the policy doesn't allow or can't tell. headers lists the source files without the license header. add_headers adds the header to them, or to the given paths, showing the changes for approval like an edit.",
+	InputSchema: GenerateSchema[LicenseCheckInput](),
+	Kind:        ToolWrite,
+	KindOf:      licenseCheckKind,
+	Function:    LicenseCheck,
+	Preview:     LicenseCheckPreview,
+}
+
+type LicenseCheckInput struct {
+	Action string   `json:"action" jsonschema_description:"dependencies, headers or add_headers." jsonschema:"required,enum=dependencies,enum=headers,enum=add_headers"`
+	Paths  []string `json:"paths,omitempty" jsonschema_description:"headers and add_headers: only these files, all source files when empty."`
+	Header string   `json:"header,omitempty" jsonschema_description:"add_headers: the header text without comment markers, when the policy has none. {year} stands for the current year."`
+}
+
+// licensePolicy is .codegent/licenses.json
+type licensePolicy struct {
+	// SPDX identifiers of the licenses dependencies may have, any when
+	// empty, and those they mustn't. Both may use * as in GPL-*.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// The header every source file starts with, without comment markers
+	Header string `json:"header,omitempty"`
+	// Globs of the files that need the header, every source file when
+	// empty, and of those that don't
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// licenseCheckKind counts the checks as reads, see KindOf
+func licenseCheckKind(input json.RawMessage) ToolKind {
+	var licenseInput LicenseCheckInput
+	if err := json.Unmarshal(input, &licenseInput); err == nil && licenseInput.Action != "add_headers" {
+		return ToolRead
+	}
+	return ToolWrite
+}
+
+func loadLicensePolicy() (licensePolicy, error) {
+	var policy licensePolicy
+	p := filepath.Join(".codegent", "licenses.json")
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return policy, nil
+	}
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("failed to parse %s: %w", p, err)
+	}
+	return policy, nil
+}
+
+func LicenseCheckPreview(ctx context.Context, input json.RawMessage) (string, error) {
+	licenseInput := LicenseCheckInput{}
+	if err := json.Unmarshal(input, &licenseInput); err != nil {
+		return "", err
+	}
+	if licenseInput.Action != "add_headers" {
+		return "", nil
+	}
+	changes, err := planHeaders(ctx, licenseInput)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, change := range changes {
+		sb.WriteString(unifiedDiff(change.path, string(change.oldContent), change.newContent))
+	}
+	return sb.String(), nil
+}
+
+func LicenseCheck(ctx context.Context, input json.RawMessage) (string, error) {
+	licenseInput := LicenseCheckInput{}
+	if err := json.Unmarshal(input, &licenseInput); err != nil {
+		return "", err
+	}
+	policy, err := loadLicensePolicy()
+	if err != nil {
+		return "", err
+	}
+
+	switch licenseInput.Action {
+	case "dependencies":
+		return checkDependencyLicenses(ctx, policy)
+	case "headers":
+		missing, err := missingHeaders(ctx, policy, licenseInput.Paths)
+		if err != nil {
+			return "", err
+		}
+		if len(missing) == 0 {
+			return "Every source file has the license header", nil
+		}
+		return fmt.Sprintf("%d files lack the license header:\n%s", len(missing), strings.Join(missing, "\n")), nil
+	case "add_headers":
+	default:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", licenseInput.Action), "Use dependencies, headers or add_headers.")
+	}
+
+	changes, err := planHeaders(ctx, licenseInput)
+	if err != nil {
+		return "", err
+	}
+	for i, change := range changes {
+		if err := writeChange(change); err != nil {
+			for _, done := range changes[:i] {
+				rollbackChange(done)
+			}
+			return "", fmt.Errorf("failed to write %s, no files were changed: %w", change.path, err)
+		}
+	}
+	paths := make([]string, len(changes))
+	for i, change := range changes {
+		fileVersions.record(change.path, []byte(change.newContent))
+		paths[i] = change.path
+	}
+	return fmt.Sprintf("Added the license header to %d files: %s", len(changes), strings.Join(paths, ", ")), nil
+}
+
+// dependencyLicense is the license of a dependency as a tool reports it
+type dependencyLicense struct {
+	Name    string
+	License string // an SPDX identifier or expression, "" if unknown
+}
+
+// checkDependencyLicenses lists the dependencies' licenses with those
+// breaking the policy first
+func checkDependencyLicenses(ctx context.Context, policy licensePolicy) (string, error) {
+	var deps []dependencyLicense
+	var notes []string
+	if _, err := os.Stat("go.mod"); err == nil {
+		goDeps, err := goLicenses(ctx)
+		if err != nil {
+			return "", err
+		}
+		deps = append(deps, goDeps...)
+	}
+	if _, err := os.Stat("package.json"); err == nil {
+		npmDeps, err := npmLicenses()
+		if err != nil {
+			notes = append(notes, "npm: "+err.Error())
+		}
+		deps = append(deps, npmDeps...)
+	}
+	if len(deps) == 0 && len(notes) == 0 {
+		return "", newToolError(errNotFound, "no go.mod or package.json here", "Run it at the top of a Go module or npm package.")
+	}
+
+	var denied, unknown, allowed []string
+	for _, dep := range deps {
+		switch {
+		case dep.License == "":
+			unknown = append(unknown, dep.Name)
+		case licenseAllowed(policy, dep.License):
+			allowed = append(allowed, dep.Name+"  "+dep.License)
+		default:
+			denied = append(denied, dep.Name+"  "+dep.License)
+		}
+	}
+	var b strings.Builder
+	if len(denied) > 0 {
+		fmt.Fprintf(&b, "Not allowed by the policy (%d):\n%s\n\n", len(denied), strings.Join(denied, "\n"))
+	}
+	if len(unknown) > 0 {
+		fmt.Fprintf(&b, "License unknown, check by hand (%d):\n%s\n\n", len(unknown), strings.Join(unknown, "\n"))
+	}
+	fmt.Fprintf(&b, "Allowed (%d):\n%s", len(allowed), strings.Join(allowed, "\n"))
+	if len(policy.Allow) == 0 && len(policy.Deny) == 0 {
+		b.WriteString("\n\nThere is no policy in .codegent/licenses.json, so every known license counts as allowed.")
+	}
+	for _, note := range notes {
+		b.WriteString("\n" + note)
+	}
+	return b.String(), nil
+}
+
+// licenseAllowed checks an SPDX identifier or expression: of alternatives
+// joined by OR one must be allowed, of licenses joined by AND all of them.
+// AND binds tighter than OR, and parentheses group. Expressions that can't
+// be parsed aren't allowed.
+func licenseAllowed(policy licensePolicy, license string) bool {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(license))
+	allowed, rest := spdxAnyOf(policy, tokens)
+	return allowed && len(rest) == 0
+}
+
+// spdxAnyOf evaluates the alternatives joined by OR at the start of tokens,
+// returning the tokens after them
+func spdxAnyOf(policy licensePolicy, tokens []string) (bool, []string) {
+	allowed, tokens := spdxAllOf(policy, tokens)
+	for len(tokens) > 0 && strings.EqualFold(tokens[0], "OR") {
+		var next bool
+		next, tokens = spdxAllOf(policy, tokens[1:])
+		allowed = allowed || next
+	}
+	return allowed, tokens
+}
+
+// spdxAllOf evaluates the licenses joined by AND at the start of tokens
+func spdxAllOf(policy licensePolicy, tokens []string) (bool, []string) {
+	allowed, tokens := spdxLicense(policy, tokens)
+	for len(tokens) > 0 && strings.EqualFold(tokens[0], "AND") {
+		var next bool
+		next, tokens = spdxLicense(policy, tokens[1:])
+		allowed = allowed && next
+	}
+	return allowed, tokens
+}
+
+// spdxLicense evaluates one license, or an expression in parentheses, at
+// the start of tokens
+func spdxLicense(policy licensePolicy, tokens []string) (bool, []string) {
+	if len(tokens) == 0 || tokens[0] == ")" {
+		return false, tokens
+	}
+	if tokens[0] == "(" {
+		allowed, rest := spdxAnyOf(policy, tokens[1:])
+		if len(rest) == 0 || rest[0] != ")" {
+			return false, rest
+		}
+		return allowed, rest[1:]
+	}
+	license, tokens := tokens[0], tokens[1:]
+	// An exception only grants more, the license decides
+	if len(tokens) >= 2 && strings.EqualFold(tokens[0], "WITH") {
+		tokens = tokens[2:]
+	}
+	matches := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(p string) bool {
+			ok, _ := path.Match(strings.ToLower(p), strings.ToLower(license))
+			return ok
+		})
+	}
+	if matches(policy.Deny) {
+		return false, tokens
+	}
+	return len(policy.Allow) == 0 || matches(policy.Allow), tokens
+}
+
+// goLicenses runs go-licenses report on the module, which prints the
+// license of every package it builds as module,URL,license
+func goLicenses(ctx context.Context) ([]dependencyLicense, error) {
+	if _, err := exec.LookPath("go-licenses"); err != nil {
+		return nil, newToolError(errNotFound, "go-licenses isn't installed", "Ask the user to install it with go install github.com/google/go-licenses@latest.")
+	}
+	cmd := exec.CommandContext(ctx, "go-licenses", "report", "./...")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	// It exits non-zero for packages it can't classify but still reports
+	// the rest
+	if err != nil && len(out) == 0 {
+		return nil, fmt.Errorf("go-licenses: %s", summarizeOutput(stderr.String()))
+	}
+	module, _ := exec.CommandContext(ctx, "go", "list", "-m").Output()
+	var deps []dependencyLicense
+	r := csv.NewReader(bytes.NewReader(out))
+	r.FieldsPerRecord = -1
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(record) < 3 {
+			continue
+		}
+		name := record[0]
+		if name == strings.TrimSpace(string(module)) || strings.HasPrefix(name, strings.TrimSpace(string(module))+"/") {
+			continue
+		}
+		license := record[2]
+		if license == "Unknown" {
+			license = ""
+		}
+		deps = append(deps, dependencyLicense{Name: name, License: license})
+	}
+	return deps, nil
+}
+
+// npmLicenses reads the license field of each direct dependency installed
+// in node_modules
+func npmLicenses() ([]dependencyLicense, error) {
+	var pkg struct {
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	data, err := os.ReadFile("package.json")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse package.json: %w", err)
+	}
+	names := make([]string, 0, len(pkg.Dependencies))
+	for name := range pkg.Dependencies {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var deps []dependencyLicense
+	var missing int
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join("node_modules", name, "package.json"))
+		if err != nil {
+			missing++
+			continue
+		}
+		// license is a string, or an object in old packages
+		var manifest struct {
+			License json.RawMessage `json:"license"`
+		}
+		json.Unmarshal(data, &manifest)
+		var license string
+		if json.Unmarshal(manifest.License, &license) != nil {
+			var old struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal(manifest.License, &old)
+			license = old.Type
+		}
+		deps = append(deps, dependencyLicense{Name: name, License: license})
+	}
+	if missing > 0 {
+		return deps, fmt.Errorf("%d of the dependencies aren't installed, run npm install to check them", missing)
+	}
+	return deps, nil
+}
+
+// lineComments maps extensions to the line comment of their language;
+// headers are only checked in these files
+var lineComments = map[string]string{
+	".go": "//", ".js": "//", ".jsx": "//", ".ts": "//", ".tsx": "//", ".mjs": "//",
+	".java": "//", ".kt": "//", ".scala": "//", ".swift": "//", ".dart": "//",
+	".c": "//", ".h": "//", ".cc": "//", ".cpp": "//", ".hpp": "//", ".cs": "//",
+	".rs": "//", ".proto": "//", ".php": "//",
+	".py": "#", ".rb": "#", ".sh": "#", ".bash": "#", ".pl": "#", ".r": "#",
+	".yaml": "#", ".yml": "#", ".toml": "#",
+	".sql": "--", ".lua": "--", ".hs": "--",
+}
+
+// generatedFile matches the marker of generated Go and other files, which
+// aren't edited by hand
+var generatedFile = regexp.MustCompile(`(?m)^.{0,4}(Code generated .* DO NOT EDIT|@generated)`)
+
+// headerPattern matches the policy's header in a file's first lines, with
+// comment markers stripped, and any year where it has {year}. Without a
+// header in the policy, any copyright notice or SPDX identifier will do.
+func headerPattern(header string) *regexp.Regexp {
+	if strings.TrimSpace(header) == "" {
+		return regexp.MustCompile(`(?im)^(copyright\b|spdx-license-identifier:)`)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(header), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			quoted := regexp.QuoteMeta(line)
+			lines = append(lines, strings.ReplaceAll(quoted, regexp.QuoteMeta("{year}"), `\d{4}(\s*-\s*\d{4})?`))
+		}
+	}
+	return regexp.MustCompile(`(?m)^` + strings.Join(lines, `\s*\n`) + `\s*$`)
+}
+
+// headerText strips comment markers from the first lines of a file
+func headerText(content, comment string) string {
+	lines := strings.SplitN(content, "\n", headerLines+1)
+	for i, line := range lines[:min(len(lines), headerLines)] {
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "*/"))
+		for _, marker := range []string{comment, "/*", "*"} {
+			if rest, ok := strings.CutPrefix(line, marker); ok {
+				line = strings.TrimSpace(rest)
+				break
+			}
+		}
+		lines[i] = line
+	}
+	return strings.Join(lines[:min(len(lines), headerLines)], "\n")
+}
+
+// sourceFiles lists the files the policy wants headers in: the tracked and
+// untracked files git knows of, or every file outside git, limited to
+// paths when given
+func sourceFiles(ctx context.Context, policy licensePolicy, paths []string) ([]string, error) {
+	var files []string
+	if len(paths) > 0 {
+		files = paths
+	} else if out, err := gitOutput(ctx, "ls-files", "-z", "--cached", "--others", "--exclude-standard"); err == nil {
+		files = strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	} else {
+		err := walkFiles(ctx, ".", 0, func(relPath string, d fs.DirEntry) bool {
+			if d.Type().IsRegular() {
+				files = append(files, filepath.ToSlash(relPath))
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var selected []string
+	for _, file := range files {
+		name := filepath.ToSlash(file)
+		if _, ok := lineComments[strings.ToLower(path.Ext(name))]; !ok || inDependencyDir(name) {
+			continue
+		}
+		matches := func(globs []string) bool {
+			return slices.ContainsFunc(globs, func(g string) bool { return matchGlob(g, name) })
+		}
+		if len(policy.Include) > 0 && !matches(policy.Include) || matches(policy.Exclude) {
+			continue
+		}
+		selected = append(selected, file)
+	}
+	return selected, nil
+}
+
+// missingHeaders lists the source files without the policy's header
+func missingHeaders(ctx context.Context, policy licensePolicy, paths []string) ([]string, error) {
+	files, err := sourceFiles(ctx, policy, paths)
+	if err != nil {
+		return nil, err
+	}
+	pattern := headerPattern(policy.Header)
+	var missing []string
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			if len(paths) > 0 {
+				return nil, err
+			}
+			continue // deleted but still in the index
+		}
+		text := string(content)
+		if isBinary(content) || generatedFile.MatchString(text) {
+			continue
+		}
+		if !pattern.MatchString(headerText(text, lineComments[strings.ToLower(filepath.Ext(file))])) {
+			missing = append(missing, file)
+		}
+	}
+	return missing, nil
+}
+
+// planHeaders adds the header to each file missing it, in memory. It goes
+// below a shebang, for Python an encoding line and for PHP the opening tag,
+// and above everything else, such as Go build constraints.
+func planHeaders(ctx context.Context, licenseInput LicenseCheckInput) ([]*fileChange, error) {
+	policy, err := loadLicensePolicy()
+	if err != nil {
+		return nil, err
+	}
+	if policy.Header == "" {
+		policy.Header = licenseInput.Header
+	}
+	if strings.TrimSpace(policy.Header) == "" {
+		return nil, newToolError(errInvalidInput, "there is no header to add", "Pass the header text, or ask the user to set header in .codegent/licenses.json.")
+	}
+	missing, err := missingHeaders(ctx, policy, licenseInput.Paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return nil, newToolError(errNoMatch, "every file already has the header", "")
+	}
+	if len(missing) > maxHeaderFiles {
+		return nil, newToolError(errInvalidInput, fmt.Sprintf("%d files lack the header, more than the limit of %d", len(missing), maxHeaderFiles), "Pass paths to add it in several calls.")
+	}
+
+	header := strings.ReplaceAll(strings.TrimSpace(policy.Header), "{year}", strconv.Itoa(time.Now().Year()))
+	var changes []*fileChange
+	for _, file := range missing {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := fileVersions.check(file, content); err != nil {
+			return nil, err
+		}
+		comment := lineComments[strings.ToLower(filepath.Ext(file))]
+		var block strings.Builder
+		for _, line := range strings.Split(header, "\n") {
+			block.WriteString(strings.TrimRight(comment+" "+strings.TrimSpace(line), " ") + "\n")
+		}
+		block.WriteString("\n")
+
+		text := string(content)
+		var prologue string
+		for _, prefix := range []string{"#!", "# -*- coding", "# vim: set fileencoding"} {
+			if strings.HasPrefix(text, prefix) {
+				line, rest, _ := strings.Cut(text, "\n")
+				prologue += line + "\n"
+				text = rest
+			}
+		}
+		// PHP prints whatever comes before its opening tag
+		if strings.HasPrefix(text, "<?php") {
+			line, rest, _ := strings.Cut(text, "\n")
+			if strings.TrimSpace(line) == "<?php" {
+				prologue += line + "\n"
+				text = rest
+			} else {
+				prologue += "<?php\n"
+				text = strings.TrimLeft(strings.TrimPrefix(text, "<?php"), " \t")
+			}
+		}
+		if prologue != "" {
+			prologue += "\n"
+			text = strings.TrimLeft(text, "\n")
+		}
+		newContent := prologue + withLineEndings(text, block.String()) + text
+		changes = append(changes, &fileChange{path: file, oldContent: content, newContent: newContent, exists: true})
+	}
+	return changes, nil
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Limits on what analyze_logs returns
+const (
+	defaultLogEntries    = 100
+	maxLogEntries        = 500
+	maxLogClusters       = 20
+	maxLogLineLength     = 500
+	maxContinuationLines = 50  // of one entry, such as a stack trace
+	logSummaryTail       = 20  // entries at the end of a summary
+	maxLogHours          = 48  // rows of the per-hour counts
+	logPrefixLength      = 256 // where timestamps and levels are looked for
+)
+
+// Analyze Logs Tool
+var AnalyzeLogsDefinition = ToolDefinition{
+	Name:        "analyze_logs",
+	Description: "Analyze a log file of any size, plain or gzipped, without reading it whole. summary counts the entries by level, groups errors and warnings into clusters of the same message with numbers, ids and addresses left out, counts them per hour and shows the last entries; start there to find out why something failed. search returns the entries matching pattern, and tail the last entries. All of them take a time range and a minimum level. Multi-line entries such as stack traces stay together, and panics and tracebacks count as fatal. Timestamps are found in ISO 8601, syslog, Apache and JSON logs; logs are assumed to be in time order, which lets a time range skip the rest of a large file.",
+	InputSchema: GenerateSchema[AnalyzeLogsInput](),
+	Kind:        ToolRead,
+	Function:    AnalyzeLogs,
+}
+
+type AnalyzeLogsInput struct {
+	Path    string `json:"path" jsonschema_description:"The log file, optionally gzipped." jsonschema:"required"`
+	Action  string `json:"action,omitempty" jsonschema_description:"summary, search or tail; summary when empty." jsonschema:"enum=summary,enum=search,enum=tail"`
+	Pattern string `json:"pattern,omitempty" jsonschema_description:"A regular expression the entries must match, required by search."`
+	Level   string `json:"level,omitempty" jsonschema_description:"The minimum level of the entries, such as warn or error." jsonschema:"enum=trace,enum=debug,enum=info,enum=warn,enum=error,enum=fatal"`
+	Since   string `json:"since,omitempty" jsonschema_description:"Only entries from this time on: a time such as 2024-05-01 22:00 or 2024-05-01T22:00:00Z, in local time without a zone, or a duration before now such as 12h."`
+	Until   string `json:"until,omitempty" jsonschema_description:"Only entries before this time, in the same forms as since."`
+	Limit   int    `json:"limit,omitempty" jsonschema_description:"search and tail: how many entries to return, 100 when 0, at most 500."`
+}
+
+// logLevels orders the levels, their index is the severity
+var logLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// logLevelAliases maps other level names to logLevels
+var logLevelAliases = map[string]string{
+	"verbose": "debug", "notice": "info", "information": "info", "warning": "warn", "err": "error",
+	"critical": "fatal", "crit": "fatal", "alert": "fatal", "emerg": "fatal", "emergency": "fatal", "panic": "fatal", "severe": "fatal",
+}
+
+var (
+	logTimestamp = regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?: ?(?:Z|[+-]\d{2}:?\d{2}))?|^[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}|\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}`)
+	// logCrash matches the lines a crashing program starts its last words
+	// with, which have no timestamp of their own
+	logCrash = regexp.MustCompile(`^(panic: |fatal error: |Traceback \(most recent call last\)|Exception in thread |Segmentation fault|SIGSEGV|Killed$)`)
+	// logVariable matches the parts of messages that differ between
+	// occurrences of the same error: quoted strings, uuids, addresses, hex
+	// and other numbers
+	logVariable = regexp.MustCompile(`"[^"]*"|'[^']*'|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b|\b0x[0-9a-fA-F]+\b|\b[0-9a-f]{12,}\b|\d+(?:\.\d+)?`)
+)
+
+// logTimeLayouts are tried in order on the timestamps logTimestamp finds
+var logTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999Z0700", "2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05.999999999 Z07:00", "2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999", "2006/01/02 15:04:05.999999999", "Jan _2 15:04:05", "02/Jan/2006:15:04:05 -0700",
+}
+
+// logEntry is a line starting with a timestamp, a level or a crash,
+// together with the lines that continue it
+type logEntry struct {
+	lines   []string
+	more    int // continuation lines left out
+	time    time.Time
+	level   int // index in logLevels, -1 when unknown
+	message string
+}
+
+// logFilter is what the entries must be
+type logFilter struct {
+	since, until time.Time
+	level        int
+	pattern      *regexp.Regexp
+}
+
+func (f *logFilter) matches(entry *logEntry) bool {
+	if !f.since.IsZero() && (entry.time.IsZero() || entry.time.Before(f.since)) {
+		return false
+	}
+	if !f.until.IsZero() && (entry.time.IsZero() || !entry.time.Before(f.until)) {
+		return false
+	}
+	if f.level > 0 && entry.level < f.level {
+		return false
+	}
+	return f.pattern == nil || slices.ContainsFunc(entry.lines, f.pattern.MatchString)
+}
+
+func AnalyzeLogs(ctx context.Context, input json.RawMessage) (string, error) {
+	logsInput := AnalyzeLogsInput{}
+	if err := json.Unmarshal(input, &logsInput); err != nil {
+		return "", err
+	}
+	filter := logFilter{}
+	var err error
+	if filter.since, err = parseLogBound(logsInput.Since); err != nil {
+		return "", err
+	}
+	if filter.until, err = parseLogBound(logsInput.Until); err != nil {
+		return "", err
+	}
+	if logsInput.Level != "" {
+		if filter.level = parseLogLevel(logsInput.Level); filter.level < 0 {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("unknown level %q", logsInput.Level), "Use one of "+strings.Join(logLevels, ", ")+".")
+		}
+	}
+	if logsInput.Pattern != "" {
+		if filter.pattern, err = regexp.Compile(logsInput.Pattern); err != nil {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("invalid pattern: %v", err), "Patterns use Go's RE2 syntax.")
+		}
+	}
+	action := cmp.Or(logsInput.Action, "summary")
+	if action == "search" && filter.pattern == nil {
+		return "", newToolError(errInvalidInput, "search needs a pattern", "Give a pattern, or use summary or tail.")
+	}
+	limit := min(cmp.Or(max(logsInput.Limit, 0), defaultLogEntries), maxLogEntries)
+
+	switch action {
+	case "summary":
+		summary := newLogSummary(filter)
+		scanned, err := scanLog(ctx, logsInput.Path, filter, summary.add)
+		if err != nil {
+			return "", err
+		}
+		return summary.String(logsInput.Path, scanned), nil
+	case "search", "tail":
+		var entries []*logEntry
+		found := 0
+		scanned, err := scanLog(ctx, logsInput.Path, filter, func(entry *logEntry) {
+			if !filter.matches(entry) {
+				return
+			}
+			found++
+			// search keeps the first entries, tail the last ones
+			if action == "search" && len(entries) == limit {
+				return
+			}
+			entries = append(entries, entry)
+			if len(entries) > limit {
+				entries = entries[1:]
+			}
+		})
+		if err != nil {
+			return "", err
+		}
+		if found == 0 {
+			return fmt.Sprintf("No matching entries among %d lines of %s", scanned, logsInput.Path), nil
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "%d of %d matching entries, from %d lines of %s:\n", len(entries), found, scanned, logsInput.Path)
+		for _, entry := range entries {
+			writeLogEntry(&b, entry, "")
+		}
+		return b.String(), nil
+	}
+	return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", action), "Use summary, search or tail.")
+}
+
+// parseLogBound reads since and until: a time, or a duration before now
+func parseLogBound(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d.Abs()), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", time.DateTime, "2006-01-02T15:04", "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, newToolError(errInvalidInput, fmt.Sprintf("can't read the time %q", value), "Give a time such as 2024-05-01 22:00, or a duration such as 12h.")
+}
+
+// parseLogLevel returns the index of a level in logLevels, or -1
+func parseLogLevel(name string) int {
+	name = strings.ToLower(name)
+	return slices.Index(logLevels, cmp.Or(logLevelAliases[name], name))
+}
+
+// logParser reads the lines of one log, whose timestamps all have the
+// same layout, so it tries the one that worked last first
+type logParser struct {
+	layout int
+}
+
+// time reads a timestamp logTimestamp found. Syslog timestamps have no
+// year, they're taken to be from the last twelve months.
+func (p *logParser) time(value string) (time.Time, bool) {
+	value = strings.Replace(value, ",", ".", 1)
+	for i := range logTimeLayouts {
+		layout := (p.layout + i) % len(logTimeLayouts)
+		t, err := time.ParseInLocation(logTimeLayouts[layout], value, time.Local)
+		if err != nil {
+			continue
+		}
+		p.layout = layout
+		if t.Year() == 0 {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// lineLevel finds the level among the words of a line's start: upper-case
+// ones, and lower-case ones only as level=error, so the word error in a
+// message isn't taken for one
+func lineLevel(prefix string) int {
+	isLetter := func(c byte) bool { return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+	for i := 0; i < len(prefix); {
+		if !isLetter(prefix[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(prefix) && isLetter(prefix[i]) {
+			i++
+		}
+		word := prefix[start:i]
+		if word == strings.ToUpper(word) {
+			if level := parseLogLevel(word); level >= 0 {
+				return level
+			}
+			continue
+		}
+		switch strings.ToLower(word) {
+		case "level", "lvl", "severity":
+			value := strings.TrimLeft(prefix[i:], `=: "`)
+			if len(value) < len(prefix[i:]) {
+				end := 0
+				for end < len(value) && isLetter(value[end]) {
+					end++
+				}
+				if level := parseLogLevel(value[:end]); level >= 0 {
+					return level
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// line finds the timestamp, level and message of a line. JSON lines have
+// them in fields, other lines near their start.
+func (p *logParser) line(line string) (t time.Time, level int, message string) {
+	level = -1
+	if strings.HasPrefix(line, "{") {
+		var fields map[string]any
+		if json.Unmarshal([]byte(line), &fields) == nil {
+			for _, key := range []string{"time", "timestamp", "@timestamp", "ts", "t"} {
+				switch value := fields[key].(type) {
+				case string:
+					t, _ = p.time(value)
+				case float64:
+					// Seconds since the epoch, or milliseconds
+					if value > 1e12 {
+						value /= 1000
+					}
+					sec, frac := int64(value), value-float64(int64(value))
+					t = time.Unix(sec, int64(frac*1e9))
+				}
+				if !t.IsZero() {
+					break
+				}
+			}
+			for _, key := range []string{"level", "severity", "lvl", "levelname", "log.level"} {
+				if name, ok := fields[key].(string); ok {
+					level = parseLogLevel(name)
+					break
+				}
+			}
+			for _, key := range []string{"msg", "message", "error", "err"} {
+				if text, ok := fields[key].(string); ok {
+					return t, level, text
+				}
+			}
+			return t, level, line
+		}
+	}
+
+	prefix := line[:min(len(line), logPrefixLength)]
+	message = line
+	if loc := logTimestamp.FindStringIndex(prefix); loc != nil {
+		t, _ = p.time(strings.Trim(prefix[loc[0]:loc[1]], "[]"))
+		message = line[loc[1]:]
+	}
+	level = lineLevel(prefix)
+	if logCrash.MatchString(line) {
+		level = len(logLevels) - 1
+	}
+	return t, level, strings.TrimSpace(message)
+}
+
+// scanLog reads the log entry by entry, handing those in the filter's time
+// range to fn, and returns how many lines it read. With since set, the
+// start is found by a binary search on the file's timestamps.
+func scanLog(ctx context.Context, path string, filter logFilter, fn func(*logEntry)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		in = gz
+	} else if !filter.since.IsZero() {
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		if start := seekLogTime(f, info.Size(), filter.since); start > 0 {
+			if _, err := f.Seek(start, io.SeekStart); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	r := bufio.NewReaderSize(in, 64*1024)
+	parser := &logParser{}
+	var entry *logEntry
+	var last time.Time
+	flush := func() bool {
+		if entry == nil {
+			return true
+		}
+		if !filter.until.IsZero() && !entry.time.IsZero() && !entry.time.Before(filter.until) {
+			return false
+		}
+		if filter.since.IsZero() || !entry.time.IsZero() && !entry.time.Before(filter.since) {
+			fn(entry)
+		}
+		return true
+	}
+	lines := 0
+	for {
+		line, err := readLogLine(r)
+		if strings.TrimSpace(line) != "" {
+			lines++
+			if lines%10000 == 0 && ctx.Err() != nil {
+				return lines, ctx.Err()
+			}
+			t, level, message := parser.line(line)
+			// A line with neither timestamp nor level continues the entry
+			// before it, once the log has shown it has timestamps
+			switch {
+			case t.IsZero() && level < 0 && !last.IsZero() && entry != nil:
+				if len(entry.lines) < maxContinuationLines {
+					entry.lines = append(entry.lines, line)
+				} else {
+					entry.more++
+				}
+			case !flush():
+				return lines, nil
+			default:
+				if t.IsZero() {
+					t = last
+				}
+				last = t
+				entry = &logEntry{lines: []string{line}, time: t, level: level, message: message}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	flush()
+	return lines, nil
+}
+
+// readLogLine reads one line, cutting lines longer than the buffer
+func readLogLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	text := string(line)
+	for errors.Is(err, bufio.ErrBufferFull) {
+		_, err = r.ReadSlice('\n')
+	}
+	return strings.TrimRight(text, "\r\n"), err
+}
+
+// seekLogTime finds an offset at a line shortly before the first one
+// stamped since or later, assuming the log is in time order
+func seekLogTime(f *os.File, size int64, since time.Time) int64 {
+	const window = 64 * 1024
+	lo, hi := int64(0), size
+	for hi-lo > window {
+		mid := lo + (hi-lo)/2
+		t, ok := firstLogTime(f, mid, window)
+		if ok && t.Before(since) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0
+	}
+	// Start at the beginning of a line
+	r := bufio.NewReader(io.NewSectionReader(f, lo, size-lo))
+	skipped, _ := r.ReadString('\n')
+	return lo + int64(len(skipped))
+}
+
+// firstLogTime returns the timestamp of the first whole line with one in
+// the window at offset
+func firstLogTime(f *os.File, offset, window int64) (time.Time, bool) {
+	buf := make([]byte, window)
+	n, _ := f.ReadAt(buf, offset)
+	lines := strings.Split(string(buf[:n]), "\n")
+	parser := &logParser{}
+	for _, line := range lines[1:max(len(lines)-1, 1)] {
+		if t, _, _ := parser.line(line); !t.IsZero() {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// writeLogEntry writes an entry's lines, cut to maxLogLineLength
+func writeLogEntry(b *strings.Builder, entry *logEntry, indent string) {
+	for _, line := range entry.lines {
+		fmt.Fprintf(b, "%s%s\n", indent, truncateRunes(line, maxLogLineLength))
+	}
+	if entry.more > 0 {
+		fmt.Fprintf(b, "%s... %d more lines\n", indent, entry.more)
+	}
+}
+
+// logCluster is the occurrences of one error or warning message
+type logCluster struct {
+	key         string
+	level       int
+	count       int
+	first, last time.Time
+	example     *logEntry
+}
+
+// logSummary aggregates the entries for the summary action
+type logSummary struct {
+	filter      logFilter
+	entries     int
+	first, last time.Time
+	levels      []int
+	clusters    map[string]*logCluster
+	hours       map[time.Time]int
+	tail        []*logEntry
+}
+
+func newLogSummary(filter logFilter) *logSummary {
+	return &logSummary{filter: filter, levels: make([]int, len(logLevels)), clusters: make(map[string]*logCluster), hours: make(map[time.Time]int)}
+}
+
+func (s *logSummary) add(entry *logEntry) {
+	// The counts cover every level, the rest only the levels asked for
+	levelFilter := s.filter
+	levelFilter.level = 0
+	if !levelFilter.matches(entry) {
+		return
+	}
+	s.entries++
+	if !entry.time.IsZero() {
+		if s.first.IsZero() {
+			s.first = entry.time
+		}
+		s.last = entry.time
+	}
+	if entry.level >= 0 {
+		s.levels[entry.level]++
+	}
+	if !s.filter.matches(entry) {
+		return
+	}
+	s.tail = append(s.tail, entry)
+	if len(s.tail) > logSummaryTail {
+		s.tail = s.tail[1:]
+	}
+	if entry.level < max(s.filter.level, slices.Index(logLevels, "warn")) {
+		return
+	}
+	if !entry.time.IsZero() {
+		s.hours[entry.time.Truncate(time.Hour)]++
+	}
+	key := truncateRunes(strings.Join(strings.Fields(logVariable.ReplaceAllString(entry.message, "_")), " "), 200)
+	cluster, ok := s.clusters[key]
+	if !ok {
+		cluster = &logCluster{key: key, level: entry.level, first: entry.time, example: entry}
+		s.clusters[key] = cluster
+	}
+	cluster.count++
+	cluster.last = entry.time
+}
+
+func (s *logSummary) String(path string, lines int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d lines read, %d entries", path, lines, s.entries)
+	if !s.first.IsZero() {
+		fmt.Fprintf(&b, " from %s to %s", s.first.Format(time.DateTime), s.last.Format(time.DateTime))
+	}
+	b.WriteString("\n")
+	var counts []string
+	for level := len(logLevels) - 1; level >= 0; level-- {
+		if s.levels[level] > 0 {
+			counts = append(counts, fmt.Sprintf("%s %d", logLevels[level], s.levels[level]))
+		}
+	}
+	if len(counts) > 0 {
+		fmt.Fprintf(&b, "By level: %s\n", strings.Join(counts, ", "))
+	}
+
+	clusters := slices.Collect(maps.Values(s.clusters))
+	slices.SortFunc(clusters, func(a, b *logCluster) int {
+		return cmp.Or(cmp.Compare(b.count, a.count), cmp.Compare(b.level, a.level), strings.Compare(a.key, b.key))
+	})
+	if len(clusters) > 0 {
+		b.WriteString("\nErrors and warnings by message, the most frequent first:\n")
+		for i, cluster := range clusters {
+			if i == maxLogClusters {
+				fmt.Fprintf(&b, "... %d more, narrow the time range or raise the level\n", len(clusters)-i)
+				break
+			}
+			fmt.Fprintf(&b, "%d× %s", cluster.count, logLevels[cluster.level])
+			if !cluster.first.IsZero() {
+				fmt.Fprintf(&b, ", %s to %s", cluster.first.Format(time.DateTime), cluster.last.Format(time.DateTime))
+			}
+			fmt.Fprintf(&b, ": %s\n", cluster.key)
+			writeLogEntry(&b, &logEntry{lines: cluster.example.lines[:min(len(cluster.example.lines), 5)]}, "    ")
+		}
+
+		hours := slices.SortedFunc(maps.Keys(s.hours), time.Time.Compare)
+		if len(hours) > 1 {
+			b.WriteString("\nErrors and warnings per hour:\n")
+			for _, hour := range hours[max(len(hours)-maxLogHours, 0):] {
+				fmt.Fprintf(&b, "%s  %d\n", hour.Format("2006-01-02 15:00"), s.hours[hour])
+			}
+		}
+	}
+
+	if len(s.tail) > 0 {
+		fmt.Fprintf(&b, "\nThe last %d entries:\n", len(s.tail))
+		for _, entry := range s.tail {
+			writeLogEntry(&b, entry, "")
+		}
+	}
+	return b.String()
+}
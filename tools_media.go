@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// maxInlineFileSize keeps a single attachment well under the API's 20MB
+// request limit
+const maxInlineFileSize = 15 << 20
+
+// ReadImage Tool
+var ReadImageDefinition = ToolDefinition{
+	Name:          "read_image",
+	Description:   "Load an image file (PNG, JPEG, WEBP, HEIC or HEIF) from the working directory so you can look at it, e.g. diagrams, screenshots or design mockups.",
+	InputSchema:   GenerateSchema[ReadImageInput](),
+	Kind:          ToolRead,
+	MediaFunction: ReadImage,
+}
+
+type ReadImageInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of an image file in the working directory." jsonschema:"required"`
+}
+
+// ReadPDF Tool
+var ReadPDFDefinition = ToolDefinition{
+	Name:          "read_pdf",
+	Description:   "Load a PDF document from the working directory so you can read it, including its figures and tables. Use this instead of read_file for .pdf files.",
+	InputSchema:   GenerateSchema[ReadPDFInput](),
+	Kind:          ToolRead,
+	MediaFunction: ReadPDF,
+}
+
+type ReadPDFInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of a PDF file in the working directory." jsonschema:"required"`
+}
+
+var imageMIMETypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".webp": "image/webp",
+	".heic": "image/heic",
+	".heif": "image/heif",
+}
+
+func ReadImage(ctx context.Context, input json.RawMessage) (string, *genai.Blob, error) {
+	readImageInput := ReadImageInput{}
+	if err := json.Unmarshal(input, &readImageInput); err != nil {
+		return "", nil, err
+	}
+
+	mimeType, ok := imageMIMETypes[strings.ToLower(filepath.Ext(readImageInput.Path))]
+	if !ok {
+		return "", nil, fmt.Errorf("unsupported image type %q, want PNG, JPEG, WEBP, HEIC or HEIF", filepath.Ext(readImageInput.Path))
+	}
+	return loadInlineFile(readImageInput.Path, mimeType)
+}
+
+func ReadPDF(ctx context.Context, input json.RawMessage) (string, *genai.Blob, error) {
+	readPDFInput := ReadPDFInput{}
+	if err := json.Unmarshal(input, &readPDFInput); err != nil {
+		return "", nil, err
+	}
+	return loadInlineFile(readPDFInput.Path, "application/pdf")
+}
+
+// loadInlineFile reads a file to attach for the model, checking its size and
+// that its contents match the expected type
+func loadInlineFile(path, mimeType string) (string, *genai.Blob, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return "", nil, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > maxInlineFileSize {
+		return "", nil, fmt.Errorf("%s is %d bytes, larger than the %d byte limit", path, info.Size(), maxInlineFileSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// HEIC/HEIF aren't sniffed by net/http, trust the extension for those
+	if detected := http.DetectContentType(data); detected != "application/octet-stream" && detected != mimeType {
+		return "", nil, fmt.Errorf("%s looks like %s, not %s", path, detected, mimeType)
+	}
+
+	return fmt.Sprintf("Loaded %s (%s, %d bytes), its contents are attached below.", path, mimeType, len(data)),
+		&genai.Blob{MIMEType: mimeType, Data: data}, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MultiEdit Tool
+var MultiEditDefinition = ToolDefinition{
+	Name: "multi_edit",
+	Description: `Apply a batch of edits, possibly across many files, in one call.
+
+Each edit replaces 'old_str' with 'new_str' in 'path', like edit_file. Edits to the same file are applied in order, so later edits see the result of earlier ones. An empty 'old_str' creates a new file.
+
+The batch is all or nothing: if any edit fails, no file is changed. Prefer this over repeated edit_file calls for refactors that touch several places.`,
+	InputSchema: GenerateSchema[MultiEditInput](),
+	Kind:        ToolWrite,
+	Function:    MultiEdit,
+	Preview:     MultiEditPreview,
+}
+
+type MultiEditInput struct {
+	Edits []MultiEditItem `json:"edits" jsonschema_description:"The edits to apply, in order." jsonschema:"required"`
+}
+
+// MultiEditItem is one edit of a batch. Unlike edit_file's, it can't
+// address lines, which earlier edits in the batch would shift.
+type MultiEditItem struct {
+	Path   string `json:"path" jsonschema_description:"The path to the file" jsonschema:"required"`
+	OldStr string `json:"old_str" jsonschema_description:"Text to search for - must match exactly. Use empty string to create a new file."`
+	NewStr string `json:"new_str" jsonschema_description:"Text to replace old_str with, or contents for a new file if old_str is empty"`
+}
+
+// fileChange is the planned new content of one file in a batch
+type fileChange struct {
+	path       string
+	oldContent []byte
+	newContent string
+	exists     bool
+}
+
+// planEdits applies the edits in memory and returns the resulting file
+// contents, in the order the files were first touched. Nothing is written.
+func planEdits(edits []MultiEditItem) ([]*fileChange, error) {
+	if len(edits) == 0 {
+		return nil, errors.New("no edits given")
+	}
+
+	var changes []*fileChange
+	byPath := make(map[string]*fileChange)
+	for i, edit := range edits {
+		if edit.Path == "" {
+			return nil, fmt.Errorf("edit %d: path is required", i+1)
+		}
+		if err := editingNotebook(edit.Path); err != nil {
+			return nil, fmt.Errorf("edit %d: %w", i+1, err)
+		}
+		if edit.OldStr == edit.NewStr {
+			return nil, fmt.Errorf("edit %d: old_str and new_str must be different", i+1)
+		}
+
+		key := filepath.Clean(edit.Path)
+		change, ok := byPath[key]
+		if !ok {
+			change = &fileChange{path: edit.Path}
+			content, err := os.ReadFile(edit.Path)
+			switch {
+			case err == nil:
+				if err := fileVersions.check(edit.Path, content); err != nil {
+					return nil, fmt.Errorf("edit %d: %w", i+1, err)
+				}
+				change.exists = true
+				change.oldContent = content
+				change.newContent = string(content)
+			case !os.IsNotExist(err):
+				return nil, fmt.Errorf("edit %d: %w", i+1, err)
+			}
+			byPath[key] = change
+			changes = append(changes, change)
+		}
+
+		oldStr := withLineEndings(change.newContent, edit.OldStr)
+		newStr := withLineEndings(change.newContent, edit.NewStr)
+		switch {
+		case edit.OldStr == "" && !change.exists && change.newContent == "":
+			change.newContent = edit.NewStr
+		case edit.OldStr == "":
+			return nil, newToolError(errInvalidInput, fmt.Sprintf("edit %d: old_str is empty but %s already exists", i+1, edit.Path), "Give the text to replace as old_str; an empty old_str creates a new file.")
+		case !strings.Contains(change.newContent, oldStr):
+			return nil, newToolError(errNoMatch, fmt.Sprintf("edit %d: old_str not found in %s", i+1, edit.Path), "Read the file again with read_file and copy old_str from it exactly, bearing in mind that earlier edits in the batch apply first.")
+		default:
+			change.newContent = strings.ReplaceAll(change.newContent, oldStr, newStr)
+		}
+	}
+	return changes, nil
+}
+
+func MultiEditPreview(ctx context.Context, input json.RawMessage) (string, error) {
+	multiEditInput := MultiEditInput{}
+	if err := json.Unmarshal(input, &multiEditInput); err != nil {
+		return "", err
+	}
+	changes, err := planEdits(multiEditInput.Edits)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, change := range changes {
+		sb.WriteString(unifiedDiff(change.path, string(change.oldContent), change.newContent))
+	}
+	return sb.String(), nil
+}
+
+func MultiEdit(ctx context.Context, input json.RawMessage) (string, error) {
+	multiEditInput := MultiEditInput{}
+	if err := json.Unmarshal(input, &multiEditInput); err != nil {
+		return "", err
+	}
+	changes, err := planEdits(multiEditInput.Edits)
+	if err != nil {
+		return "", err
+	}
+
+	// Write every file, and put back what was there if any write fails
+	for i, change := range changes {
+		if err := writeChange(change); err != nil {
+			for _, done := range changes[:i] {
+				rollbackChange(done)
+			}
+			return "", fmt.Errorf("failed to write %s, no files were changed: %w", change.path, err)
+		}
+	}
+
+	paths := make([]string, len(changes))
+	for i, change := range changes {
+		fileVersions.record(change.path, []byte(change.newContent))
+		paths[i] = change.path
+	}
+	return fmt.Sprintf("Applied %d edits to %d files: %s", len(multiEditInput.Edits), len(changes), strings.Join(paths, ", ")), nil
+}
+
+func writeChange(change *fileChange) error {
+	if !change.exists {
+		if err := os.MkdirAll(filepath.Dir(change.path), 0755); err != nil {
+			return err
+		}
+	}
+	fileVersions.beforeWrite(change.path)
+	return os.WriteFile(change.path, []byte(change.newContent), 0644)
+}
+
+func rollbackChange(change *fileChange) {
+	if change.exists {
+		os.WriteFile(change.path, change.oldContent, 0644)
+	} else {
+		os.Remove(change.path)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// maxCellOutputTokens bounds the outputs notebook_read shows for one cell
+const maxCellOutputTokens = 1000
+
+// NotebookRead Tool
+var NotebookReadDefinition = ToolDefinition{
+	Name:        "notebook_read",
+	Description: "Read a Jupyter notebook (.ipynb) cell by cell instead of as raw JSON: each cell's number, type and source, and the outputs of code cells, with text and errors summarized and images only named. Use the cell numbers it shows with notebook_edit.",
+	InputSchema: GenerateSchema[NotebookReadInput](),
+	Kind:        ToolRead,
+	Function:    NotebookRead,
+}
+
+type NotebookReadInput struct {
+	Path        string `json:"path" jsonschema_description:"The relative path of the notebook." jsonschema:"required"`
+	Cell        int    `json:"cell,omitempty" jsonschema_description:"Optional number of the only cell to show, counting from 1."`
+	SkipOutputs bool   `json:"skip_outputs,omitempty" jsonschema_description:"Leave out the outputs of code cells."`
+}
+
+// NotebookEdit Tool
+var NotebookEditDefinition = ToolDefinition{
+	Name: "notebook_edit",
+	Description: `Edit the cells of a Jupyter notebook (.ipynb). Never change notebooks with edit_file: replacing text in their JSON corrupts them.
+
+replace sets the source of cell 'cell', and its type when cell_type is given. insert adds a new cell after 'cell', 0 for the top, and creates the notebook if it doesn't exist. delete removes cell 'cell'. Cells are numbered from 1, as notebook_read shows them. Code cells whose source changes lose their outputs, which no longer match; run the notebook again to get new ones.`,
+	InputSchema: GenerateSchema[NotebookEditInput](),
+	Kind:        ToolWrite,
+	Function:    NotebookEdit,
+	Preview:     NotebookEditPreview,
+}
+
+type NotebookEditInput struct {
+	Path     string `json:"path" jsonschema_description:"The relative path of the notebook." jsonschema:"required"`
+	Action   string `json:"action" jsonschema_description:"replace, insert or delete." jsonschema:"required,enum=replace,enum=insert,enum=delete"`
+	Cell     int    `json:"cell" jsonschema_description:"The cell to replace or delete, or the cell to insert after, 0 inserting at the top." jsonschema:"required"`
+	CellType string `json:"cell_type,omitempty" jsonschema_description:"code, markdown or raw. insert: code when empty. replace: the cell's type is kept when empty." jsonschema:"enum=code,enum=markdown,enum=raw"`
+	Source   string `json:"source,omitempty" jsonschema_description:"replace and insert: the cell's whole new source."`
+}
+
+// notebook is a decoded .ipynb file. Cells are kept as generic maps, so
+// metadata and fields this doesn't know about survive an edit.
+type notebook struct {
+	doc    map[string]any
+	cells  []map[string]any
+	indent string
+}
+
+// editingNotebook refuses text edits of notebooks, see notebook_edit
+func editingNotebook(path string) error {
+	if strings.EqualFold(filepath.Ext(path), ".ipynb") {
+		return newToolError(errInvalidInput, path+" is a Jupyter notebook, which text edits of its JSON corrupt", "Use notebook_read and notebook_edit to change its cells.")
+	}
+	return nil
+}
+
+func parseNotebook(content []byte) (*notebook, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	// Numbers stay as written, execution counts must not turn into floats
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, newToolError(errInvalidInput, "the notebook isn't valid JSON: "+err.Error(), "Fix it with read_file and edit_file, or restore it from git.")
+	}
+	if version, _ := doc["nbformat"].(json.Number); version.String() != "4" {
+		return nil, newToolError(errInvalidInput, fmt.Sprintf("nbformat %v notebooks aren't supported, only version 4", doc["nbformat"]), "Ask the user to upgrade it with jupyter nbconvert --to notebook.")
+	}
+	nb := &notebook{doc: doc, indent: " "}
+	cells, _ := doc["cells"].([]any)
+	for _, cell := range cells {
+		if cell, ok := cell.(map[string]any); ok {
+			nb.cells = append(nb.cells, cell)
+		}
+	}
+	// Jupyter indents by one space, keep whatever the file uses
+	if _, rest, ok := bytes.Cut(content, []byte("\n")); ok {
+		if n := len(rest) - len(bytes.TrimLeft(rest, " \t")); n > 0 {
+			nb.indent = string(rest[:n])
+		}
+	}
+	return nb, nil
+}
+
+// marshal encodes the notebook as Jupyter does: sorted keys, unescaped
+// HTML and non-ASCII characters, and a final newline
+func (nb *notebook) marshal() (string, error) {
+	cells := make([]any, len(nb.cells))
+	for i, cell := range nb.cells {
+		cells[i] = cell
+	}
+	nb.doc["cells"] = cells
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", nb.indent)
+	if err := enc.Encode(nb.doc); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// language is the kernel's language, used for code fences
+func (nb *notebook) language() string {
+	metadata, _ := nb.doc["metadata"].(map[string]any)
+	kernelspec, _ := metadata["kernelspec"].(map[string]any)
+	languageInfo, _ := metadata["language_info"].(map[string]any)
+	language, _ := kernelspec["language"].(string)
+	name, _ := languageInfo["name"].(string)
+	return cmp.Or(language, name, "python")
+}
+
+// multilineText joins the strings notebooks split text into
+func multilineText(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []any:
+		var b strings.Builder
+		for _, line := range value {
+			if line, ok := line.(string); ok {
+				b.WriteString(line)
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// splitSource splits a cell's source into lines the way Jupyter stores it
+func splitSource(source string) []any {
+	lines := []any{}
+	for _, line := range strings.SplitAfter(source, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// cellOutputs summarizes the outputs of a code cell
+func cellOutputs(cell map[string]any) string {
+	outputs, _ := cell["outputs"].([]any)
+	var parts []string
+	for _, output := range outputs {
+		output, ok := output.(map[string]any)
+		if !ok {
+			continue
+		}
+		switch output["output_type"] {
+		case "stream":
+			parts = append(parts, multilineText(output["text"]))
+		case "error":
+			traceback, _ := output["traceback"].([]any)
+			lines := make([]string, 0, len(traceback))
+			for _, line := range traceback {
+				if line, ok := line.(string); ok {
+					lines = append(lines, ansiEscape.ReplaceAllString(line, ""))
+				}
+			}
+			if len(lines) == 0 {
+				lines = append(lines, fmt.Sprintf("%v: %v", output["ename"], output["evalue"]))
+			}
+			parts = append(parts, strings.Join(lines, "\n"))
+		case "execute_result", "display_data":
+			data, _ := output["data"].(map[string]any)
+			if text, ok := data["text/plain"]; ok {
+				parts = append(parts, multilineText(text))
+			}
+			for _, mime := range slices.Sorted(maps.Keys(data)) {
+				if mime != "text/plain" {
+					parts = append(parts, fmt.Sprintf("[%s output]", mime))
+				}
+			}
+		}
+	}
+	return truncateOutput(strings.TrimRight(strings.Join(parts, "\n"), "\n"), maxCellOutputTokens)
+}
+
+// render shows the cells as text, all of them when only is 0
+func (nb *notebook) render(only int, outputs bool) string {
+	var b strings.Builder
+	language := nb.language()
+	for i, cell := range nb.cells {
+		if only > 0 && i+1 != only {
+			continue
+		}
+		cellType, _ := cell["cell_type"].(string)
+		fmt.Fprintf(&b, "## Cell %d, %s", i+1, cellType)
+		if count, ok := cell["execution_count"].(json.Number); ok {
+			fmt.Fprintf(&b, ", run %s", count)
+		}
+		fence := ""
+		if cellType == "code" {
+			fence = language
+		}
+		fmt.Fprintf(&b, "\n```%s\n%s\n```\n", fence, strings.TrimRight(multilineText(cell["source"]), "\n"))
+		if outputs && cellType == "code" {
+			if out := cellOutputs(cell); out != "" {
+				fmt.Fprintf(&b, "Output:\n```\n%s\n```\n", out)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func NotebookRead(ctx context.Context, input json.RawMessage) (string, error) {
+	readInput := NotebookReadInput{}
+	if err := json.Unmarshal(input, &readInput); err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(readInput.Path)
+	if err != nil {
+		return "", err
+	}
+	nb, err := parseNotebook(content)
+	if err != nil {
+		return "", err
+	}
+	fileVersions.record(readInput.Path, content)
+	if readInput.Cell < 0 || readInput.Cell > len(nb.cells) {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("there is no cell %d, the notebook has %d", readInput.Cell, len(nb.cells)), "")
+	}
+	if len(nb.cells) == 0 {
+		return readInput.Path + " has no cells", nil
+	}
+	return nb.render(readInput.Cell, !readInput.SkipOutputs), nil
+}
+
+// newCellID returns an id for a new cell, which nbformat 4.5 requires
+func newCellID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// setCellType changes the type of a cell, adding or dropping the fields
+// only code cells have
+func setCellType(cell map[string]any, cellType string) {
+	cell["cell_type"] = cellType
+	if cellType == "code" {
+		if _, ok := cell["outputs"]; !ok {
+			cell["outputs"] = []any{}
+		}
+		if _, ok := cell["execution_count"]; !ok {
+			cell["execution_count"] = nil
+		}
+	} else {
+		delete(cell, "outputs")
+		delete(cell, "execution_count")
+	}
+}
+
+// planNotebookEdit applies the edit in memory. The change carries the new
+// JSON, before and after the readable cells for the preview.
+func planNotebookEdit(editInput NotebookEditInput) (change *fileChange, before, after string, err error) {
+	if editingNotebook(editInput.Path) == nil {
+		return nil, "", "", newToolError(errInvalidInput, editInput.Path+" isn't a .ipynb notebook", "Use edit_file for other files.")
+	}
+	change = &fileChange{path: editInput.Path}
+	content, err := os.ReadFile(editInput.Path)
+	switch {
+	case err == nil:
+		if err := fileVersions.check(editInput.Path, content); err != nil {
+			return nil, "", "", err
+		}
+		change.exists = true
+		change.oldContent = content
+	case os.IsNotExist(err) && editInput.Action == "insert":
+		content = []byte(`{"cells": [], "metadata": {}, "nbformat": 4, "nbformat_minor": 5}`)
+	default:
+		return nil, "", "", err
+	}
+	nb, err := parseNotebook(content)
+	if err != nil {
+		return nil, "", "", err
+	}
+	before = nb.render(0, false)
+
+	// Inserts go after a cell, at most the last one
+	if editInput.Cell < 0 || editInput.Cell > len(nb.cells) || editInput.Cell == 0 && editInput.Action != "insert" {
+		return nil, "", "", newToolError(errInvalidInput, fmt.Sprintf("there is no cell %d, the notebook has %d", editInput.Cell, len(nb.cells)), "Check the cell numbers with notebook_read.")
+	}
+	switch editInput.Action {
+	case "replace":
+		cell := nb.cells[editInput.Cell-1]
+		if editInput.CellType != "" {
+			setCellType(cell, editInput.CellType)
+		}
+		if multilineText(cell["source"]) != editInput.Source && cell["cell_type"] == "code" {
+			cell["outputs"] = []any{}
+			cell["execution_count"] = nil
+		}
+		cell["source"] = splitSource(editInput.Source)
+	case "insert":
+		cell := map[string]any{"metadata": map[string]any{}, "source": splitSource(editInput.Source)}
+		setCellType(cell, cmp.Or(editInput.CellType, "code"))
+		minor, _ := nb.doc["nbformat_minor"].(json.Number)
+		if n, _ := minor.Int64(); n >= 5 {
+			cell["id"] = newCellID()
+		}
+		nb.cells = append(nb.cells[:editInput.Cell], append([]map[string]any{cell}, nb.cells[editInput.Cell:]...)...)
+	case "delete":
+		nb.cells = append(nb.cells[:editInput.Cell-1], nb.cells[editInput.Cell:]...)
+	default:
+		return nil, "", "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", editInput.Action), "Use replace, insert or delete.")
+	}
+	if change.newContent, err = nb.marshal(); err != nil {
+		return nil, "", "", err
+	}
+	return change, before, nb.render(0, false), nil
+}
+
+func NotebookEditPreview(ctx context.Context, input json.RawMessage) (string, error) {
+	editInput := NotebookEditInput{}
+	if err := json.Unmarshal(input, &editInput); err != nil {
+		return "", err
+	}
+	change, before, after, err := planNotebookEdit(editInput)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(change.path, before, after), nil
+}
+
+func NotebookEdit(ctx context.Context, input json.RawMessage) (string, error) {
+	editInput := NotebookEditInput{}
+	if err := json.Unmarshal(input, &editInput); err != nil {
+		return "", err
+	}
+	change, _, _, err := planNotebookEdit(editInput)
+	if err != nil {
+		return "", err
+	}
+	if err := writeChange(change); err != nil {
+		return "", err
+	}
+	fileVersions.record(change.path, []byte(change.newContent))
+	switch editInput.Action {
+	case "replace":
+		return fmt.Sprintf("Replaced cell %d of %s", editInput.Cell, change.path), nil
+	case "insert":
+		return fmt.Sprintf("Inserted cell %d into %s", editInput.Cell+1, change.path), nil
+	}
+	return fmt.Sprintf("Deleted cell %d of %s, the cells after it moved up by one", editInput.Cell, change.path), nil
+}
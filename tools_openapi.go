@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxSchemaDepth bounds how deep inline schemas are spelled out; named
+// schemas are only named, the model can look them up on their own
+const maxSchemaDepth = 4
+
+// openAPIMethods are the operations of a path item, in the order they are
+// listed
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIMarker finds the top-level key that makes a YAML or JSON file an
+// OpenAPI or Swagger spec
+var openAPIMarker = regexp.MustCompile(`(?m)^\s*"?(openapi|swagger)"?\s*:`)
+
+// OpenAPI Tool
+var OpenAPIDefinition = ToolDefinition{
+	Name:        "openapi",
+	Description: "Answer questions about the OpenAPI and Swagger specs in the repository, so client and server code matches the contract instead of a guess. Without path or schema it lists each spec's endpoints and schemas. With path it describes the endpoint's parameters, request body and responses, or lists the endpoints under a prefix such as /users. With schema it gives a schema's properties, types and required fields. $refs to other schemas are named, look those up the same way.",
+	InputSchema: GenerateSchema[OpenAPIInput](),
+	Kind:        ToolRead,
+	Function:    OpenAPI,
+}
+
+type OpenAPIInput struct {
+	File   string `json:"file,omitempty" jsonschema_description:"The spec file. Leave empty to use every spec below the working directory."`
+	Path   string `json:"path,omitempty" jsonschema_description:"An endpoint path as written in the spec, e.g. /users/{id}, or a prefix to list the endpoints under it."`
+	Method string `json:"method,omitempty" jsonschema_description:"Optional HTTP method to narrow path down to one operation, e.g. POST."`
+	Schema string `json:"schema,omitempty" jsonschema_description:"The name of a schema under components/schemas, or definitions in Swagger 2."`
+}
+
+// openAPISpec is a parsed spec
+type openAPISpec struct {
+	Path string
+	Doc  map[string]any
+}
+
+func OpenAPI(ctx context.Context, input json.RawMessage) (string, error) {
+	openAPIInput := OpenAPIInput{}
+	if err := json.Unmarshal(input, &openAPIInput); err != nil {
+		return "", err
+	}
+	var specs []*openAPISpec
+	if openAPIInput.File != "" {
+		spec, err := loadOpenAPISpec(openAPIInput.File)
+		if err != nil {
+			return "", err
+		}
+		specs = append(specs, spec)
+	} else {
+		var err error
+		if specs, err = findOpenAPISpecs(ctx); err != nil {
+			return "", err
+		}
+		if len(specs) == 0 {
+			return "", newToolError(errNotFound, "no OpenAPI or Swagger spec below the working directory", "Pass the spec as file if it has an unusual extension, or ask the user where the API is described.")
+		}
+	}
+
+	var b strings.Builder
+	for _, spec := range specs {
+		switch {
+		case openAPIInput.Schema != "":
+			schema, ok := spec.schemas()[openAPIInput.Schema]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&b, "%s in %s\n", openAPIInput.Schema, spec.Path)
+			spec.writeSchema(&b, schema, "  ", 0)
+			b.WriteString("\n")
+		case openAPIInput.Path != "":
+			spec.writeEndpoints(&b, openAPIInput.Path, strings.ToLower(openAPIInput.Method))
+		default:
+			spec.writeOverview(&b)
+		}
+	}
+	if b.Len() == 0 {
+		switch {
+		case openAPIInput.Schema != "":
+			return "", newToolError(errNotFound, fmt.Sprintf("no schema %q", openAPIInput.Schema), "Call openapi without arguments to list the schemas.")
+		default:
+			return "", newToolError(errNotFound, fmt.Sprintf("no endpoint matches %s %s", openAPIInput.Method, openAPIInput.Path), "Call openapi without arguments to list the endpoints.")
+		}
+	}
+	return b.String(), nil
+}
+
+// findOpenAPISpecs parses the YAML and JSON files below the working
+// directory that are OpenAPI or Swagger specs
+func findOpenAPISpecs(ctx context.Context) ([]*openAPISpec, error) {
+	var specs []*openAPISpec
+	err := walkFiles(ctx, ".", 0, func(relPath string, d fs.DirEntry) bool {
+		if d.IsDir() || !slices.Contains([]string{".yaml", ".yml", ".json"}, filepath.Ext(relPath)) || inDependencyDir(relPath) {
+			return true
+		}
+		if info, err := d.Info(); err != nil || info.Size() > 10<<20 {
+			return true
+		}
+		content, err := os.ReadFile(relPath)
+		if err != nil || !openAPIMarker.Match(content) {
+			return true
+		}
+		if spec, err := parseOpenAPISpec(relPath, content); err == nil {
+			specs = append(specs, spec)
+		}
+		return true
+	})
+	return specs, err
+}
+
+// inDependencyDir reports whether a path is in a directory of vendored or
+// installed dependencies, whose specs and protos aren't the project's
+func inDependencyDir(relPath string) bool {
+	for _, dir := range strings.Split(filepath.ToSlash(filepath.Dir(relPath)), "/") {
+		if dir == "vendor" || dir == "node_modules" {
+			return true
+		}
+	}
+	return false
+}
+
+func loadOpenAPISpec(path string) (*openAPISpec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := parseOpenAPISpec(path, content)
+	if err != nil {
+		return nil, newToolError(errInvalidInput, err.Error(), "Check that file is an OpenAPI or Swagger spec.")
+	}
+	return spec, nil
+}
+
+// parseOpenAPISpec parses a spec, YAML or JSON alike
+func parseOpenAPISpec(path string, content []byte) (*openAPISpec, error) {
+	var doc map[string]any
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if doc["openapi"] == nil && doc["swagger"] == nil {
+		return nil, fmt.Errorf("%s is not an OpenAPI or Swagger spec", path)
+	}
+	return &openAPISpec{Path: path, Doc: doc}, nil
+}
+
+// schemas are the named schemas of the spec
+func (s *openAPISpec) schemas() map[string]any {
+	if components, ok := s.Doc["components"].(map[string]any); ok {
+		if schemas, ok := components["schemas"].(map[string]any); ok {
+			return schemas
+		}
+	}
+	definitions, _ := s.Doc["definitions"].(map[string]any)
+	return definitions
+}
+
+// resolve follows a local $ref, such as #/components/parameters/id, and
+// returns node itself when it isn't one
+func (s *openAPISpec) resolve(node any) any {
+	for range 10 {
+		m, ok := node.(map[string]any)
+		if !ok {
+			return node
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return node
+		}
+		var target any = s.Doc
+		for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			key = strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+			next, ok := target.(map[string]any)
+			if !ok {
+				return node
+			}
+			target = next[key]
+		}
+		if target == nil {
+			return node
+		}
+		node = target
+	}
+	return node
+}
+
+// writeOverview lists the spec's endpoints and schemas
+func (s *openAPISpec) writeOverview(b *strings.Builder) {
+	version, _ := s.Doc["openapi"].(string)
+	if version == "" {
+		version = fmt.Sprint("Swagger ", s.Doc["swagger"])
+	} else {
+		version = "OpenAPI " + version
+	}
+	info, _ := s.Doc["info"].(map[string]any)
+	fmt.Fprintf(b, "%s (%s", s.Path, version)
+	if title, ok := info["title"].(string); ok {
+		fmt.Fprintf(b, ", %s %v", title, info["version"])
+	}
+	b.WriteString(")\n")
+	if servers, ok := s.Doc["servers"].([]any); ok {
+		for _, server := range servers {
+			if server, ok := server.(map[string]any); ok {
+				fmt.Fprintf(b, "Server: %v\n", server["url"])
+			}
+		}
+	} else if host, ok := s.Doc["host"].(string); ok {
+		basePath, _ := s.Doc["basePath"].(string)
+		fmt.Fprintf(b, "Server: %s%s\n", host, basePath)
+	}
+	b.WriteString("Endpoints:\n")
+	s.writeEndpointList(b, "")
+	if schemas := s.schemas(); len(schemas) > 0 {
+		fmt.Fprintf(b, "Schemas: %s\n", strings.Join(sortedKeys(schemas), ", "))
+	}
+	b.WriteString("\n")
+}
+
+// writeEndpointList lists the operations of the paths starting with
+// prefix, one per line
+func (s *openAPISpec) writeEndpointList(b *strings.Builder, prefix string) int {
+	paths, _ := s.Doc["paths"].(map[string]any)
+	n := 0
+	for _, path := range sortedKeys(paths) {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		item, _ := s.resolve(paths[path]).(map[string]any)
+		for _, method := range openAPIMethods {
+			operation, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			n++
+			fmt.Fprintf(b, "  %-7s %s", strings.ToUpper(method), path)
+			if id, ok := operation["operationId"].(string); ok {
+				fmt.Fprintf(b, " %s", id)
+			}
+			if summary, ok := operation["summary"].(string); ok {
+				fmt.Fprintf(b, ": %s", oneLine(summary))
+			}
+			if deprecated, _ := operation["deprecated"].(bool); deprecated {
+				b.WriteString(" (deprecated)")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return n
+}
+
+// writeEndpoints describes the operations of path, or lists the endpoints
+// under it when no path matches exactly
+func (s *openAPISpec) writeEndpoints(b *strings.Builder, path, method string) {
+	paths, _ := s.Doc["paths"].(map[string]any)
+	item, ok := s.resolve(paths[path]).(map[string]any)
+	if !ok {
+		var list strings.Builder
+		if s.writeEndpointList(&list, strings.TrimSuffix(path, "/")) > 0 {
+			fmt.Fprintf(b, "%s, endpoints under %s:\n%s\n", s.Path, path, list.String())
+		}
+		return
+	}
+	for _, m := range openAPIMethods {
+		operation, ok := item[m].(map[string]any)
+		if !ok || method != "" && m != method {
+			continue
+		}
+		fmt.Fprintf(b, "%s %s", strings.ToUpper(m), path)
+		if id, ok := operation["operationId"].(string); ok {
+			fmt.Fprintf(b, " (%s)", id)
+		}
+		fmt.Fprintf(b, " in %s\n", s.Path)
+		for _, key := range []string{"summary", "description"} {
+			if text, ok := operation[key].(string); ok {
+				fmt.Fprintf(b, "%s\n", strings.TrimSpace(text))
+			}
+		}
+		if deprecated, _ := operation["deprecated"].(bool); deprecated {
+			b.WriteString("Deprecated\n")
+		}
+
+		// Path-level parameters apply unless the operation overrides them
+		var parameters []map[string]any
+		for _, list := range []any{operation["parameters"], item["parameters"]} {
+			list, _ := list.([]any)
+			for _, p := range list {
+				p, ok := s.resolve(p).(map[string]any)
+				if ok && !slices.ContainsFunc(parameters, func(q map[string]any) bool { return q["name"] == p["name"] && q["in"] == p["in"] }) {
+					parameters = append(parameters, p)
+				}
+			}
+		}
+		var body map[string]any
+		if len(parameters) > 0 {
+			b.WriteString("Parameters:\n")
+			for _, p := range parameters {
+				// Swagger 2 puts the request body among the parameters
+				if p["in"] == "body" {
+					body = map[string]any{"required": p["required"], "description": p["description"], "content": map[string]any{"": map[string]any{"schema": p["schema"]}}}
+					continue
+				}
+				schema := p["schema"]
+				if schema == nil {
+					schema = p
+				}
+				fmt.Fprintf(b, "  %v (%v): %s%s%s\n", p["name"], p["in"], s.schemaType(schema), requiredMark(p["required"]), describedAs(p["description"]))
+			}
+		}
+		if requestBody, ok := s.resolve(operation["requestBody"]).(map[string]any); ok {
+			body = requestBody
+		}
+		if body != nil {
+			fmt.Fprintf(b, "Request body%s%s:\n", requiredMark(body["required"]), describedAs(body["description"]))
+			s.writeContent(b, body["content"], "  ")
+		}
+		if responses, ok := operation["responses"].(map[string]any); ok {
+			b.WriteString("Responses:\n")
+			for _, code := range sortedKeys(responses) {
+				response, _ := s.resolve(responses[code]).(map[string]any)
+				fmt.Fprintf(b, "  %s%s\n", code, describedAs(response["description"]))
+				content := response["content"]
+				if schema, ok := response["schema"]; ok {
+					content = map[string]any{"": map[string]any{"schema": schema}}
+				}
+				s.writeContent(b, content, "    ")
+			}
+		}
+		b.WriteString("\n")
+	}
+}
+
+// writeContent describes the schema of each media type of a request body
+// or response
+func (s *openAPISpec) writeContent(b *strings.Builder, content any, indent string) {
+	media, _ := content.(map[string]any)
+	for _, mediaType := range sortedKeys(media) {
+		entry, _ := media[mediaType].(map[string]any)
+		schema, ok := entry["schema"]
+		if !ok {
+			continue
+		}
+		if mediaType != "" {
+			fmt.Fprintf(b, "%s%s: ", indent, mediaType)
+		} else {
+			b.WriteString(indent)
+		}
+		fmt.Fprintf(b, "%s\n", s.schemaType(schema))
+		s.writeSchema(b, schema, indent+"  ", 1)
+	}
+}
+
+// writeSchema spells out the properties of an inline object schema, or of
+// a named one at the top, one per line
+func (s *openAPISpec) writeSchema(b *strings.Builder, schema any, indent string, depth int) {
+	if depth > maxSchemaDepth {
+		return
+	}
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return
+	}
+	if _, isRef := m["$ref"]; isRef {
+		if depth > 0 {
+			return
+		}
+		m, _ = s.resolve(m).(map[string]any)
+	}
+	if depth == 0 {
+		if description, ok := m["description"].(string); ok {
+			fmt.Fprintf(b, "%s%s\n", indent, oneLine(description))
+		}
+		if _, hasProperties := m["properties"]; !hasProperties {
+			fmt.Fprintf(b, "%s%s\n", indent, s.schemaType(m))
+		}
+	}
+	if items, ok := m["items"]; ok && m["type"] == "array" {
+		s.writeSchema(b, items, indent, depth+1)
+		return
+	}
+	for _, key := range []string{"allOf", "oneOf", "anyOf"} {
+		list, _ := m[key].([]any)
+		for _, part := range list {
+			if part, ok := part.(map[string]any); ok && part["$ref"] == nil {
+				s.writeSchema(b, part, indent, depth+1)
+			}
+		}
+	}
+	properties, _ := m["properties"].(map[string]any)
+	required, _ := m["required"].([]any)
+	for _, name := range sortedKeys(properties) {
+		property := properties[name]
+		fmt.Fprintf(b, "%s%s: %s%s", indent, name, s.schemaType(property), requiredMark(slices.Contains(required, any(name))))
+		if p, ok := property.(map[string]any); ok {
+			if readOnly, _ := p["readOnly"].(bool); readOnly {
+				b.WriteString(", read-only")
+			}
+			b.WriteString(describedAs(p["description"]))
+		}
+		b.WriteString("\n")
+		s.writeSchema(b, property, indent+"  ", depth+1)
+	}
+	if additional, ok := m["additionalProperties"].(map[string]any); ok {
+		fmt.Fprintf(b, "%s*: %s\n", indent, s.schemaType(additional))
+	}
+}
+
+// schemaType names a schema in a few words, such as "array of User",
+// "all of Base | object" or "string (date-time), one of a, b"
+func (s *openAPISpec) schemaType(schema any) string {
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return "any"
+	}
+	if ref, ok := m["$ref"].(string); ok {
+		return ref[strings.LastIndex(ref, "/")+1:]
+	}
+	var t string
+	switch typ := m["type"].(type) {
+	case string:
+		t = typ
+	case []any:
+		var types []string
+		for _, part := range typ {
+			types = append(types, fmt.Sprint(part))
+		}
+		t = strings.Join(types, " or ")
+	}
+	for _, key := range []string{"allOf", "oneOf", "anyOf"} {
+		list, ok := m[key].([]any)
+		if !ok {
+			continue
+		}
+		var parts []string
+		for _, part := range list {
+			parts = append(parts, s.schemaType(part))
+		}
+		combined := map[string]string{"allOf": "all of ", "oneOf": "one of ", "anyOf": "any of "}[key] + strings.Join(parts, " | ")
+		t = strings.TrimSpace(t + " " + combined)
+	}
+	switch {
+	case t == "array":
+		t = "array of " + s.schemaType(m["items"])
+	case t == "" && m["properties"] != nil:
+		t = "object"
+	case t == "":
+		t = "any"
+	}
+	if format, ok := m["format"].(string); ok {
+		t += " (" + format + ")"
+	}
+	if values, ok := m["enum"].([]any); ok {
+		var names []string
+		for _, v := range values {
+			names = append(names, fmt.Sprint(v))
+		}
+		t += ", one of " + strings.Join(names, ", ")
+	}
+	if nullable, _ := m["nullable"].(bool); nullable {
+		t += ", nullable"
+	}
+	if def, ok := m["default"]; ok {
+		t += fmt.Sprintf(", default %v", def)
+	}
+	return t
+}
+
+func requiredMark(required any) string {
+	if required, _ := required.(bool); required {
+		return ", required"
+	}
+	return ""
+}
+
+func describedAs(description any) string {
+	if description, ok := description.(string); ok && strings.TrimSpace(description) != "" {
+		return " - " + oneLine(description)
+	}
+	return ""
+}
+
+// oneLine joins the lines of a description
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
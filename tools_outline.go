@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxOutlineLines bounds the declarations code_outline lists in all
+const maxOutlineLines = 500
+
+// Code Outline Tool
+var CodeOutlineDefinition = ToolDefinition{
+	Name:        "code_outline",
+	Description: "Outline the declarations of a source file, or of every source file under a directory as a map of the repository: functions, methods, types, classes, interfaces, structs, traits and constants, with their line ranges. Works for Go, JavaScript, TypeScript, Python and Rust. Use it to find your way around an unfamiliar codebase, or to see what a file contains before reading the parts you need.",
+	InputSchema: GenerateSchema[CodeOutlineInput](),
+	Kind:        ToolRead,
+	Function:    CodeOutline,
+}
+
+type CodeOutlineInput struct {
+	Path     string `json:"path,omitempty" jsonschema_description:"A file or directory, the working directory when empty."`
+	Exported bool   `json:"exported,omitempty" jsonschema_description:"Only list exported declarations: capitalized in Go, exported in JavaScript and TypeScript, pub in Rust, without a leading underscore in Python."`
+}
+
+func CodeOutline(ctx context.Context, input json.RawMessage) (string, error) {
+	outlineInput := CodeOutlineInput{}
+	if err := json.Unmarshal(input, &outlineInput); err != nil {
+		return "", err
+	}
+	path := filepath.Clean(outlineInput.Path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", newToolError(errNotFound, fmt.Sprintf("%s doesn't exist", path), "Use list_files to find the path.")
+	}
+
+	var index *symbolIndex
+	root := path
+	if info.IsDir() {
+		if index, err = buildSymbolIndex(ctx, path); err != nil {
+			return "", err
+		}
+	} else {
+		root = filepath.Dir(path)
+		if !strings.HasSuffix(path, ".go") && !indexedSource(path) {
+			return "", newToolError(errInvalidInput, fmt.Sprintf("%s isn't in a language code_outline reads", path), "Outline Go, JavaScript, TypeScript, Python or Rust files, or read the file.")
+		}
+		index = &symbolIndex{refs: make(map[string][]symbolRef)}
+		index.addPath(ctx, token.NewFileSet(), root, filepath.Base(path))
+	}
+
+	var b strings.Builder
+	file, listed, omitted := "", 0, 0
+	for _, def := range index.defs {
+		if outlineInput.Exported && !def.Exported {
+			continue
+		}
+		if listed == maxOutlineLines {
+			omitted++
+			continue
+		}
+		if def.File != file {
+			file = def.File
+			fmt.Fprintf(&b, "\n%s\n", filepath.Join(root, file))
+		}
+		name := def.Name
+		if def.Recv != "" {
+			name = def.Recv + "." + name
+		}
+		fmt.Fprintf(&b, "  %s %s, lines %d-%d\n", def.Kind, name, def.Line, def.EndLine)
+		listed++
+	}
+	if listed == 0 {
+		return "No declarations found in " + path, nil
+	}
+	if omitted > 0 {
+		fmt.Fprintf(&b, "\n%d more declarations left out, outline a subdirectory or set exported", omitted)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Protobuf Tool
+var ProtobufDefinition = ToolDefinition{
+	Name:        "protobuf",
+	Description: "Answer questions about the .proto files in the repository, so gRPC and protobuf code matches the contract. Without name it lists each file's package, services with their RPCs, messages and enums. With name it gives the definition of a message (fields with types, numbers and labels, oneofs), an enum (values) or a service, or for an RPC such as AgentService.SendMessage its streaming and the fields of its request and response. Comments in the proto are included.",
+	InputSchema: GenerateSchema[ProtobufInput](),
+	Kind:        ToolRead,
+	Function:    Protobuf,
+}
+
+type ProtobufInput struct {
+	File string `json:"file,omitempty" jsonschema_description:"The .proto file. Leave empty to use every .proto file below the working directory."`
+	Name string `json:"name,omitempty" jsonschema_description:"A message, enum, service or RPC, by its name (User), qualified name (acme.v1.User), nested name (User.Address) or Service.Method."`
+}
+
+// protoFile is what a .proto file declares
+type protoFile struct {
+	Path     string
+	Package  string
+	Messages []*protoMessage // nested ones too, named Outer.Inner
+	Enums    []*protoEnum
+	Services []*protoService
+}
+
+type protoMessage struct {
+	Name   string
+	Doc    string
+	Fields []protoField
+}
+
+type protoField struct {
+	Label  string // repeated, optional or required
+	Type   string // map<K, V> for maps
+	Name   string
+	Number string
+	Oneof  string
+	Doc    string
+}
+
+type protoEnum struct {
+	Name   string
+	Doc    string
+	Values []protoField // Name and Number
+}
+
+type protoService struct {
+	Name    string
+	Doc     string
+	Methods []protoRPC
+}
+
+type protoRPC struct {
+	Name, Request, Response    string
+	ClientStream, ServerStream bool
+	Doc                        string
+}
+
+func Protobuf(ctx context.Context, input json.RawMessage) (string, error) {
+	protoInput := ProtobufInput{}
+	if err := json.Unmarshal(input, &protoInput); err != nil {
+		return "", err
+	}
+	var files []*protoFile
+	if protoInput.File != "" {
+		content, err := os.ReadFile(protoInput.File)
+		if err != nil {
+			return "", err
+		}
+		file, err := parseProto(protoInput.File, string(content))
+		if err != nil {
+			return "", newToolError(errInvalidInput, err.Error(), "Read the file with read_file instead.")
+		}
+		files = append(files, file)
+	} else {
+		err := walkFiles(ctx, ".", 0, func(relPath string, d fs.DirEntry) bool {
+			if d.IsDir() || filepath.Ext(relPath) != ".proto" || inDependencyDir(relPath) {
+				return true
+			}
+			content, err := os.ReadFile(relPath)
+			if err != nil {
+				return true
+			}
+			// Files that don't parse are left out rather than failing the
+			// question about the others
+			if file, err := parseProto(relPath, string(content)); err == nil {
+				files = append(files, file)
+			}
+			return true
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(files) == 0 {
+			return "", newToolError(errNotFound, "no .proto files below the working directory", "")
+		}
+	}
+
+	var b strings.Builder
+	if protoInput.Name == "" {
+		for _, file := range files {
+			file.writeOverview(&b)
+		}
+		return b.String(), nil
+	}
+	for _, file := range files {
+		file.writeDefinition(&b, protoInput.Name, files)
+	}
+	if b.Len() == 0 {
+		return "", newToolError(errNotFound, fmt.Sprintf("nothing named %q", protoInput.Name), "Call protobuf without name to list the definitions.")
+	}
+	return b.String(), nil
+}
+
+// matches reports whether name refers to a definition of the file, as
+// User, acme.v1.User or a suffix of a nested name such as Outer.User
+func (f *protoFile) matches(defined, name string) bool {
+	name = strings.TrimPrefix(name, ".")
+	return defined == name || f.Package+"."+defined == name || strings.HasSuffix(defined, "."+name)
+}
+
+func (f *protoFile) writeOverview(b *strings.Builder) {
+	fmt.Fprintf(b, "%s", f.Path)
+	if f.Package != "" {
+		fmt.Fprintf(b, " (package %s)", f.Package)
+	}
+	b.WriteString("\n")
+	for _, service := range f.Services {
+		fmt.Fprintf(b, "service %s\n", service.Name)
+		for _, rpc := range service.Methods {
+			fmt.Fprintf(b, "  %s\n", rpc.signature())
+		}
+	}
+	if len(f.Messages) > 0 {
+		var names []string
+		for _, message := range f.Messages {
+			names = append(names, message.Name)
+		}
+		fmt.Fprintf(b, "messages: %s\n", strings.Join(names, ", "))
+	}
+	if len(f.Enums) > 0 {
+		var names []string
+		for _, enum := range f.Enums {
+			names = append(names, enum.Name)
+		}
+		fmt.Fprintf(b, "enums: %s\n", strings.Join(names, ", "))
+	}
+	b.WriteString("\n")
+}
+
+// writeDefinition describes what the file defines under name. The request
+// and response of an RPC are looked up in all files.
+func (f *protoFile) writeDefinition(b *strings.Builder, name string, files []*protoFile) {
+	for _, message := range f.Messages {
+		if f.matches(message.Name, name) {
+			fmt.Fprintf(b, "%s in %s\n", f.qualified(message.Name), f.Path)
+			message.write(b, "")
+			b.WriteString("\n")
+		}
+	}
+	for _, enum := range f.Enums {
+		if f.matches(enum.Name, name) {
+			fmt.Fprintf(b, "%s in %s\n", f.qualified(enum.Name), f.Path)
+			writeDoc(b, enum.Doc, "")
+			fmt.Fprintf(b, "enum %s {\n", enum.Name)
+			for _, value := range enum.Values {
+				writeDoc(b, value.Doc, "  ")
+				fmt.Fprintf(b, "  %s = %s;\n", value.Name, value.Number)
+			}
+			b.WriteString("}\n\n")
+		}
+	}
+	for _, service := range f.Services {
+		if f.matches(service.Name, name) {
+			fmt.Fprintf(b, "%s in %s\n", f.qualified(service.Name), f.Path)
+			writeDoc(b, service.Doc, "")
+			fmt.Fprintf(b, "service %s {\n", service.Name)
+			for _, rpc := range service.Methods {
+				writeDoc(b, rpc.Doc, "  ")
+				fmt.Fprintf(b, "  %s;\n", rpc.signature())
+			}
+			b.WriteString("}\n\n")
+		}
+		for _, rpc := range service.Methods {
+			if !f.matches(service.Name+"."+rpc.Name, name) && !(rpc.Name == name && !strings.Contains(name, ".")) {
+				continue
+			}
+			fmt.Fprintf(b, "%s.%s in %s\n", f.qualified(service.Name), rpc.Name, f.Path)
+			writeDoc(b, rpc.Doc, "")
+			fmt.Fprintf(b, "%s\n", rpc.signature())
+			for _, messageName := range []string{rpc.Request, rpc.Response} {
+				if message, file := findProtoMessage(files, f, messageName); message != nil {
+					fmt.Fprintf(b, "\n%s in %s\n", file.qualified(message.Name), file.Path)
+					message.write(b, "")
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+}
+
+// findProtoMessage looks a message type up, in the file that refers to it
+// first
+func findProtoMessage(files []*protoFile, from *protoFile, name string) (*protoMessage, *protoFile) {
+	for _, file := range append([]*protoFile{from}, files...) {
+		for _, message := range file.Messages {
+			if file.matches(message.Name, name) {
+				return message, file
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (f *protoFile) qualified(name string) string {
+	if f.Package == "" {
+		return name
+	}
+	return f.Package + "." + name
+}
+
+func (r protoRPC) signature() string {
+	stream := func(streaming bool) string {
+		if streaming {
+			return "stream "
+		}
+		return ""
+	}
+	return fmt.Sprintf("rpc %s(%s%s) returns (%s%s)", r.Name, stream(r.ClientStream), r.Request, stream(r.ServerStream), r.Response)
+}
+
+func (m *protoMessage) write(b *strings.Builder, indent string) {
+	writeDoc(b, m.Doc, indent)
+	fmt.Fprintf(b, "%smessage %s {\n", indent, m.Name[strings.LastIndex(m.Name, ".")+1:])
+	oneof := ""
+	for _, field := range m.Fields {
+		if field.Oneof != oneof {
+			if oneof != "" {
+				fmt.Fprintf(b, "%s  }\n", indent)
+			}
+			if field.Oneof != "" {
+				fmt.Fprintf(b, "%s  oneof %s {\n", indent, field.Oneof)
+			}
+			oneof = field.Oneof
+		}
+		fieldIndent := indent + "  "
+		if oneof != "" {
+			fieldIndent += "  "
+		}
+		writeDoc(b, field.Doc, fieldIndent)
+		label := ""
+		if field.Label != "" {
+			label = field.Label + " "
+		}
+		fmt.Fprintf(b, "%s%s%s %s = %s;\n", fieldIndent, label, field.Type, field.Name, field.Number)
+	}
+	if oneof != "" {
+		fmt.Fprintf(b, "%s  }\n", indent)
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func writeDoc(b *strings.Builder, doc, indent string) {
+	for _, line := range strings.Split(doc, "\n") {
+		if line != "" {
+			fmt.Fprintf(b, "%s// %s\n", indent, line)
+		}
+	}
+}
+
+// protoToken is a word, number, string or punctuation of a .proto file,
+// with the comment lines right before it
+type protoToken struct {
+	Text string
+	Doc  string
+	Line int
+}
+
+// tokenizeProto splits a .proto file into tokens. Comments are kept as
+// the doc of the token that follows them.
+func tokenizeProto(content string) []protoToken {
+	var tokens []protoToken
+	var doc []string
+	line, blank := 1, true
+	runes := []rune(content)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			// A blank line ends the comment a declaration starts with
+			if blank {
+				doc = nil
+			}
+			line++
+			blank = true
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			end := i
+			for end < len(runes) && runes[end] != '\n' {
+				end++
+			}
+			// A comment after a declaration on its line isn't the next one's
+			if blank {
+				doc = append(doc, strings.TrimSpace(string(runes[i+2:end])))
+			}
+			i = end
+			blank = false
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := i + 2
+			for end+1 < len(runes) && !(runes[end] == '*' && runes[end+1] == '/') {
+				if runes[end] == '\n' {
+					line++
+				}
+				end++
+			}
+			for _, text := range strings.Split(string(runes[i+2:min(end, len(runes))]), "\n") {
+				doc = append(doc, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text), "*")))
+			}
+			i = end + 2
+			blank = false
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r && runes[end] != '\n' {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			tokens = append(tokens, protoToken{Text: string(runes[i:min(end+1, len(runes))]), Line: line})
+			i = end + 1
+			blank = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-' || r == '+':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || strings.ContainsRune("_.-+", runes[end])) {
+				end++
+			}
+			tokens = append(tokens, protoToken{Text: string(runes[i:end]), Doc: strings.Join(doc, "\n"), Line: line})
+			doc = nil
+			i = end
+			blank = false
+		default:
+			tokens = append(tokens, protoToken{Text: string(r), Line: line})
+			doc = nil
+			i++
+			blank = false
+		}
+	}
+	return tokens
+}
+
+// protoParser reads the declarations of a .proto file. It understands the
+// structure of messages, enums and services and skips what it doesn't
+// need, such as options and extensions.
+type protoParser struct {
+	tokens []protoToken
+	pos    int
+	file   *protoFile
+}
+
+func parseProto(path, content string) (file *protoFile, err error) {
+	p := &protoParser{tokens: tokenizeProto(content), file: &protoFile{Path: path}}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to parse %s: %v", path, r)
+		}
+	}()
+	for !p.done() {
+		p.topLevel()
+	}
+	return p.file, nil
+}
+
+func (p *protoParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *protoParser) peek() protoToken {
+	if p.done() {
+		panic("unexpected end of file")
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *protoParser) next() protoToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *protoParser) expect(text string) {
+	if t := p.next(); t.Text != text {
+		panic(fmt.Sprintf("line %d: want %q, got %q", t.Line, text, t.Text))
+	}
+}
+
+// skipStatement skips to the end of a statement, past a block it opens
+func (p *protoParser) skipStatement() {
+	for {
+		switch p.next().Text {
+		case ";":
+			return
+		case "{":
+			p.skipBlock()
+			return
+		}
+	}
+}
+
+// skipBlock skips to the } closing an opened block
+func (p *protoParser) skipBlock() {
+	for depth := 1; depth > 0; {
+		switch p.next().Text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+		}
+	}
+}
+
+func (p *protoParser) topLevel() {
+	t := p.peek()
+	switch t.Text {
+	case "package":
+		p.next()
+		p.file.Package = p.next().Text
+		p.expect(";")
+	case "message":
+		p.message("")
+	case "enum":
+		p.enum("")
+	case "service":
+		p.service()
+	case ";":
+		p.next()
+	default:
+		// syntax, edition, import, option and extend
+		p.skipStatement()
+	}
+}
+
+func (p *protoParser) message(outer string) {
+	doc := p.next().Doc
+	name := p.next().Text
+	if outer != "" {
+		name = outer + "." + name
+	}
+	message := &protoMessage{Name: name, Doc: doc}
+	p.file.Messages = append(p.file.Messages, message)
+	p.expect("{")
+	p.messageBody(message, "")
+}
+
+// messageBody reads fields up to the closing }, those of a oneof too
+func (p *protoParser) messageBody(message *protoMessage, oneof string) {
+	for {
+		t := p.peek()
+		switch t.Text {
+		case "}":
+			p.next()
+			return
+		case ";":
+			p.next()
+		case "message":
+			p.message(message.Name)
+		case "enum":
+			p.enum(message.Name)
+		case "oneof":
+			p.next()
+			name := p.next().Text
+			p.expect("{")
+			p.messageBody(message, name)
+		case "option", "reserved", "extensions", "extend":
+			p.skipStatement()
+		case "group":
+			panic(fmt.Sprintf("line %d: groups aren't supported", t.Line))
+		default:
+			message.Fields = append(message.Fields, p.field(oneof))
+		}
+	}
+}
+
+// field reads [label] type name = number [options];
+func (p *protoParser) field(oneof string) protoField {
+	field := protoField{Oneof: oneof, Doc: p.peek().Doc}
+	if label := p.peek().Text; label == "repeated" || label == "optional" || label == "required" {
+		field.Label = p.next().Text
+	}
+	field.Type = p.next().Text
+	if field.Type == "map" {
+		p.expect("<")
+		key := p.next().Text
+		p.expect(",")
+		value := p.next().Text
+		p.expect(">")
+		field.Type = fmt.Sprintf("map<%s, %s>", key, value)
+	}
+	field.Name = p.next().Text
+	p.expect("=")
+	field.Number = p.next().Text
+	p.skipStatement()
+	return field
+}
+
+func (p *protoParser) enum(outer string) {
+	doc := p.next().Doc
+	name := p.next().Text
+	if outer != "" {
+		name = outer + "." + name
+	}
+	enum := &protoEnum{Name: name, Doc: doc}
+	p.file.Enums = append(p.file.Enums, enum)
+	p.expect("{")
+	for {
+		t := p.peek()
+		switch t.Text {
+		case "}":
+			p.next()
+			return
+		case ";":
+			p.next()
+		case "option", "reserved":
+			p.skipStatement()
+		default:
+			value := protoField{Doc: t.Doc, Name: p.next().Text}
+			p.expect("=")
+			value.Number = p.next().Text
+			p.skipStatement()
+			enum.Values = append(enum.Values, value)
+		}
+	}
+}
+
+func (p *protoParser) service() {
+	doc := p.next().Doc
+	service := &protoService{Name: p.next().Text, Doc: doc}
+	p.file.Services = append(p.file.Services, service)
+	p.expect("{")
+	for {
+		t := p.peek()
+		switch t.Text {
+		case "}":
+			p.next()
+			return
+		case ";":
+			p.next()
+		case "rpc":
+			p.next()
+			rpc := protoRPC{Name: p.next().Text, Doc: t.Doc}
+			rpc.ClientStream, rpc.Request = p.rpcType()
+			p.expect("returns")
+			rpc.ServerStream, rpc.Response = p.rpcType()
+			p.skipStatement()
+			service.Methods = append(service.Methods, rpc)
+		default:
+			p.skipStatement()
+		}
+	}
+}
+
+// rpcType reads ([stream] Type)
+func (p *protoParser) rpcType() (bool, string) {
+	p.expect("(")
+	stream := false
+	name := p.next().Text
+	if name == "stream" && p.peek().Text != ")" {
+		stream, name = true, p.next().Text
+	}
+	p.expect(")")
+	return stream, name
+}
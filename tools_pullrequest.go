@@ -0,0 +1,211 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Create Pull Request Tool
+var CreatePullRequestDefinition = ToolDefinition{
+	Name:        "create_pull_request",
+	Description: "Push the current branch and open a pull request on GitHub, or a merge request on GitLab, once the work is committed and the user wants it reviewed. Write the title and description like a colleague would: what changed and why. List the tests and checks you ran and their results in tests. Commit everything first; when the current branch is the base or the default branch, a new branch is created from it. Needs the user's approval.",
+	InputSchema: GenerateSchema[CreatePullRequestInput](),
+	Kind:        ToolExecute,
+	Commands:    pullRequestCommands,
+	Function:    CreatePullRequest,
+}
+
+type CreatePullRequestInput struct {
+	Title string `json:"title" jsonschema_description:"A short summary of the change, in the imperative, e.g. \"Retry failed uploads\"." jsonschema:"required"`
+	Body  string `json:"body" jsonschema_description:"The description in Markdown: what changed, why, and anything reviewers should look at closely. Reference issues as #123, or \"Fixes #123\" to close them." jsonschema:"required"`
+	Tests string `json:"tests,omitempty" jsonschema_description:"The tests and checks you ran and their results, e.g. \"go test ./... passes\". Added as a Testing section."`
+	Base  string `json:"base,omitempty" jsonschema_description:"The branch to merge into, the repository's default branch when empty."`
+	Draft bool   `json:"draft,omitempty" jsonschema_description:"Open it as a draft, for work that isn't ready for review."`
+}
+
+// gitRemote matches remote URLs as https://host/path, ssh://user@host:port/path
+// or the scp-like user@host:path
+var gitRemote = regexp.MustCompile(`^(?:[a-z+]+://)?(?:[^@/]+@)?([^/:]+)(?::[0-9]+)?[:/](.+?)(?:\.git)?/?$`)
+
+// parseRemote returns the host and repository path of a remote URL
+func parseRemote(remote string) (host, path string, ok bool) {
+	m := gitRemote.FindStringSubmatch(strings.TrimSpace(remote))
+	if m == nil {
+		return "", "", false
+	}
+	return strings.ToLower(m[1]), m[2], true
+}
+
+// apiHost returns the host of an API URL in an environment variable, "" if
+// it's unset or set by the project's .env, see userEnvOr
+func apiHost(key string) string {
+	u, err := url.Parse(userEnvOr(key, ""))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// isGitLab reports whether a remote's host is GitLab: gitlab.com, a host
+// called gitlab, or the one in GITLAB_URL
+func isGitLab(host string) bool {
+	return host == "gitlab.com" || strings.HasPrefix(host, "gitlab.") || host == apiHost("GITLAB_URL")
+}
+
+// defaultBranch returns the branch origin's HEAD points to, asking the
+// remote since refs/remotes/origin/HEAD is only set by clone
+func defaultBranch(ctx context.Context) (string, error) {
+	out, err := gitOutput(ctx, "ls-remote", "--symref", "origin", "HEAD")
+	if err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			if ref, ok := strings.CutPrefix(line, "ref: refs/heads/"); ok {
+				branch, _, _ := strings.Cut(ref, "\t")
+				return branch, nil
+			}
+		}
+	}
+	ref, err := gitOutput(ctx, "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+	if err != nil {
+		return "", newToolError(errFailed, "can't tell origin's default branch", "Pass base, or ask the user to run git remote set-head origin --auto.")
+	}
+	return strings.TrimPrefix(ref, "origin/"), nil
+}
+
+// pullRequestCommands returns the push of the current branch, see
+// Commands. Git allows branch names such as "+main", which as a refspec
+// would force the push.
+func pullRequestCommands(input json.RawMessage) []string {
+	branch, err := gitOutput(context.Background(), "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return nil
+	}
+	return []string{"git push --set-upstream origin " + branch}
+}
+
+func CreatePullRequest(ctx context.Context, input json.RawMessage) (string, error) {
+	prInput := CreatePullRequestInput{}
+	if err := json.Unmarshal(input, &prInput); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(prInput.Title) == "" {
+		return "", newToolError(errInvalidInput, "title is required", "Summarize the change in the title.")
+	}
+
+	remote, err := gitOutput(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		return "", newToolError(errNotFound, "no origin remote to push to: "+err.Error(), "Ask the user where the repository is hosted.")
+	}
+	host, repoPath, ok := parseRemote(remote)
+	if !ok {
+		return "", newToolError(errFailed, "can't tell the repository from the origin remote "+remote, "Ask the user to open the pull request.")
+	}
+	gitlab := isGitLab(host)
+	if !gitlab && host != "github.com" && host != apiHost("GITHUB_API_URL") {
+		return "", newToolError(errFailed, "origin is on "+host+", which isn't GitHub or GitLab", "For GitHub Enterprise set GITHUB_API_URL, for self-hosted GitLab GITLAB_URL.")
+	}
+
+	if status, err := gitOutput(ctx, "status", "--porcelain"); err != nil {
+		return "", err
+	} else if status != "" {
+		return "", newToolError(errInvalidInput, "there are uncommitted changes:\n"+status, "Commit what belongs in the pull request first, and ask the user about the rest.")
+	}
+	branch, err := gitOutput(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if branch == "HEAD" {
+		return "", newToolError(errInvalidInput, "HEAD is detached", "Create a branch for the commits with git switch -c first.")
+	}
+	defaultBase, err := defaultBranch(ctx)
+	if err != nil {
+		return "", err
+	}
+	base := cmp.Or(prInput.Base, defaultBase)
+	// The remote's base, which may be behind the local one
+	upstream := "origin/" + base
+	if _, err := gitOutput(ctx, "rev-parse", "--verify", "--quiet", upstream); err != nil {
+		upstream = base
+	}
+	if ahead, _ := gitOutput(ctx, "rev-list", "--count", upstream+"..HEAD"); ahead == "0" {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s has no commits that aren't on %s", branch, base), "Commit the changes first.")
+	}
+
+	// The base and the default branch are never pushed to directly
+	var note string
+	if branch == base || branch == defaultBase {
+		branch = "codegent/" + branchSlug(prInput.Title)
+		if _, err := gitOutput(ctx, "switch", "-c", branch); err != nil {
+			return "", err
+		}
+		note = fmt.Sprintf(" Created branch %s for it, %s still has the commits locally.", branch, base)
+	}
+	if _, err := gitOutput(ctx, "push", "--set-upstream", "origin", branch); err != nil {
+		return "", newToolError(errFailed, err.Error(), "Tell the user, pushing may need their credentials.")
+	}
+
+	body := strings.TrimSpace(prInput.Body)
+	if tests := strings.TrimSpace(prInput.Tests); tests != "" {
+		body += "\n\n## Testing\n\n" + tests
+	}
+	var link string
+	if gitlab {
+		link, err = openMergeRequest(ctx, host, repoPath, branch, base, prInput.Title, body, prInput.Draft)
+	} else {
+		link, err = openGitHubPullRequest(ctx, repoPath, branch, base, prInput.Title, body, prInput.Draft)
+	}
+	if err != nil {
+		return "", fmt.Errorf("pushed %s, but opening the pull request failed: %w", branch, err)
+	}
+	return fmt.Sprintf("Pushed %s and opened %s into %s.%s", branch, link, base, note), nil
+}
+
+func openGitHubPullRequest(ctx context.Context, repo, branch, base, title, body string, draft bool) (string, error) {
+	var created struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	err := githubAPI(ctx, http.MethodPost, "/repos/"+repo+"/pulls", map[string]any{
+		"title": title, "body": body, "head": branch, "base": base, "draft": draft,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	return "pull request #" + strconv.Itoa(created.Number) + " " + created.HTMLURL, nil
+}
+
+// openMergeRequest opens a merge request with the GitLab REST API, on
+// GITLAB_URL or else the remote's host, with GITLAB_TOKEN
+func openMergeRequest(ctx context.Context, host, project, branch, base, title, body string, draft bool) (string, error) {
+	token := secret("gitlab")
+	if token == "" {
+		return "", newToolError(errDenied, "GITLAB_TOKEN isn't set", "Tell the user to set GITLAB_TOKEN, or store it with codegent auth login gitlab, to open merge requests.")
+	}
+	if draft {
+		title = "Draft: " + title
+	}
+	header := make(http.Header)
+	header.Set("PRIVATE-TOKEN", token)
+	endpoint := strings.TrimSuffix(userEnvOr("GITLAB_URL", "https://"+host), "/") + "/api/v4/projects/" + url.PathEscape(project) + "/merge_requests"
+	var created struct {
+		IID    int    `json:"iid"`
+		WebURL string `json:"web_url"`
+	}
+	err := issueRequest(ctx, "GitLab", http.MethodPost, endpoint, header, map[string]any{
+		"source_branch": branch, "target_branch": base, "title": title, "description": body, "remove_source_branch": true,
+	}, &created)
+	if err != nil {
+		if errors.Is(err, errIssueNotFound) {
+			return "", newToolError(errNotFound, "GitLab has no project "+project, "Check GITLAB_URL and that the token can see the project.")
+		}
+		return "", err
+	}
+	return "merge request !" + strconv.Itoa(created.IID) + " " + created.WebURL, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxReplaceFiles bounds how many files one regex_replace call may change
+const maxReplaceFiles = 200
+
+// RegexReplace Tool
+var RegexReplaceDefinition = ToolDefinition{
+	Name: "regex_replace",
+	Description: `Regex search-and-replace in one file, or in every file matching a glob.
+
+Uses Go (RE2) regular expression syntax. In 'replacement', $1 or ${name} refer to capture groups; use $$ for a literal $. Use this for mechanical renames across many places where edit_file would need one call per occurrence.
+
+Set dry_run to only count the matches per file without changing anything. Otherwise all files are changed at once or not at all. Binary files, Jupyter notebooks and .git are skipped.`,
+	InputSchema: GenerateSchema[RegexReplaceInput](),
+	Kind:        ToolWrite,
+	KindOf:      regexReplaceKind,
+	Function:    RegexReplace,
+	Preview:     RegexReplacePreview,
+}
+
+type RegexReplaceInput struct {
+	Pattern     string `json:"pattern" jsonschema_description:"The regular expression to search for." jsonschema:"required"`
+	Replacement string `json:"replacement" jsonschema_description:"The replacement text, which may reference capture groups as $1 or ${name}." jsonschema:"required"`
+	Path        string `json:"path,omitempty" jsonschema_description:"A single file to change. Either path or glob is required."`
+	Glob        string `json:"glob,omitempty" jsonschema_description:"A glob relative to the working directory, such as 'src/**/*.go', selecting the files to change. ** matches any number of directories."`
+	DryRun      bool   `json:"dry_run,omitempty" jsonschema_description:"Only report how many matches each file has, without changing files."`
+}
+
+// regexReplaceKind counts dry runs as reads, see KindOf
+func regexReplaceKind(input json.RawMessage) ToolKind {
+	var replaceInput RegexReplaceInput
+	if err := json.Unmarshal(input, &replaceInput); err == nil && replaceInput.DryRun {
+		return ToolRead
+	}
+	return ToolWrite
+}
+
+type replaceResult struct {
+	DryRun        bool           `json:"dry_run,omitempty"`
+	FilesChanged  int            `json:"files_changed,omitempty"`
+	FilesToChange int            `json:"files_to_change,omitempty"`
+	Files         []replaceCount `json:"files"`
+}
+
+type replaceCount struct {
+	Path    string `json:"path"`
+	Matches int    `json:"matches"`
+}
+
+// planReplace runs the replacement in memory over the selected files and
+// returns the files that would change with their match counts
+func planReplace(ctx context.Context, replaceInput RegexReplaceInput) ([]*fileChange, []replaceCount, error) {
+	if replaceInput.Pattern == "" {
+		return nil, nil, errors.New("pattern is required")
+	}
+	re, err := regexp.Compile(replaceInput.Pattern)
+	if err != nil {
+		return nil, nil, newToolError(errInvalidInput, fmt.Sprintf("invalid pattern: %v", err), "Patterns use Go's RE2 syntax, which has no lookarounds or backreferences.")
+	}
+
+	var paths []string
+	switch {
+	case replaceInput.Path != "" && replaceInput.Glob != "":
+		return nil, nil, errors.New("give either path or glob, not both")
+	case replaceInput.Path != "":
+		if err := editingNotebook(replaceInput.Path); err != nil {
+			return nil, nil, err
+		}
+		paths = []string{replaceInput.Path}
+	case replaceInput.Glob != "":
+		err := walkFiles(ctx, ".", 0, func(relPath string, d fs.DirEntry) bool {
+			if d.Type().IsRegular() && matchGlob(replaceInput.Glob, filepath.ToSlash(relPath)) {
+				paths = append(paths, relPath)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, errors.New("either path or glob is required")
+	}
+
+	var changes []*fileChange
+	var counts []replaceCount
+	for _, p := range paths {
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isBinary(content) || editingNotebook(p) != nil {
+			continue
+		}
+		matches := len(re.FindAllIndex(content, -1))
+		if matches == 0 {
+			continue
+		}
+		if err := fileVersions.check(p, content); err != nil {
+			return nil, nil, err
+		}
+		newContent := re.ReplaceAll(content, []byte(replaceInput.Replacement))
+		counts = append(counts, replaceCount{Path: p, Matches: matches})
+		if string(newContent) != string(content) {
+			changes = append(changes, &fileChange{path: p, oldContent: content, newContent: string(newContent), exists: true})
+		}
+	}
+	if len(counts) == 0 {
+		return nil, nil, newToolError(errNoMatch, "pattern matched nothing", "Check the pattern against the file with read_file, and the glob with list_files.")
+	}
+	if len(changes) > maxReplaceFiles {
+		return nil, nil, newToolError(errInvalidInput, fmt.Sprintf("replacement would change %d files, more than the limit of %d", len(changes), maxReplaceFiles), "Narrow the glob and replace in several calls.")
+	}
+	return changes, counts, nil
+}
+
+func RegexReplacePreview(ctx context.Context, input json.RawMessage) (string, error) {
+	replaceInput := RegexReplaceInput{}
+	if err := json.Unmarshal(input, &replaceInput); err != nil {
+		return "", err
+	}
+	if replaceInput.DryRun {
+		return "", nil
+	}
+	changes, counts, err := planReplace(ctx, replaceInput)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	total := 0
+	for _, count := range counts {
+		total += count.Matches
+	}
+	fmt.Fprintf(&sb, "%d matches in %d files\n", total, len(counts))
+	for _, change := range changes {
+		sb.WriteString(unifiedDiff(change.path, string(change.oldContent), change.newContent))
+	}
+	return sb.String(), nil
+}
+
+func RegexReplace(ctx context.Context, input json.RawMessage) (string, error) {
+	replaceInput := RegexReplaceInput{}
+	if err := json.Unmarshal(input, &replaceInput); err != nil {
+		return "", err
+	}
+	changes, counts, err := planReplace(ctx, replaceInput)
+	if err != nil {
+		return "", err
+	}
+
+	if !replaceInput.DryRun {
+		for i, change := range changes {
+			if err := writeChange(change); err != nil {
+				for _, done := range changes[:i] {
+					rollbackChange(done)
+				}
+				return "", fmt.Errorf("failed to write %s, no files were changed: %w", change.path, err)
+			}
+		}
+		for _, change := range changes {
+			fileVersions.record(change.path, []byte(change.newContent))
+		}
+	}
+
+	result := replaceResult{DryRun: replaceInput.DryRun, Files: counts}
+	if replaceInput.DryRun {
+		result.FilesToChange = len(changes)
+	} else {
+		result.FilesChanged = len(changes)
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// matchGlob reports whether the slash-separated name matches pattern, where
+// ** matches any number of path segments and other segments follow path.Match
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Write Scratch Tool
+//
+// Scratch files live in ~/.codegent/scratch/<session ID>, outside the
+// workspace, so like read tools they need no approval: they change nothing
+// the user's repository or commands see.
+var WriteScratchDefinition = ToolDefinition{
+	Name:        "write_scratch",
+	Description: "Save text to a scratch file of this session, outside the workspace: analysis notes, plans, long command output or generated data to come back to later without keeping it in the conversation or adding files to the repository. Read it back with read_scratch. The result has the file's absolute path, for commands that need it as input. Scratch files last as long as the session, resumed sessions keep them.",
+	InputSchema: GenerateSchema[WriteScratchInput](),
+	Kind:        ToolRead,
+	Function:    WriteScratch,
+}
+
+type WriteScratchInput struct {
+	Name    string `json:"name" jsonschema_description:"The scratch file's name, such as \"notes.md\" or \"data/users.json\"." jsonschema:"required"`
+	Content string `json:"content" jsonschema_description:"The text to write."`
+	Append  bool   `json:"append,omitempty" jsonschema_description:"Add the text to the end of the file instead of replacing it."`
+}
+
+// Read Scratch Tool
+var ReadScratchDefinition = ToolDefinition{
+	Name:        "read_scratch",
+	Description: "Read a scratch file saved with write_scratch in this session, whole or a range of its lines, or list the scratch files with their sizes when no name is given.",
+	InputSchema: GenerateSchema[ReadScratchInput](),
+	Kind:        ToolRead,
+	Function:    ReadScratch,
+}
+
+type ReadScratchInput struct {
+	Name      string `json:"name,omitempty" jsonschema_description:"The scratch file to read. Lists the scratch files when empty."`
+	StartLine int    `json:"start_line,omitempty" jsonschema_description:"Optional 1-based first line to return."`
+	EndLine   int    `json:"end_line,omitempty" jsonschema_description:"Optional 1-based last line to return (inclusive). Defaults to the end of the file."`
+}
+
+type scratchKey struct{}
+
+// withScratch gives the tools run with ctx the scratch area of a session
+func withScratch(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, scratchKey{}, sessionID)
+}
+
+// scratchDir returns the scratch area of the session the tool runs for,
+// see withScratch
+func scratchDir(ctx context.Context) (string, error) {
+	sessionID, _ := ctx.Value(scratchKey{}).(string)
+	if sessionID == "" {
+		return "", newToolError(errFailed, "no session to keep scratch files for", "")
+	}
+	return sessionScratchDir(sessionID)
+}
+
+// sessionScratchDir is where a session's scratch files are kept
+func sessionScratchDir(sessionID string) (string, error) {
+	dir, err := codegentDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "scratch", sessionID), nil
+}
+
+// scratchPath resolves a scratch file's name in the session's scratch
+// area, refusing names that leave it
+func scratchPath(ctx context.Context, name string) (string, error) {
+	dir, err := scratchDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	if name == "." || filepath.IsAbs(name) || !filepath.IsLocal(name) {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%q isn't a scratch file name", name), "Use a relative name such as \"notes.md\".")
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func WriteScratch(ctx context.Context, input json.RawMessage) (string, error) {
+	scratchInput := WriteScratchInput{}
+	if err := json.Unmarshal(input, &scratchInput); err != nil {
+		return "", err
+	}
+	path, err := scratchPath(ctx, scratchInput.Name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if scratchInput.Append {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(scratchInput.Content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	verb := "Wrote"
+	if scratchInput.Append {
+		verb = "Appended"
+	}
+	return fmt.Sprintf("%s %d bytes to scratch file %s, now %d bytes, at %s", verb, len(scratchInput.Content), filepath.ToSlash(filepath.Clean(scratchInput.Name)), info.Size(), path), nil
+}
+
+func ReadScratch(ctx context.Context, input json.RawMessage) (string, error) {
+	scratchInput := ReadScratchInput{}
+	if err := json.Unmarshal(input, &scratchInput); err != nil {
+		return "", err
+	}
+	if scratchInput.Name == "" {
+		return listScratch(ctx)
+	}
+	path, err := scratchPath(ctx, scratchInput.Name)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", newToolError(errNotFound, fmt.Sprintf("no scratch file %s", scratchInput.Name), "Call read_scratch without a name to list the scratch files.")
+	}
+	if err != nil {
+		return "", err
+	}
+	if scratchInput.StartLine <= 0 && scratchInput.EndLine <= 0 {
+		return string(content), nil
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(content), "\n"), "\n")
+	first := max(scratchInput.StartLine, 1)
+	last := len(lines)
+	if scratchInput.EndLine > 0 {
+		last = min(scratchInput.EndLine, last)
+	}
+	if first > last {
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s has %d lines", scratchInput.Name, len(lines)), "")
+	}
+	return strings.Join(lines[first-1:last], ""), nil
+}
+
+// listScratch lists the session's scratch files, by name
+func listScratch(ctx context.Context) (string, error) {
+	dir, err := scratchDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	files := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(w, "%s\t%d bytes\t%s\n", filepath.ToSlash(rel), info.Size(), info.ModTime().Format(time.DateTime))
+		files++
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) || err == nil && files == 0 {
+		return "No scratch files yet, save one with write_scratch", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	w.Flush()
+	return fmt.Sprintf("Scratch files in %s:\n%s", dir, b.String()), nil
+}
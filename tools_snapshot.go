@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// snapshotRefs is where snapshots are kept, out of the way of branches,
+// tags and the stash
+const snapshotRefs = "refs/codegent/snapshots/"
+
+// maxSnapshots is how many snapshots are kept, older ones are dropped
+const maxSnapshots = 20
+
+// Workspace Snapshot Tool
+var WorkspaceSnapshotDefinition = ToolDefinition{
+	Name:        "workspace_snapshot",
+	Description: "Snapshot the whole working tree, uncommitted changes and untracked files included, before a large or risky operation such as a sweeping refactor, a dependency upgrade or a code generator, so it can be put back as a whole later. save records the snapshot without touching any file, the index or git's stash. list shows the snapshots, diff what changed since one, and restore puts the working tree back to one, after saving the current state so the restore can be undone in turn. drop deletes one. Ignored files, such as dependencies and build output, aren't included. Unlike reverting edits, this covers changes made by commands as well.",
+	InputSchema: GenerateSchema[WorkspaceSnapshotInput](),
+	Kind:        ToolWrite,
+	KindOf:      workspaceSnapshotKind,
+	Function:    WorkspaceSnapshot,
+}
+
+type WorkspaceSnapshotInput struct {
+	Action string `json:"action" jsonschema_description:"save, list, diff, restore or drop." jsonschema:"required,enum=save,enum=list,enum=diff,enum=restore,enum=drop"`
+	Label  string `json:"label,omitempty" jsonschema_description:"save: what the snapshot is before, e.g. \"before upgrading React\"."`
+	ID     int    `json:"id,omitempty" jsonschema_description:"diff, restore and drop: the snapshot's number as list shows it, the latest when 0."`
+}
+
+// workspaceSnapshotKind counts saving, listing and diffing as reads, as
+// they leave the working tree as it is, see KindOf
+func workspaceSnapshotKind(input json.RawMessage) ToolKind {
+	var snapshotInput WorkspaceSnapshotInput
+	if err := json.Unmarshal(input, &snapshotInput); err == nil {
+		switch snapshotInput.Action {
+		case "save", "list", "diff":
+			return ToolRead
+		}
+	}
+	return ToolWrite
+}
+
+func WorkspaceSnapshot(ctx context.Context, input json.RawMessage) (string, error) {
+	snapshotInput := WorkspaceSnapshotInput{}
+	if err := json.Unmarshal(input, &snapshotInput); err != nil {
+		return "", err
+	}
+	top, err := gitOutput(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", newToolError(errNotFound, "not in a git repository", "Snapshots are kept by git; ask the user whether to run git init.")
+	}
+
+	switch snapshotInput.Action {
+	case "save":
+		label := strings.TrimSpace(snapshotInput.Label)
+		if label == "" {
+			label = "snapshot"
+		}
+		id, err := saveSnapshot(ctx, top, label, 0)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Saved snapshot %d", id), nil
+	case "list":
+		return listSnapshots(ctx, top)
+	case "diff", "restore", "drop":
+	default:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", snapshotInput.Action), "Use save, list, diff, restore or drop.")
+	}
+
+	id, err := findSnapshot(ctx, top, snapshotInput.ID)
+	if err != nil {
+		return "", err
+	}
+	ref := snapshotRefs + strconv.Itoa(id)
+	switch snapshotInput.Action {
+	case "diff":
+		current, err := workingTree(ctx, top)
+		if err != nil {
+			return "", err
+		}
+		stat, err := gitIn(ctx, top, "diff", "--stat", ref, current)
+		if err != nil {
+			return "", err
+		}
+		if stat == "" {
+			return fmt.Sprintf("Nothing changed since snapshot %d", id), nil
+		}
+		diff, _ := gitIn(ctx, top, "diff", "--no-color", ref, current)
+		return stat + "\n\n" + truncateOutput(diff, 6000), nil
+	case "restore":
+		return restoreSnapshot(ctx, top, id)
+	}
+	if _, err := gitIn(ctx, top, "update-ref", "-d", ref); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Dropped snapshot %d", id), nil
+}
+
+// snapshotGit runs git at the top of the repository with its own index,
+// when given, so the user's staging area isn't touched
+func snapshotGit(ctx context.Context, top, index string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = top
+	cmd.Stdin = stdin
+	// Snapshots are commits no one pushes, they needn't be the user's
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=codegent", "GIT_AUTHOR_EMAIL=codegent@localhost",
+		"GIT_COMMITTER_NAME=codegent", "GIT_COMMITTER_EMAIL=codegent@localhost")
+	if index != "" {
+		cmd.Env = append(cmd.Env, "GIT_INDEX_FILE="+index)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// withSnapshotIndex calls fn with a scratch index, starting out as a copy of
+// the user's one so unchanged files needn't be hashed again
+func withSnapshotIndex(ctx context.Context, top string, fn func(index string) error) error {
+	dir, err := os.MkdirTemp("", "codegent-snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	index := filepath.Join(dir, "index")
+	userIndex, err := gitIn(ctx, top, "rev-parse", "--path-format=absolute", "--git-path", "index")
+	if err != nil {
+		return err
+	}
+	if content, err := os.ReadFile(userIndex); err == nil {
+		if err := os.WriteFile(index, content, 0644); err != nil {
+			return err
+		}
+	}
+	return fn(index)
+}
+
+// workingTree writes the working tree, untracked files included, as a git
+// tree and returns its id
+func workingTree(ctx context.Context, top string) (string, error) {
+	var tree string
+	err := withSnapshotIndex(ctx, top, func(index string) error {
+		if _, err := snapshotGit(ctx, top, index, nil, "add", "-A", "--", "."); err != nil {
+			return err
+		}
+		var err error
+		tree, err = snapshotGit(ctx, top, index, nil, "write-tree")
+		return err
+	})
+	return tree, err
+}
+
+// snapshotIDs returns the numbers of the snapshots, latest first
+func snapshotIDs(ctx context.Context, top string) ([]int, error) {
+	out, err := gitIn(ctx, top, "for-each-ref", "--format=%(refname)", snapshotRefs)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, ref := range strings.Split(out, "\n") {
+		if id, err := strconv.Atoi(strings.TrimPrefix(ref, snapshotRefs)); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	slices.Reverse(ids)
+	return ids, nil
+}
+
+// saveSnapshot commits the working tree on top of HEAD and keeps it under
+// snapshotRefs with the next number, dropping the oldest snapshots beyond
+// maxSnapshots other than keep
+func saveSnapshot(ctx context.Context, top, label string, keep int) (int, error) {
+	tree, err := workingTree(ctx, top)
+	if err != nil {
+		return 0, err
+	}
+	args := []string{"commit-tree", tree, "-m", label}
+	if head, err := gitIn(ctx, top, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "-p", head)
+	}
+	commit, err := snapshotGit(ctx, top, "", nil, args...)
+	if err != nil {
+		return 0, err
+	}
+	ids, err := snapshotIDs(ctx, top)
+	if err != nil {
+		return 0, err
+	}
+	id := 1
+	if len(ids) > 0 {
+		id = ids[0] + 1
+	}
+	if _, err := gitIn(ctx, top, "update-ref", snapshotRefs+strconv.Itoa(id), commit); err != nil {
+		return 0, err
+	}
+	for i, old := range ids {
+		if i >= maxSnapshots-1 && old != keep {
+			gitIn(ctx, top, "update-ref", "-d", snapshotRefs+strconv.Itoa(old))
+		}
+	}
+	return id, nil
+}
+
+// listSnapshots lists the snapshots, latest first, with how much each
+// differs from the commit it was taken on
+func listSnapshots(ctx context.Context, top string) (string, error) {
+	ids, err := snapshotIDs(ctx, top)
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "No snapshots", nil
+	}
+	var b strings.Builder
+	for _, id := range ids {
+		ref := snapshotRefs + strconv.Itoa(id)
+		line, _ := gitIn(ctx, top, "log", "-1", "--format=%s, %cr, on %p", ref)
+		fmt.Fprintf(&b, "%d  %s", id, strings.TrimSuffix(line, ", on "))
+		if strings.HasSuffix(line, ", on ") {
+			b.WriteString("\n")
+			continue
+		}
+		stat, _ := gitIn(ctx, top, "diff", "--shortstat", ref+"^", ref)
+		if stat == "" {
+			stat = "no uncommitted changes"
+		}
+		fmt.Fprintf(&b, ": %s\n", stat)
+	}
+	return b.String(), nil
+}
+
+// findSnapshot checks that the snapshot exists, picking the latest one when
+// id is 0
+func findSnapshot(ctx context.Context, top string, id int) (int, error) {
+	ids, err := snapshotIDs(ctx, top)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case len(ids) == 0:
+		return 0, newToolError(errNotFound, "there are no snapshots", "Save one first.")
+	case id == 0:
+		return ids[0], nil
+	case !slices.Contains(ids, id):
+		return 0, newToolError(errNotFound, fmt.Sprintf("there is no snapshot %d", id), "Use a number list shows.")
+	}
+	return id, nil
+}
+
+// restoreSnapshot makes the working tree match the snapshot: files it
+// changed are written back, files added since are deleted. The current
+// state is saved first. HEAD and the index are left as they are.
+func restoreSnapshot(ctx context.Context, top string, id int) (string, error) {
+	ref, err := gitIn(ctx, top, "rev-parse", "--verify", snapshotRefs+strconv.Itoa(id)+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	current, err := workingTree(ctx, top)
+	if err != nil {
+		return "", err
+	}
+	changes, err := gitIn(ctx, top, "diff", "--name-status", "--no-renames", "-z", current, ref)
+	if err != nil {
+		return "", err
+	}
+	if changes == "" {
+		return fmt.Sprintf("The working tree already matches snapshot %d", id), nil
+	}
+	undo, err := saveSnapshot(ctx, top, fmt.Sprintf("before restoring snapshot %d", id), id)
+	if err != nil {
+		return "", err
+	}
+
+	var write, remove []string
+	fields := strings.Split(strings.TrimSuffix(changes, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "D" {
+			remove = append(remove, fields[i+1])
+		} else {
+			write = append(write, fields[i+1])
+		}
+	}
+	for _, path := range remove {
+		if err := os.Remove(filepath.Join(top, path)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if len(write) > 0 {
+		err := withSnapshotIndex(ctx, top, func(index string) error {
+			if _, err := snapshotGit(ctx, top, index, nil, "read-tree", ref); err != nil {
+				return err
+			}
+			_, err := snapshotGit(ctx, top, index, strings.NewReader(strings.Join(write, "\x00")+"\x00"), "checkout-index", "-f", "-z", "--stdin")
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Restored snapshot %d: wrote %d files and deleted %d. The state before is snapshot %d, restore it to undo this. Read files again before editing them.", id, len(write), len(remove), undo), nil
+}
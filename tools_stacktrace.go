@@ -0,0 +1,304 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Limits on the code resolve_stack_trace shows
+const (
+	defaultTraceContext = 5  // lines before and after a frame's line
+	maxTraceContext     = 20 // of the same
+	maxTraceFrames      = 8  // frames shown with their code
+	maxOtherFrames      = 10 // frames outside the workspace listed
+	maxAttachedFrames   = 4  // frames attachStackTrace attaches
+)
+
+var (
+	// traceLocation is a Go file and line in a stack trace, a test failure
+	// or a compiler error: /src/app/server.go:42 +0x1d, server_test.go:42:
+	// or ./server.go:42:7:
+	traceLocation = regexp.MustCompile(`(?:^|[\s(])((?:[A-Za-z]:)?[\w.@/\\+-]*\.go):(\d+)\b`)
+	// traceHeadline is a line saying what went wrong
+	traceHeadline = regexp.MustCompile(`^(panic: |fatal error: |--- FAIL: |FAIL\s|WARNING: DATA RACE)`)
+	// goroutineHeader starts the frames of a goroutine in a panic
+	goroutineHeader = regexp.MustCompile(`^goroutine \d+ \[[^\]]+\]:`)
+)
+
+// Resolve Stack Trace Tool
+var ResolveStackTraceDefinition = ToolDefinition{
+	Name:        "resolve_stack_trace",
+	Description: "Resolve a Go panic, race report, test failure or compiler error to the code it points at. Every file:line in the trace is matched to a file in the workspace, whether the trace has absolute paths from another machine, module paths from a -trimpath build or bare test file names, and the code around the first frames is returned with the line marked. Frames in the standard library and dependencies are only listed. Use it on traces the user pastes or that a command prints, before reading files.",
+	InputSchema: GenerateSchema[ResolveStackTraceInput](),
+	Kind:        ToolRead,
+	Function:    ResolveStackTrace,
+}
+
+type ResolveStackTraceInput struct {
+	Trace   string `json:"trace" jsonschema_description:"The stack trace or test output, as printed." jsonschema:"required"`
+	Context int    `json:"context,omitempty" jsonschema_description:"Lines of code to show before and after each frame's line, 5 when 0, at most 20."`
+}
+
+// traceFrame is one file:line of a trace, with the function when the trace
+// names it
+type traceFrame struct {
+	Function string
+	File     string // as in the trace
+	Line     int
+	Resolved string // the file in the workspace, "" when outside it
+}
+
+// parseStackTrace finds the frames of a trace, in order and without
+// repeats, and the lines saying what went wrong
+func parseStackTrace(trace string) (frames []traceFrame, headlines []string) {
+	lines := strings.Split(strings.ReplaceAll(trace, "\r\n", "\n"), "\n")
+	seen := make(map[string]bool)
+	for i, line := range lines {
+		if traceHeadline.MatchString(strings.TrimSpace(line)) {
+			headlines = append(headlines, strings.TrimSpace(line))
+		}
+		for _, match := range traceLocation.FindAllStringSubmatch(line, -1) {
+			key := match[1] + ":" + match[2]
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			n, _ := strconv.Atoi(match[2])
+			frame := traceFrame{File: match[1], Line: n}
+			// Panics name the function on the line before its location
+			if strings.HasPrefix(line, "\t") && i > 0 && !strings.HasPrefix(lines[i-1], "\t") && !goroutineHeader.MatchString(lines[i-1]) {
+				frame.Function = traceFunction(lines[i-1])
+			}
+			frames = append(frames, frame)
+		}
+	}
+	return frames, headlines
+}
+
+// traceFunction strips the arguments from a panic's function line, such
+// as main.(*Server).handle(0xc000010000, {0x0, 0x0})
+func traceFunction(line string) string {
+	line = strings.TrimSpace(line)
+	if rest, ok := strings.CutPrefix(line, "created by "); ok {
+		name, _, _ := strings.Cut(rest, " in goroutine")
+		return "created by " + name
+	}
+	if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+		return line[:i]
+	}
+	return line
+}
+
+// traceResolver matches the files of a trace to the workspace
+type traceResolver struct {
+	module    string              // the module path in go.mod
+	goroot    string              // the standard library, never resolved
+	basenames map[string][]string // Go files by name, read on first use
+}
+
+func newTraceResolver() *traceResolver {
+	r := &traceResolver{goroot: filepath.ToSlash(runtime.GOROOT())}
+	if content, err := os.ReadFile("go.mod"); err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			if module, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+				r.module = strings.Trim(strings.TrimSpace(module), `"`)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// resolve returns the workspace file a trace's path refers to, or "" for
+// the standard library, dependencies and files that aren't there
+func (r *traceResolver) resolve(ctx context.Context, path string) string {
+	slashed := filepath.ToSlash(path)
+	exists := func(p string) bool {
+		info, err := os.Stat(filepath.FromSlash(p))
+		return err == nil && info.Mode().IsRegular()
+	}
+	if filepath.IsAbs(path) {
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") && exists(rel) {
+				return filepath.ToSlash(rel)
+			}
+		}
+	} else if exists(slashed) {
+		return filepath.ToSlash(filepath.Clean(slashed))
+	}
+	// -trimpath builds print module paths instead of directories
+	if r.module != "" {
+		if _, rest, ok := strings.Cut(slashed, r.module+"/"); ok && exists(rest) {
+			return rest
+		}
+	}
+	if r.goroot != "" && strings.HasPrefix(slashed, r.goroot+"/") || strings.Contains(slashed, "/pkg/mod/") {
+		return ""
+	}
+
+	// Paths from another checkout end in the workspace's path, the longest
+	// such ending is taken
+	parts := strings.Split(strings.TrimPrefix(slashed, "./"), "/")
+	for i := 1; i < len(parts); i++ {
+		if suffix := strings.Join(parts[i:], "/"); exists(suffix) {
+			return suffix
+		}
+	}
+	// Test failures give only the file's name
+	if len(parts) == 1 {
+		if r.basenames == nil {
+			r.basenames = make(map[string][]string)
+			walkFiles(ctx, ".", 0, func(relPath string, d fs.DirEntry) bool {
+				if strings.HasSuffix(relPath, ".go") && !d.IsDir() && !inDependencyDir(relPath) {
+					name := filepath.Base(relPath)
+					r.basenames[name] = append(r.basenames[name], filepath.ToSlash(relPath))
+				}
+				return true
+			})
+		}
+		if found := r.basenames[parts[0]]; len(found) == 1 {
+			return found[0]
+		}
+	}
+	return ""
+}
+
+// resolveFrames resolves the frames of a trace in the working directory
+func resolveFrames(ctx context.Context, frames []traceFrame) {
+	resolver := newTraceResolver()
+	for i := range frames {
+		frames[i].Resolved = resolver.resolve(ctx, frames[i].File)
+	}
+}
+
+// frameSource returns the lines around a frame's line, numbered, with the
+// line itself marked, and the range it covers
+func frameSource(frame traceFrame, context int) (source string, first, last int, ok bool) {
+	content, err := os.ReadFile(frame.Resolved)
+	if err != nil || isBinary(content) {
+		return "", 0, 0, false
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if frame.Line < 1 || frame.Line > len(lines) {
+		return "", 0, 0, false
+	}
+	fileVersions.record(frame.Resolved, content)
+	first, last = max(frame.Line-context, 1), min(frame.Line+context, len(lines))
+	var b strings.Builder
+	for n := first; n <= last; n++ {
+		marker := " "
+		if n == frame.Line {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s%5d\t%s\n", marker, n, lines[n-1])
+	}
+	return strings.TrimSuffix(b.String(), "\n"), first, last, true
+}
+
+// looksLikeStackTrace tells a pasted trace from a message that merely
+// mentions a file and line
+func looksLikeStackTrace(message string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimSpace(line)
+		if goroutineHeader.MatchString(line) || traceHeadline.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachStackTrace attaches the code around the first frames in the
+// workspace of a trace pasted into a message, as resolve_stack_trace
+// would show it
+func (a *Agent) attachStackTrace(ctx context.Context, message string) {
+	if !a.config.AutoContext || !looksLikeStackTrace(message) {
+		return
+	}
+	frames, _ := parseStackTrace(message)
+	if len(frames) == 0 {
+		return
+	}
+
+	var attached []string
+	a.inWorkspace(func() {
+		resolveFrames(ctx, frames)
+		for _, frame := range frames {
+			if len(attached) == maxAttachedFrames {
+				break
+			}
+			if frame.Resolved == "" {
+				continue
+			}
+			source, first, last, ok := frameSource(frame, defaultTraceContext)
+			if !ok {
+				continue
+			}
+			a.attachments = append(a.attachments, fmt.Sprintf("Code around %s:%d, lines %d-%d:\n```go\n%s\n```", frame.Resolved, frame.Line, first, last, source))
+			attached = append(attached, fmt.Sprintf("%s:%d", frame.Resolved, frame.Line))
+		}
+	})
+	if len(attached) > 0 {
+		fmt.Fprintln(a.out, paint(roleDim, tr("Attached %s", strings.Join(attached, ", "))))
+	}
+}
+
+func ResolveStackTrace(ctx context.Context, input json.RawMessage) (string, error) {
+	traceInput := ResolveStackTraceInput{}
+	if err := json.Unmarshal(input, &traceInput); err != nil {
+		return "", err
+	}
+	frames, headlines := parseStackTrace(traceInput.Trace)
+	if len(frames) == 0 {
+		return "", newToolError(errNoMatch, "no file:line locations of Go files found in the trace", "Pass the trace as printed, including the lines with the file paths.")
+	}
+	resolveFrames(ctx, frames)
+	context := min(cmp.Or(max(traceInput.Context, 0), defaultTraceContext), maxTraceContext)
+
+	var b strings.Builder
+	for _, headline := range headlines {
+		b.WriteString(headline + "\n")
+	}
+	if len(headlines) > 0 {
+		b.WriteString("\n")
+	}
+	shown := 0
+	var other []string
+	for _, frame := range frames {
+		name := cmp.Or(frame.Function, "frame")
+		if frame.Resolved == "" {
+			other = append(other, fmt.Sprintf("%s (%s:%d)", name, frame.File, frame.Line))
+			continue
+		}
+		if shown == maxTraceFrames {
+			fmt.Fprintf(&b, "%s at %s:%d\n", name, frame.Resolved, frame.Line)
+			continue
+		}
+		source, _, _, ok := frameSource(frame, context)
+		if !ok {
+			fmt.Fprintf(&b, "%s at %s:%d, which the file doesn't have any more\n\n", name, frame.Resolved, frame.Line)
+			continue
+		}
+		shown++
+		fmt.Fprintf(&b, "%s at %s:%d\n```go\n%s\n```\n\n", name, frame.Resolved, frame.Line, source)
+	}
+	if shown == 0 {
+		b.WriteString("None of the frames are in the workspace.\n")
+	}
+	if len(other) > 0 {
+		fmt.Fprintf(&b, "Outside the workspace: %s", strings.Join(other[:min(len(other), maxOtherFrames)], ", "))
+		if len(other) > maxOtherFrames {
+			fmt.Fprintf(&b, " and %d more", len(other)-maxOtherFrames)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String()), nil
+}
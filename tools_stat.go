@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Stat Tool
+var StatDefinition = ToolDefinition{
+	Name:        "stat",
+	Description: "Get metadata for a file or directory: type, size, modification time and permissions. Use this to check whether a file exists, how big it is before reading it, or whether a build artifact is newer than its source.",
+	InputSchema: GenerateSchema[StatInput](),
+	Kind:        ToolRead,
+	Function:    Stat,
+}
+
+type StatInput struct {
+	Path string `json:"path" jsonschema_description:"The relative path of a file or directory in the working directory." jsonschema:"required"`
+}
+
+type statResult struct {
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Size        int64  `json:"size"`
+	ModTime     string `json:"mod_time"`
+	Permissions string `json:"permissions"`
+	Mode        string `json:"mode"`
+	Target      string `json:"symlink_target,omitempty"`
+}
+
+func Stat(ctx context.Context, input json.RawMessage) (string, error) {
+	statInput := StatInput{}
+	if err := json.Unmarshal(input, &statInput); err != nil {
+		return "", err
+	}
+
+	info, err := os.Lstat(statInput.Path)
+	if err != nil {
+		return "", err
+	}
+
+	result := statResult{
+		Path:        statInput.Path,
+		Type:        fileType(info.Mode()),
+		Size:        info.Size(),
+		ModTime:     info.ModTime().Format(time.RFC3339),
+		Permissions: fmt.Sprintf("%04o", info.Mode().Perm()),
+		Mode:        info.Mode().String(),
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		result.Target, _ = os.Readlink(statInput.Path)
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// maxSymbolRefs bounds how many references find_symbol returns
+const maxSymbolRefs = 200
+
+// FindSymbol Tool
+var FindSymbolDefinition = ToolDefinition{
+	Name:        "find_symbol",
+	Description: "Find where an identifier is declared (func, method, type, var or const, and class, interface, struct, enum or trait) and every file and line that uses it, across the Go, JavaScript, TypeScript, Python and Rust files in the working directory. Matching is by name, so unrelated symbols with the same name are included. Use this to plan renames and to find callers of a function.",
+	InputSchema: GenerateSchema[FindSymbolInput](),
+	Kind:        ToolRead,
+	Function:    FindSymbol,
+}
+
+type FindSymbolInput struct {
+	Name string `json:"name" jsonschema_description:"The identifier to look up, such as a function, method, type or field name, without package qualifier." jsonschema:"required"`
+	Path string `json:"path,omitempty" jsonschema_description:"Optional directory to search. Defaults to the working directory."`
+}
+
+type findSymbolResult struct {
+	Definitions []symbol    `json:"definitions"`
+	References  []symbolRef `json:"references"`
+	Note        string      `json:"note,omitempty"`
+}
+
+func FindSymbol(ctx context.Context, input json.RawMessage) (string, error) {
+	findInput := FindSymbolInput{}
+	if err := json.Unmarshal(input, &findInput); err != nil {
+		return "", err
+	}
+	if findInput.Name == "" {
+		return "", errors.New("name is required")
+	}
+	root := findInput.Path
+	if root == "" {
+		root = "."
+	}
+
+	index, err := buildSymbolIndex(ctx, root)
+	if err != nil {
+		return "", err
+	}
+	result := findSymbolResult{}
+	result.Definitions, result.References = index.lookup(findInput.Name)
+	if len(result.References) > maxSymbolRefs {
+		result.Note = "references truncated, narrow the search with path"
+		result.References = result.References[:maxSymbolRefs]
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project Targets Tool
+var ProjectTargetsDefinition = ToolDefinition{
+	Name:        "project_targets",
+	Description: "List the targets of the project's Makefile, Taskfile and package.json scripts with their descriptions, or run one. Prefer the project's own targets, such as make test or npm run lint, over guessing build and test commands: they set the flags and environment the project expects. Without target it lists them; with target it runs it, which needs the user's approval like any command.",
+	InputSchema: GenerateSchema[ProjectTargetsInput](),
+	Kind:        ToolExecute,
+	KindOf:      projectTargetsKind,
+	Commands:    projectTargetsCommands,
+	Function:    ProjectTargets,
+}
+
+type ProjectTargetsInput struct {
+	Target string   `json:"target,omitempty" jsonschema_description:"The target to run, as listed. Leave empty to list the targets."`
+	Runner string   `json:"runner,omitempty" jsonschema_description:"make, task or npm, for a target name more than one of them defines." jsonschema:"enum=make,enum=task,enum=npm"`
+	Args   []string `json:"args,omitempty" jsonschema_description:"Extra arguments, such as VAR=value for make or arguments passed on to an npm script."`
+}
+
+// projectTargetsKind counts listing the targets as a read and running one
+// as a command, see KindOf
+func projectTargetsKind(input json.RawMessage) ToolKind {
+	var targetsInput ProjectTargetsInput
+	if err := json.Unmarshal(input, &targetsInput); err == nil && targetsInput.Target == "" {
+		return ToolRead
+	}
+	return ToolExecute
+}
+
+// projectTargetsCommands returns the target and arguments a call runs,
+// see Commands
+func projectTargetsCommands(input json.RawMessage) []string {
+	var targetsInput ProjectTargetsInput
+	if err := json.Unmarshal(input, &targetsInput); err != nil || targetsInput.Target == "" {
+		return nil
+	}
+	return []string{strings.Join(append([]string{targetsInput.Target}, targetsInput.Args...), " ")}
+}
+
+// projectTarget is a target of a Makefile, Taskfile or package.json
+type projectTarget struct {
+	Runner      string // make, task or npm
+	Name        string
+	Description string
+}
+
+func ProjectTargets(ctx context.Context, input json.RawMessage) (string, error) {
+	targetsInput := ProjectTargetsInput{}
+	if err := json.Unmarshal(input, &targetsInput); err != nil {
+		return "", err
+	}
+	targets, err := projectTargets()
+	if err != nil {
+		return "", err
+	}
+	if len(targets) == 0 {
+		return "", newToolError(errNotFound, "no Makefile, Taskfile or package.json scripts in the working directory", "Look for the build instructions in the README instead.")
+	}
+	if targetsInput.Target == "" {
+		var b strings.Builder
+		for _, t := range targets {
+			fmt.Fprintf(&b, "%s %s", t.Runner, t.Name)
+			if t.Description != "" {
+				fmt.Fprintf(&b, ": %s", t.Description)
+			}
+			b.WriteString("\n")
+		}
+		return b.String(), nil
+	}
+
+	var matches []projectTarget
+	for _, t := range targets {
+		if t.Name == targetsInput.Target && (targetsInput.Runner == "" || t.Runner == targetsInput.Runner) {
+			matches = append(matches, t)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return "", newToolError(errNotFound, fmt.Sprintf("no target %q", targetsInput.Target), "Call project_targets without a target to list them.")
+	case len(matches) > 1:
+		return "", newToolError(errInvalidInput, fmt.Sprintf("%s and %s both define %q", matches[0].Runner, matches[1].Runner, targetsInput.Target), "Pick one with runner.")
+	}
+	command, err := targetCommand(matches[0], targetsInput.Args)
+	if err != nil {
+		return "", err
+	}
+	return runStreamed(ctx, exec.CommandContext(ctx, command[0], command[1:]...))
+}
+
+// targetCommand returns the command line that runs a target
+func targetCommand(t projectTarget, args []string) ([]string, error) {
+	var command []string
+	switch t.Runner {
+	case "make":
+		command = append([]string{"make", t.Name}, args...)
+	case "task":
+		command = []string{"task", t.Name}
+		if len(args) > 0 {
+			command = append(append(command, "--"), args...)
+		}
+	case "npm":
+		command = []string{npmRunner(""), "run", t.Name}
+		if len(args) > 0 {
+			command = append(append(command, "--"), args...)
+		}
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return nil, &toolError{Code: errFailed, Message: command[0] + " is not installed", Suggestion: "Run the target's commands with run_command, or tell the user " + command[0] + " is needed."}
+	}
+	return command, nil
+}
+
+// npmRunner picks the package manager whose lock file the project in dir
+// has, the working directory when empty
+func npmRunner(dir string) string {
+	for _, lock := range []struct{ file, runner string }{
+		{"pnpm-lock.yaml", "pnpm"}, {"yarn.lock", "yarn"}, {"bun.lockb", "bun"}, {"bun.lock", "bun"},
+	} {
+		if _, err := os.Stat(filepath.Join(dir, lock.file)); err == nil {
+			return lock.runner
+		}
+	}
+	return "npm"
+}
+
+// projectTargets collects the targets of the project in the working
+// directory, in the order of the files and the targets in them
+func projectTargets() ([]projectTarget, error) {
+	var targets []projectTarget
+	for _, name := range []string{"GNUmakefile", "makefile", "Makefile"} {
+		data, err := os.ReadFile(name)
+		if err == nil {
+			targets = append(targets, makeTargets(data)...)
+			break
+		}
+	}
+	for _, name := range []string{"Taskfile.yml", "Taskfile.yaml", "taskfile.yml", "taskfile.yaml"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		found, err := taskfileTargets(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		targets = append(targets, found...)
+		break
+	}
+	if data, err := os.ReadFile("package.json"); err == nil {
+		found, err := npmTargets(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse package.json: %w", err)
+		}
+		targets = append(targets, found...)
+	}
+	return targets, nil
+}
+
+// makeRule matches a rule's targets and what follows the colon
+var makeRule = regexp.MustCompile(`^([A-Za-z0-9_./-][A-Za-z0-9_./ -]*?)\s*::?([^=].*)?$`)
+
+// makeTargets lists the explicit targets of a Makefile. A "## comment"
+// after the prerequisites, or comment lines right above the rule, describe
+// a target.
+func makeTargets(data []byte) []projectTarget {
+	var targets []projectTarget
+	var comment []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if text, ok := strings.CutPrefix(line, "#"); ok {
+			comment = append(comment, strings.TrimSpace(strings.TrimLeft(text, "#")))
+			continue
+		}
+		m := makeRule.FindStringSubmatch(line)
+		if m == nil || strings.HasPrefix(line, "\t") {
+			comment = nil
+			continue
+		}
+		description := strings.Join(comment, " ")
+		if _, after, ok := strings.Cut(m[2], "##"); ok {
+			description = strings.TrimSpace(after)
+		}
+		comment = nil
+		for _, name := range strings.Fields(m[1]) {
+			// Special targets such as .PHONY and files such as build/app.o
+			// aren't entry points
+			if strings.HasPrefix(name, ".") || strings.Contains(name, "/") || strings.Contains(name, ".") {
+				continue
+			}
+			if !slices.ContainsFunc(targets, func(t projectTarget) bool { return t.Name == name }) {
+				targets = append(targets, projectTarget{Runner: "make", Name: name, Description: description})
+			}
+		}
+	}
+	return targets
+}
+
+// taskfileTargets lists the tasks of a Taskfile with their desc, leaving
+// out internal ones
+func taskfileTargets(data []byte) ([]projectTarget, error) {
+	var taskfile struct {
+		Tasks yaml.Node `yaml:"tasks"`
+	}
+	if err := yaml.Unmarshal(data, &taskfile); err != nil {
+		return nil, err
+	}
+	var targets []projectTarget
+	// Tasks are a mapping, kept in the file's order
+	for i := 0; i+1 < len(taskfile.Tasks.Content); i += 2 {
+		name := taskfile.Tasks.Content[i].Value
+		var task struct {
+			Desc     string `yaml:"desc"`
+			Internal bool   `yaml:"internal"`
+		}
+		if body := taskfile.Tasks.Content[i+1]; body.Kind == yaml.MappingNode {
+			if err := body.Decode(&task); err != nil {
+				return nil, err
+			}
+		}
+		if !task.Internal {
+			targets = append(targets, projectTarget{Runner: "task", Name: name, Description: task.Desc})
+		}
+	}
+	return targets, nil
+}
+
+// npmTargets lists the scripts of a package.json, described by their
+// commands
+func npmTargets(data []byte) ([]projectTarget, error) {
+	var pkg struct {
+		Scripts json.RawMessage `json:"scripts"`
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil || len(pkg.Scripts) == 0 {
+		return nil, err
+	}
+	// Decoded token by token to keep the file's order
+	var targets []projectTarget
+	dec := json.NewDecoder(bytes.NewReader(pkg.Scripts))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		var name, command string
+		if err := dec.Decode(&name); err != nil {
+			return nil, err
+		}
+		if err := dec.Decode(&command); err != nil {
+			return nil, err
+		}
+		targets = append(targets, projectTarget{Runner: "npm", Name: name, Description: command})
+	}
+	return targets, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxTodos bounds how many comments find_todos returns
+const maxTodos = 500
+
+// todoPattern matches a TODO-style tag in a comment, with an optional
+// owner in parentheses, e.g. "// TODO(alice): ..." or "# FIXME ..."
+var todoPattern = regexp.MustCompile(`(?://|#|/\*|<!--|--|;)\s*\b(TODO|FIXME|HACK|XXX)\b(?:\(([^)]*)\))?:?\s*(.*)`)
+
+// FindTodos Tool
+var FindTodosDefinition = ToolDefinition{
+	Name:        "find_todos",
+	Description: "Find TODO, FIXME, HACK and XXX comments with their file, line and text. Use this to get an overview of known loose ends, or to find a specific TODO to work on.",
+	InputSchema: GenerateSchema[FindTodosInput](),
+	Kind:        ToolRead,
+	Function:    FindTodos,
+}
+
+type FindTodosInput struct {
+	Path string   `json:"path,omitempty" jsonschema_description:"Optional file or directory to scan. Defaults to the working directory."`
+	Tags []string `json:"tags,omitempty" jsonschema_description:"Optional tags to look for, such as [\"FIXME\"]. Defaults to all of TODO, FIXME, HACK and XXX."`
+}
+
+type todoItem struct {
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+	Tag   string `json:"tag"`
+	Owner string `json:"owner,omitempty"`
+	Text  string `json:"text"`
+}
+
+// aiTagged reports whether the comment is addressed to the agent, written
+// as TODO(AI): ... or TODO: AI: ...
+func (t todoItem) aiTagged() bool {
+	return strings.EqualFold(t.Owner, "ai") || strings.HasPrefix(strings.ToUpper(t.Text), "AI:")
+}
+
+// scanTodos returns the TODO comments in content, which is skipped when
+// binary
+func scanTodos(path string, content []byte) []todoItem {
+	if isBinary(content) {
+		return nil
+	}
+	var todos []todoItem
+	for i, line := range strings.Split(string(content), "\n") {
+		m := todoPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		text := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(m[3]), "*/"))
+		text = strings.TrimSpace(strings.TrimSuffix(text, "-->"))
+		todos = append(todos, todoItem{File: path, Line: i + 1, Tag: m[1], Owner: m[2], Text: text})
+	}
+	return todos
+}
+
+// findTodos scans a file or every file below a directory
+func findTodos(ctx context.Context, root string) ([]todoItem, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		content, err := os.ReadFile(root)
+		if err != nil {
+			return nil, err
+		}
+		return scanTodos(root, content), nil
+	}
+
+	var todos []todoItem
+	err = walkFiles(ctx, root, 0, func(relPath string, d fs.DirEntry) bool {
+		if !d.Type().IsRegular() {
+			return true
+		}
+		path := filepath.Join(root, relPath)
+		content, err := os.ReadFile(path)
+		if err == nil {
+			todos = append(todos, scanTodos(path, content)...)
+		}
+		return true
+	})
+	return todos, err
+}
+
+func FindTodos(ctx context.Context, input json.RawMessage) (string, error) {
+	findInput := FindTodosInput{}
+	if err := json.Unmarshal(input, &findInput); err != nil {
+		return "", err
+	}
+	root := findInput.Path
+	if root == "" {
+		root = "."
+	}
+
+	todos, err := findTodos(ctx, root)
+	if err != nil {
+		return "", err
+	}
+	if len(findInput.Tags) > 0 {
+		filtered := todos[:0]
+		for _, todo := range todos {
+			for _, tag := range findInput.Tags {
+				if strings.EqualFold(todo.Tag, tag) {
+					filtered = append(filtered, todo)
+					break
+				}
+			}
+		}
+		todos = filtered
+	}
+
+	result := struct {
+		Todos []todoItem `json:"todos"`
+		Note  string     `json:"note,omitempty"`
+	}{Todos: todos}
+	if len(todos) > maxTodos {
+		result.Todos = todos[:maxTodos]
+		result.Note = "truncated, narrow the search with path or tags"
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
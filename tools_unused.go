@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Unused Code Tool
+var UnusedCodeDefinition = ToolDefinition{
+	Name:        "unused_code",
+	Description: "Find unused Go code and dependencies so they can be removed safely. code lists functions nothing reachable from main or the tests calls, using deadcode, or else unused identifiers, using staticcheck. dependencies lists the modules go.mod requires that no package needs, what go mod tidy would change, and with module, why a module is needed. tidy runs go mod tidy, then builds and vets everything, and puts go.mod and go.sum back if that fails. Before deleting reported code, check with find_symbol that it isn't used through reflection, build tags or code generation, and run the build and tests afterwards.",
+	InputSchema: GenerateSchema[UnusedCodeInput](),
+	Kind:        ToolExecute,
+	KindOf:      unusedCodeKind,
+	Function:    UnusedCode,
+}
+
+type UnusedCodeInput struct {
+	Action   string `json:"action" jsonschema_description:"code, dependencies or tidy." jsonschema:"required,enum=code,enum=dependencies,enum=tidy"`
+	Packages string `json:"packages,omitempty" jsonschema_description:"code: the packages to analyze, ./... when empty."`
+	Module   string `json:"module,omitempty" jsonschema_description:"dependencies: a module to explain, showing the import chain that needs it."`
+}
+
+// unusedCodeKind counts the analyses as reads, see KindOf
+func unusedCodeKind(input json.RawMessage) ToolKind {
+	var unusedInput UnusedCodeInput
+	if err := json.Unmarshal(input, &unusedInput); err == nil && unusedInput.Action != "tidy" {
+		return ToolRead
+	}
+	return ToolExecute
+}
+
+func UnusedCode(ctx context.Context, input json.RawMessage) (string, error) {
+	unusedInput := UnusedCodeInput{}
+	if err := json.Unmarshal(input, &unusedInput); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat("go.mod"); err != nil {
+		return "", newToolError(errNotFound, "no go.mod here", "Run it at the top of a Go module.")
+	}
+	switch unusedInput.Action {
+	case "code":
+		return deadCode(ctx, cmp.Or(strings.TrimSpace(unusedInput.Packages), "./..."))
+	case "dependencies":
+		if unusedInput.Module != "" {
+			out, err := exec.CommandContext(ctx, "go", "mod", "why", "-m", unusedInput.Module).CombinedOutput()
+			if err != nil {
+				return "", fmt.Errorf("go mod why: %s", strings.TrimSpace(string(out)))
+			}
+			return strings.TrimSpace(string(out)), nil
+		}
+		return unusedDependencies(ctx)
+	case "tidy":
+		return tidyModule(ctx)
+	}
+	return "", newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", unusedInput.Action), "Use code, dependencies or tidy.")
+}
+
+// deadCode runs deadcode, which finds functions unreachable from main and
+// the tests across the whole program, or else staticcheck's check for
+// unused identifiers, which works package by package
+func deadCode(ctx context.Context, packages string) (string, error) {
+	tool, args := "deadcode", []string{"-test"}
+	if _, err := exec.LookPath(tool); err != nil {
+		tool, args = "staticcheck", []string{"-checks", "U1000"}
+		if _, err := exec.LookPath(tool); err != nil {
+			return "", newToolError(errNotFound, "neither deadcode nor staticcheck is installed", "Ask the user to install one: go install golang.org/x/tools/cmd/deadcode@latest, or honnef.co/go/tools/cmd/staticcheck@latest.")
+		}
+	}
+	cmd := exec.CommandContext(ctx, tool, append(args, strings.Fields(packages)...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// staticcheck exits 1 when it finds something
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || stdout.Len() == 0) {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("%s: %s", tool, summarizeOutput(stderr.String()))
+		}
+		return "", fmt.Errorf("%s: %w", tool, err)
+	}
+	found := strings.TrimSpace(stdout.String())
+	if found == "" {
+		return "No unused code found by " + tool, nil
+	}
+	note := "Functions reachable from neither main nor the tests, found by deadcode:"
+	if tool == "staticcheck" {
+		note = "Unused identifiers, found by staticcheck, which doesn't see across packages; exported ones aren't checked:"
+	}
+	return note + "\n" + truncateOutput(found, 4000), nil
+}
+
+// unusedDependencies asks go mod why about every module go.mod requires
+// directly, and go mod tidy what it would change
+func unusedDependencies(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "go", "mod", "edit", "-json").Output()
+	if err != nil {
+		return "", fmt.Errorf("go mod edit: %w", err)
+	}
+	var mod struct {
+		Require []struct {
+			Path     string
+			Indirect bool
+		}
+	}
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return "", err
+	}
+	var direct []string
+	for _, req := range mod.Require {
+		if !req.Indirect {
+			direct = append(direct, req.Path)
+		}
+	}
+
+	var b strings.Builder
+	if len(direct) > 0 {
+		why, err := exec.CommandContext(ctx, "go", append([]string{"mod", "why", "-m"}, direct...)...).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("go mod why: %s", summarizeOutput(string(why)))
+		}
+		// Each module is a "# path" line followed by the import chain
+		// that needs it, or a note that nothing does
+		var unused []string
+		var module string
+		for _, line := range strings.Split(string(why), "\n") {
+			if name, ok := strings.CutPrefix(line, "# "); ok {
+				module = name
+			} else if strings.HasPrefix(line, "(main module does not need") {
+				unused = append(unused, module)
+			}
+		}
+		if len(unused) > 0 {
+			fmt.Fprintf(&b, "Required directly but not imported by any package:\n%s\n\n", strings.Join(unused, "\n"))
+		} else {
+			fmt.Fprintf(&b, "All %d direct requirements are imported.\n\n", len(direct))
+		}
+	}
+	// go mod tidy -diff needs Go 1.23, older versions are just skipped
+	tidy, err := exec.CommandContext(ctx, "go", "mod", "tidy", "-diff").Output()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		b.WriteString("go mod tidy wouldn't change anything.")
+	case errors.As(err, &exitErr) && len(tidy) > 0:
+		fmt.Fprintf(&b, "go mod tidy would change:\n%s", truncateOutput(tidyDiff(string(tidy)), 3000))
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// tidyDiff leaves go.sum out of a go mod tidy diff, its hashes say nothing
+// a go.mod diff doesn't
+func tidyDiff(diff string) string {
+	var kept []string
+	skip := false
+	for _, part := range strings.SplitAfter(diff, "\n") {
+		if strings.HasPrefix(part, "diff ") || strings.HasPrefix(part, "--- ") {
+			skip = strings.Contains(part, "go.sum")
+		}
+		if !skip {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// tidyModule runs go mod tidy and checks that everything still builds,
+// restoring go.mod and go.sum otherwise
+func tidyModule(ctx context.Context) (string, error) {
+	files := []string{"go.mod", "go.sum"}
+	before := make(map[string][]byte)
+	for _, file := range files {
+		if content, err := os.ReadFile(file); err == nil {
+			before[file] = content
+		}
+		fileVersions.beforeWrite(file)
+	}
+	restore := func() {
+		for _, file := range files {
+			if content, ok := before[file]; ok {
+				os.WriteFile(file, content, 0644)
+			} else {
+				os.Remove(file)
+			}
+		}
+	}
+
+	progress := toolOutput(ctx)
+	if output, err := runCheck(ctx, "go mod tidy", progress); err != nil {
+		restore()
+		return "", fmt.Errorf("go mod tidy failed, go.mod is unchanged:\n%s", summarizeOutput(output))
+	}
+	after, _ := os.ReadFile("go.mod")
+	if string(after) == string(before["go.mod"]) {
+		return "go.mod was already tidy", nil
+	}
+	if output, err := runCheck(ctx, "go build ./... && go vet ./...", progress); err != nil {
+		restore()
+		return "", newToolError(errFailed, "the module doesn't build after go mod tidy, go.mod and go.sum were put back:\n"+summarizeOutput(output), "A removed module may be used under a build tag; check with go mod why.")
+	}
+	for _, file := range files {
+		if content, err := os.ReadFile(file); err == nil {
+			fileVersions.record(file, content)
+		}
+	}
+	return "Tidied go.mod, and the module builds and vets:\n" + unifiedDiff("go.mod", string(before["go.mod"]), string(after)), nil
+}
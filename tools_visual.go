@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"google.golang.org/genai"
+)
+
+const (
+	// pixelThreshold is how far a color channel may be off, out of 255,
+	// before a pixel counts as changed, so antialiasing isn't a change
+	pixelThreshold = 24
+	// diffCell is the size of the squares changed pixels are grouped into
+	// to describe where a page changed
+	diffCell = 16
+	// maxDiffRegions bounds the changed regions listed
+	maxDiffRegions = 10
+)
+
+// VisualDiff Tool
+var VisualDiffDefinition = ToolDefinition{
+	Name:          "visual_diff",
+	Description:   "Catch unintended UI changes from your edits. Before changing a page or a terminal UI, capture it under a name; afterwards, compare with that name to get the share of pixels that changed, where on the page, an image marking the changed pixels, and the changes to the page's element structure and text. Pages are captured from the browser tool's page, which compare reloads at the captured URL and size. With command, the output of a command is captured as a terminal of columns × rows would show it, for CLIs and TUIs, and compare runs it again.",
+	InputSchema:   GenerateSchema[VisualDiffInput](),
+	Kind:          ToolExecute,
+	KindOf:        visualDiffKind,
+	Commands:      visualDiffCommands,
+	MediaFunction: VisualDiff,
+}
+
+type VisualDiffInput struct {
+	Action   string `json:"action" jsonschema_description:"capture the current state as a baseline, or compare the current state with one." jsonschema:"required,enum=capture,enum=compare"`
+	Name     string `json:"name" jsonschema_description:"The baseline's name, e.g. \"checkout-page\"." jsonschema:"required"`
+	Command  string `json:"command,omitempty" jsonschema_description:"capture: a shell command whose terminal output to capture instead of the browser page, e.g. \"go run ./cmd/tui --demo\". compare runs the baseline's command again."`
+	Columns  int    `json:"columns,omitempty" jsonschema_description:"command: the terminal width, 80 when 0."`
+	Rows     int    `json:"rows,omitempty" jsonschema_description:"command: the terminal height; 0 keeps all output lines."`
+	FullPage bool   `json:"full_page,omitempty" jsonschema_description:"capture: capture the whole page instead of the viewport."`
+}
+
+// visualDiffKind counts capturing pages as a read, and running commands as
+// a command, see KindOf
+func visualDiffKind(input json.RawMessage) ToolKind {
+	var diffInput VisualDiffInput
+	if err := json.Unmarshal(input, &diffInput); err != nil {
+		return ToolExecute
+	}
+	if diffInput.Command != "" {
+		return ToolExecute
+	}
+	if diffInput.Action == "compare" {
+		visualMu.Lock()
+		baseline := visualBaselines[diffInput.Name]
+		visualMu.Unlock()
+		if baseline != nil && baseline.Command != "" {
+			return ToolExecute
+		}
+	}
+	return ToolRead
+}
+
+// visualDiffCommands returns the command a capture runs, or the one of the
+// baseline a comparison runs again, see Commands
+func visualDiffCommands(input json.RawMessage) []string {
+	var diffInput VisualDiffInput
+	if err := json.Unmarshal(input, &diffInput); err != nil {
+		return nil
+	}
+	if diffInput.Action == "compare" {
+		visualMu.Lock()
+		defer visualMu.Unlock()
+		if baseline := visualBaselines[diffInput.Name]; baseline != nil {
+			return []string{baseline.Command}
+		}
+		return nil
+	}
+	return []string{diffInput.Command}
+}
+
+// visualCapture is what a page or command looked like
+type visualCapture struct {
+	// Page
+	URL           string
+	Width, Height int
+	FullPage      bool
+	Screenshot    []byte
+	// Command
+	Command       string
+	Columns, Rows int
+	Raw           string // the output with its escape sequences
+
+	// Element outline of the page, or the terminal screen
+	Structure string
+}
+
+var (
+	visualMu        sync.Mutex
+	visualBaselines = make(map[string]*visualCapture)
+)
+
+func VisualDiff(ctx context.Context, input json.RawMessage) (string, *genai.Blob, error) {
+	diffInput := VisualDiffInput{}
+	if err := json.Unmarshal(input, &diffInput); err != nil {
+		return "", nil, err
+	}
+	if diffInput.Name == "" {
+		return "", nil, newToolError(errInvalidInput, "name is required", "Name the baseline, e.g. \"login-page\".")
+	}
+
+	switch diffInput.Action {
+	case "capture":
+		capture := &visualCapture{Command: diffInput.Command, Columns: cmp.Or(diffInput.Columns, 80), Rows: diffInput.Rows, FullPage: diffInput.FullPage}
+		if err := capture.take(ctx); err != nil {
+			return "", nil, err
+		}
+		visualMu.Lock()
+		visualBaselines[diffInput.Name] = capture
+		visualMu.Unlock()
+		if capture.Command != "" {
+			return fmt.Sprintf("Captured the output of %s as %q, compare with it after your changes.", capture.Command, diffInput.Name), nil, nil
+		}
+		return fmt.Sprintf("Captured %s at %dx%d as %q, compare with it after your changes.", capture.URL, capture.Width, capture.Height, diffInput.Name), nil, nil
+	case "compare":
+		visualMu.Lock()
+		baseline := visualBaselines[diffInput.Name]
+		visualMu.Unlock()
+		if baseline == nil {
+			return "", nil, newToolError(errNotFound, fmt.Sprintf("no baseline named %q", diffInput.Name), "Capture one before making changes; a baseline can't be taken afterwards.")
+		}
+		current := &visualCapture{URL: baseline.URL, Width: baseline.Width, Height: baseline.Height, FullPage: baseline.FullPage, Command: baseline.Command, Columns: baseline.Columns, Rows: baseline.Rows}
+		if err := current.take(ctx); err != nil {
+			return "", nil, err
+		}
+		return compareCaptures(diffInput.Name, baseline, current)
+	}
+	return "", nil, newToolError(errInvalidInput, fmt.Sprintf("unknown action %q", diffInput.Action), "Use capture or compare.")
+}
+
+// take captures the command's output, or else the browser's page. A page
+// with a URL already is loaded there first, at the same size.
+func (c *visualCapture) take(ctx context.Context) error {
+	if c.Command != "" {
+		cmd := shellCommand(ctx, c.Command)
+		cmd.Env = append(os.Environ(), "COLUMNS="+strconv.Itoa(c.Columns), "TERM=xterm-256color")
+		if c.Rows > 0 {
+			cmd.Env = append(cmd.Env, "LINES="+strconv.Itoa(c.Rows))
+		}
+		// A failing command is captured as well, the output tells why
+		output, _ := cmd.CombinedOutput()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.Raw = string(output)
+		c.Structure = renderTerminal(c.Raw, c.Columns, c.Rows)
+		return nil
+	}
+
+	page, err := launchBrowser(ctx)
+	if err != nil {
+		return err
+	}
+	if c.URL != "" {
+		if err := page.navigate(ctx, c.URL, c.Width, c.Height); err != nil {
+			return err
+		}
+	}
+	var state struct {
+		URL           string
+		Width, Height int
+		Outline       string
+	}
+	if err := page.evaluateValue(ctx, pageOutlineScript, &state); err != nil {
+		return err
+	}
+	if state.URL == "about:blank" {
+		return newToolError(errInvalidInput, "the browser has no page open", "Navigate to the page with the browser tool first, or pass command for a terminal UI.")
+	}
+	c.URL, c.Width, c.Height, c.Structure = state.URL, state.Width, state.Height, state.Outline
+	c.Screenshot, err = page.screenshot(ctx, c.FullPage)
+	return err
+}
+
+// pageOutlineScript describes the page's location and size, and outlines
+// its visible elements one per line, indented by depth, with their ID,
+// classes and own text
+const pageOutlineScript = `(() => {
+	const lines = [];
+	const walk = (el, depth) => {
+		if (lines.length >= 3000 || ["SCRIPT", "STYLE", "NOSCRIPT", "TEMPLATE"].includes(el.tagName)) return;
+		const style = getComputedStyle(el);
+		if (style.display === "none" || style.visibility === "hidden") return;
+		let line = "  ".repeat(depth) + el.tagName.toLowerCase();
+		if (el.id) line += "#" + el.id;
+		for (const c of [...el.classList].slice(0, 4)) line += "." + c;
+		const text = [...el.childNodes].filter(n => n.nodeType === 3).map(n => n.textContent.trim()).filter(Boolean).join(" ");
+		if (text) line += " " + JSON.stringify(text.slice(0, 100));
+		if (el.value) line += " value=" + JSON.stringify(String(el.value).slice(0, 100));
+		lines.push(line);
+		for (const child of el.children) walk(child, depth + 1);
+	};
+	walk(document.body, 0);
+	return {URL: location.href, Width: innerWidth, Height: innerHeight, Outline: lines.join("\n")};
+})()`
+
+// compareCaptures describes how current differs from baseline, with an
+// image of the changed pixels for pages
+func compareCaptures(name string, baseline, current *visualCapture) (string, *genai.Blob, error) {
+	var b strings.Builder
+	var blob *genai.Blob
+	if current.Command != "" {
+		fmt.Fprintf(&b, "Output of %s compared with %q\n", current.Command, name)
+	} else {
+		fmt.Fprintf(&b, "%s compared with %q\n", current.URL, name)
+		before, err := png.Decode(bytes.NewReader(baseline.Screenshot))
+		if err != nil {
+			return "", nil, err
+		}
+		after, err := png.Decode(bytes.NewReader(current.Screenshot))
+		if err != nil {
+			return "", nil, err
+		}
+		diff := comparePixels(before, after)
+		if diff.Changed == 0 {
+			b.WriteString("Pixels: no visible change\n")
+		} else {
+			fmt.Fprintf(&b, "Pixels: %.2f%% changed (%d of %d)", 100*float64(diff.Changed)/float64(diff.Total), diff.Changed, diff.Total)
+			if before.Bounds() != after.Bounds() {
+				fmt.Fprintf(&b, ", the size changed from %dx%d to %dx%d", before.Bounds().Dx(), before.Bounds().Dy(), after.Bounds().Dx(), after.Bounds().Dy())
+			}
+			b.WriteString("\nChanged regions, as x,y width x height:\n")
+			for _, r := range diff.Regions {
+				fmt.Fprintf(&b, "  %d,%d %dx%d\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+			}
+			var encoded bytes.Buffer
+			if err := png.Encode(&encoded, diff.Image); err != nil {
+				return "", nil, err
+			}
+			blob = &genai.Blob{MIMEType: "image/png", Data: encoded.Bytes()}
+			b.WriteString("The attached image shows the page now, faded, with the changed pixels in red.\n")
+		}
+	}
+
+	switch structure := unifiedDiff(name, baseline.Structure, current.Structure); {
+	case structure != "":
+		label := "Structure"
+		if current.Command != "" {
+			label = "Screen"
+		}
+		fmt.Fprintf(&b, "%s changes:\n%s", label, truncateOutput(structure, 4000))
+	case current.Command != "" && baseline.Raw != current.Raw:
+		b.WriteString("Screen: the text is the same, colors, styles or cursor movements changed\n")
+	case current.Command != "":
+		b.WriteString("Screen: no change\n")
+	default:
+		b.WriteString("Structure: no change\n")
+	}
+	return b.String(), blob, nil
+}
+
+// pixelDiff is how two screenshots differ
+type pixelDiff struct {
+	Changed, Total int
+	// The largest areas of changed pixels
+	Regions []image.Rectangle
+	// The new screenshot faded, with changed pixels in red
+	Image *image.RGBA
+}
+
+// comparePixels compares two images over the area they cover together.
+// Pixels only one of them covers count as changed.
+func comparePixels(before, after image.Image) pixelDiff {
+	bounds := before.Bounds().Union(after.Bounds())
+	diff := pixelDiff{Total: bounds.Dx() * bounds.Dy(), Image: image.NewRGBA(bounds)}
+	cols, rows := (bounds.Dx()+diffCell-1)/diffCell, (bounds.Dy()+diffCell-1)/diffCell
+	cells := make([]bool, cols*rows)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Pt(x, y)
+			changed := !p.In(before.Bounds()) || !p.In(after.Bounds()) || colorsDiffer(before.At(x, y), after.At(x, y))
+			if changed {
+				diff.Changed++
+				cells[(y-bounds.Min.Y)/diffCell*cols+(x-bounds.Min.X)/diffCell] = true
+				diff.Image.Set(x, y, color.RGBA{R: 255, A: 255})
+				continue
+			}
+			// Unchanged pixels are faded toward white
+			r, g, b, _ := after.At(x, y).RGBA()
+			gray := uint8((r + g + b) / 3 >> 8)
+			faded := 255 - (255-gray)/4
+			diff.Image.Set(x, y, color.RGBA{R: faded, G: faded, B: faded, A: 255})
+		}
+	}
+	diff.Regions = changedRegions(cells, cols, rows, bounds.Min)
+	return diff
+}
+
+func colorsDiffer(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	for _, d := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+		if max(d[0], d[1])-min(d[0], d[1]) > pixelThreshold<<8 {
+			return true
+		}
+	}
+	return false
+}
+
+// changedRegions joins neighboring changed cells into rectangles, the
+// largest first
+func changedRegions(cells []bool, cols, rows int, origin image.Point) []image.Rectangle {
+	var regions []image.Rectangle
+	seen := make([]bool, len(cells))
+	for start := range cells {
+		if !cells[start] || seen[start] {
+			continue
+		}
+		var region image.Rectangle
+		stack := []int{start}
+		seen[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%cols, i/cols
+			cell := image.Rect(x*diffCell, y*diffCell, (x+1)*diffCell, (y+1)*diffCell).Add(origin)
+			if region.Empty() {
+				region = cell
+			} else {
+				region = region.Union(cell)
+			}
+			for _, n := range [][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] < 0 || n[0] >= cols || n[1] < 0 || n[1] >= rows {
+					continue
+				}
+				if j := n[1]*cols + n[0]; cells[j] && !seen[j] {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+		regions = append(regions, region)
+	}
+	slices.SortFunc(regions, func(a, b image.Rectangle) int {
+		return b.Dx()*b.Dy() - a.Dx()*a.Dy()
+	})
+	return regions[:min(len(regions), maxDiffRegions)]
+}
+
+// terminalSequence matches the escape sequences terminals interpret: CSI
+// sequences, OSC strings and two-character escapes
+var terminalSequence = regexp.MustCompile(`^\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[ -~])`)
+
+// renderTerminal plays output on a screen columns wide, as a terminal
+// would show it: lines wrap, and the cursor movements and erasing TUIs
+// draw with are followed. With rows > 0 the screen is that high and
+// scrolls; otherwise it keeps every line.
+func renderTerminal(output string, columns, rows int) string {
+	var screen [][]rune
+	row, col := 0, 0
+	line := func(r int) []rune {
+		for len(screen) <= r {
+			screen = append(screen, nil)
+		}
+		return screen[r]
+	}
+	scroll := func() {
+		if rows > 0 && row >= rows {
+			screen = screen[row-rows+1:]
+			row = rows - 1
+		}
+	}
+	for i := 0; i < len(output); {
+		if m := terminalSequence.FindString(output[i:]); m != "" {
+			i += len(m)
+			if m[1] != '[' {
+				continue
+			}
+			final := m[len(m)-1]
+			params := strings.Split(strings.TrimLeft(m[2:len(m)-1], "?"), ";")
+			n := func(k, def int) int {
+				if k < len(params) {
+					if v, err := strconv.Atoi(params[k]); err == nil && v > 0 {
+						return v
+					}
+				}
+				return def
+			}
+			switch final {
+			case 'H', 'f':
+				row, col = n(0, 1)-1, n(1, 1)-1
+			case 'A':
+				row = max(row-n(0, 1), 0)
+			case 'B':
+				row += n(0, 1)
+			case 'C':
+				col += n(0, 1)
+			case 'D':
+				col = max(col-n(0, 1), 0)
+			case 'G':
+				col = n(0, 1) - 1
+			case 'd':
+				row = n(0, 1) - 1
+			case 'E':
+				row, col = row+n(0, 1), 0
+			case 'F':
+				row, col = max(row-n(0, 1), 0), 0
+			case 'J':
+				switch n(0, 0) {
+				case 0:
+					l := line(row)
+					screen[row] = l[:min(col, len(l))]
+					screen = screen[:row+1]
+				case 2, 3:
+					screen = nil
+				}
+			case 'K':
+				l := line(row)
+				switch n(0, 0) {
+				case 0:
+					screen[row] = l[:min(col, len(l))]
+				case 1:
+					for c := 0; c <= col && c < len(l); c++ {
+						l[c] = ' '
+					}
+				case 2:
+					screen[row] = nil
+				}
+			case 'h', 'l':
+				// Switching to the alternate screen starts a blank one
+				if strings.Contains(m, "1049") {
+					screen, row, col = nil, 0, 0
+				}
+			}
+			if rows > 0 {
+				row = min(row, rows-1)
+			}
+			col = min(col, columns-1)
+			continue
+		}
+
+		r, size := rune(output[i]), 1
+		if r >= 0x80 {
+			r, size = utf8.DecodeRuneInString(output[i:])
+		}
+		i += size
+		switch {
+		case r == '\n':
+			row, col = row+1, 0
+			scroll()
+		case r == '\r':
+			col = 0
+		case r == '\b':
+			col = max(col-1, 0)
+		case r == '\t':
+			col = min((col/8+1)*8, columns-1)
+		case r < ' ' || r == 0x7f:
+		default:
+			if col >= columns {
+				row, col = row+1, 0
+				scroll()
+			}
+			l := line(row)
+			for len(l) <= col {
+				l = append(l, ' ')
+			}
+			l[col] = r
+			screen[row] = l
+			col++
+		}
+	}
+
+	var b strings.Builder
+	for _, l := range screen {
+		b.WriteString(strings.TrimRight(string(l), " "))
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
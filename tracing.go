@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.30.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genai"
+)
+
+// tracer records spans for requests, model calls and tool calls. Spans go
+// nowhere unless setupTracing installed an exporter.
+var tracer = otel.Tracer("github.com/anubhavgh023/codegent")
+
+// setupTracing exports spans over OTLP/gRPC when an OTLP endpoint is
+// configured with the standard OTEL_EXPORTER_OTLP_* variables. The returned
+// function flushes the spans that are still buffered.
+func setupTracing(ctx context.Context) (func(), error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("codegent"),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return func() { provider.Shutdown(context.Background()) }, nil
+}
+
+// endSpan marks span failed when err is set and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// usageAttributes describes the tokens a model call used
+func usageAttributes(usage *genai.GenerateContentResponseUsageMetadata) []attribute.KeyValue {
+	if usage == nil {
+		return nil
+	}
+	return []attribute.KeyValue{
+		attribute.Int("gen_ai.usage.input_tokens", int(usage.PromptTokenCount)),
+		attribute.Int("gen_ai.usage.output_tokens", int(usage.CandidatesTokenCount)),
+		attribute.Int("gen_ai.usage.cached_tokens", int(usage.CachedContentTokenCount)),
+		attribute.Int("gen_ai.usage.thoughts_tokens", int(usage.ThoughtsTokenCount)),
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is set by release builds with -ldflags "-X main.version=v1.2.3".
+// Development builds don't look for updates.
+var version = "dev"
+
+// releaseKey is the base64 Ed25519 public key the checksums of releases are
+// signed with, set like version. Builds without it can't upgrade
+// themselves: checksums.txt comes from the same server as the binary.
+var releaseKey = ""
+
+// release is what codegent needs of a GitHub release
+type release struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// latestRelease asks GitHub for the latest release. CODEGENT_RELEASES_URL
+// points it at a mirror or a fork instead, unless it's set by the project's
+// .env.
+func latestRelease(ctx context.Context) (*release, error) {
+	url := userEnvOr("CODEGENT_RELEASES_URL", "https://api.github.com/repos/anubhavgh023/codegent/releases/latest")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("checking for the latest release: %s", resp.Status)
+	}
+	var rel release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("checking for the latest release: %w", err)
+	}
+	return &rel, nil
+}
+
+// asset returns the download URL of the release file called name, "" if
+// there is none
+func (r *release) asset(name string) string {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset.URL
+		}
+	}
+	return ""
+}
+
+func download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// newerVersion reports whether version a, like v1.2.3, is newer than b.
+// Pre-release suffixes are ignored and "dev" is older than any release.
+func newerVersion(a, b string) bool {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return pa[i] > pb[i]
+		}
+	}
+	return false
+}
+
+func versionParts(v string) [3]int {
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	var parts [3]int
+	for i, part := range strings.SplitN(v, ".", 3) {
+		parts[i], _ = strconv.Atoi(part)
+	}
+	return parts
+}
+
+// updateCheck is the outcome of the last look for a new release, kept so
+// the chat doesn't wait for the network when it starts
+type updateCheck struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Latest    string    `json:"latest"`
+}
+
+const updateCheckInterval = 24 * time.Hour
+
+func updateCheckPath() (string, error) {
+	dir, err := codegentDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "update-check.json"), nil
+}
+
+// updateNotice tells the user when the last check found a newer release,
+// and checks again in the background once a day
+func updateNotice(w io.Writer) {
+	if version == "dev" {
+		return
+	}
+	path, err := updateCheckPath()
+	if err != nil {
+		return
+	}
+	var last updateCheck
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &last)
+	}
+	if newerVersion(last.Latest, version) {
+		fmt.Fprintln(w, paint(roleNotice, tr("codegent %s is available, you have %s. Install it with: codegent upgrade", last.Latest, version)))
+	}
+	if time.Since(last.CheckedAt) < updateCheckInterval {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		rel, err := latestRelease(ctx)
+		if err != nil {
+			return
+		}
+		data, err := json.MarshalIndent(updateCheck{CheckedAt: time.Now(), Latest: rel.TagName}, "", "  ")
+		if err != nil || os.MkdirAll(filepath.Dir(path), 0755) != nil {
+			return
+		}
+		os.WriteFile(path, data, 0644)
+	}()
+}
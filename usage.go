@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// usageRecord is one line of the usage log, describing either a model call
+// or a tool call
+type usageRecord struct {
+	Time    time.Time `json:"time"`
+	Session string    `json:"session"`
+
+	Model        string `json:"model,omitempty"`
+	InputTokens  int    `json:"input_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	CachedTokens int    `json:"cached_tokens,omitempty"`
+
+	Tool         string   `json:"tool,omitempty"`
+	Write        bool     `json:"write,omitempty"`
+	Failed       bool     `json:"failed,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Files        []string `json:"files,omitempty"`
+	LinesAdded   int      `json:"lines_added,omitempty"`
+	LinesRemoved int      `json:"lines_removed,omitempty"`
+}
+
+var usageLogWarn sync.Once
+
+// appendUsage adds rec to the usage log. Failing to write it only warns,
+// once, since it must never get in the way of the actual work.
+func appendUsage(rec usageRecord) {
+	st, err := store()
+	if err == nil {
+		err = st.AppendUsage(rec)
+	}
+	if err != nil {
+		usageLogWarn.Do(func() { log.Println("WARNING not recording usage:", err.Error()) })
+	}
+}
+
+// recordModelCall logs the tokens a successful model call used
+func (a *Agent) recordModelCall(model string, usage *genai.GenerateContentResponseUsageMetadata) {
+	if usage == nil {
+		return
+	}
+	rec := usageRecord{
+		Time:         time.Now(),
+		Session:      a.session.ID,
+		Model:        model,
+		InputTokens:  int(usage.PromptTokenCount),
+		OutputTokens: int(usage.CandidatesTokenCount + usage.ThoughtsTokenCount),
+		CachedTokens: int(usage.CachedContentTokenCount),
+	}
+	appendUsage(rec)
+	if cost, ok := estimateCost(rec); ok {
+		a.costUsed += cost
+	}
+	if a.status != nil {
+		a.status.observe(rec, usage)
+	}
+}
+
+// recordToolCall logs a tool call, with the files and lines it changed when
+// it is a successful write
+func (a *Agent) recordToolCall(name string, input map[string]interface{}, result map[string]interface{}) {
+	errText, failed := result["error"]
+	rec := usageRecord{
+		Time:    time.Now(),
+		Session: a.session.ID,
+		Tool:    name,
+		Write:   a.toolKind(name) == ToolWrite,
+		Failed:  failed,
+	}
+	if failed {
+		rec.Error = fmt.Sprint(errText)
+	}
+	if rec.Write && !failed {
+		// multi_edit nests its edits, the other tools edit one path
+		edits := []interface{}{input}
+		if nested, ok := input["edits"].([]interface{}); ok {
+			edits = nested
+		}
+		for _, edit := range edits {
+			edit, _ := edit.(map[string]interface{})
+			if path, _ := edit["path"].(string); path != "" && !slices.Contains(rec.Files, path) {
+				rec.Files = append(rec.Files, path)
+			}
+			oldStr, _ := edit["old_str"].(string)
+			newStr, _ := edit["new_str"].(string)
+			rec.LinesRemoved += countLines(oldStr)
+			rec.LinesAdded += countLines(newStr)
+		}
+	}
+	appendUsage(rec)
+}
+
+func countLines(s string) int {
+	if s == "" {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(s, "\n"), "\n") + 1
+}
+
+// modelPrice is a model's list price in USD per million tokens
+type modelPrice struct {
+	prefix        string
+	input, output float64
+}
+
+// modelPrices are matched by model name prefix, in order, so more specific
+// names come first. Cached input is billed at a quarter of the input price.
+var modelPrices = []modelPrice{
+	{"gemini-2.5-pro", 1.25, 10},
+	{"gemini-2.5-flash-lite", 0.10, 0.40},
+	{"gemini-2.5-flash", 0.30, 2.50},
+	{"gemini-2.0-flash-lite", 0.075, 0.30},
+	{"gemini-2.0-flash", 0.10, 0.40},
+	{"gemini-1.5-pro", 1.25, 5},
+	{"gemini-1.5-flash", 0.075, 0.30},
+	{ollamaPrefix, 0, 0},
+}
+
+// estimateCost prices a model call, reporting false for unknown models
+func estimateCost(rec usageRecord) (float64, bool) {
+	// Vertex AI names look like publishers/google/models/gemini-2.0-flash
+	model := rec.Model
+	if i := strings.LastIndex(model, "models/"); i >= 0 {
+		model = model[i+len("models/"):]
+	}
+	for _, price := range modelPrices {
+		if strings.HasPrefix(model, price.prefix) {
+			fresh := float64(rec.InputTokens - rec.CachedTokens)
+			cached := float64(rec.CachedTokens)
+			return (fresh*price.input + cached*price.input/4 + float64(rec.OutputTokens)*price.output) / 1e6, true
+		}
+	}
+	return 0, false
+}
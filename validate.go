@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// validateArgs checks a tool call's arguments against the tool's input
+// schema: required properties, no unknown ones, types and enums. Models get
+// these wrong now and then, and the error tells them precisely where.
+func validateArgs(tool ToolDefinition, args map[string]interface{}) error {
+	var problems []string
+	checkValue(&tool.InputSchema, "", args, &problems)
+	if len(problems) == 0 {
+		return nil
+	}
+	return newToolError(errInvalidInput, "invalid arguments: "+strings.Join(problems, "; "),
+		fmt.Sprintf("Call %s again with arguments matching its schema.", tool.Name))
+}
+
+// checkValue appends what is wrong with value to problems, naming it by
+// its path in the arguments, such as edits[1].path
+func checkValue(schema *genai.Schema, path string, value interface{}, problems *[]string) {
+	where := path
+	if where == "" {
+		where = "arguments"
+	}
+	switch schema.Type {
+	case genai.TypeObject:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: want an object, got %s", where, jsonType(value)))
+			return
+		}
+		for _, name := range schema.Required {
+			if object[name] == nil {
+				*problems = append(*problems, fmt.Sprintf("%s is required", joinPath(path, name)))
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, known := schema.Properties[name]
+			switch {
+			case !known:
+				*problems = append(*problems, fmt.Sprintf("%s is not a known argument", joinPath(path, name)))
+			case object[name] != nil:
+				checkValue(prop, joinPath(path, name), object[name], problems)
+			}
+		}
+	case genai.TypeArray:
+		items, ok := value.([]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: want an array, got %s", where, jsonType(value)))
+			return
+		}
+		if schema.Items != nil {
+			for i, item := range items {
+				checkValue(schema.Items, fmt.Sprintf("%s[%d]", path, i), item, problems)
+			}
+		}
+	case genai.TypeString:
+		s, ok := value.(string)
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: want a string, got %s", where, jsonType(value)))
+			return
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, s) {
+			*problems = append(*problems, fmt.Sprintf("%s: %q is not one of %s", where, s, strings.Join(schema.Enum, ", ")))
+		}
+	case genai.TypeInteger:
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			*problems = append(*problems, fmt.Sprintf("%s: want an integer, got %s", where, jsonType(value)))
+		}
+	case genai.TypeNumber:
+		if _, ok := value.(float64); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: want a number, got %s", where, jsonType(value)))
+		}
+	case genai.TypeBoolean:
+		if _, ok := value.(bool); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: want a boolean, got %s", where, jsonType(value)))
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonType names the JSON type of a decoded value, for error messages
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// walkWorkers bounds how many directories are read at the same time
+const walkWorkers = 16
+
+// walkNode is a directory being read in the background
+type walkNode struct {
+	relPath  string
+	entries  []fs.DirEntry
+	children map[string]*walkNode
+	err      error
+	done     chan struct{}
+}
+
+// directoryWalker reads directories on a bounded pool of workers
+type directoryWalker struct {
+	ctx      context.Context
+	root     string
+	maxDepth int
+	sem      chan struct{}
+}
+
+// walkFiles calls fn for every entry below root, in the same lexical order
+// as filepath.WalkDir. While fn works through a directory, all of its
+// subdirectories are read concurrently, so wide trees are read in parallel
+// but nothing far ahead of fn is read. fn returns false to stop the walk,
+// which also stops the pending reads. Directories at maxDepth (if > 0) are
+// reported but not descended into, and .git is skipped. Cancelling ctx
+// stops the walk with its error.
+func walkFiles(ctx context.Context, root string, maxDepth int, fn func(relPath string, d fs.DirEntry) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &directoryWalker{
+		ctx:      ctx,
+		root:     root,
+		maxDepth: maxDepth,
+		sem:      make(chan struct{}, walkWorkers),
+	}
+	rootNode := &walkNode{relPath: ".", done: make(chan struct{})}
+	go w.read(rootNode)
+	_, err := w.visit(rootNode, fn)
+	return err
+}
+
+func (w *directoryWalker) read(node *walkNode) {
+	defer close(node.done)
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		node.err = w.ctx.Err()
+		return
+	}
+	node.entries, node.err = os.ReadDir(filepath.Join(w.root, node.relPath))
+	<-w.sem
+}
+
+// visit reports the node's entries in order, descending into each child
+// directory right after its entry has been reported
+func (w *directoryWalker) visit(node *walkNode, fn func(relPath string, d fs.DirEntry) bool) (bool, error) {
+	<-node.done
+	if node.err != nil {
+		return false, node.err
+	}
+
+	// Start reading every subdirectory before walking through them
+	node.children = make(map[string]*walkNode)
+	for _, entry := range node.entries {
+		if !entry.IsDir() || entry.Name() == ".git" {
+			continue
+		}
+		relPath := filepath.Join(node.relPath, entry.Name())
+		if w.maxDepth > 0 && pathDepth(relPath) >= w.maxDepth {
+			continue
+		}
+		child := &walkNode{relPath: relPath, done: make(chan struct{})}
+		node.children[entry.Name()] = child
+		go w.read(child)
+	}
+
+	for _, entry := range node.entries {
+		if entry.IsDir() && entry.Name() == ".git" {
+			continue
+		}
+		if err := w.ctx.Err(); err != nil {
+			return false, err
+		}
+		if !fn(filepath.Join(node.relPath, entry.Name()), entry) {
+			return false, nil
+		}
+		if child := node.children[entry.Name()]; child != nil {
+			if ok, err := w.visit(child, fn); !ok || err != nil {
+				return ok, err
+			}
+		}
+	}
+	return true, nil
+}
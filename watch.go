@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fileStamp identifies a version of a file without reading it
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotFiles stamps every regular file below root
+func snapshotFiles(root string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	walkFiles(context.Background(), root, 0, func(relPath string, d fs.DirEntry) bool {
+		if !d.Type().IsRegular() {
+			return true
+		}
+		if info, err := d.Info(); err == nil {
+			stamps[filepath.Join(root, relPath)] = fileStamp{info.ModTime(), info.Size()}
+		}
+		return true
+	})
+	return stamps
+}
+
+// watchFiles polls root every interval and calls fn with the files created
+// or modified since the last poll, until ctx is done. Polling keeps this
+// portable and dependency free; repositories are small enough to stat.
+func watchFiles(ctx context.Context, root string, interval time.Duration, fn func(changed []string)) {
+	stamps := snapshotFiles(root)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := snapshotFiles(root)
+		var changed []string
+		for path, stamp := range current {
+			if old, ok := stamps[path]; !ok || old != stamp {
+				changed = append(changed, path)
+			}
+		}
+		stamps = current
+		if len(changed) > 0 {
+			sort.Strings(changed)
+			fn(changed)
+			// Don't report what fn changed itself as the user's edits
+			stamps = snapshotFiles(root)
+		}
+	}
+}
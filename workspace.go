@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// workspaceMu is held while a tool runs for an agent with its own workspace.
+// Tools resolve paths against the process working directory and keep state
+// in fileVersions, so agents sharing a process take turns switching both:
+// their tool calls run one at a time.
+var workspaceMu sync.Mutex
+
+// inWorkspace runs fn with the agent's workspace root as the working
+// directory. This only changes where relative paths start; it is not a
+// sandbox, and absolute or "../" paths still reach outside the root. Agents
+// without a root run fn in the working directory as is.
+func (a *Agent) inWorkspace(fn func()) error {
+	if a.root == "" {
+		fn()
+		return nil
+	}
+
+	workspaceMu.Lock()
+	defer workspaceMu.Unlock()
+	prevDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(a.root); err != nil {
+		return fmt.Errorf("workspace unavailable: %w", err)
+	}
+	defer os.Chdir(prevDir)
+	prevFiles := fileVersions
+	fileVersions = a.files
+	defer func() { fileVersions = prevFiles }()
+
+	fn()
+	return nil
+}
+
+// setRoot gives the agent its own workspace root and file tracker
+func (a *Agent) setRoot(root string) {
+	a.root = root
+	a.files = newFileTracker()
+}
+
+// resolveWorkspace resolves dir, relative to root or absolute, to a
+// directory inside root. An empty dir is root itself.
+func resolveWorkspace(root, dir string) (string, error) {
+	path := dir
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, dir)
+	}
+	path = filepath.Clean(path)
+	if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("workspace %s is outside %s", dir, root)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("workspace %s is not a directory", dir)
+	}
+	return path, nil
+}